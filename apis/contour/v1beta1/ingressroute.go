@@ -24,6 +24,17 @@ type IngressRouteSpec struct {
 	VirtualHost *VirtualHost `json:"virtualhost"`
 	// Routes are the ingress routes
 	Routes []Route `json:"routes"`
+	// TCPProxy proxies the TLS connections for the virtual host to a
+	// service, in place of routes. It requires virtualhost.tls
+	TCPProxy *TCPProxy `json:"tcpproxy,omitempty"`
+}
+
+// TCPProxy describes the service which TLS connections, selected by
+// their SNI hostname, are proxied to.
+type TCPProxy struct {
+	// Services are the services to proxy connections to. Exactly
+	// one service must be given
+	Services []Service `json:"services"`
 }
 
 // VirtualHost appears at most once. If it is present, the object is considered
@@ -39,14 +50,39 @@ type VirtualHost struct {
 	// are described in fqdn and aliases, the tls.secretName secret must contain a
 	// matching certificate
 	TLS *TLS `json:"tls"`
+	// PerFilterConfig overrides the listener level configuration of the
+	// named HTTP filters for this virtual host
+	PerFilterConfig map[string]FilterConfig `json:"perFilterConfig,omitempty"`
+	// CorsPolicy is the default cross origin resource sharing policy
+	// for the routes of this virtual host
+	CorsPolicy *CorsPolicy `json:"corsPolicy,omitempty"`
+	// ForwardingHeaders, if set, overrides the serve level choice of
+	// whether x-forwarded-proto and x-forwarded-port are added to
+	// requests proxied for this virtual host
+	ForwardingHeaders *bool `json:"forwardingHeaders,omitempty"`
+	// Visibility is either "public", the default, or "internal". An
+	// internal virtual host is served only by the internal HTTP listener
+	// and does not support TLS
+	Visibility string `json:"visibility,omitempty"`
+	// CaseInsensitive, if true, matches the prefix or regex of every
+	// route of this virtual host without regard to case
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
 }
 
 // TLS describes tls properties. The CNI names that will be matched on
 // are described in fqdn and aliases, the tls.secretName secret must contain a
 // matching certificate
 type TLS struct {
-	// required, the name of a secret in the current namespace
+	// required, unless passthrough is set, the name of a secret in the
+	// current namespace
 	SecretName string `json:"secretName"`
+	// optional, if true the TLS connection is passed through to the
+	// tcpproxy service rather than terminated by Envoy
+	Passthrough bool `json:"passthrough,omitempty"`
+	// optional, the name of a second secret in the current namespace whose
+	// certificate is served alongside the first, eg. an ECDSA certificate
+	// paired with an RSA one
+	SecondarySecretName string `json:"secondarySecretName,omitempty"`
 	// Minimum TLS version this vhost should negotiate
 	MinimumProtocolVersion string `json:"minimumProtocolVersion"`
 }
@@ -55,12 +91,155 @@ type TLS struct {
 type Route struct {
 	// Match defines the prefix match
 	Match string `json:"match"`
+	// MatchType defines how Match is interpreted, either "Prefix" (the
+	// default) or "Regex". A Regex match must be a valid regular expression.
+	MatchType string `json:"matchType"`
+	// Headers are conditions on the request headers, each of which
+	// must be met for the request to match the route
+	Headers []HeaderMatch `json:"headers,omitempty"`
 	// Services are the services to proxy traffic
 	Services []Service `json:"services"`
 	// Delegate specifies that this route should be delegated to another IngressRoute
 	Delegate `json:"delegate"`
 	// Enables websocket support for the route
 	EnableWebsockets bool `json:"enableWebsockets"`
+	// MaxGRPCTimeout caps the grpc-timeout header a gRPC client may
+	// send, specified as a duration or "infinity". If unset the serve
+	// level default is used.
+	MaxGRPCTimeout string `json:"maxGrpcTimeout,omitempty"`
+	// TimeoutPolicy defines the timeouts applied to the route
+	TimeoutPolicy *TimeoutPolicy `json:"timeoutPolicy,omitempty"`
+	// PerFilterConfig overrides the listener level configuration of the
+	// named HTTP filters for this route
+	PerFilterConfig map[string]FilterConfig `json:"perFilterConfig,omitempty"`
+	// MaxRequestBytes is the largest request body, in bytes, accepted
+	// by the route. Larger requests receive a 413. Zero means unlimited
+	MaxRequestBytes int64 `json:"maxRequestBytes,omitempty"`
+	// CorsPolicy overrides the virtual host's cross origin resource
+	// sharing policy for this route
+	CorsPolicy *CorsPolicy `json:"corsPolicy,omitempty"`
+	// CaseSensitive, when false, matches the route's prefix or regex
+	// without regard to case. If unset matching is case sensitive
+	CaseSensitive *bool `json:"caseSensitive,omitempty"`
+	// WebsocketPolicy defines the timeouts applied to upgraded
+	// connections. It requires EnableWebsockets
+	WebsocketPolicy *WebsocketPolicy `json:"websocketPolicy,omitempty"`
+	// HealthCheck, if set, overrides the health check of each of the
+	// route's services. A service whose own health check differs is
+	// given a separate cluster for this route
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+	// Fallback, if set, serves the route's requests while none of its
+	// services have ready endpoints
+	Fallback *Fallback `json:"fallback,omitempty"`
+	// Decorator, if set, names the spans traced for the route
+	Decorator *Decorator `json:"decorator,omitempty"`
+	// RetryBuffer, if set, buffers request bodies so that retried
+	// requests, eg. POSTs, are replayed in full. It requires a retry
+	// policy, ie. a TimeoutPolicy with a finite PerTry timeout
+	RetryBuffer *RetryBuffer `json:"retryBuffer,omitempty"`
+	// MetadataMatch, if set, sends the route's requests only to the
+	// endpoints of its services whose load balancing metadata, copied
+	// from the labels of their pods, has each of these values
+	MetadataMatch map[string]string `json:"metadataMatch,omitempty"`
+}
+
+// RetryBuffer defines how the request bodies of a retried route are
+// buffered.
+type RetryBuffer struct {
+	// MaxBytes is the largest request body, in bytes, buffered for
+	// replay. Larger requests receive a 413. It must be positive and
+	// must not exceed the limit Contour is configured with
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// Decorator defines how the spans traced for a route are named.
+type Decorator struct {
+	// Operation is the operation name of the route's spans
+	Operation string `json:"operation"`
+}
+
+// Fallback defines how a route whose services have no ready endpoints
+// is served. Exactly one of Service or Status must be set.
+type Fallback struct {
+	// Service, if set, receives the route's requests instead
+	Service *Service `json:"service,omitempty"`
+	// Status, if set, is the HTTP status Envoy responds with directly
+	Status int `json:"status,omitempty"`
+}
+
+// HeaderMatch matches a request header by name. Exactly one of Exact,
+// Present, Regex or Range must be set.
+type HeaderMatch struct {
+	// Name is the name of the header
+	Name string `json:"name"`
+	// Exact matches a header whose value is exactly this string
+	Exact string `json:"exact,omitempty"`
+	// Present, if true, matches a header with any value
+	Present bool `json:"present,omitempty"`
+	// Regex matches a header whose whole value matches this
+	// regular expression
+	Regex string `json:"regex,omitempty"`
+	// Range matches a header whose value is an integer in this range
+	Range *HeaderRange `json:"range,omitempty"`
+}
+
+// HeaderRange is a half open range of integers, [start, end).
+type HeaderRange struct {
+	// Start is the first integer in the range
+	Start int64 `json:"start"`
+	// End is the integer after the last in the range
+	End int64 `json:"end"`
+}
+
+// CorsPolicy defines the cross origin resource sharing policy
+// of a virtual host or route.
+type CorsPolicy struct {
+	// AllowOrigin lists the origins allowed to make CORS requests,
+	// at least one origin is required
+	AllowOrigin []string `json:"allowOrigin"`
+	// AllowMethods lists the methods sent in access-control-allow-methods
+	AllowMethods []string `json:"allowMethods,omitempty"`
+	// AllowHeaders lists the headers sent in access-control-allow-headers
+	AllowHeaders []string `json:"allowHeaders,omitempty"`
+	// ExposeHeaders lists the headers sent in access-control-expose-headers
+	ExposeHeaders []string `json:"exposeHeaders,omitempty"`
+	// MaxAge is how long, as a duration, the results of a preflight
+	// request may be cached
+	MaxAge string `json:"maxAge,omitempty"`
+	// AllowCredentials, if true, allows requests with credentials
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+}
+
+// FilterConfig overrides the configuration of a HTTP filter. The supported
+// filters are envoy.ext_authz and envoy.rate_limit.
+type FilterConfig struct {
+	// Disabled, if true, disables the filter
+	Disabled bool `json:"disabled,omitempty"`
+	// Stage overrides the rate limit stage, in the range 0-10.
+	// Only supported by envoy.rate_limit
+	Stage int `json:"stage,omitempty"`
+}
+
+// TimeoutPolicy defines the timeouts applied to a route. Each timeout
+// is specified as a duration, eg. "1m30s", or "infinity".
+type TimeoutPolicy struct {
+	// Request is the timeout for the whole request, including retries
+	Request string `json:"request,omitempty"`
+	// PerTry is the timeout for each upstream attempt, it must not
+	// exceed Request unless Request is infinite
+	PerTry string `json:"perTry,omitempty"`
+}
+
+// WebsocketPolicy defines the timeouts applied to the websocket
+// connections of a route. Each timeout is specified as a duration,
+// eg. "1m30s", or "infinity".
+type WebsocketPolicy struct {
+	// Idle is the timeout for an upgraded connection with no activity
+	Idle string `json:"idle,omitempty"`
+	// MaxConnectionDuration is the longest an upgraded connection
+	// may remain open. It replaces the route's request timeout, which
+	// must not also be finite
+	MaxConnectionDuration string `json:"maxConnectionDuration,omitempty"`
 }
 
 // Service defines an upstream to proxy traffic to
@@ -76,6 +255,13 @@ type Service struct {
 	HealthCheck *HealthCheck `json:"healthCheck"`
 	// LB Algorithm to apply (see https://github.com/heptio/contour/blob/master/design/ingressroute-design.md#load-balancing)
 	Strategy string `json:"strategy"`
+	// MinimumRingSize is the minimum number of entries in the hash ring
+	// when Strategy is RingHash. Zero means Envoy's default
+	MinimumRingSize int64 `json:"minimumRingSize,omitempty"`
+	// RequestHeadersToAdd is a map of header names to values which
+	// are added to the requests routed to this service, eg. to tag
+	// which weighted service served the request
+	RequestHeadersToAdd map[string]string `json:"requestHeadersToAdd,omitempty"`
 }
 
 // Delegate allows for delegating VHosts to other IngressRoutes
@@ -14,26 +14,37 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/heptio/contour/internal/debug"
 	clientset "github.com/heptio/contour/internal/generated/clientset/versioned"
+	contourinformers "github.com/heptio/contour/internal/generated/informers/externalversions"
 	"github.com/heptio/contour/internal/httpsvc"
 	"github.com/heptio/workgroup"
 	"github.com/prometheus/client_golang/prometheus"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/heptio/contour/internal/contour"
+	"github.com/heptio/contour/internal/dag"
 	"github.com/heptio/contour/internal/envoy"
 	"github.com/heptio/contour/internal/grpc"
 	"github.com/heptio/contour/internal/k8s"
@@ -42,7 +53,20 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var ingressrouteRootNamespaceFlag string
+var (
+	ingressrouteRootNamespaceFlag string
+	ingressSelectorFlag           string
+	tlsSecretsOnlyFlag            bool
+	defaultResponseFlag           string
+	drainTimeoutFlag              time.Duration
+	notReadyAddressesFlag         bool
+	subsetLabelsFlag              []string
+	additionalHTTPListenersFlag   []string
+	httpLoopbackFlag              bool
+	validateClustersFlag          string
+	leaderElectFlag               bool
+	leaderElectMetricsFlag        bool
+)
 
 func main() {
 	log := logrus.StandardLogger()
@@ -60,6 +84,7 @@ func main() {
 	bootstrap.Flag("statsd-enabled", "enable statsd output").BoolVar(&config.StatsdEnabled)
 	bootstrap.Flag("statsd-address", "statsd address").StringVar(&config.StatsdAddress)
 	bootstrap.Flag("statsd-port", "statsd port").IntVar(&config.StatsdPort)
+	bootstrap.Flag("stats-flush-interval", "Interval at which Envoy flushes stats to its sinks").DurationVar(&config.StatsFlushInterval)
 
 	cli := app.Command("cli", "A CLI client for the Heptio Contour Kubernetes ingress controller.")
 	var client Client
@@ -75,11 +100,19 @@ func main() {
 	rds := cli.Command("rds", "watch routes.")
 	rds.Arg("resources", "RDS resource filter").StringsVar(&resources)
 
+	validate := app.Command("validate", "Report the problems Contour would find in the Kubernetes objects of YAML files, without serving them.")
+	validateFilesArg := validate.Arg("files", "YAML files of Kubernetes objects").Required().ExistingFiles()
+
 	serve := app.Command("serve", "Serve xDS API traffic")
 	inCluster := serve.Flag("incluster", "use in cluster configuration.").Bool()
 	kubeconfig := serve.Flag("kubeconfig", "path to kubeconfig (if not in running inside a cluster)").Default(filepath.Join(os.Getenv("HOME"), ".kube", "config")).String()
 	xdsAddr := serve.Flag("xds-address", "xDS gRPC API address").Default("127.0.0.1").String()
 	xdsPort := serve.Flag("xds-port", "xDS gRPC API port").Default("8001").Int()
+	xdsSendTimeout := serve.Flag("xds-send-timeout", "Close xDS streams to Envoys which do not read a response within this duration").Default("1m").Duration()
+	xdsStaleNodeLag := serve.Flag("xds-stale-node-lag", "Report Envoys which have not applied the configuration sent to them within this duration as stale").Default("1m").Duration()
+	var nacks grpc.NACKs
+	serve.Flag("xds-nack-threshold", "Reject a version of an xDS resource type after this many consecutive NACKs across all Envoys; 0 disables").Default("5").IntVar(&nacks.Threshold)
+	serve.Flag("xds-nack-rollback", "Serve each Envoy the resources it last ACKed in place of a rejected version").BoolVar(&nacks.Rollback)
 
 	ch := contour.CacheHandler{
 		FieldLogger: log.WithField("context", "CacheHandler"),
@@ -92,37 +125,98 @@ func main() {
 		},
 	}
 
+	le := k8s.LeaderElection{
+		FieldLogger:   log.WithField("context", "leaderelection"),
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+
+	// the metrics, health, and debug endpoints are each served on
+	// their own address and port. endpoints which share an address
+	// and port share a single listener.
+	httpsvcs := httpsvc.Manager{
+		FieldLogger: log,
+	}
+
 	// configuration parameters for debug service
 	debugsvc := debug.Service{
-		Service: httpsvc.Service{
-			FieldLogger: log.WithField("context", "debugsvc"),
-		},
-		// plumb the DAGAdapter's Builder through
+		// plumb the ResourceEventHandler's Builder through
 		// to the debug handler
 		Builder: &reh.Builder,
 	}
 
-	serve.Flag("debug-http-address", "address the debug http endpoint will bind too").Default("127.0.0.1").StringVar(&debugsvc.Addr)
-	serve.Flag("debug-http-port", "port the debug http endpoint will bind too").Default("6060").IntVar(&debugsvc.Port)
+	debugep := httpsvcs.Endpoint(httpsvc.Debug)
+	serve.Flag("debug-http-address", "address the debug http endpoint will bind too").Default("127.0.0.1").StringVar(&debugep.Addr)
+	serve.Flag("debug-http-port", "port the debug http endpoint will bind too").Default("6060").IntVar(&debugep.Port)
 
-	metricsvc := metrics.Service{
-		Service: httpsvc.Service{
-			FieldLogger: log.WithField("context", "metricsvc"),
-		},
-	}
+	metricsep := httpsvcs.Endpoint(httpsvc.Metrics)
+	serve.Flag("http-address", "address the metrics http endpoint will bind too").Default("0.0.0.0").StringVar(&metricsep.Addr)
+	serve.Flag("http-port", "port the metrics http endpoint will bind too").Default("8000").IntVar(&metricsep.Port)
 
-	serve.Flag("http-address", "address the metrics http endpoint will bind too").Default("0.0.0.0").StringVar(&metricsvc.Addr)
-	serve.Flag("http-port", "port the metrics http endpoint will bind too").Default("8000").IntVar(&metricsvc.Port)
+	healthep := httpsvcs.Endpoint(httpsvc.Health)
+	serve.Flag("health-address", "address the health http endpoint will bind too, defaults to --http-address").StringVar(&healthep.Addr)
+	serve.Flag("health-port", "port the health http endpoint will bind too, defaults to --http-port").IntVar(&healthep.Port)
 
 	serve.Flag("envoy-http-access-log", "Envoy HTTP access log").Default(contour.DEFAULT_HTTP_ACCESS_LOG).StringVar(&ch.HTTPAccessLog)
 	serve.Flag("envoy-https-access-log", "Envoy HTTPS access log").Default(contour.DEFAULT_HTTPS_ACCESS_LOG).StringVar(&ch.HTTPSAccessLog)
+	serve.Flag("accesslog-format", "Format of the Envoy HTTP and HTTPS access logs, one of envoy or json").Default(contour.ACCESS_LOG_FORMAT_ENVOY).EnumVar(&ch.AccessLogFormat, contour.ACCESS_LOG_FORMAT_ENVOY, contour.ACCESS_LOG_FORMAT_JSON)
+	serve.Flag("accesslog-json-fields", "JSON access log field, in the form KEY=OPERATOR, eg. method=REQ(:METHOD) (may be repeated)").StringMapVar(&ch.AccessLogJSONFields)
+	serve.Flag("accesslog-exclude-path", "Request path, eg. /healthz, which is not access logged for any host (may be repeated)").StringsVar(&ch.AccessLogExcludePaths)
+	serve.Flag("accesslog-sample-rate", "Access log one in this many requests, unless a virtual host sets its own rate").Uint32Var(&ch.AccessLogSampleRate)
+	serve.Flag("accesslog-errors-only", "Access log only requests whose response status is 400 or above").BoolVar(&ch.AccessLogErrorsOnly)
 	serve.Flag("envoy-http-address", "Envoy HTTP listener address").StringVar(&ch.HTTPAddress)
+	serve.Flag("envoy-http-loopback", "Bind the Envoy HTTP listener to the loopback address, for sidecar deployments").BoolVar(&httpLoopbackFlag)
+	serve.Flag("envoy-internal-http-address", "Envoy internal HTTP listener address, serving only IngressRoutes of internal visibility").StringVar(&ch.InternalHTTPAddress)
+	serve.Flag("envoy-internal-http-port", "Envoy internal HTTP listener port; if unset there is no internal listener").IntVar(&ch.InternalHTTPPort)
+	serve.Flag("additional-http-listener", "Additional Envoy HTTP listener serving the same routes, in the form port[:address] (may be repeated)").StringsVar(&additionalHTTPListenersFlag)
 	serve.Flag("envoy-https-address", "Envoy HTTPS listener address").StringVar(&ch.HTTPSAddress)
 	serve.Flag("envoy-http-port", "Envoy HTTP listener port").IntVar(&ch.HTTPPort)
 	serve.Flag("envoy-https-port", "Envoy HTTPS listener port").IntVar(&ch.HTTPSPort)
+	serve.Flag("disable-https", "Do not generate the HTTPS listener or route configuration, TLS is handled elsewhere").BoolVar(&ch.DisableHTTPS)
 	serve.Flag("use-proxy-protocol", "Use PROXY protocol for all listeners").BoolVar(&ch.UseProxyProto)
-	serve.Flag("ingress-class-name", "Contour IngressClass name").StringVar(&reh.IngressClass)
+	serve.Flag("envoy-api-compat", "How the TLS configuration of the HTTPS listener is emitted, one of tls-context or transport-socket for newer Envoys").Default(contour.ENVOY_API_COMPAT_TLS_CONTEXT).EnumVar(&ch.EnvoyAPICompat, contour.ENVOY_API_COMPAT_TLS_CONTEXT, contour.ENVOY_API_COMPAT_TRANSPORT_SOCKET)
+	serve.Flag("envoy-eds-config-source", "How Envoy fetches the endpoints of each cluster, one of grpc or ads. ads requires Envoy's bootstrap to configure ads_config").Default(contour.EDS_CONFIG_SOURCE_GRPC).EnumVar(&ch.EDSConfigSource, contour.EDS_CONFIG_SOURCE_GRPC, contour.EDS_CONFIG_SOURCE_ADS)
+	serve.Flag("envoy-connect-timeout", "Default timeout for Envoy to connect to an upstream cluster, overridden per service by the contour.heptio.com/upstream-connect-timeout annotation").Default("250ms").DurationVar(&ch.ConnectTimeout)
+	serve.Flag("envoy-dns-lookup-family", "Default DNS lookup family of STRICT_DNS and LOGICAL_DNS clusters, one of auto, v4 or v6, overridden per service by the contour.heptio.com/dns-lookup-family annotation; if unset Envoy's default applies").EnumVar(&ch.DNSLookupFamily, dag.DNSLookupFamilyAuto, dag.DNSLookupFamilyV4, dag.DNSLookupFamilyV6)
+	serve.Flag("envoy-dns-refresh-rate", "Default DNS refresh rate of STRICT_DNS and LOGICAL_DNS clusters, overridden per service by the contour.heptio.com/dns-refresh-rate annotation; if unset Envoy's default applies").DurationVar(&ch.DNSRefreshRate)
+	serve.Flag("envoy-gzip", "Compress responses to clients which accept gzip encoding").BoolVar(&ch.Gzip)
+	serve.Flag("envoy-gzip-content-type", "Response content type to compress (may be repeated); if unset Envoy's defaults apply").StringsVar(&ch.GzipContentTypes)
+	serve.Flag("envoy-gzip-min-content-length", "Minimum length, in bytes, of the responses to compress; if unset Envoy's default applies").IntVar(&ch.GzipMinContentLength)
+	serve.Flag("route-config-prefix", "Prefix of the names of the route configurations served over RDS, so that Envoys fed by more than one Contour fetch distinct ones").StringVar(&ch.RouteConfigNames.Prefix)
+	serve.Flag("stats-prefix", "Prefix of the stat_prefix of every Envoy listener filter, eg. the name of the Contour pod").StringVar(&ch.StatsPrefix)
+	serve.Flag("envoy-sds", "Fetch the TLS certificates of the HTTPS listener over SDS rather than embedding them in the listener").BoolVar(&ch.UseSDS)
+	serve.Flag("envoy-stream-idle-timeout", "Reset HTTP request streams which are idle for this duration, independent of the connection idle timeout").DurationVar(&ch.StreamIdleTimeout)
+	serve.Flag("envoy-tcp-fast-open-queue-length", "Enable TCP Fast Open on all listeners with this pending queue length").IntVar(&ch.TCPFastOpenQueueLength)
+	serve.Flag("envoy-response-header", "Envoy response header to add to every response, in the form NAME=VALUE (may be repeated)").StringMapVar(&ch.ResponseHeadersToAdd)
+	serve.Flag("envoy-max-grpc-timeout", "Default maximum grpc-timeout a gRPC client may request, capped by any request timeout").DurationVar(&ch.MaxGRPCTimeout)
+	serve.Flag("envoy-request-timeout", "Default timeout of routes which do not set their own; if unset Envoy's default applies").DurationVar(&ch.RequestTimeout)
+	serve.Flag("envoy-forwarding-headers", "Add x-forwarded-proto and x-forwarded-port to proxied requests, unless an IngressRoute overrides it").BoolVar(&ch.ForwardingHeaders)
+	serve.Flag("envoy-route-metadata", "Name the Kubernetes object which produced each route in the route's metadata, for access logs and tracing; --no-envoy-route-metadata omits it").Default("true").BoolVar(&ch.RouteMetadata)
+	serve.Flag("envoy-validate-clusters", "Whether Envoy rejects route configurations which refer to unknown clusters, one of true or false; if unset Envoy's default applies").EnumVar(&validateClustersFlag, "true", "false")
+	serve.Flag("disable-https-redirect", "Serve every route over HTTP as well as HTTPS, ignoring annotations which request a redirect to HTTPS").BoolVar(&ch.DisableHTTPSRedirect)
+	serve.Flag("endpoint-drain-timeout", "Keep the endpoints of terminating pods, draining, for up to this duration").DurationVar(&drainTimeoutFlag)
+	serve.Flag("cluster-removal-grace-period", "Keep the clusters, and endpoints, of deleted services for this duration so requests in flight can complete").DurationVar(&ch.ClusterRemovalGracePeriod)
+	serve.Flag("endpoint-subset-label", "Pod label copied into the load balancing metadata of the pod's endpoints, for IngressRoute metadataMatch (may be repeated)").StringsVar(&subsetLabelsFlag)
+	serve.Flag("endpoint-include-not-ready", "Include the not-ready addresses of endpoints, marked unhealthy").BoolVar(&notReadyAddressesFlag)
+	serve.Flag("ingress-class-name", "Contour IngressClass name, or a comma separated list of names").StringVar(&reh.IngressClass)
 	serve.Flag("ingressroute-root-namespaces", "Restrict contour to searching these namespaces for root ingress routes").StringVar(&ingressrouteRootNamespaceFlag)
+	serve.Flag("default-backend-namespace", "Only Ingresses and IngressRoutes in this namespace may create the \"*\" virtual host").StringVar(&reh.DefaultBackendNamespace)
+	serve.Flag("disable-default-backend", "No Ingress or IngressRoute may create the \"*\" virtual host").BoolVar(&reh.DisableDefaultBackend)
+	serve.Flag("max-retry-buffer-bytes", "Largest retryBuffer maxBytes an IngressRoute route may request; if unset 1MiB").Uint32Var(&reh.MaxRetryBufferBytes)
+	serve.Flag("foreign-annotations", "Honour the annotations of other ingress controllers which Contour can translate").BoolVar(&reh.ForeignAnnotations)
+	serve.Flag("default-response", "Catch-all response for unclaimed hosts over HTTP, one of 404, 421, or route-to:<namespace>/<service>:<port>").StringVar(&defaultResponseFlag)
+	serve.Flag("leader-elect", "Elect a leader among the Contour replicas; only the leader writes IngressRoute status").BoolVar(&leaderElectFlag)
+	serve.Flag("leader-elect-namespace", "Namespace of the ConfigMap used for leader election").Default("heptio-contour").StringVar(&le.Namespace)
+	serve.Flag("leader-elect-configmap", "Name of the ConfigMap used for leader election").Default("contour").StringVar(&le.Name)
+	serve.Flag("leader-elect-identity", "Identity of this replica for leader election, defaults to the hostname").StringVar(&le.Identity)
+	serve.Flag("leader-elect-metrics", "Serve metrics only from the leader, so that replicas may share a metrics port; requires a separate --health-port").BoolVar(&leaderElectMetricsFlag)
+	serve.Flag("ingress-selector", "Restrict contour to Ingress and IngressRoute objects matching this label selector").StringVar(&ingressSelectorFlag)
+	serve.Flag("tls-secrets-only", "Watch only Secrets of type kubernetes.io/tls, ignoring certificates in Opaque Secrets").BoolVar(&tlsSecretsOnlyFlag)
+
+	validate.Flag("ingress-class-name", "Contour IngressClass name, or a comma separated list of names").StringVar(&reh.IngressClass)
+	validate.Flag("ingressroute-root-namespaces", "Restrict contour to searching these namespaces for root ingress routes").StringVar(&ingressrouteRootNamespaceFlag)
+	validate.Flag("disable-https", "Do not generate the HTTPS listener or route configuration, TLS is handled elsewhere").BoolVar(&ch.DisableHTTPS)
 
 	args := os.Args[1:]
 	switch kingpin.MustParse(app.Parse(args)) {
@@ -140,6 +234,9 @@ func main() {
 	case rds.FullCommand():
 		stream := client.RouteStream()
 		watchstream(stream, routeType, resources)
+	case validate.FullCommand():
+		reh.IngressRouteRootNamespaces = parseRootNamespaces(ingressrouteRootNamespaceFlag)
+		os.Exit(validateFiles(os.Stdout, *validateFilesArg, &reh, &ch))
 	case serve.FullCommand():
 		log.Infof("args: %v", args)
 		var g workgroup.Group
@@ -152,40 +249,190 @@ func main() {
 		flag.Parse()
 
 		reh.IngressRouteRootNamespaces = parseRootNamespaces(ingressrouteRootNamespaceFlag)
+		reh.InternalListener = ch.InternalHTTPPort != 0
 
-		client, contourClient := newClient(*kubeconfig, *inCluster)
+		// a rejected version is reported in the status of every
+		// valid IngressRoute until it is superseded.
+		ch.Rejections = nacks.Rejections
+		nacks.Notify = reh.Recompute
+
+		selector, err := labels.Parse(ingressSelectorFlag)
+		check(err)
+
+		reh.DefaultResponse, err = parseDefaultResponse(defaultResponseFlag)
+		check(err)
+
+		check(contour.ValidateAccessLogJSONFields(ch.AccessLogJSONFields))
+		check(contour.ValidateAccessLogExcludePaths(ch.AccessLogExcludePaths))
+		check(contour.ValidateAccessLogSampleRate(ch.AccessLogSampleRate))
+
+		ch.AdditionalHTTPListeners, err = parseAdditionalHTTPListeners(additionalHTTPListenersFlag)
+		check(err)
 
-		wl := log.WithField("context", "watch")
-		k8s.WatchServices(&g, client, wl, &reh)
-		k8s.WatchIngress(&g, client, wl, &reh)
-		k8s.WatchSecrets(&g, client, wl, &reh)
-		k8s.WatchIngressRoutes(&g, contourClient, wl, &reh)
+		ch.HTTPAddress, err = envoyHTTPAddress(ch.HTTPAddress, httpLoopbackFlag)
+		check(err)
 
-		ch.IngressRouteStatus = &k8s.IngressRouteStatus{
-			Client: contourClient,
+		if validateClustersFlag != "" {
+			enabled := validateClustersFlag == "true"
+			ch.ValidateClusters = &enabled
 		}
 
+		if ch.DisableHTTPSRedirect {
+			log.Warn("--disable-https-redirect is set: routes which request a redirect to HTTPS will be served over plain HTTP")
+		}
+
+		client, contourClient := newClient(*kubeconfig, *inCluster)
+
 		// Endpoints updates are handled directly by the EndpointsTranslator
 		// due to their high update rate and their orthogonal nature.
+		// It also watches Services to name unnamed endpoint ports.
 		et := &contour.EndpointsTranslator{
-			FieldLogger: log.WithField("context", "endpointstranslator"),
+			FieldLogger:        log.WithField("context", "endpointstranslator"),
+			DrainTimeout:       drainTimeoutFlag,
+			NotReadyAddresses:  notReadyAddressesFlag,
+			RemovalGracePeriod: ch.ClusterRemovalGracePeriod,
+			SubsetLabels:       subsetLabelsFlag,
+		}
+
+		// the status writer forgets deleted IngressRoutes.
+		irs := &k8s.IngressRouteStatus{
+			Client:      contourClient,
+			FieldLogger: log.WithField("context", "ingressroutestatus"),
+		}
+
+		// the event recorder forgets deleted Ingresses.
+		ies := &k8s.IngressEvents{
+			Client: client,
+		}
+
+		// resync timer disabled
+		factory := informers.NewSharedInformerFactory(client, 0)
+		contourFactory := contourinformers.NewSharedInformerFactory(contourClient, 0)
+		k8s.WatchServices(factory, &reh, et)
+		k8s.WatchIngress(factory, selector, &reh, cache.ResourceEventHandlerFuncs{DeleteFunc: ies.OnDelete})
+		k8s.WatchSecrets(factory, tlsSecretsOnlyFlag, &reh)
+		k8s.WatchIngressRoutes(contourFactory, selector, &reh, cache.ResourceEventHandlerFuncs{DeleteFunc: irs.OnDelete})
+		k8s.WatchEndpoints(factory, et, &reh)
+		if et.DrainTimeout > 0 || len(et.SubsetLabels) > 0 {
+			// pods are only watched when draining, or copying their
+			// labels into endpoint metadata, is enabled.
+			k8s.WatchPods(factory, et)
+		}
+		k8s.StartInformers(&g, log.WithField("context", "watch"), factory, contourFactory)
+
+		// a POST to /debug/resync recomputes the DAG and re-emits
+		// every cache, forcing connected Envoys to resync.
+		debugsvc.Resync = func() {
+			reh.Recompute()
+			// unchanged listeners are not re-emitted by Recompute.
+			ch.ListenerCache.Notify()
+			et.Notify()
+		}
+
+		// a GET to /debug/status reports the state of the last recompute
+		// and of every cache, and lists the Envoys which have lagged more than
+		// --xds-stale-node-lag behind the configuration sent to them.
+		var nodes grpc.Nodes
+		debugsvc.Status = func() interface{} {
+			s := ch.Snapshot()
+			s.Caches["endpoints"] = contour.SnapshotCache(et)
+			return struct {
+				contour.Snapshot
+				StaleNodes []grpc.StaleNode `json:"staleNodes"`
+			}{
+				Snapshot:   s,
+				StaleNodes: nodes.Stale(time.Now(), *xdsStaleNodeLag),
+			}
 		}
-		k8s.WatchEndpoints(&g, client, wl, et)
 
 		registry := prometheus.NewRegistry()
-		metricsvc.Registry = registry
 
 		// register detault process / go collectors
 		registry.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
 		registry.MustRegister(prometheus.NewGoCollector())
 
+		// unless configured otherwise health is served alongside metrics.
+		if healthep.Addr == "" {
+			healthep.Addr = metricsep.Addr
+		}
+		if healthep.Port == 0 {
+			healthep.Port = metricsep.Port
+		}
+		debugsvc.Register(httpsvcs.ServeMux(httpsvc.Debug))
+		metrics.RegisterMetrics(httpsvcs.ServeMux(httpsvc.Metrics), registry)
+		metrics.RegisterHealthCheck(httpsvcs.ServeMux(httpsvc.Health))
+
 		// register our custom metrics
 		metrics := metrics.NewMetrics(registry)
 		ch.Metrics = metrics
 		reh.Metrics = metrics
 
-		g.Add(debugsvc.Start)
-		g.Add(metricsvc.Start)
+		irs.Metrics = metrics
+		ch.IngressRouteStatus = irs
+		ch.IngressEvents = ies
+
+		// the stale nodes are counted, for contour_xds_stale_nodes, twice
+		// per --xds-stale-node-lag.
+		g.Add(func(stop <-chan struct{}) error {
+			nodes.Reconcile(stop, *xdsStaleNodeLag/2, *xdsStaleNodeLag, metrics)
+			return nil
+		})
+
+		// with --leader-elect every replica serves xDS, only the leader
+		// writes IngressRoute status and, with --leader-elect-metrics,
+		// serves metrics.
+		var leaderOnly *httpsvc.Service
+		if leaderElectFlag {
+			if le.Identity == "" {
+				le.Identity, err = os.Hostname()
+				check(err)
+			}
+			le.Client = client
+			ch.IsLeader = le.IsLeader
+
+			metricsmux := httpsvcs.ServeMux(httpsvc.Metrics)
+			if leaderElectMetricsFlag {
+				if metricsmux == httpsvcs.ServeMux(httpsvc.Health) || metricsmux == httpsvcs.ServeMux(httpsvc.Debug) {
+					check(fmt.Errorf("--leader-elect-metrics requires metrics to be served on their own address and port"))
+				}
+				for _, svc := range httpsvcs.Services() {
+					if &svc.ServeMux == metricsmux {
+						leaderOnly = svc
+					}
+				}
+			}
+
+			le.OnStartedLeading = func(stop <-chan struct{}) {
+				// statuses are only written by a recompute, so the new
+				// leader recomputes to write any it has missed.
+				reh.Recompute()
+				if leaderOnly != nil {
+					leaderOnly.Start(stop)
+				}
+			}
+			g.Add(le.Start)
+		}
+
+		for _, svc := range httpsvcs.Services() {
+			if svc == leaderOnly {
+				continue
+			}
+			g.Add(svc.Start)
+		}
+
+		// SIGINT or SIGTERM stops the group, shutting down the
+		// http and grpc servers.
+		g.Add(func(stop <-chan struct{}) error {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(c)
+			select {
+			case sig := <-c:
+				log.WithField("signal", sig).Info("shutting down")
+			case <-stop:
+			}
+			return nil
+		})
 
 		g.Add(func(stop <-chan struct{}) error {
 			log := log.WithField("context", "grpc")
@@ -203,16 +450,31 @@ func main() {
 				clusterType  = typePrefix + "Cluster"
 				routeType    = typePrefix + "RouteConfiguration"
 				listenerType = typePrefix + "Listener"
+				secretType   = typePrefix + "auth.Secret"
 			)
 			s := grpc.NewAPI(log, map[string]grpc.Cache{
 				clusterType:  &ch.ClusterCache,
 				routeType:    &ch.RouteCache,
 				listenerType: &ch.ListenerCache,
 				endpointType: et,
+				secretType:   &ch.SecretCache,
+			}, grpc.Options{
+				SendTimeout: *xdsSendTimeout,
+				Metrics:     metrics,
+				Nodes:       &nodes,
+				NACKs:       &nacks,
 			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				// end open streams, and stop gracefully, once
+				// the group is stopped.
+				<-stop
+				cancel()
+			}()
 			log.Println("started")
 			defer log.Println("stopped")
-			return s.Serve(l)
+			return s.Run(ctx, l)
 		})
 		g.Run()
 	default:
@@ -256,3 +518,74 @@ func parseRootNamespaces(rn string) []string {
 	}
 	return ns
 }
+
+// parseDefaultResponse parses the value of --default-response, either
+// the HTTP status 404 or 421, or route-to:<namespace>/<service>:<port>.
+func parseDefaultResponse(dr string) (*dag.DefaultResponse, error) {
+	switch dr {
+	case "":
+		return nil, nil
+	case "404":
+		return &dag.DefaultResponse{Status: http.StatusNotFound}, nil
+	case "421":
+		// misdirected request
+		return &dag.DefaultResponse{Status: 421}, nil
+	}
+	const prefix = "route-to:"
+	if !strings.HasPrefix(dr, prefix) {
+		return nil, fmt.Errorf("invalid default response %q: must be one of 404, 421, or %s<namespace>/<service>:<port>", dr, prefix)
+	}
+	target := strings.TrimPrefix(dr, prefix)
+	slash := strings.Index(target, "/")
+	colon := strings.LastIndex(target, ":")
+	if slash < 1 || colon < slash+2 || colon == len(target)-1 {
+		return nil, fmt.Errorf("invalid default response %q: target must be <namespace>/<service>:<port>", dr)
+	}
+	return &dag.DefaultResponse{
+		Namespace: target[:slash],
+		Name:      target[slash+1 : colon],
+		Port:      intstr.Parse(target[colon+1:]),
+	}, nil
+}
+
+// parseAdditionalHTTPListeners parses each port[:address] of the
+// --additional-http-listener flag. Each listener is named for its
+// port, so no two may share one.
+func parseAdditionalHTTPListeners(flags []string) ([]contour.HTTPListener, error) {
+	var listeners []contour.HTTPListener
+	seen := make(map[int]bool)
+	for _, f := range flags {
+		var l contour.HTTPListener
+		port := f
+		if i := strings.Index(f, ":"); i >= 0 {
+			port, l.Address = f[:i], f[i+1:]
+			if l.Address == "" {
+				return nil, fmt.Errorf("invalid additional HTTP listener %q: address must not be empty", f)
+			}
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return nil, fmt.Errorf("invalid additional HTTP listener %q: port must be in the range 1-65535", f)
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("invalid additional HTTP listener %q: port %d is already used", f, p)
+		}
+		seen[p] = true
+		l.Port = p
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// envoyHTTPAddress returns the address of the HTTP listener; the
+// loopback address if --envoy-http-loopback is set, so that only
+// the pod Envoy runs in as a sidecar can reach it.
+func envoyHTTPAddress(address string, loopback bool) (string, error) {
+	if !loopback {
+		return address, nil
+	}
+	if address != "" && address != contour.LOOPBACK_LISTENER_ADDRESS {
+		return "", fmt.Errorf("--envoy-http-loopback conflicts with --envoy-http-address=%s", address)
+	}
+	return contour.LOOPBACK_LISTENER_ADDRESS, nil
+}
@@ -16,6 +16,10 @@ package main
 import (
 	"reflect"
 	"testing"
+
+	"github.com/heptio/contour/internal/contour"
+	"github.com/heptio/contour/internal/dag"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func TestParseRootNamespaces(t *testing.T) {
@@ -50,3 +54,159 @@ func TestParseRootNamespaces(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDefaultResponse(t *testing.T) {
+	tests := map[string]struct {
+		input   string
+		want    *dag.DefaultResponse
+		wantErr bool
+	}{
+		"empty": {
+			input: "",
+			want:  nil,
+		},
+		"404": {
+			input: "404",
+			want:  &dag.DefaultResponse{Status: 404},
+		},
+		"421": {
+			input: "421",
+			want:  &dag.DefaultResponse{Status: 421},
+		},
+		"route to numeric port": {
+			input: "route-to:heptio-contour/default-backend:8080",
+			want: &dag.DefaultResponse{
+				Namespace: "heptio-contour",
+				Name:      "default-backend",
+				Port:      intstr.FromInt(8080),
+			},
+		},
+		"route to named port": {
+			input: "route-to:heptio-contour/default-backend:http",
+			want: &dag.DefaultResponse{
+				Namespace: "heptio-contour",
+				Name:      "default-backend",
+				Port:      intstr.FromString("http"),
+			},
+		},
+		"unsupported status": {
+			input:   "500",
+			wantErr: true,
+		},
+		"missing namespace": {
+			input:   "route-to:default-backend:8080",
+			wantErr: true,
+		},
+		"missing port": {
+			input:   "route-to:heptio-contour/default-backend",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseDefaultResponse(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseAdditionalHTTPListeners(t *testing.T) {
+	tests := map[string]struct {
+		input   []string
+		want    []contour.HTTPListener
+		wantErr bool
+	}{
+		"empty": {
+			input: nil,
+			want:  nil,
+		},
+		"port only": {
+			input: []string{"8081"},
+			want:  []contour.HTTPListener{{Port: 8081}},
+		},
+		"port and address": {
+			input: []string{"8081", "8082:10.0.0.1"},
+			want: []contour.HTTPListener{
+				{Port: 8081},
+				{Address: "10.0.0.1", Port: 8082},
+			},
+		},
+		"invalid port": {
+			input:   []string{"http"},
+			wantErr: true,
+		},
+		"port out of range": {
+			input:   []string{"65536"},
+			wantErr: true,
+		},
+		"empty address": {
+			input:   []string{"8081:"},
+			wantErr: true,
+		},
+		"duplicate port": {
+			input:   []string{"8081", "8081:10.0.0.1"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseAdditionalHTTPListeners(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEnvoyHTTPAddress(t *testing.T) {
+	tests := map[string]struct {
+		address  string
+		loopback bool
+		want     string
+		wantErr  bool
+	}{
+		"default": {
+			want: "",
+		},
+		"address": {
+			address: "10.0.0.1",
+			want:    "10.0.0.1",
+		},
+		"loopback": {
+			loopback: true,
+			want:     "127.0.0.1",
+		},
+		"loopback and loopback address": {
+			address:  "127.0.0.1",
+			loopback: true,
+			want:     "127.0.0.1",
+		},
+		"loopback and another address": {
+			address:  "10.0.0.1",
+			loopback: true,
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := envoyHTTPAddress(tc.address, tc.loopback)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error: %v, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/heptio/contour/internal/contour"
+	contourscheme "github.com/heptio/contour/internal/generated/clientset/versioned/scheme"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// validateFiles reports, on w, the problems of the objects in the YAML
+// files at paths, as contour serve would find them, and returns the
+// exit status: 1 if any problem is an error.
+func validateFiles(w io.Writer, paths []string, reh *contour.ResourceEventHandler, ch *contour.CacheHandler) int {
+	objs, err := readObjects(paths)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	status := 0
+	for _, p := range contour.Validate(reh, ch, objs) {
+		severity := "error"
+		if p.Warning {
+			severity = "warning"
+		} else {
+			status = 1
+		}
+		name := p.Name
+		if p.Namespace != "" {
+			name = p.Namespace + "/" + name
+		}
+		fmt.Fprintf(w, "%s: %s %s: %s\n", severity, p.Kind, name, p.Message)
+	}
+	return status
+}
+
+// readObjects decodes every Kubernetes object, of the kinds known to
+// client-go or to Contour, in the YAML documents of the files at paths.
+func readObjects(paths []string) ([]interface{}, error) {
+	s := runtime.NewScheme()
+	scheme.AddToScheme(s)
+	contourscheme.AddToScheme(s)
+	decoder := serializer.NewCodecFactory(s).UniversalDeserializer()
+
+	var objs []interface{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r := yaml.NewYAMLReader(bufio.NewReader(f))
+		for {
+			doc, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if len(strings.TrimSpace(string(doc))) == 0 {
+				continue
+			}
+			obj, _, err := decoder.Decode(doc, nil, nil)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			objs = append(objs, obj)
+		}
+		f.Close()
+	}
+	return objs, nil
+}
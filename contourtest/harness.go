@@ -0,0 +1,408 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package contourtest runs Contour's xDS server in process for tests.
+// Kubernetes objects are fed to a Harness, and the resources Contour
+// derives from them are fetched, or watched, over gRPC as Envoy would.
+package contourtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/heptio/contour/internal/contour"
+	"github.com/heptio/contour/internal/generated/clientset/versioned/fake"
+	cgrpc "github.com/heptio/contour/internal/grpc"
+	"github.com/heptio/contour/internal/k8s"
+	"github.com/heptio/contour/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"k8s.io/api/core/v1"
+)
+
+// The type URLs of the resources served by Contour.
+const (
+	typePrefix   = "type.googleapis.com/envoy.api.v2."
+	EndpointType = typePrefix + "ClusterLoadAssignment"
+	ClusterType  = typePrefix + "Cluster"
+	RouteType    = typePrefix + "RouteConfiguration"
+	ListenerType = typePrefix + "Listener"
+	SecretType   = typePrefix + "auth.Secret"
+)
+
+// timeout bounds each fetch, and each wait for a watched response.
+const timeout = time.Second
+
+// Harness is an in process Contour, serving xDS on a loopback port.
+type Harness struct {
+	t    *testing.T
+	reh  *contour.ResourceEventHandler
+	et   *contour.EndpointsTranslator
+	cc   *grpc.ClientConn
+	stop func()
+}
+
+// NewHarness starts a Harness, which logs to t. Each of opts may
+// configure the ResourceEventHandler, and through its Notifier the
+// CacheHandler, before the server starts. The Harness must be closed.
+func NewHarness(t *testing.T, opts ...func(*contour.ResourceEventHandler)) *Harness {
+	t.Helper()
+	log := logrus.New()
+	log.Out = &testWriter{t}
+
+	et := &contour.EndpointsTranslator{
+		FieldLogger: log,
+	}
+
+	ch := &contour.CacheHandler{
+		IngressRouteStatus: &k8s.IngressRouteStatus{
+			Client: fake.NewSimpleClientset(),
+		},
+		Metrics: metrics.NewMetrics(prometheus.NewRegistry()),
+	}
+
+	reh := &contour.ResourceEventHandler{
+		Notifier: ch,
+		Metrics:  ch.Metrics,
+	}
+
+	for _, opt := range opts {
+		opt(reh)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	check(t, err)
+	discard := logrus.New()
+	discard.Out = new(discardWriter)
+	// Resource types in xDS v2.
+	srv := cgrpc.NewAPI(discard, map[string]cgrpc.Cache{
+		ClusterType:  &ch.ClusterCache,
+		RouteType:    &ch.RouteCache,
+		ListenerType: &ch.ListenerCache,
+		EndpointType: et,
+		SecretType:   &ch.SecretCache,
+	}, cgrpc.Options{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.Serve(l)
+	}()
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	check(t, err)
+
+	return &Harness{
+		t:   t,
+		reh: reh,
+		et:  et,
+		cc:  cc,
+		stop: func() {
+			// close client connection
+			cc.Close()
+
+			// shut down listener, stop server and wait for it to stop
+			l.Close()
+			srv.Stop()
+			wg.Wait()
+		},
+	}
+}
+
+// Close stops the Harness.
+func (h *Harness) Close() {
+	h.stop()
+}
+
+// ClientConn returns the connection to the Harness's xDS server, for
+// requests the Harness does not make itself.
+func (h *Harness) ClientConn() *grpc.ClientConn {
+	return h.cc
+}
+
+// OnAdd adds obj, as if it were added to the Kubernetes API.
+func (h *Harness) OnAdd(obj interface{}) {
+	switch obj.(type) {
+	case *v1.Endpoints:
+		h.et.OnAdd(obj)
+	default:
+		h.reh.OnAdd(obj)
+	}
+}
+
+// OnUpdate replaces oldObj with newObj, as if it were updated in the
+// Kubernetes API.
+func (h *Harness) OnUpdate(oldObj, newObj interface{}) {
+	switch newObj.(type) {
+	case *v1.Endpoints:
+		h.et.OnUpdate(oldObj, newObj)
+	default:
+		h.reh.OnUpdate(oldObj, newObj)
+	}
+}
+
+// OnDelete deletes obj, as if it were deleted from the Kubernetes API.
+func (h *Harness) OnDelete(obj interface{}) {
+	switch obj.(type) {
+	case *v1.Endpoints:
+		h.et.OnDelete(obj)
+	default:
+		h.reh.OnDelete(obj)
+	}
+}
+
+// FetchCDS returns the current clusters, limited to the named
+// resources, rn, if any.
+func (h *Harness) FetchCDS(rn ...string) *v2.DiscoveryResponse {
+	h.t.Helper()
+	return h.fetch(ClusterType, rn)
+}
+
+// FetchRDS returns the current route configurations, limited to the
+// named resources, rn, if any.
+func (h *Harness) FetchRDS(rn ...string) *v2.DiscoveryResponse {
+	h.t.Helper()
+	return h.fetch(RouteType, rn)
+}
+
+// FetchLDS returns the current listeners, limited to the named
+// resources, rn, if any.
+func (h *Harness) FetchLDS(rn ...string) *v2.DiscoveryResponse {
+	h.t.Helper()
+	return h.fetch(ListenerType, rn)
+}
+
+// FetchEDS returns the current cluster load assignments, limited to
+// the named resources, rn, if any.
+func (h *Harness) FetchEDS(rn ...string) *v2.DiscoveryResponse {
+	h.t.Helper()
+	return h.fetch(EndpointType, rn)
+}
+
+// AssertCDS fails the test unless the current clusters are want.
+func (h *Harness) AssertCDS(want ...proto.Message) {
+	h.t.Helper()
+	h.AssertEqual(h.Response(ClusterType, want...), h.FetchCDS())
+}
+
+// AssertRDS fails the test unless the current route configurations
+// are want.
+func (h *Harness) AssertRDS(want ...proto.Message) {
+	h.t.Helper()
+	h.AssertEqual(h.Response(RouteType, want...), h.FetchRDS())
+}
+
+// AssertLDS fails the test unless the current listeners are want.
+func (h *Harness) AssertLDS(want ...proto.Message) {
+	h.t.Helper()
+	h.AssertEqual(h.Response(ListenerType, want...), h.FetchLDS())
+}
+
+// AssertEDS fails the test unless the current cluster load
+// assignments are want.
+func (h *Harness) AssertEDS(want ...proto.Message) {
+	h.t.Helper()
+	h.AssertEqual(h.Response(EndpointType, want...), h.FetchEDS())
+}
+
+// fetch returns the first response to a request for the typeURL
+// resources named rn.
+func (h *Harness) fetch(typeURL string, rn []string) *v2.DiscoveryResponse {
+	h.t.Helper()
+	w := h.Watch(typeURL, rn...)
+	defer w.Close()
+	return w.Next()
+}
+
+// Response returns the response Contour sends for resources of typeURL.
+func (h *Harness) Response(typeURL string, resources ...proto.Message) *v2.DiscoveryResponse {
+	h.t.Helper()
+	resp := &v2.DiscoveryResponse{
+		VersionInfo: "0",
+		TypeUrl:     typeURL,
+		Nonce:       "0",
+	}
+	for _, r := range resources {
+		resp.Resources = append(resp.Resources, h.Any(r))
+	}
+	return resp
+}
+
+// Any returns pb marshalled into a types.Any.
+func (h *Harness) Any(pb proto.Message) types.Any {
+	h.t.Helper()
+	any, err := types.MarshalAny(pb)
+	check(h.t, err)
+	return *any
+}
+
+// AssertEqual fails the test unless got is want.
+func (h *Harness) AssertEqual(want, got *v2.DiscoveryResponse) {
+	h.t.Helper()
+	m := proto.TextMarshaler{Compact: true, ExpandAny: true}
+	a := m.Text(want)
+	b := m.Text(got)
+	if a != b {
+		m := proto.TextMarshaler{
+			Compact:   false,
+			ExpandAny: true,
+		}
+		h.t.Fatalf("\nexpected:\n%v\ngot:\n%v", m.Text(want), m.Text(got))
+	}
+}
+
+// Watch is an open stream of the responses to a single request.
+type Watch struct {
+	h       *Harness
+	typeURL string
+	cancel  context.CancelFunc
+	resps   chan *v2.DiscoveryResponse
+	errc    chan error
+}
+
+// Watch opens a stream, as Envoy would, requesting the typeURL
+// resources named rn, or all of them if rn is empty. Contour sends a
+// response immediately and again each time the resources change. The
+// Watch must be closed.
+func (h *Harness) Watch(typeURL string, rn ...string) *Watch {
+	h.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	st, err := h.stream(ctx, typeURL)
+	if err != nil {
+		cancel()
+		h.t.Fatal(err)
+	}
+	err = st.Send(&v2.DiscoveryRequest{
+		TypeUrl:       typeURL,
+		ResourceNames: rn,
+	})
+	if err != nil {
+		cancel()
+		h.t.Fatal(err)
+	}
+	w := &Watch{
+		h:       h,
+		typeURL: typeURL,
+		cancel:  cancel,
+		resps:   make(chan *v2.DiscoveryResponse),
+		errc:    make(chan error, 1),
+	}
+	go func() {
+		for {
+			resp, err := st.Recv()
+			if err != nil {
+				w.errc <- err
+				return
+			}
+			select {
+			case w.resps <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Next returns the next response on the stream. It fails the test if
+// none arrives within a second.
+func (w *Watch) Next() *v2.DiscoveryResponse {
+	w.h.t.Helper()
+	select {
+	case resp := <-w.resps:
+		return resp
+	case err := <-w.errc:
+		w.h.t.Fatalf("%s stream: %v", w.typeURL, err)
+	case <-time.After(timeout):
+		w.h.t.Fatalf("%s stream: no response within %v", w.typeURL, timeout)
+	}
+	return nil
+}
+
+// AssertNext fails the test unless the next response on the stream
+// carries want.
+func (w *Watch) AssertNext(want ...proto.Message) {
+	w.h.t.Helper()
+	w.h.AssertEqual(w.h.Response(w.typeURL, want...), w.Next())
+}
+
+// AssertIdle fails the test if a response arrives on the stream
+// within d.
+func (w *Watch) AssertIdle(d time.Duration) {
+	w.h.t.Helper()
+	select {
+	case resp := <-w.resps:
+		w.h.t.Fatalf("%s stream: unexpected response: %v", w.typeURL, resp)
+	case <-time.After(d):
+	}
+}
+
+// Close closes the stream.
+func (w *Watch) Close() {
+	w.cancel()
+}
+
+type grpcStream interface {
+	Send(*v2.DiscoveryRequest) error
+	Recv() (*v2.DiscoveryResponse, error)
+}
+
+// stream opens the xDS stream of typeURL resources.
+func (h *Harness) stream(ctx context.Context, typeURL string) (grpcStream, error) {
+	switch typeURL {
+	case ClusterType:
+		return v2.NewClusterDiscoveryServiceClient(h.cc).StreamClusters(ctx)
+	case RouteType:
+		return v2.NewRouteDiscoveryServiceClient(h.cc).StreamRoutes(ctx)
+	case ListenerType:
+		return v2.NewListenerDiscoveryServiceClient(h.cc).StreamListeners(ctx)
+	case EndpointType:
+		return v2.NewEndpointDiscoveryServiceClient(h.cc).StreamEndpoints(ctx)
+	case SecretType:
+		return discovery.NewSecretDiscoveryServiceClient(h.cc).StreamSecrets(ctx)
+	default:
+		return nil, fmt.Errorf("no stream for type URL %q", typeURL)
+	}
+}
+
+type testWriter struct {
+	*testing.T
+}
+
+func (t *testWriter) Write(buf []byte) (int, error) {
+	t.Logf("%s", buf)
+	return len(buf), nil
+}
+
+type discardWriter struct {
+}
+
+func (d *discardWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/heptio/contour/internal/dag"
+)
+
+// Access log formats.
+const (
+	// ACCESS_LOG_FORMAT_ENVOY writes Envoy's default text access log.
+	ACCESS_LOG_FORMAT_ENVOY = "envoy"
+
+	// ACCESS_LOG_FORMAT_JSON writes one JSON object per request.
+	ACCESS_LOG_FORMAT_JSON = "json"
+)
+
+// DEFAULT_ACCESS_LOG_JSON_FIELDS maps each JSON access log key to the
+// Envoy command operator which supplies its value.
+var DEFAULT_ACCESS_LOG_JSON_FIELDS = map[string]string{
+	"@timestamp":                "START_TIME",
+	"authority":                 "REQ(:AUTHORITY)",
+	"bytes_received":            "BYTES_RECEIVED",
+	"bytes_sent":                "BYTES_SENT",
+	"downstream_local_address":  "DOWNSTREAM_LOCAL_ADDRESS",
+	"downstream_remote_address": "DOWNSTREAM_REMOTE_ADDRESS",
+	"duration":                  "DURATION",
+	"method":                    "REQ(:METHOD)",
+	"path":                      "REQ(X-ENVOY-ORIGINAL-PATH?:PATH)",
+	"protocol":                  "PROTOCOL",
+	"request_id":                "REQ(X-REQUEST-ID)",
+	"requested_server_name":     "REQUESTED_SERVER_NAME",
+	"response_code":             "RESPONSE_CODE",
+	"response_flags":            "RESPONSE_FLAGS",
+	"upstream_cluster":          "UPSTREAM_CLUSTER",
+	"upstream_host":             "UPSTREAM_HOST",
+	"upstream_local_address":    "UPSTREAM_LOCAL_ADDRESS",
+	"user_agent":                "REQ(USER-AGENT)",
+	"x_forwarded_for":           "REQ(X-FORWARDED-FOR)",
+}
+
+// operator arguments, eg. the header name in REQ(X-REQUEST-ID).
+const (
+	argNone = iota
+	argOptional
+	argRequired
+)
+
+// operators lists the Envoy access log command operators and whether
+// each takes an argument.
+var operators = map[string]int{
+	"BYTES_RECEIVED":                         argNone,
+	"BYTES_SENT":                             argNone,
+	"DOWNSTREAM_LOCAL_ADDRESS":               argNone,
+	"DOWNSTREAM_REMOTE_ADDRESS":              argNone,
+	"DOWNSTREAM_REMOTE_ADDRESS_WITHOUT_PORT": argNone,
+	"DURATION":                               argNone,
+	"DYNAMIC_METADATA":                       argRequired,
+	"PROTOCOL":                               argNone,
+	"REQ":                                    argRequired,
+	"REQUESTED_SERVER_NAME":                  argNone,
+	"RESP":                                   argRequired,
+	"RESPONSE_CODE":                          argNone,
+	"RESPONSE_FLAGS":                         argNone,
+	"START_TIME":                             argOptional,
+	"TRAILER":                                argRequired,
+	"UPSTREAM_CLUSTER":                       argNone,
+	"UPSTREAM_HOST":                          argNone,
+	"UPSTREAM_LOCAL_ADDRESS":                 argNone,
+}
+
+// ValidateAccessLogJSONFields returns an error if any key of fields
+// is empty or any value is not a known Envoy command operator.
+func ValidateAccessLogJSONFields(fields map[string]string) error {
+	for _, k := range sortedkeys(fields) {
+		if k == "" {
+			return fmt.Errorf("access log JSON field name must not be empty")
+		}
+		if err := validateOperator(fields[k]); err != nil {
+			return fmt.Errorf("access log JSON field %q: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// ValidateAccessLogExcludePaths returns an error if any of paths is
+// not an absolute request path.
+func ValidateAccessLogExcludePaths(paths []string) error {
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("access log exclude path %q must begin with /", p)
+		}
+	}
+	return nil
+}
+
+// validateOperator returns an error if op is not a command operator,
+// without its surrounding %'s, such as DURATION or REQ(:METHOD).
+func validateOperator(op string) error {
+	name, arg := op, ""
+	if i := strings.IndexByte(op, '('); i >= 0 {
+		if !strings.HasSuffix(op, ")") {
+			return fmt.Errorf("command operator %q is missing a closing parenthesis", op)
+		}
+		name, arg = op[:i], op[i+1:len(op)-1]
+		if arg == "" {
+			return fmt.Errorf("command operator %q has an empty argument", op)
+		}
+	}
+	kind, ok := operators[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown command operator %q", name)
+	case kind == argNone && arg != "":
+		return fmt.Errorf("command operator %q does not take an argument", name)
+	case kind == argRequired && arg == "":
+		return fmt.Errorf("command operator %q requires an argument", name)
+	}
+	return nil
+}
+
+// jsonaccesslog returns a file access log which writes the command
+// operators of fields as a JSON object to path.
+func jsonaccesslog(path string, fields map[string]string) *types.Value {
+	format := make(map[string]*types.Value, len(fields))
+	for k, op := range fields {
+		format[k] = sv("%" + op + "%")
+	}
+	return lv(
+		st(map[string]*types.Value{
+			"name": sv(accessLog),
+			"config": st(map[string]*types.Value{
+				"path":        sv(path),
+				"json_format": st(format),
+			}),
+		}),
+	)
+}
+
+// accessLogExclusion is a request path which is not access logged,
+// for requests to host or, if host is empty, to any host.
+type accessLogExclusion struct {
+	host, path string
+}
+
+// accesslogexclusions returns the paths of paths, excluded for every
+// host, and those excluded by each virtual host of root, ordered and
+// without duplicates.
+func accesslogexclusions(paths []string, root dag.Visitable) []accessLogExclusion {
+	seen := make(map[accessLogExclusion]bool)
+	add := func(host string, paths []string) {
+		if host == "*" {
+			host = ""
+		}
+		for _, p := range paths {
+			seen[accessLogExclusion{host: host, path: p}] = true
+		}
+	}
+	add("", paths)
+	root.Visit(func(vh dag.Vertex) {
+		switch vh := vh.(type) {
+		case *dag.VirtualHost:
+			add(vh.FQDN(), vh.AccessLogExcludePaths)
+		case *dag.SecureVirtualHost:
+			add(vh.FQDN(), vh.AccessLogExcludePaths)
+		}
+	})
+	exclusions := make([]accessLogExclusion, 0, len(seen))
+	for e := range seen {
+		exclusions = append(exclusions, e)
+	}
+	sort.Slice(exclusions, func(i, j int) bool {
+		if exclusions[i].host == exclusions[j].host {
+			return exclusions[i].path < exclusions[j].path
+		}
+		return exclusions[i].host < exclusions[j].host
+	})
+	return exclusions
+}
+
+// ValidateAccessLogSampleRate returns an error if rate is finer than
+// the one in a million requests Envoy can sample.
+func ValidateAccessLogSampleRate(rate uint32) error {
+	if rate > maxAccessLogSampleRate {
+		return fmt.Errorf("access log sample rate %d must not exceed %d", rate, maxAccessLogSampleRate)
+	}
+	return nil
+}
+
+// maxAccessLogSampleRate is the largest access log sample rate, the
+// denominator of the fraction of requests a runtime filter samples.
+const maxAccessLogSampleRate = 1000000
+
+// accessLogSample is the rate, one in rate requests, at which requests
+// to host or, if host is empty, to any other host are access logged.
+type accessLogSample struct {
+	host string
+	rate uint32
+}
+
+// accesslogsamples returns the sample rate of every host, rate, and
+// that of each virtual host of root which overrides it, ordered by host.
+func accesslogsamples(rate uint32, root dag.Visitable) []accessLogSample {
+	rates := map[string]uint32{"": rate}
+	add := func(host string, rate uint32) {
+		if host != "*" && rate > 0 {
+			rates[host] = rate
+		}
+	}
+	root.Visit(func(vh dag.Vertex) {
+		switch vh := vh.(type) {
+		case *dag.VirtualHost:
+			add(vh.FQDN(), vh.AccessLogSampleRate)
+		case *dag.SecureVirtualHost:
+			add(vh.FQDN(), vh.AccessLogSampleRate)
+		}
+	})
+	samples := make([]accessLogSample, 0, len(rates))
+	for host, rate := range rates {
+		samples = append(samples, accessLogSample{host: host, rate: rate})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].host < samples[j].host
+	})
+	return samples
+}
+
+// accesslogfilter returns the access log filter which passes every
+// request but those matching exclusions, those not sampled at the rate
+// of samples for their host and, if errorsOnly is true, those whose
+// response status is below 400, or nil if it would pass every request.
+// Envoy applies the filter to every access log of the HTTP connection
+// manager, whatever its sink.
+func accesslogfilter(exclusions []accessLogExclusion, samples []accessLogSample, errorsOnly bool) *types.Value {
+	var filters []*types.Value
+	for _, e := range exclusions {
+		f := headerfilter(":path", e.path)
+		if e.host != "" {
+			// log unless both the host and the path match.
+			f = st(map[string]*types.Value{
+				"or_filter": st(map[string]*types.Value{
+					"filters": lv(headerfilter(":authority", e.host), f),
+				}),
+			})
+		}
+		filters = append(filters, f)
+	}
+	if f := samplefilter(samples); f != nil {
+		filters = append(filters, f)
+	}
+	if errorsOnly {
+		filters = append(filters, st(map[string]*types.Value{
+			"status_code_filter": st(map[string]*types.Value{
+				"comparison": st(map[string]*types.Value{
+					"op": sv("GE"),
+					"value": st(map[string]*types.Value{
+						"default_value": nv(400),
+						"runtime_key":   sv("contour.access_log.min_status"),
+					}),
+				}),
+			}),
+		}))
+	}
+	return andfilter(filters...)
+}
+
+// samplefilter returns the access log filter which passes one in every
+// rate requests to each host of samples, or nil if every request passes.
+// A request whose :authority is none of the hosts of samples is sampled
+// at the rate of the sample whose host is empty.
+func samplefilter(samples []accessLogSample) *types.Value {
+	sampled := false
+	for _, s := range samples {
+		sampled = sampled || s.rate > 1
+	}
+	if !sampled {
+		return nil
+	}
+	var hosts, others []*types.Value
+	for _, s := range samples {
+		if s.host == "" {
+			others = append(others, runtimefilter("contour.access_log.sample_rate", s.rate))
+			continue
+		}
+		match := st(map[string]*types.Value{
+			"header_filter": st(map[string]*types.Value{
+				"header": st(map[string]*types.Value{
+					"name":        sv(":authority"),
+					"exact_match": sv(s.host),
+				}),
+			}),
+		})
+		hosts = append(hosts, andfilter(match, runtimefilter("contour.access_log.sample_rate."+s.host, s.rate)))
+		others = append(others, headerfilter(":authority", s.host))
+	}
+	if len(hosts) == 0 {
+		return andfilter(others...)
+	}
+	// log a request to an overriding host at its own rate, or any other
+	// request at the rate of every host.
+	return st(map[string]*types.Value{
+		"or_filter": st(map[string]*types.Value{
+			"filters": lv(append(hosts, andfilter(others...))...),
+		}),
+	})
+}
+
+// runtimefilter returns an access log filter which passes one in every
+// rate requests, adjustable with the runtime key, or nil if rate is not
+// more than one.
+func runtimefilter(key string, rate uint32) *types.Value {
+	if rate <= 1 {
+		return nil
+	}
+	return st(map[string]*types.Value{
+		"runtime_filter": st(map[string]*types.Value{
+			"runtime_key": sv(key),
+			"percent_sampled": st(map[string]*types.Value{
+				"numerator":   nv(float64(maxAccessLogSampleRate / rate)),
+				"denominator": sv("MILLION"),
+			}),
+		}),
+	})
+}
+
+// andfilter returns an access log filter which passes requests passed
+// by every non nil filter of filters, or nil if there are none.
+func andfilter(filters ...*types.Value) *types.Value {
+	var fs []*types.Value
+	for _, f := range filters {
+		if f != nil {
+			fs = append(fs, f)
+		}
+	}
+	switch len(fs) {
+	case 0:
+		return nil
+	case 1:
+		return fs[0]
+	default:
+		return st(map[string]*types.Value{
+			"and_filter": st(map[string]*types.Value{
+				"filters": lv(fs...),
+			}),
+		})
+	}
+}
+
+// headerfilter returns an access log filter which passes requests
+// whose header name is not exactly value.
+func headerfilter(name, value string) *types.Value {
+	return st(map[string]*types.Value{
+		"header_filter": st(map[string]*types.Value{
+			"header": st(map[string]*types.Value{
+				"name":         sv(name),
+				"exact_match":  sv(value),
+				"invert_match": bv(true),
+			}),
+		}),
+	})
+}
+
+func sortedkeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import "testing"
+
+func TestValidateAccessLogJSONFields(t *testing.T) {
+	tests := map[string]struct {
+		fields map[string]string
+		want   string
+	}{
+		"default fields": {
+			fields: DEFAULT_ACCESS_LOG_JSON_FIELDS,
+		},
+		"custom fields": {
+			fields: map[string]string{
+				"ts":     "START_TIME(%s.%3f)",
+				"method": "REQ(:METHOD)",
+				"status": "RESPONSE_CODE",
+			},
+		},
+		"empty key": {
+			fields: map[string]string{
+				"": "DURATION",
+			},
+			want: "access log JSON field name must not be empty",
+		},
+		"unknown operator": {
+			fields: map[string]string{
+				"duration": "ELAPSED",
+			},
+			want: `access log JSON field "duration": unknown command operator "ELAPSED"`,
+		},
+		"missing argument": {
+			fields: map[string]string{
+				"method": "REQ",
+			},
+			want: `access log JSON field "method": command operator "REQ" requires an argument`,
+		},
+		"unexpected argument": {
+			fields: map[string]string{
+				"status": "RESPONSE_CODE(200)",
+			},
+			want: `access log JSON field "status": command operator "RESPONSE_CODE" does not take an argument`,
+		},
+		"unbalanced parenthesis": {
+			fields: map[string]string{
+				"method": "REQ(:METHOD",
+			},
+			want: `access log JSON field "method": command operator "REQ(:METHOD" is missing a closing parenthesis`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got string
+			if err := ValidateAccessLogJSONFields(tc.fields); err != nil {
+				got = err.Error()
+			}
+			if tc.want != got {
+				t.Fatalf("expected: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@
 package contour
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
@@ -43,15 +44,20 @@ func (c *cache) remove(name string) {
 	c.mu.Unlock()
 }
 
-// Values returns a slice of the value stored in the cache.
+// Values returns a slice of the value stored in the cache, sorted by name.
 func (c *cache) Values(filter func(string) bool) []proto.Message {
 	c.mu.Lock()
-	values := make([]proto.Message, 0, len(c.entries))
-	for n, v := range c.entries {
+	names := make([]string, 0, len(c.entries))
+	for n := range c.entries {
 		if filter(n) {
-			values = append(values, v)
+			names = append(names, n)
 		}
 	}
+	sort.Strings(names)
+	values := make([]proto.Message, 0, len(names))
+	for _, n := range names {
+		values = append(values, c.entries[n])
+	}
 	c.mu.Unlock()
 	return values
 }
@@ -61,18 +67,43 @@ type clusterLoadAssignmentCache struct {
 	cache
 }
 
-// Add adds an entry to the cache. If a ClusterLoadAssignment with the same
-// name exists, it is replaced.
-func (c *clusterLoadAssignmentCache) Add(assignments ...*v2.ClusterLoadAssignment) {
-	for _, a := range assignments {
-		c.insert(a.ClusterName, a)
+// Update removes the named entries from the cache, then adds
+// assignments, replacing any ClusterLoadAssignment of the same name,
+// as a single change. Assignments equal to those they replace, and
+// names not present in the cache, are ignored. Update reports whether
+// the contents of the cache changed.
+//
+// Values never observes the cache part way through an Update, so a
+// ClusterLoadAssignment which is both removed and added is never
+// observed missing.
+func (c *clusterLoadAssignmentCache) Update(assignments []*v2.ClusterLoadAssignment, remove []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]proto.Message)
 	}
-}
 
-// Remove removes the named entry from the cache. If the entry
-// is not present in the cache, the operation is a no-op.
-func (c *clusterLoadAssignmentCache) Remove(names ...string) {
-	for _, n := range names {
-		c.remove(n)
+	added := make(map[string]bool, len(assignments))
+	for _, a := range assignments {
+		added[a.ClusterName] = true
+	}
+	changed := false
+	for _, name := range remove {
+		if added[name] {
+			// replaced below.
+			continue
+		}
+		if _, ok := c.entries[name]; ok {
+			delete(c.entries, name)
+			changed = true
+		}
+	}
+	for _, a := range assignments {
+		if old, ok := c.entries[a.ClusterName]; ok && proto.Equal(old, a) {
+			continue
+		}
+		c.entries[a.ClusterName] = a
+		changed = true
 	}
+	return changed
 }
@@ -14,11 +14,15 @@
 package contour
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	"github.com/gogo/protobuf/proto"
 )
 
@@ -169,3 +173,151 @@ func TestCacheValues(t *testing.T) {
 		})
 	}
 }
+
+func TestClusterLoadAssignmentCacheUpdate(t *testing.T) {
+	cla := func(name string, addrs ...string) *v2.ClusterLoadAssignment {
+		var lbendpoints []endpoint.LbEndpoint
+		for _, a := range addrs {
+			lbendpoints = append(lbendpoints, lbendpoint(a, 8080))
+		}
+		return clusterloadassignment(name, lbendpoints...)
+	}
+
+	var c clusterLoadAssignmentCache
+	if !c.Update([]*v2.ClusterLoadAssignment{cla("default/a", "10.0.0.1"), cla("default/b", "10.0.0.2")}, nil) {
+		t.Fatal("expected adding to an empty cache to change it")
+	}
+
+	tests := []struct {
+		name   string
+		add    []*v2.ClusterLoadAssignment
+		remove []string
+		want   bool
+	}{{
+		name: "equal assignment",
+		add:  []*v2.ClusterLoadAssignment{cla("default/a", "10.0.0.1")},
+		want: false,
+	}, {
+		name:   "missing name",
+		remove: []string{"default/c"},
+		want:   false,
+	}, {
+		name:   "removed and added unchanged",
+		add:    []*v2.ClusterLoadAssignment{cla("default/a", "10.0.0.1")},
+		remove: []string{"default/a"},
+		want:   false,
+	}, {
+		name: "changed assignment",
+		add:  []*v2.ClusterLoadAssignment{cla("default/a", "10.0.0.3")},
+		want: true,
+	}, {
+		name:   "removed",
+		remove: []string{"default/b"},
+		want:   true,
+	}}
+
+	for _, tc := range tests {
+		if got := c.Update(tc.add, tc.remove); got != tc.want {
+			t.Fatalf("%s: expected changed %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	want := []proto.Message{cla("default/a", "10.0.0.3")}
+	got := c.Values(func(string) bool { return true })
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+}
+
+// TestClusterLoadAssignmentCacheUpdateNeverOmits applies random updates
+// while concurrently taking snapshots of the cache, and checks that no
+// snapshot omits an assignment present both before and after the update
+// in progress.
+func TestClusterLoadAssignmentCacheUpdateNeverOmits(t *testing.T) {
+	const (
+		names   = 8
+		updates = 500
+		readers = 4
+	)
+	r := rand.New(rand.NewSource(1))
+	name := func(i int) string { return fmt.Sprintf("default/svc-%d/80", i) }
+
+	var c clusterLoadAssignmentCache
+	present := make(map[string]bool)
+	for i := 0; i < updates; i++ {
+		// replace a random subset of the names, with a new address,
+		// and remove another.
+		var add []*v2.ClusterLoadAssignment
+		var remove []string
+		after := make(map[string]bool)
+		for n := range present {
+			after[n] = true
+		}
+		for j := 0; j < names; j++ {
+			switch r.Intn(3) {
+			case 0:
+				add = append(add, clusterloadassignment(name(j), lbendpoint(fmt.Sprintf("10.0.0.%d", r.Intn(4)), 8080)))
+				after[name(j)] = true
+				if r.Intn(2) == 0 {
+					// removed, then replaced, in the same update.
+					remove = append(remove, name(j))
+				}
+			case 1:
+				remove = append(remove, name(j))
+				delete(after, name(j))
+			}
+		}
+
+		// must are the names present both before and after.
+		must := make(map[string]bool)
+		for n := range present {
+			if after[n] {
+				must[n] = true
+			}
+		}
+
+		done := make(chan struct{})
+		errc := make(chan error, readers)
+		var wg sync.WaitGroup
+		for k := 0; k < readers; k++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					seen := make(map[string]bool)
+					for _, v := range c.Values(func(string) bool { return true }) {
+						seen[v.(*v2.ClusterLoadAssignment).ClusterName] = true
+					}
+					for n := range must {
+						if !seen[n] {
+							errc <- fmt.Errorf("update %d: snapshot omits %q", i, n)
+							return
+						}
+					}
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+			}()
+		}
+		c.Update(add, remove)
+		close(done)
+		wg.Wait()
+		select {
+		case err := <-errc:
+			t.Fatal(err)
+		default:
+		}
+
+		present = after
+		got := make(map[string]bool)
+		for _, v := range c.Values(func(string) bool { return true }) {
+			got[v.(*v2.ClusterLoadAssignment).ClusterName] = true
+		}
+		if !reflect.DeepEqual(present, got) {
+			t.Fatalf("update %d: expected %v, got %v", i, present, got)
+		}
+	}
+}
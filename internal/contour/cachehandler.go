@@ -17,6 +17,11 @@
 package contour
 
 import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/heptio/contour/internal/dag"
 	"github.com/heptio/contour/internal/k8s"
 	"github.com/heptio/contour/internal/metrics"
@@ -29,10 +34,55 @@ type CacheHandler struct {
 	ListenerCache
 	RouteCache
 	ClusterCache
+	SecretCache
+
+	// DisableHTTPS, if true, suppresses the HTTPS listener and the
+	// ingress_https route configuration, for example when TLS is
+	// terminated before traffic reaches Envoy.
+	DisableHTTPS bool
+
+	// RouteConfigNames names the route configurations served over
+	// RDS and fetched by the listeners.
+	RouteConfigNames RouteConfigNames
+
+	// ClusterRemovalGracePeriod, if non zero, is how long a cluster
+	// is kept, draining, after the object it was generated from
+	// disappears, so Envoy does not abort requests in flight to it.
+	// A cluster which is generated again during its grace period
+	// is no longer draining.
+	ClusterRemovalGracePeriod time.Duration
 
 	IngressRouteStatus *k8s.IngressRouteStatus
+
+	// IsLeader, if set, reports whether this Contour is the leader
+	// of its replicas. Only the leader writes IngressRoute status.
+	IsLeader func() bool
+
+	// Rejections, if set, describes the configuration Envoy has
+	// rejected, which the status of each valid IngressRoute reports.
+	Rejections func() []string
+
+	// IngressEvents, if set, records an Event against each Ingress
+	// skipped while building the DAG.
+	IngressEvents *k8s.IngressEvents
+
 	logrus.FieldLogger
 	*metrics.Metrics
+
+	mu sync.Mutex
+
+	// clusters are the clusters generated by the last update,
+	// draining are those since removed which are yet to expire.
+	clusters map[string]*v2.Cluster
+	draining map[string]*v2.Cluster
+	removals scheduler
+
+	// last records the state of the last recompute for Snapshot.
+	last recompute
+
+	// serviceWarnings are the Service warnings logged by the last
+	// recompute.
+	serviceWarnings map[string]bool
 }
 
 type statusable interface {
@@ -42,27 +92,94 @@ type statusable interface {
 func (ch *CacheHandler) OnChange(b *dag.Builder) {
 	timer := prometheus.NewTimer(ch.CacheHandlerOnUpdateSummary)
 	defer timer.ObserveDuration()
-	dag := b.Build()
-	ch.setIngressRouteStatus(dag)
-	ch.updateListeners(dag)
-	ch.updateRoutes(dag)
-	ch.updateClusters(dag)
-	ch.updateIngressRouteMetric(dag)
+	rebuild := prometheus.NewTimer(ch.DAGRebuildHistogram)
+	defer rebuild.ObserveDuration()
+	start := time.Now()
+	d := b.Build()
+	ch.setIngressRouteStatus(d)
+	ch.writeIngressEvents(d)
+	ch.logServiceWarnings(d)
+	var v dag.Visitable = d
+	if ch.DisableHTTPS {
+		v = insecureOnly{v}
+	}
+	ch.updateListeners(v)
+	ch.updateRoutes(v)
+	ch.updateClusters(v)
+	ch.updateSecrets(v)
+	ch.updateIngressRouteMetric(d)
+	ch.recordRecompute(d, time.Since(start))
+}
+
+// insecureOnly is a dag.Visitable which skips SecureVirtualHosts.
+type insecureOnly struct {
+	dag.Visitable
+}
+
+func (i insecureOnly) Visit(fn func(dag.Vertex)) {
+	i.Visitable.Visit(func(v dag.Vertex) {
+		if _, ok := v.(*dag.SecureVirtualHost); ok {
+			return
+		}
+		fn(v)
+	})
 }
 
 func (ch *CacheHandler) setIngressRouteStatus(st statusable) {
+	if ch.IsLeader != nil && !ch.IsLeader() {
+		return
+	}
+	var rejections []string
+	if ch.Rejections != nil {
+		rejections = ch.Rejections()
+	}
 	for _, s := range st.Statuses() {
-		err := ch.IngressRouteStatus.SetStatus(s.Status, s.Description, s.Object)
+		description := s.Description
+		if s.Status == dag.StatusValid && len(rejections) > 0 {
+			// Envoy does not say which object is at fault, so
+			// every IngressRoute it has not applied says so.
+			description += ", but Envoy rejected the configuration, " + strings.Join(rejections, ", ")
+		}
+		err := ch.IngressRouteStatus.SetStatus(s.Status, description, s.Object)
 		if err != nil {
-			ch.Errorf("Error Setting Status of IngressRoute: ", err)
+			ch.WithError(err).Errorf("error setting status of IngressRoute %s/%s", s.Object.Namespace, s.Object.Name)
 		}
 	}
 }
 
+func (ch *CacheHandler) writeIngressEvents(d *dag.DAG) {
+	if ch.IngressEvents == nil {
+		return
+	}
+	for _, w := range d.Warnings() {
+		if err := ch.IngressEvents.Warning(w.Object, w.Reason, w.Message); err != nil {
+			ch.WithError(err).Errorf("error recording event for Ingress %s/%s", w.Object.Namespace, w.Object.Name)
+		}
+	}
+}
+
+// logServiceWarnings logs each of d's ServiceWarnings which the last
+// recompute did not, so that a misconfigured Service is logged once
+// rather than on every change.
+func (ch *CacheHandler) logServiceWarnings(d *dag.DAG) {
+	logged := make(map[string]bool)
+	for _, w := range d.ServiceWarnings() {
+		service := w.Object.Namespace + "/" + w.Object.Name
+		key := service + " " + w.Message
+		logged[key] = true
+		if ch.serviceWarnings[key] {
+			continue
+		}
+		ch.WithField("service", service).WithField("reason", w.Reason).Warn(w.Message)
+	}
+	ch.serviceWarnings = logged
+}
+
 func (ch *CacheHandler) updateListeners(v dag.Visitable) {
 	lv := listenerVisitor{
 		ListenerCache: &ch.ListenerCache,
 		Visitable:     v,
+		names:         ch.RouteConfigNames,
 	}
 	ch.ListenerCache.Update(lv.Visit())
 }
@@ -71,6 +188,7 @@ func (ch *CacheHandler) updateRoutes(v dag.Visitable) {
 	rv := routeVisitor{
 		RouteCache: &ch.RouteCache,
 		Visitable:  v,
+		names:      ch.RouteConfigNames,
 	}
 	routes := rv.Visit()
 	ch.RouteCache.Update(routes)
@@ -81,7 +199,67 @@ func (ch *CacheHandler) updateClusters(v dag.Visitable) {
 		ClusterCache: &ch.ClusterCache,
 		Visitable:    v,
 	}
-	ch.clusterCache.Update(cv.Visit())
+	clusters := cv.Visit()
+	if ch.ClusterRemovalGracePeriod <= 0 {
+		ch.clusterCache.Update(clusters)
+		return
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for name := range clusters {
+		if _, ok := ch.draining[name]; ok {
+			// regenerated during its grace period.
+			delete(ch.draining, name)
+			ch.removals.Cancel(name)
+		}
+	}
+	for name, c := range ch.clusters {
+		if _, ok := clusters[name]; ok {
+			continue
+		}
+		if ch.draining == nil {
+			ch.draining = make(map[string]*v2.Cluster)
+		}
+		ch.draining[name] = c
+		name := name
+		ch.removals.Schedule(name, ch.ClusterRemovalGracePeriod, func() { ch.expireCluster(name) })
+	}
+	ch.clusters = clusters
+	ch.updateClusterCache()
+}
+
+// expireCluster removes the draining cluster name from the cluster cache.
+func (ch *CacheHandler) expireCluster(name string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if _, ok := ch.draining[name]; !ok {
+		// regenerated after the timer fired.
+		return
+	}
+	delete(ch.draining, name)
+	ch.updateClusterCache()
+}
+
+// updateClusterCache replaces the contents of the cluster cache with
+// the current and draining clusters. ch.mu must be held.
+func (ch *CacheHandler) updateClusterCache() {
+	clusters := make(map[string]*v2.Cluster, len(ch.clusters)+len(ch.draining))
+	for name, c := range ch.draining {
+		clusters[name] = c
+	}
+	for name, c := range ch.clusters {
+		clusters[name] = c
+	}
+	ch.clusterCache.Update(clusters)
+}
+
+func (ch *CacheHandler) updateSecrets(v dag.Visitable) {
+	sv := secretVisitor{
+		SecretCache: &ch.SecretCache,
+		Visitable:   v,
+	}
+	ch.secretCache.Update(sv.Visit())
 }
 
 func (ch *CacheHandler) updateIngressRouteMetric(st statusable) {
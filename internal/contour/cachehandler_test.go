@@ -14,13 +14,26 @@
 package contour
 
 import (
+	"bytes"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/gogo/protobuf/proto"
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
 	"github.com/heptio/contour/internal/dag"
+	"github.com/heptio/contour/internal/generated/clientset/versioned/fake"
+	"github.com/heptio/contour/internal/k8s"
 	"github.com/heptio/contour/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestIngressRouteMetrics(t *testing.T) {
@@ -549,3 +562,433 @@ func TestIngressRouteMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheHandlerClusterRemovalGracePeriod(t *testing.T) {
+	i1 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: &v1beta1.IngressBackend{
+				ServiceName: "kuard",
+				ServicePort: intstr.FromInt(80),
+			},
+		},
+	}
+	s1 := service("default", "kuard", v1.ServicePort{
+		Protocol: "TCP",
+		Port:     80,
+	})
+
+	var fc fakeClock
+	ch := CacheHandler{
+		ClusterRemovalGracePeriod: time.Minute,
+		removals:                  scheduler{clock: &fc},
+	}
+	var b dag.Builder
+	b.Insert(i1)
+	b.Insert(s1)
+	ch.updateClusters(b.Build())
+	assertClusterNames(t, &ch, "default/kuard/80")
+
+	// the service is deleted, its cluster drains.
+	b.Remove(s1)
+	ch.updateClusters(b.Build())
+	fc.Advance(59 * time.Second)
+	assertClusterNames(t, &ch, "default/kuard/80")
+
+	// then expires.
+	fc.Advance(time.Second)
+	assertClusterNames(t, &ch)
+
+	// the service returns, then is deleted again.
+	b.Insert(s1)
+	ch.updateClusters(b.Build())
+	b.Remove(s1)
+	ch.updateClusters(b.Build())
+	fc.Advance(30 * time.Second)
+
+	// recreating the service cancels the pending removal.
+	b.Insert(s1)
+	ch.updateClusters(b.Build())
+	fc.Advance(time.Hour)
+	assertClusterNames(t, &ch, "default/kuard/80")
+}
+
+func TestCacheHandlerOnChangeObservesDAGRebuild(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	ch := CacheHandler{
+		Metrics: metrics.NewMetrics(registry),
+	}
+	var b dag.Builder
+	b.Insert(&v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: &v1beta1.IngressBackend{
+				ServiceName: "kuard",
+				ServicePort: intstr.FromInt(80),
+			},
+		},
+	})
+	ch.OnChange(&b)
+	ch.OnChange(&b)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "contour_dag_rebuild_duration_seconds" {
+			continue
+		}
+		if got := mf.GetMetric()[0].GetHistogram().GetSampleCount(); got != 2 {
+			t.Fatalf("expected 2 observations, got %d", got)
+		}
+		return
+	}
+	t.Fatal("contour_dag_rebuild_duration_seconds was not gathered")
+}
+
+func assertClusterNames(t *testing.T, ch *CacheHandler, want ...string) {
+	t.Helper()
+	got := []string{}
+	for _, c := range contents(&ch.ClusterCache) {
+		got = append(got, c.(*v2.Cluster).Name)
+	}
+	sort.Strings(got)
+	if want == nil {
+		want = []string{}
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected clusters %v, got %v", want, got)
+	}
+}
+
+func TestCacheHandlerOutputIsDeterministic(t *testing.T) {
+	var b dag.Builder
+	for _, name := range []string{"kuard", "nginx", "httpbin", "echo"} {
+		b.Insert(service("default", name, v1.ServicePort{
+			Protocol: "TCP",
+			Port:     80,
+		}, v1.ServicePort{
+			Protocol: "TCP",
+			Port:     8080,
+		}))
+		b.Insert(&v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: v1beta1.IngressSpec{
+				TLS: []v1beta1.IngressTLS{{
+					Hosts:      []string{name + ".example.com"},
+					SecretName: "secret",
+				}},
+				Rules: []v1beta1.IngressRule{{
+					Host: name + ".example.com",
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{
+							Paths: []v1beta1.HTTPIngressPath{{
+								Path: "/",
+								Backend: v1beta1.IngressBackend{
+									ServiceName: name,
+									ServicePort: intstr.FromInt(80),
+								},
+							}, {
+								Path: "/api",
+								Backend: v1beta1.IngressBackend{
+									ServiceName: name,
+									ServicePort: intstr.FromInt(8080),
+								},
+							}},
+						},
+					},
+				}},
+			},
+		})
+	}
+	b.Insert(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "default",
+		},
+		Data: secretdata("certificate", "key"),
+	})
+	b.Insert(&ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "weighted",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "weighted.example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{
+					{Name: "kuard", Port: 80, Weight: 40},
+					{Name: "nginx", Port: 80, Weight: 30},
+					{Name: "httpbin", Port: 80, Weight: 20},
+					{Name: "echo", Port: 80, Weight: 10},
+				},
+			}, {
+				Match:    "/a",
+				Services: []ingressroutev1.Service{{Name: "kuard", Port: 8080}},
+			}, {
+				Match:    "/b",
+				Services: []ingressroutev1.Service{{Name: "nginx", Port: 8080}},
+			}},
+		},
+	})
+
+	// marshal returns the marshaled contents of every cache.
+	marshal := func(ch *CacheHandler) []byte {
+		var buf []byte
+		for _, c := range []interface {
+			Values(func(string) bool) []proto.Message
+		}{&ch.ListenerCache, &ch.RouteCache, &ch.ClusterCache, &ch.SecretCache} {
+			for _, v := range contents(c) {
+				// the generated marshalers write map fields, such
+				// as those of the HTTP connection manager's Struct,
+				// in random order; the text format sorts them.
+				buf = append(buf, proto.CompactTextString(v)...)
+			}
+		}
+		return buf
+	}
+
+	var want []byte
+	for i := 0; i < 50; i++ {
+		var ch CacheHandler
+		d := b.Build()
+		ch.updateListeners(d)
+		ch.updateRoutes(d)
+		ch.updateClusters(d)
+		ch.updateSecrets(d)
+		got := marshal(&ch)
+		if i == 0 {
+			want = got
+			continue
+		}
+		if !bytes.Equal(want, got) {
+			t.Fatalf("recompute %d: output differs from the first compute", i)
+		}
+	}
+}
+
+func TestCacheHandlerSnapshot(t *testing.T) {
+	ch := CacheHandler{
+		Metrics: metrics.NewMetrics(prometheus.NewRegistry()),
+	}
+	var b dag.Builder
+	b.Insert(service("default", "kuard", v1.ServicePort{
+		Protocol: "TCP",
+		Port:     80,
+	}))
+	b.Insert(&v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: &v1beta1.IngressBackend{
+				ServiceName: "kuard",
+				ServicePort: intstr.FromInt(80),
+			},
+		},
+	})
+	ch.OnChange(&b)
+	first := ch.Snapshot()
+	ch.OnChange(&b)
+	second := ch.Snapshot()
+
+	if second.Generation != 2 {
+		t.Fatalf("expected generation 2, got %d", second.Generation)
+	}
+	want := map[string]int{"VirtualHost": 1, "Route": 1, "Service": 1}
+	if !reflect.DeepEqual(want, second.Objects) {
+		t.Fatalf("expected objects %v, got %v", want, second.Objects)
+	}
+	if got := second.Caches["clusters"].Resources; got != 1 {
+		t.Fatalf("expected 1 cluster, got %d", got)
+	}
+	if !reflect.DeepEqual(first.Caches, second.Caches) {
+		t.Fatalf("expected unchanged caches to keep their versions: %v, got %v", first.Caches, second.Caches)
+	}
+}
+
+func TestCacheHandlerIngressRouteStatusLeader(t *testing.T) {
+	ir := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "default",
+		},
+	}
+	tests := map[string]struct {
+		isLeader func() bool
+		want     int // status writes
+	}{
+		"no leader election": {
+			want: 1,
+		},
+		"leader": {
+			isLeader: func() bool { return true },
+			want:     1,
+		},
+		"not leader": {
+			isLeader: func() bool { return false },
+			want:     0,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(ir)
+			ch := CacheHandler{
+				IngressRouteStatus: &k8s.IngressRouteStatus{
+					Client: client,
+				},
+				IsLeader: tc.isLeader,
+			}
+			ch.setIngressRouteStatus(statuses{{
+				Object:      ir,
+				Status:      dag.StatusValid,
+				Description: "valid IngressRoute",
+			}})
+			if got := len(client.Actions()); got != tc.want {
+				t.Fatalf("expected %d status writes, got %d: %v", tc.want, got, client.Actions())
+			}
+		})
+	}
+}
+
+func TestCacheHandlerIngressRouteStatusRejections(t *testing.T) {
+	ir := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "default",
+		},
+	}
+	client := fake.NewSimpleClientset(ir)
+	ch := CacheHandler{
+		IngressRouteStatus: &k8s.IngressRouteStatus{
+			Client: client,
+		},
+		Rejections: func() []string {
+			return []string{"RouteConfiguration: invalid regex"}
+		},
+	}
+	ch.setIngressRouteStatus(statuses{{
+		Object:      ir,
+		Status:      dag.StatusValid,
+		Description: "valid IngressRoute",
+	}})
+	actions := client.Actions()
+	if len(actions) != 1 {
+		t.Fatalf("expected 1 status write, got %d: %v", len(actions), actions)
+	}
+	patch := string(actions[0].(k8stesting.PatchAction).GetPatch())
+	want := "valid IngressRoute, but Envoy rejected the configuration, RouteConfiguration: invalid regex"
+	if !strings.Contains(patch, want) {
+		t.Fatalf("expected the status to report %q, got %s", want, patch)
+	}
+}
+
+type statuses []dag.Status
+
+func (s statuses) Statuses() []dag.Status { return s }
+
+func TestCacheHandlerRouteConfigNames(t *testing.T) {
+	objs := []interface{}{
+		service("default", "kuard", v1.ServicePort{
+			Protocol: "TCP",
+			Port:     8080,
+		}),
+		&v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kuard",
+				Namespace: "default",
+			},
+			Spec: v1beta1.IngressSpec{
+				TLS: []v1beta1.IngressTLS{{
+					Hosts:      []string{"kuard.example.com"},
+					SecretName: "secret",
+				}},
+				Rules: []v1beta1.IngressRule{{
+					Host: "kuard.example.com",
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{
+							Paths: []v1beta1.HTTPIngressPath{{
+								Backend: v1beta1.IngressBackend{
+									ServiceName: "kuard",
+									ServicePort: intstr.FromInt(8080),
+								},
+							}},
+						},
+					},
+				}},
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "secret",
+				Namespace: "default",
+			},
+			Data: secretdata("certificate", "key"),
+		},
+	}
+
+	for _, prefix := range []string{"", "blue"} {
+		t.Run(prefix, func(t *testing.T) {
+			names := RouteConfigNames{Prefix: prefix}
+			ch := CacheHandler{
+				RouteConfigNames: names,
+				Metrics:          metrics.NewMetrics(prometheus.NewRegistry()),
+			}
+			var b dag.Builder
+			for _, o := range objs {
+				b.Insert(o)
+			}
+			ch.OnChange(&b)
+
+			want := []string{names.Name(ENVOY_HTTP_LISTENER), names.Name(ENVOY_HTTPS_LISTENER)}
+			sort.Strings(want)
+
+			// every route configuration is named by the provider.
+			var got []string
+			for _, v := range ch.RouteCache.Values(func(string) bool { return true }) {
+				got = append(got, v.(*v2.RouteConfiguration).Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(want, got) {
+				t.Fatalf("expected route configurations %v, got %v", want, got)
+			}
+
+			// every listener fetches a route configuration by the same
+			// name, which the RDS filter selects.
+			var fetched []string
+			for _, v := range ch.ListenerCache.Values(func(string) bool { return true }) {
+				for _, fc := range v.(*v2.Listener).FilterChains {
+					for _, f := range fc.Filters {
+						if f.Name != httpFilter {
+							continue
+						}
+						name := f.Config.Fields["rds"].GetStructValue().Fields["route_config_name"].GetStringValue()
+						if rcs := ch.RouteCache.Values(func(n string) bool { return n == name }); len(rcs) != 1 {
+							t.Fatalf("listener %q: route configuration %q is not served", v.(*v2.Listener).Name, name)
+						}
+						fetched = append(fetched, name)
+					}
+				}
+			}
+			sort.Strings(fetched)
+			if !reflect.DeepEqual(want, fetched) {
+				t.Fatalf("expected listeners to fetch %v, got %v", want, fetched)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@
 package contour
 
 import (
+	"sort"
 	"sync"
 
 	"strconv"
@@ -29,6 +30,7 @@ import (
 	"github.com/gogo/protobuf/types"
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
 	"github.com/heptio/contour/internal/dag"
+	"k8s.io/api/core/v1"
 )
 
 const (
@@ -38,13 +40,100 @@ const (
 	hcUnhealthyThreshold = 3
 	hcHealthyThreshold   = 2
 	hcHost               = "contour-envoy-healthcheck"
+
+	// defaultConnectTimeout is the connect timeout of clusters when
+	// neither the service nor ClusterCache.ConnectTimeout sets one.
+	defaultConnectTimeout = 250 * time.Millisecond
 )
 
 // ClusterCache manages the contents of the gRPC CDS cache.
 type ClusterCache struct {
+	// EDSConfigSource selects how Envoy fetches the endpoints of each
+	// EDS cluster, either EDS_CONFIG_SOURCE_GRPC or EDS_CONFIG_SOURCE_ADS.
+	// If not set, defaults to EDS_CONFIG_SOURCE_GRPC.
+	EDSConfigSource string
+
+	// ConnectTimeout is the connect timeout of each cluster whose
+	// service does not set its own with the upstream-connect-timeout
+	// annotation. If not set, defaults to 250ms.
+	ConnectTimeout time.Duration
+
+	// DNSLookupFamily is the DNS lookup family, one of dag.DNSLookupFamilyAuto,
+	// dag.DNSLookupFamilyV4, or dag.DNSLookupFamilyV6, of each STRICT_DNS
+	// or LOGICAL_DNS cluster whose service does not set its own with the
+	// dns-lookup-family annotation. If not set, Envoy's default applies.
+	DNSLookupFamily string
+
+	// DNSRefreshRate is how often each STRICT_DNS or LOGICAL_DNS cluster
+	// whose service does not set its own with the dns-refresh-rate
+	// annotation resolves its hosts. If not set, Envoy's default applies.
+	DNSRefreshRate time.Duration
+
 	clusterCache
 }
 
+const (
+	// EDS_CONFIG_SOURCE_GRPC fetches endpoints over a dedicated EDS
+	// gRPC stream to the contour cluster.
+	EDS_CONFIG_SOURCE_GRPC = "grpc"
+
+	// EDS_CONFIG_SOURCE_ADS fetches endpoints over the aggregated
+	// discovery stream configured by Envoy's bootstrap ads_config.
+	EDS_CONFIG_SOURCE_ADS = "ads"
+)
+
+// edsConfigSource returns the ConfigSource from which Envoy should fetch
+// the endpoints of EDS clusters.
+func (c *ClusterCache) edsConfigSource() *core.ConfigSource {
+	switch c.EDSConfigSource {
+	case EDS_CONFIG_SOURCE_ADS:
+		return adsconfigsource()
+	default:
+		return apiconfigsource("contour") // hard coded by initconfig
+	}
+}
+
+// connectTimeout returns the connect timeout of svc's cluster.
+func (c *ClusterCache) connectTimeout(svc *dag.Service) time.Duration {
+	switch {
+	case svc.ConnectTimeout > 0:
+		return svc.ConnectTimeout
+	case c.ConnectTimeout > 0:
+		return c.ConnectTimeout
+	default:
+		return defaultConnectTimeout
+	}
+}
+
+// dnsLookupFamily returns the DNS lookup family of svc's cluster.
+func (c *ClusterCache) dnsLookupFamily(svc *dag.Service) v2.Cluster_DnsLookupFamily {
+	f := svc.DNSLookupFamily
+	if f == "" {
+		f = c.DNSLookupFamily
+	}
+	switch f {
+	case dag.DNSLookupFamilyV4:
+		return v2.Cluster_V4_ONLY
+	case dag.DNSLookupFamilyV6:
+		return v2.Cluster_V6_ONLY
+	default:
+		return v2.Cluster_AUTO
+	}
+}
+
+// dnsRefreshRate returns the DNS refresh rate of svc's cluster, or nil
+// for Envoy's default.
+func (c *ClusterCache) dnsRefreshRate(svc *dag.Service) *time.Duration {
+	rate := svc.DNSRefreshRate
+	if rate <= 0 {
+		rate = c.DNSRefreshRate
+	}
+	if rate <= 0 {
+		return nil
+	}
+	return &rate
+}
+
 type clusterCache struct {
 	mu      sync.Mutex
 	values  map[string]*v2.Cluster
@@ -91,15 +180,20 @@ func (c *clusterCache) notify() {
 	c.waiters = c.waiters[:0]
 }
 
-// Values returns a slice of the value stored in the cache.
+// Values returns a slice of the value stored in the cache, sorted by name.
 func (c *clusterCache) Values(filter func(string) bool) []proto.Message {
 	c.mu.Lock()
-	values := make([]proto.Message, 0, len(c.values))
-	for _, v := range c.values {
-		if filter(v.Name) {
-			values = append(values, v)
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		if filter(name) {
+			names = append(names, name)
 		}
 	}
+	sort.Strings(names)
+	values := make([]proto.Message, 0, len(names))
+	for _, name := range names {
+		values = append(values, c.values[name])
+	}
 	c.mu.Unlock()
 	return values
 }
@@ -127,7 +221,7 @@ func (v *clusterVisitor) visit(vertex dag.Vertex) {
 }
 
 func (v *clusterVisitor) edscluster(svc *dag.Service) {
-	name := hashname(60, svc.Namespace(), svc.Name(), strconv.Itoa(int(svc.Port)))
+	name := clustername(svc)
 	if _, ok := v.clusters[name]; ok {
 		// already created this cluster via another edge. skip it.
 		return
@@ -136,8 +230,8 @@ func (v *clusterVisitor) edscluster(svc *dag.Service) {
 	c := &v2.Cluster{
 		Name:             name,
 		Type:             v2.Cluster_EDS,
-		EdsClusterConfig: edsconfig("contour", servicename(svc.Namespace(), svc.Name(), svc.ServicePort.Name)),
-		ConnectTimeout:   250 * time.Millisecond,
+		EdsClusterConfig: edsconfig(v.edsConfigSource(), servicename(svc.Namespace(), svc.Name(), svc.ServicePort.Name)),
+		ConnectTimeout:   v.connectTimeout(svc),
 		LbPolicy:         edslbstrategy(svc.LoadBalancerStrategy),
 		CommonLbConfig: &v2.Cluster_CommonLbConfig{
 			HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
@@ -146,10 +240,49 @@ func (v *clusterVisitor) edscluster(svc *dag.Service) {
 		},
 	}
 
-	// Set HealthCheck if requested
+	if svc.DiscoveryType != "" {
+		c.Type = clusterdiscoverytype(svc.DiscoveryType)
+		c.EdsClusterConfig = nil
+		c.Hosts = []*core.Address{
+			clusterhost(svc),
+		}
+	}
+
+	// only DNS clusters resolve their hosts, EDS and STATIC clusters
+	// must not carry DNS settings.
+	switch c.Type {
+	case v2.Cluster_STRICT_DNS, v2.Cluster_LOGICAL_DNS:
+		c.DnsLookupFamily = v.dnsLookupFamily(svc)
+		c.DnsRefreshRate = v.dnsRefreshRate(svc)
+	}
+
+	if c.LbPolicy == v2.Cluster_RING_HASH && svc.MinimumRingSize > 0 {
+		c.LbConfig = &v2.Cluster_RingHashLbConfig_{
+			RingHashLbConfig: &v2.Cluster_RingHashLbConfig{
+				MinimumRingSize: &types.UInt64Value{Value: svc.MinimumRingSize},
+			},
+		}
+	}
+
+	// both EDS and DNS clusters pool their upstream connections.
+	if svc.IdleTimeout > 0 {
+		idle := svc.IdleTimeout
+		c.CommonHttpProtocolOptions = &core.HttpProtocolOptions{
+			IdleTimeout: &idle,
+		}
+	}
+	// TODO send svc.MaxConnectionDuration too, see docs/deploy-options.md.
+
+	// Set HealthCheck if requested. The health check of an HTTP/2
+	// service speaks HTTP/2 too, so that pods which only speak
+	// HTTP/1.1, despite the service's upstream protocol annotation,
+	// fail it and are reported unhealthy.
 	if svc.HealthCheck != nil {
-		c.HealthChecks = edshealthcheck(svc.HealthCheck)
+		c.HealthChecks = edshealthcheck(svc.HealthCheck, svc.Protocol == "h2" || svc.Protocol == "h2c")
 	}
+	// TODO per cluster protocol error counts would point at such a
+	// misconfiguration more directly, but Contour does not yet serve LRS
+	// nor read Envoy's stats.
 
 	if svc.MaxConnections > 0 || svc.MaxPendingRequests > 0 || svc.MaxRequests > 0 || svc.MaxRetries > 0 {
 		c.CircuitBreakers = &cluster.CircuitBreakers{
@@ -162,20 +295,62 @@ func (v *clusterVisitor) edscluster(svc *dag.Service) {
 		}
 	}
 
+	// TODO upstream TLS is all or nothing for a cluster. Choosing it per
+	// endpoint, see docs/deploy-options.md, also needs endpoint metadata
+	// from the EndpointsTranslator.
+	// TODO newer Envoys deprecate Http2ProtocolOptions in favour of the
+	// envoy.extensions.upstreams.http.v3.HttpProtocolOptions of
+	// Cluster.typed_extension_protocol_options, which the v2 API lacks.
 	switch svc.Protocol {
 	case "h2":
-		c.Http2ProtocolOptions = &core.Http2ProtocolOptions{}
+		c.Http2ProtocolOptions = http2protocoloptions(svc)
 		c.TlsContext = &auth.UpstreamTlsContext{
 			CommonTlsContext: &auth.CommonTlsContext{
 				AlpnProtocols: []string{"h2"},
 			},
 		}
 	case "h2c":
-		c.Http2ProtocolOptions = &core.Http2ProtocolOptions{}
+		c.Http2ProtocolOptions = http2protocoloptions(svc)
 	}
 	v.clusters[c.Name] = c
 }
 
+// clustername returns the name of the cluster generated for svc.
+// Variants of a service are named apart from each other.
+func clustername(svc *dag.Service) string {
+	if svc.Variant != "" {
+		return hashname(60, svc.Namespace(), svc.Name(), strconv.Itoa(int(svc.Port)), svc.Variant)
+	}
+	return hashname(60, svc.Namespace(), svc.Name(), strconv.Itoa(int(svc.Port)))
+}
+
+func clusterdiscoverytype(dt string) v2.Cluster_DiscoveryType {
+	switch dt {
+	case dag.DiscoveryTypeStrictDNS:
+		return v2.Cluster_STRICT_DNS
+	case dag.DiscoveryTypeLogicalDNS:
+		return v2.Cluster_LOGICAL_DNS
+	default:
+		return v2.Cluster_STATIC
+	}
+}
+
+// clusterhost returns the address of svc for a cluster which does not
+// use EDS; its cluster IP for a STATIC cluster, otherwise its DNS name.
+func clusterhost(svc *dag.Service) *core.Address {
+	var host string
+	switch {
+	case svc.DiscoveryType == dag.DiscoveryTypeStatic:
+		host = svc.Object.Spec.ClusterIP
+	case svc.Object.Spec.Type == v1.ServiceTypeExternalName:
+		host = svc.Object.Spec.ExternalName
+	default:
+		host = svc.Name() + "." + svc.Namespace() + ".svc.cluster.local"
+	}
+	addr := socketaddress(host, uint32(svc.Port))
+	return &addr
+}
+
 func edslbstrategy(lbStrategy string) v2.Cluster_LbPolicy {
 	switch lbStrategy {
 	case "WeightedLeastRequest":
@@ -191,7 +366,7 @@ func edslbstrategy(lbStrategy string) v2.Cluster_LbPolicy {
 	}
 }
 
-func edshealthcheck(hc *ingressroutev1.HealthCheck) []*core.HealthCheck {
+func edshealthcheck(hc *ingressroutev1.HealthCheck, http2 bool) []*core.HealthCheck {
 	host := hcHost
 	if hc.Host != "" {
 		host = hc.Host
@@ -206,8 +381,9 @@ func edshealthcheck(hc *ingressroutev1.HealthCheck) []*core.HealthCheck {
 		HealthyThreshold:   countOrDefault(hc.HealthyThresholdCount, hcHealthyThreshold),
 		HealthChecker: &core.HealthCheck_HttpHealthCheck_{
 			HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
-				Path: hc.Path,
-				Host: host,
+				Path:     hc.Path,
+				Host:     host,
+				UseHttp2: http2,
 			},
 		},
 	}}
@@ -233,6 +409,16 @@ func countOrDefault(count, def uint32) *types.UInt32Value {
 }
 
 // uint32OrNil returns a *types.UInt32Value containing the v or nil if v is zero.
+// http2protocoloptions returns the HTTP/2 options of the cluster for
+// svc; settings the service does not tune are left to Envoy.
+func http2protocoloptions(svc *dag.Service) *core.Http2ProtocolOptions {
+	return &core.Http2ProtocolOptions{
+		MaxConcurrentStreams:        uint32OrNil(int(svc.HTTP2MaxConcurrentStreams)),
+		InitialStreamWindowSize:     uint32OrNil(int(svc.HTTP2InitialStreamWindowSize)),
+		InitialConnectionWindowSize: uint32OrNil(int(svc.HTTP2InitialConnectionWindowSize)),
+	}
+}
+
 func uint32OrNil(v int) *types.UInt32Value {
 	switch v {
 	case 0:
@@ -242,9 +428,9 @@ func uint32OrNil(v int) *types.UInt32Value {
 	}
 }
 
-func edsconfig(source, name string) *v2.Cluster_EdsClusterConfig {
+func edsconfig(source *core.ConfigSource, name string) *v2.Cluster_EdsClusterConfig {
 	return &v2.Cluster_EdsClusterConfig{
-		EdsConfig:   apiconfigsource(source), // hard coded by initconfig
+		EdsConfig:   source,
 		ServiceName: name,
 	}
 }
@@ -260,6 +446,14 @@ func apiconfigsource(clusters ...string) *core.ConfigSource {
 	}
 }
 
+func adsconfigsource() *core.ConfigSource {
+	return &core.ConfigSource{
+		ConfigSourceSpecifier: &core.ConfigSource_Ads{
+			Ads: new(core.AggregatedConfigSource),
+		},
+	}
+}
+
 // servicename returns a fixed name for this service and portname
 func servicename(namespace, name, portname string) string {
 	sn := []string{
@@ -34,6 +34,7 @@ import (
 
 func TestClusterVisit(t *testing.T) {
 	tests := map[string]struct {
+		*ClusterCache
 		objs []interface{}
 		want map[string]*v2.Cluster
 	}{
@@ -80,6 +81,52 @@ func TestClusterVisit(t *testing.T) {
 					},
 				}),
 		},
+		"eds config source ads": {
+			ClusterCache: &ClusterCache{
+				EDSConfigSource: EDS_CONFIG_SOURCE_ADS,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(443),
+						},
+					},
+				},
+				service("default", "kuard",
+					v1.ServicePort{
+						Protocol:   "TCP",
+						Port:       443,
+						TargetPort: intstr.FromInt(8443),
+					},
+				),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/443",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig: &core.ConfigSource{
+							ConfigSourceSpecifier: &core.ConfigSource_Ads{
+								Ads: new(core.AggregatedConfigSource),
+							},
+						},
+						ServiceName: "default/kuard",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				}),
+		},
 		"single named service": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
@@ -109,7 +156,1060 @@ func TestClusterVisit(t *testing.T) {
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/kuard/https",
+						ServiceName: "default/kuard/https",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				}),
+		},
+		"h2c upstream": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromString("http"),
+						},
+					},
+				},
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-protocol.h2c": "80,http",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Name:     "http",
+						Port:     80,
+					},
+				),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/kuard/http",
+					},
+					ConnectTimeout:       250 * time.Millisecond,
+					LbPolicy:             v2.Cluster_ROUND_ROBIN,
+					Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"h2c upstream with health check": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 80,
+								HealthCheck: &ingressroutev1.HealthCheck{
+									Path: "/healthz",
+								},
+							}},
+						}},
+					},
+				},
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-protocol.h2c": "http",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Name:     "http",
+						Port:     80,
+					},
+				),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/kuard/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					HealthChecks: edshealthcheck(&ingressroutev1.HealthCheck{
+						Path: "/healthz",
+					}, true),
+					Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"h2c upstream with http2 settings": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromString("http"),
+						},
+					},
+				},
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-protocol.h2c":                "80,http",
+						"contour.heptio.com/http2-max-concurrent-streams":         "100",
+						"contour.heptio.com/http2-initial-stream-window-size":     "65536",
+						"contour.heptio.com/http2-initial-connection-window-size": "1048576",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Name:     "http",
+						Port:     80,
+					},
+				),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/kuard/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					Http2ProtocolOptions: &core.Http2ProtocolOptions{
+						MaxConcurrentStreams:        &types.UInt32Value{Value: 100},
+						InitialStreamWindowSize:     &types.UInt32Value{Value: 65536},
+						InitialConnectionWindowSize: &types.UInt32Value{Value: 1048576},
+					},
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"h2c upstream with invalid http2 settings": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromString("http"),
+						},
+					},
+				},
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-protocol.h2c":                "80,http",
+						"contour.heptio.com/http2-max-concurrent-streams":         "0",
+						"contour.heptio.com/http2-initial-stream-window-size":     "1024",
+						"contour.heptio.com/http2-initial-connection-window-size": "lots",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Name:     "http",
+						Port:     80,
+					},
+				),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/kuard/http",
+					},
+					ConnectTimeout:       250 * time.Millisecond,
+					LbPolicy:             v2.Cluster_ROUND_ROBIN,
+					Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"http2 settings ignored without h2": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromString("http"),
+						},
+					},
+				},
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/http2-max-concurrent-streams": "100",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Name:     "http",
+						Port:     80,
+					},
+				),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/kuard/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"strict dns discovery type": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				withclusterip("10.0.0.10", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/cluster-discovery-type": "STRICT_DNS",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name:           "default/kuard/80",
+					Type:           v2.Cluster_STRICT_DNS,
+					Hosts:          []*core.Address{clusteraddress("kuard.default.svc.cluster.local", 80)},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"logical dns discovery type": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				withclusterip("10.0.0.10", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/cluster-discovery-type": "LOGICAL_DNS",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name:           "default/kuard/80",
+					Type:           v2.Cluster_LOGICAL_DNS,
+					Hosts:          []*core.Address{clusteraddress("kuard.default.svc.cluster.local", 80)},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"static discovery type": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				withclusterip("10.0.0.10", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/cluster-discovery-type": "STATIC",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name:           "default/kuard/80",
+					Type:           v2.Cluster_STATIC,
+					Hosts:          []*core.Address{clusteraddress("10.0.0.10", 80)},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"static discovery type on headless service": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				withclusterip("None", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/cluster-discovery-type": "STATIC",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/kuard",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"unknown discovery type": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				withclusterip("10.0.0.10", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/cluster-discovery-type": "ORIGINAL_DST",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/kuard/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/kuard",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"long namespace and service name": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "webserver-1-unimatrix-zero-one",
+						Namespace: "beurocratic-company-test-domain-1",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "tiny-cog-department-test-instance",
+							ServicePort: intstr.FromInt(443),
+						},
+					},
+				},
+				service("beurocratic-company-test-domain-1", "tiny-cog-department-test-instance",
+					v1.ServicePort{
+						Name:       "svc-0",
+						Protocol:   "TCP",
+						Port:       443,
+						TargetPort: intstr.FromInt(8443),
+					},
+				),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "beurocratic-company-test-domain-1/tiny-cog-depa-81582b/443",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "beurocratic-company-test-domain-1/tiny-cog-department-test-instance/svc-0",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				}),
+		},
+		"two service ports": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}, {
+								Name: "backend",
+								Port: 8080,
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}, v1.ServicePort{
+					Name:       "alt",
+					Protocol:   "TCP",
+					Port:       8080,
+					TargetPort: intstr.FromString("9001"),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+				&v2.Cluster{
+					Name: "default/backend/8080",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/alt",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with simple path healthcheck": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+								HealthCheck: &ingressroutev1.HealthCheck{
+									Path: "/healthy",
+								},
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					HealthChecks: []*core.HealthCheck{{
+						Timeout:  duration(hcTimeout),
+						Interval: duration(hcInterval),
+						UnhealthyThreshold: &types.UInt32Value{
+							Value: hcUnhealthyThreshold,
+						},
+						HealthyThreshold: &types.UInt32Value{
+							Value: hcHealthyThreshold,
+						},
+						HealthChecker: &core.HealthCheck_HttpHealthCheck_{
+							HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
+								Path: "/healthy",
+								Host: "contour-envoy-healthcheck",
+							},
+						},
+					}},
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with custom healthcheck": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+								HealthCheck: &ingressroutev1.HealthCheck{
+									Host:                    "foo-bar-host",
+									Path:                    "/healthy",
+									TimeoutSeconds:          99,
+									IntervalSeconds:         98,
+									UnhealthyThresholdCount: 97,
+									HealthyThresholdCount:   96,
+								},
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					HealthChecks: []*core.HealthCheck{{
+						Timeout:  duration(99 * time.Second),
+						Interval: duration(98 * time.Second),
+						UnhealthyThreshold: &types.UInt32Value{
+							Value: 97,
+						},
+						HealthyThreshold: &types.UInt32Value{
+							Value: 96,
+						},
+						HealthChecker: &core.HealthCheck_HttpHealthCheck_{
+							HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
+								Path: "/healthy",
+								Host: "foo-bar-host",
+							},
+						},
+					}},
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with RoundRobin lb algorithm": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:     "backend",
+								Port:     80,
+								Strategy: "RoundRobin",
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with WeightedLeastRequest lb algorithm": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:     "backend",
+								Port:     80,
+								Strategy: "WeightedLeastRequest",
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_LEAST_REQUEST,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with RingHash lb algorithm": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:     "backend",
+								Port:     80,
+								Strategy: "RingHash",
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_RING_HASH,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with RingHash minimum ring size": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:            "backend",
+								Port:            80,
+								Strategy:        "RingHash",
+								MinimumRingSize: 2048,
+							}},
+						}, {
+							Match: "/other",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				// the route which sets a ring size has a
+				// cluster of its own.
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+				&v2.Cluster{
+					Name: "default/backend/80/ring-2048",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_RING_HASH,
+					LbConfig: &v2.Cluster_RingHashLbConfig_{
+						RingHashLbConfig: &v2.Cluster_RingHashLbConfig{
+							MinimumRingSize: &types.UInt64Value{Value: 2048},
+						},
+					},
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with Maglev lb algorithm": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:     "backend",
+								Port:     80,
+								Strategy: "Maglev",
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_MAGLEV,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with Random lb algorithm": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:     "backend",
+								Port:     80,
+								Strategy: "Random",
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
+					},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_RANDOM,
+					CommonLbConfig: &v2.Cluster_CommonLbConfig{
+						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+							Value: 0,
+						},
+					},
+				},
+			),
+		},
+		"ingressroute with unknown lb algorithm": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:     "backend",
+								Port:     80,
+								Strategy: "lulz",
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Name:       "http",
+					Protocol:   "TCP",
+					Port:       80,
+					TargetPort: intstr.FromInt(6502),
+				}),
+			},
+			want: clustermap(
+				&v2.Cluster{
+					Name: "default/backend/80",
+					Type: v2.Cluster_EDS,
+					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+						ServiceName: "default/backend/http",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
@@ -118,9 +1218,10 @@ func TestClusterVisit(t *testing.T) {
 							Value: 0,
 						},
 					},
-				}),
+				},
+			),
 		},
-		"h2c upstream": {
+		"circuitbreaker annotations": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
@@ -138,7 +1239,10 @@ func TestClusterVisit(t *testing.T) {
 					"default",
 					"kuard",
 					map[string]string{
-						"contour.heptio.com/upstream-protocol.h2c": "80,http",
+						"contour.heptio.com/max-connections":      "9000",
+						"contour.heptio.com/max-pending-requests": "4096",
+						"contour.heptio.com/max-requests":         "404",
+						"contour.heptio.com/max-retries":          "7",
 					},
 					v1.ServicePort{
 						Protocol: "TCP",
@@ -155,9 +1259,16 @@ func TestClusterVisit(t *testing.T) {
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
 						ServiceName: "default/kuard/http",
 					},
-					ConnectTimeout:       250 * time.Millisecond,
-					LbPolicy:             v2.Cluster_ROUND_ROBIN,
-					Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+					ConnectTimeout: 250 * time.Millisecond,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					CircuitBreakers: &cluster.CircuitBreakers{
+						Thresholds: []*cluster.CircuitBreakers_Thresholds{{
+							MaxConnections:     uint32t(9000),
+							MaxPendingRequests: uint32t(4096),
+							MaxRequests:        uint32t(404),
+							MaxRetries:         uint32t(7),
+						}},
+					},
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -166,51 +1277,92 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"long namespace and service name": {
+		"named and numeric references to a port share one cluster": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "webserver-1-unimatrix-zero-one",
-						Namespace: "beurocratic-company-test-domain-1",
+						Name:      "byname",
+						Namespace: "default",
 					},
 					Spec: v1beta1.IngressSpec{
-						Backend: &v1beta1.IngressBackend{
-							ServiceName: "tiny-cog-department-test-instance",
-							ServicePort: intstr.FromInt(443),
+						Rules: []v1beta1.IngressRule{{
+							Host: "byname.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: *backend("kuard", intstr.FromString("http")),
+									}},
+								},
+							},
+						}},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "bynumber",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "bynumber.example.com",
 						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name:     "kuard",
+								Port:     80,
+								Strategy: "Random",
+								HealthCheck: &ingressroutev1.HealthCheck{
+									Path: "/healthz",
+								},
+							}},
+						}},
 					},
 				},
-				service("beurocratic-company-test-domain-1", "tiny-cog-department-test-instance",
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/max-connections": "9000",
+					},
 					v1.ServicePort{
-						Name:       "svc-0",
-						Protocol:   "TCP",
-						Port:       443,
-						TargetPort: intstr.FromInt(8443),
+						Protocol: "TCP",
+						Name:     "http",
+						Port:     80,
 					},
 				),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "beurocratic-company-test-domain-1/tiny-cog-depa-81582b/443",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "beurocratic-company-test-domain-1/tiny-cog-department-test-instance/svc-0",
+						ServiceName: "default/kuard/http",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
-					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					LbPolicy:       v2.Cluster_RANDOM,
+					HealthChecks: edshealthcheck(&ingressroutev1.HealthCheck{
+						Path: "/healthz",
+					}, false),
+					CircuitBreakers: &cluster.CircuitBreakers{
+						Thresholds: []*cluster.CircuitBreakers_Thresholds{{
+							MaxConnections: uint32t(9000),
+						}},
+					},
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
 						},
 					},
-				}),
+				},
+			),
 		},
-		"two service ports": {
+		"route health check overrides the service health check": {
 			objs: []interface{}{
 				&ingressroutev1.IngressRoute{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
 					Spec: ingressroutev1.IngressRouteSpec{
@@ -220,37 +1372,58 @@ func TestClusterVisit(t *testing.T) {
 						Routes: []ingressroutev1.Route{{
 							Match: "/",
 							Services: []ingressroutev1.Service{{
-								Name: "backend",
+								Name: "kuard",
 								Port: 80,
-							}, {
-								Name: "backend",
-								Port: 8080,
+								HealthCheck: &ingressroutev1.HealthCheck{
+									Path: "/healthz",
+								},
+							}},
+						}, {
+							Match: "/checkout",
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 80,
+								HealthCheck: &ingressroutev1.HealthCheck{
+									Path: "/healthz",
+								},
+							}},
+							HealthCheck: &ingressroutev1.HealthCheck{
+								Path: "/ready",
+							},
+						}, {
+							// the same as the service's, no variant.
+							Match: "/static",
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 80,
+								HealthCheck: &ingressroutev1.HealthCheck{
+									Path: "/healthz",
+								},
 							}},
+							HealthCheck: &ingressroutev1.HealthCheck{
+								Path: "/healthz",
+							},
 						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}, v1.ServicePort{
-					Name:       "alt",
-					Protocol:   "TCP",
-					Port:       8080,
-					TargetPort: intstr.FromString("9001"),
+				service("default", "kuard", v1.ServicePort{
+					Protocol: "TCP",
+					Port:     80,
 				}),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
+						ServiceName: "default/kuard",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					HealthChecks: edshealthcheck(&ingressroutev1.HealthCheck{
+						Path: "/healthz",
+					}, false),
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -258,14 +1431,17 @@ func TestClusterVisit(t *testing.T) {
 					},
 				},
 				&v2.Cluster{
-					Name: "default/backend/8080",
+					Name: "default/kuard/80/hc-15eb73f2",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/alt",
+						ServiceName: "default/kuard",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
+					HealthChecks: edshealthcheck(&ingressroutev1.HealthCheck{
+						Path: "/ready",
+					}, false),
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -274,62 +1450,45 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with simple path healthcheck": {
+		"upstream idle timeout": {
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
 						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name: "backend",
-								Port: 80,
-								HealthCheck: &ingressroutev1.HealthCheck{
-									Path: "/healthy",
-								},
-							}},
-						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-idle-timeout": "10m",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
+						ServiceName: "default/kuard",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
-					HealthChecks: []*core.HealthCheck{{
-						Timeout:  duration(hcTimeout),
-						Interval: duration(hcInterval),
-						UnhealthyThreshold: &types.UInt32Value{
-							Value: hcUnhealthyThreshold,
-						},
-						HealthyThreshold: &types.UInt32Value{
-							Value: hcHealthyThreshold,
-						},
-						HealthChecker: &core.HealthCheck_HttpHealthCheck_{
-							HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
-								Path: "/healthy",
-								Host: "contour-envoy-healthcheck",
-							},
-						},
-					}},
+					CommonHttpProtocolOptions: &core.HttpProtocolOptions{
+						IdleTimeout: duration(10 * time.Minute),
+					},
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -338,67 +1497,42 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with custom healthcheck": {
+		"infinite upstream idle timeout": {
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
 						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name: "backend",
-								Port: 80,
-								HealthCheck: &ingressroutev1.HealthCheck{
-									Host:                    "foo-bar-host",
-									Path:                    "/healthy",
-									TimeoutSeconds:          99,
-									IntervalSeconds:         98,
-									UnhealthyThresholdCount: 97,
-									HealthyThresholdCount:   96,
-								},
-							}},
-						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-idle-timeout": "infinity",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
+						ServiceName: "default/kuard",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
-					HealthChecks: []*core.HealthCheck{{
-						Timeout:  duration(99 * time.Second),
-						Interval: duration(98 * time.Second),
-						UnhealthyThreshold: &types.UInt32Value{
-							Value: 97,
-						},
-						HealthyThreshold: &types.UInt32Value{
-							Value: 96,
-						},
-						HealthChecker: &core.HealthCheck_HttpHealthCheck_{
-							HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
-								Path: "/healthy",
-								Host: "foo-bar-host",
-							},
-						},
-					}},
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -407,43 +1541,42 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with RoundRobin lb algorithm": {
+		"default connect timeout": {
+			ClusterCache: &ClusterCache{
+				ConnectTimeout: 5 * time.Second,
+			},
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
-						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name:     "backend",
-								Port:     80,
-								Strategy: "RoundRobin",
-							}},
-						}},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
+						},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					nil,
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
+						ServiceName: "default/kuard",
 					},
-					ConnectTimeout: 250 * time.Millisecond,
+					ConnectTimeout: 5 * time.Second,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
@@ -453,44 +1586,45 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with WeightedLeastRequest lb algorithm": {
+		"upstream connect timeout overrides default": {
+			ClusterCache: &ClusterCache{
+				ConnectTimeout: 5 * time.Second,
+			},
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
 						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name:     "backend",
-								Port:     80,
-								Strategy: "WeightedLeastRequest",
-							}},
-						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-connect-timeout": "2s",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
+						ServiceName: "default/kuard",
 					},
-					ConnectTimeout: 250 * time.Millisecond,
-					LbPolicy:       v2.Cluster_LEAST_REQUEST,
+					ConnectTimeout: 2 * time.Second,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -499,44 +1633,42 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with RingHash lb algorithm": {
+		"malformed upstream connect timeout": {
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
 						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name:     "backend",
-								Port:     80,
-								Strategy: "RingHash",
-							}},
-						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/upstream-connect-timeout": "infinity",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
+						ServiceName: "default/kuard",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
-					LbPolicy:       v2.Cluster_RING_HASH,
+					LbPolicy:       v2.Cluster_ROUND_ROBIN,
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -545,44 +1677,45 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with Maglev lb algorithm": {
+		"strict dns discovery type with default dns settings": {
+			ClusterCache: &ClusterCache{
+				DNSLookupFamily: "v4",
+				DNSRefreshRate:  30 * time.Second,
+			},
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
 						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name:     "backend",
-								Port:     80,
-								Strategy: "Maglev",
-							}},
-						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				withclusterip("10.0.0.10", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/cluster-discovery-type": "STRICT_DNS",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
-					Type: v2.Cluster_EDS,
-					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
-						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
-					},
-					ConnectTimeout: 250 * time.Millisecond,
-					LbPolicy:       v2.Cluster_MAGLEV,
+					Name:            "default/kuard/80",
+					Type:            v2.Cluster_STRICT_DNS,
+					Hosts:           []*core.Address{clusteraddress("kuard.default.svc.cluster.local", 80)},
+					DnsLookupFamily: v2.Cluster_V4_ONLY,
+					DnsRefreshRate:  duration(30 * time.Second),
+					ConnectTimeout:  250 * time.Millisecond,
+					LbPolicy:        v2.Cluster_ROUND_ROBIN,
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -591,44 +1724,47 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with Random lb algorithm": {
+		"logical dns discovery type overrides default dns settings": {
+			ClusterCache: &ClusterCache{
+				DNSLookupFamily: "v4",
+				DNSRefreshRate:  30 * time.Second,
+			},
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
 						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name:     "backend",
-								Port:     80,
-								Strategy: "Random",
-							}},
-						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				withclusterip("10.0.0.10", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/cluster-discovery-type": "LOGICAL_DNS",
+						"contour.heptio.com/dns-lookup-family":      "v6",
+						"contour.heptio.com/dns-refresh-rate":       "5s",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
-					Type: v2.Cluster_EDS,
-					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
-						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
-					},
-					ConnectTimeout: 250 * time.Millisecond,
-					LbPolicy:       v2.Cluster_RANDOM,
+					Name:            "default/kuard/80",
+					Type:            v2.Cluster_LOGICAL_DNS,
+					Hosts:           []*core.Address{clusteraddress("kuard.default.svc.cluster.local", 80)},
+					DnsLookupFamily: v2.Cluster_V6_ONLY,
+					DnsRefreshRate:  duration(5 * time.Second),
+					ConnectTimeout:  250 * time.Millisecond,
+					LbPolicy:        v2.Cluster_ROUND_ROBIN,
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -637,41 +1773,44 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"ingressroute with unknown lb algorithm": {
+		"dns settings are not applied to eds clusters": {
+			ClusterCache: &ClusterCache{
+				DNSLookupFamily: "v4",
+				DNSRefreshRate:  30 * time.Second,
+			},
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "simple",
+						Name:      "kuard",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(80),
 						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{{
-								Name:     "backend",
-								Port:     80,
-								Strategy: "lulz",
-							}},
-						}},
 					},
 				},
-				service("default", "backend", v1.ServicePort{
-					Name:       "http",
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(6502),
-				}),
+				withclusterip("10.0.0.10", serviceWithAnnotations(
+					"default",
+					"kuard",
+					map[string]string{
+						"contour.heptio.com/dns-lookup-family": "v6",
+						"contour.heptio.com/dns-refresh-rate":  "5s",
+					},
+					v1.ServicePort{
+						Protocol: "TCP",
+						Port:     80,
+					},
+				)),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/backend/80",
+					Name: "default/kuard/80",
 					Type: v2.Cluster_EDS,
 					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
 						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/backend/http",
+						ServiceName: "default/kuard",
 					},
 					ConnectTimeout: 250 * time.Millisecond,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
@@ -683,7 +1822,11 @@ func TestClusterVisit(t *testing.T) {
 				},
 			),
 		},
-		"circuitbreaker annotations": {
+		"dns settings are not applied to static clusters": {
+			ClusterCache: &ClusterCache{
+				DNSLookupFamily: "v4",
+				DNSRefreshRate:  30 * time.Second,
+			},
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
@@ -693,44 +1836,31 @@ func TestClusterVisit(t *testing.T) {
 					Spec: v1beta1.IngressSpec{
 						Backend: &v1beta1.IngressBackend{
 							ServiceName: "kuard",
-							ServicePort: intstr.FromString("http"),
+							ServicePort: intstr.FromInt(80),
 						},
 					},
 				},
-				serviceWithAnnotations(
+				withclusterip("10.0.0.10", serviceWithAnnotations(
 					"default",
 					"kuard",
 					map[string]string{
-						"contour.heptio.com/max-connections":      "9000",
-						"contour.heptio.com/max-pending-requests": "4096",
-						"contour.heptio.com/max-requests":         "404",
-						"contour.heptio.com/max-retries":          "7",
+						"contour.heptio.com/cluster-discovery-type": "STATIC",
+						"contour.heptio.com/dns-lookup-family":      "v6",
+						"contour.heptio.com/dns-refresh-rate":       "5s",
 					},
 					v1.ServicePort{
 						Protocol: "TCP",
-						Name:     "http",
 						Port:     80,
 					},
-				),
+				)),
 			},
 			want: clustermap(
 				&v2.Cluster{
-					Name: "default/kuard/80",
-					Type: v2.Cluster_EDS,
-					EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
-						EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-						ServiceName: "default/kuard/http",
-					},
+					Name:           "default/kuard/80",
+					Type:           v2.Cluster_STATIC,
+					Hosts:          []*core.Address{clusteraddress("10.0.0.10", 80)},
 					ConnectTimeout: 250 * time.Millisecond,
 					LbPolicy:       v2.Cluster_ROUND_ROBIN,
-					CircuitBreakers: &cluster.CircuitBreakers{
-						Thresholds: []*cluster.CircuitBreakers_Thresholds{{
-							MaxConnections:     uint32t(9000),
-							MaxPendingRequests: uint32t(4096),
-							MaxRequests:        uint32t(404),
-							MaxRetries:         uint32t(7),
-						}},
-					},
 					CommonLbConfig: &v2.Cluster_CommonLbConfig{
 						HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
 							Value: 0,
@@ -750,8 +1880,12 @@ func TestClusterVisit(t *testing.T) {
 			for _, o := range tc.objs {
 				reh.OnAdd(o)
 			}
+			cc := tc.ClusterCache
+			if cc == nil {
+				cc = new(ClusterCache)
+			}
 			v := clusterVisitor{
-				ClusterCache: new(ClusterCache),
+				ClusterCache: cc,
 				Visitable:    reh.Build(),
 			}
 			got := v.Visit()
@@ -783,6 +1917,16 @@ func serviceWithAnnotations(ns, name string, annotations map[string]string, port
 	}
 }
 
+func withclusterip(ip string, svc *v1.Service) *v1.Service {
+	svc.Spec.ClusterIP = ip
+	return svc
+}
+
+func clusteraddress(host string, port uint32) *core.Address {
+	addr := socketaddress(host, port)
+	return &addr
+}
+
 func clustermap(clusters ...*v2.Cluster) map[string]*v2.Cluster {
 	m := make(map[string]*v2.Cluster)
 	for _, c := range clusters {
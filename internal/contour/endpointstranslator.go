@@ -14,11 +14,19 @@
 package contour
 
 import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	"github.com/gogo/protobuf/types"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	_cache "k8s.io/client-go/tools/cache"
 )
 
@@ -28,18 +36,84 @@ type EndpointsTranslator struct {
 	logrus.FieldLogger
 	clusterLoadAssignmentCache
 	Cond
+
+	// DrainTimeout is how long the address of a terminating pod is
+	// kept, DRAINING, after it is removed from its Endpoints, so that
+	// in-flight requests can complete. Terminating pods are learnt from
+	// a Pod informer which must also be registered with the translator.
+	// If not set, addresses are removed immediately.
+	DrainTimeout time.Duration
+
+	// NotReadyAddresses includes the not-ready addresses of each
+	// Endpoints, marked UNHEALTHY, alongside its ready addresses,
+	// which are marked HEALTHY.
+	NotReadyAddresses bool
+
+	// RemovalGracePeriod, if non zero, is how long the
+	// ClusterLoadAssignments of a deleted Endpoints are kept so the
+	// cluster, if kept draining, still has endpoints. Recreating the
+	// Endpoints during its grace period cancels their removal.
+	RemovalGracePeriod time.Duration
+
+	// SubsetLabels are the pod labels copied into the load balancing
+	// metadata, under the envoy.lb filter metadata namespace, of the
+	// endpoints of each pod, so that routes may select subsets of a
+	// cluster's endpoints. Pod labels are learnt from a Pod informer
+	// which must also be registered with the translator.
+	// If not set, endpoints carry no metadata.
+	SubsetLabels []string
+
+	mu sync.Mutex
+
+	// services and endpoints are keyed by namespace/name. services
+	// are used to name endpoint ports which the endpoints controller,
+	// or whoever manages the endpoints object, left unnamed.
+	services  map[string]*v1.Service
+	endpoints map[string]*v1.Endpoints
+
+	// terminating holds the deletion timestamp of terminating pods,
+	// keyed by namespace/name. draining holds the addresses removed
+	// from each Endpoints which are still draining, keyed by IP.
+	terminating map[string]time.Time
+	draining    map[string]map[string]*drainer
+
+	// labels holds the SubsetLabels of each pod, keyed by
+	// namespace/name. Pods which have none are not retained.
+	labels map[string]map[string]string
+
+	// removed holds the deleted Endpoints whose removal is
+	// scheduled, keyed by namespace/name.
+	removed  map[string]*v1.Endpoints
+	removals scheduler
+}
+
+// drainer is the address of a terminating pod which has been removed
+// from its Endpoints but remains in the ClusterLoadAssignment until
+// its deadline.
+type drainer struct {
+	address  v1.EndpointAddress
+	ports    []v1.EndpointPort
+	deadline time.Time
 }
 
 func (e *EndpointsTranslator) OnAdd(obj interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	switch obj := obj.(type) {
 	case *v1.Endpoints:
 		e.addEndpoints(obj)
+	case *v1.Service:
+		e.updateService(obj)
+	case *v1.Pod:
+		e.updatePod(obj)
 	default:
 		e.Errorf("OnAdd unexpected type %T: %#v", obj, obj)
 	}
 }
 
 func (e *EndpointsTranslator) OnUpdate(oldObj, newObj interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	switch newObj := newObj.(type) {
 	case *v1.Endpoints:
 		oldObj, ok := oldObj.(*v1.Endpoints)
@@ -48,6 +122,10 @@ func (e *EndpointsTranslator) OnUpdate(oldObj, newObj interface{}) {
 			return
 		}
 		e.updateEndpoints(oldObj, newObj)
+	case *v1.Service:
+		e.updateService(newObj)
+	case *v1.Pod:
+		e.updatePod(newObj)
 	default:
 		e.Errorf("OnUpdate unexpected type %T: %#v", newObj, newObj)
 	}
@@ -56,7 +134,18 @@ func (e *EndpointsTranslator) OnUpdate(oldObj, newObj interface{}) {
 func (e *EndpointsTranslator) OnDelete(obj interface{}) {
 	switch obj := obj.(type) {
 	case *v1.Endpoints:
+		e.mu.Lock()
+		defer e.mu.Unlock()
 		e.removeEndpoints(obj)
+	case *v1.Service:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.removeService(obj)
+	case *v1.Pod:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.terminating, obj.Namespace+"/"+obj.Name)
+		delete(e.labels, obj.Namespace+"/"+obj.Name)
 	case _cache.DeletedFinalStateUnknown:
 		e.OnDelete(obj.Obj) // recurse into ourselves with the tombstoned value
 	default:
@@ -65,10 +154,20 @@ func (e *EndpointsTranslator) OnDelete(obj interface{}) {
 }
 
 func (e *EndpointsTranslator) addEndpoints(ep *v1.Endpoints) {
+	e.storeEndpoints(ep)
+	key := ep.Namespace + "/" + ep.Name
+	if oldep, ok := e.removed[key]; ok {
+		// recreated during its grace period.
+		delete(e.removed, key)
+		e.removals.Cancel(key)
+		e.recomputeClusterLoadAssignment(oldep, ep)
+		return
+	}
 	e.recomputeClusterLoadAssignment(nil, ep)
 }
 
 func (e *EndpointsTranslator) updateEndpoints(oldep, newep *v1.Endpoints) {
+	e.storeEndpoints(newep)
 	if len(newep.Subsets) == 0 && len(oldep.Subsets) == 0 {
 		// if there are no endpoints in this object, and the old
 		// object also had zero endpoints, ignore this update
@@ -79,18 +178,318 @@ func (e *EndpointsTranslator) updateEndpoints(oldep, newep *v1.Endpoints) {
 }
 
 func (e *EndpointsTranslator) removeEndpoints(ep *v1.Endpoints) {
+	key := ep.Namespace + "/" + ep.Name
+	delete(e.endpoints, key)
+	if e.RemovalGracePeriod > 0 {
+		if e.removed == nil {
+			e.removed = make(map[string]*v1.Endpoints)
+		}
+		e.removed[key] = ep
+		e.removals.Schedule(key, e.RemovalGracePeriod, func() { e.expireRemoved(key) })
+		return
+	}
+	e.recomputeClusterLoadAssignment(ep, nil)
+}
+
+// expireRemoved removes the ClusterLoadAssignments of the deleted
+// Endpoints key once its grace period has passed.
+func (e *EndpointsTranslator) expireRemoved(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ep, ok := e.removed[key]
+	if !ok {
+		// recreated after the timer fired.
+		return
+	}
+	delete(e.removed, key)
 	e.recomputeClusterLoadAssignment(ep, nil)
 }
 
+func (e *EndpointsTranslator) storeEndpoints(ep *v1.Endpoints) {
+	if e.endpoints == nil {
+		e.endpoints = make(map[string]*v1.Endpoints)
+	}
+	e.endpoints[ep.Namespace+"/"+ep.Name] = ep
+}
+
+// updateService records svc and, if its endpoints are known, replaces
+// their ClusterLoadAssignments with ones named using svc's ports.
+func (e *EndpointsTranslator) updateService(svc *v1.Service) {
+	key := svc.Namespace + "/" + svc.Name
+	ep, ok := e.endpoints[key]
+	var remove []string
+	if ok {
+		_, remove = e.clusterLoadAssignments(ep, nil)
+	}
+	if e.services == nil {
+		e.services = make(map[string]*v1.Service)
+	}
+	e.services[key] = svc
+	if ok {
+		// the renamed ClusterLoadAssignments replace the old ones
+		// in a single update, so none is ever published missing.
+		add, _ := e.clusterLoadAssignments(nil, ep)
+		e.update(add, remove)
+	}
+}
+
+func (e *EndpointsTranslator) removeService(svc *v1.Service) {
+	key := svc.Namespace + "/" + svc.Name
+	ep, ok := e.endpoints[key]
+	var remove []string
+	if ok {
+		_, remove = e.clusterLoadAssignments(ep, nil)
+	}
+	delete(e.services, key)
+	if ok {
+		add, _ := e.clusterLoadAssignments(nil, ep)
+		e.update(add, remove)
+	}
+}
+
+// updatePod records whether pod is terminating, and its SubsetLabels.
+// Only terminating pods, and those with SubsetLabels, are retained.
+func (e *EndpointsTranslator) updatePod(pod *v1.Pod) {
+	key := pod.Namespace + "/" + pod.Name
+	e.updatePodLabels(key, pod)
+	if pod.DeletionTimestamp == nil {
+		delete(e.terminating, key)
+		return
+	}
+	if e.terminating == nil {
+		e.terminating = make(map[string]time.Time)
+	}
+	e.terminating[key] = pod.DeletionTimestamp.Time
+}
+
+// updatePodLabels records the SubsetLabels of pod and, if they have
+// changed, recomputes the ClusterLoadAssignments of the Endpoints in
+// pod's namespace which include it.
+func (e *EndpointsTranslator) updatePodLabels(key string, pod *v1.Pod) {
+	if len(e.SubsetLabels) == 0 {
+		return
+	}
+	labels := make(map[string]string)
+	for _, l := range e.SubsetLabels {
+		if v, ok := pod.Labels[l]; ok {
+			labels[l] = v
+		}
+	}
+	if old := e.labels[key]; len(labels) == len(old) && (len(old) == 0 || reflect.DeepEqual(labels, old)) {
+		return
+	}
+	if len(labels) == 0 {
+		delete(e.labels, key)
+	} else {
+		if e.labels == nil {
+			e.labels = make(map[string]map[string]string)
+		}
+		e.labels[key] = labels
+	}
+	for _, ep := range e.endpoints {
+		if ep.Namespace == pod.Namespace && includesPod(ep, pod.Name) {
+			e.recomputeClusterLoadAssignment(&v1.Endpoints{ObjectMeta: ep.ObjectMeta}, ep)
+		}
+	}
+}
+
+// includesPod returns true if any address of ep is that of the named
+// pod in ep's namespace.
+func includesPod(ep *v1.Endpoints, name string) bool {
+	for _, s := range ep.Subsets {
+		for _, as := range [][]v1.EndpointAddress{s.Addresses, s.NotReadyAddresses} {
+			for _, a := range as {
+				if a.TargetRef != nil && a.TargetRef.Kind == "Pod" && a.TargetRef.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// metadata returns the load balancing metadata of the endpoint of
+// address a, in namespace, or nil if its pod has no SubsetLabels.
+func (e *EndpointsTranslator) metadata(namespace string, a v1.EndpointAddress) *core.Metadata {
+	if a.TargetRef == nil || a.TargetRef.Kind != "Pod" {
+		return nil
+	}
+	labels := e.labels[namespace+"/"+a.TargetRef.Name]
+	if len(labels) == 0 {
+		return nil
+	}
+	fields := make(map[string]*types.Value)
+	for k, v := range labels {
+		fields[k] = sv(v)
+	}
+	return &core.Metadata{
+		FilterMetadata: map[string]*types.Struct{
+			lbMetadataNamespace: {Fields: fields},
+		},
+	}
+}
+
+// lbMetadataNamespace is the filter metadata namespace of the metadata
+// which Envoy's subset load balancer, and RouteAction.metadata_match,
+// use.
+const lbMetadataNamespace = "envoy.lb"
+
+// drain updates the addresses draining from newep, given that oldep
+// was its previous value, and adds those still draining to clas. It
+// returns the drainers which have expired.
+func (e *EndpointsTranslator) drain(oldep, newep *v1.Endpoints, clas map[string]*v2.ClusterLoadAssignment) []*drainer {
+	if e.DrainTimeout <= 0 {
+		return nil
+	}
+	key := newep.Namespace + "/" + newep.Name
+	ready := make(map[string]bool)
+	for _, s := range newep.Subsets {
+		for _, a := range s.Addresses {
+			ready[a.IP] = true
+		}
+		if e.NotReadyAddresses {
+			// a not-ready address is still present, as UNHEALTHY.
+			for _, a := range s.NotReadyAddresses {
+				ready[a.IP] = true
+			}
+		}
+	}
+
+	now := time.Now()
+	draining := e.draining[key]
+	for _, s := range oldep.Subsets {
+		for _, a := range s.Addresses {
+			if _, ok := draining[a.IP]; ok || ready[a.IP] {
+				continue
+			}
+			if a.TargetRef == nil || a.TargetRef.Kind != "Pod" {
+				continue
+			}
+			deleted, ok := e.terminating[a.TargetRef.Namespace+"/"+a.TargetRef.Name]
+			if !ok {
+				// not terminating, eg. it failed its readiness probe.
+				continue
+			}
+			// there is no point draining a pod after it has been killed.
+			deadline := now.Add(e.DrainTimeout)
+			if deleted.Before(deadline) {
+				deadline = deleted
+			}
+			if !deadline.After(now) {
+				continue
+			}
+			if draining == nil {
+				draining = make(map[string]*drainer)
+			}
+			draining[a.IP] = &drainer{
+				address:  a,
+				ports:    s.Ports,
+				deadline: deadline,
+			}
+			time.AfterFunc(deadline.Sub(now), func() { e.expire(key) })
+		}
+	}
+
+	var expired []*drainer
+	for ip, d := range draining {
+		if ready[ip] || !d.deadline.After(now) {
+			if !ready[ip] {
+				expired = append(expired, d)
+			}
+			delete(draining, ip)
+			continue
+		}
+		for _, p := range d.ports {
+			portname := e.portname(newep, p)
+			cla, ok := clas[portname]
+			if !ok {
+				cla = clusterloadassignment(servicename(newep.ObjectMeta.Namespace, newep.ObjectMeta.Name, portname))
+				clas[portname] = cla
+			}
+			lb := drainingendpoint(d.address.IP, p.Port)
+			lb.Metadata = e.metadata(newep.Namespace, d.address)
+			cla.Endpoints[0].LbEndpoints = append(cla.Endpoints[0].LbEndpoints, lb)
+		}
+	}
+
+	if len(draining) == 0 {
+		delete(e.draining, key)
+		return expired
+	}
+	if e.draining == nil {
+		e.draining = make(map[string]map[string]*drainer)
+	}
+	e.draining[key] = draining
+	return expired
+}
+
+// expire recomputes the ClusterLoadAssignments of the Endpoints
+// named by key once one of its addresses has finished draining.
+func (e *EndpointsTranslator) expire(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ep, ok := e.endpoints[key]
+	if !ok {
+		// the Endpoints has since been deleted.
+		ns, name := key, ""
+		if i := strings.IndexByte(key, '/'); i >= 0 {
+			ns, name = key[:i], key[i+1:]
+		}
+		ep = &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: ns,
+				Name:      name,
+			},
+		}
+	}
+	e.recomputeClusterLoadAssignment(&v1.Endpoints{ObjectMeta: ep.ObjectMeta}, ep)
+}
+
+// portname returns the name of the service port which ep's port p
+// belongs to. If the endpoints controller named p, that name is used.
+// Otherwise, for example the hand written endpoints of a headless
+// service, p takes the name of the service port with the same number,
+// so the ClusterLoadAssignment matches the cluster's EDS service name.
+func (e *EndpointsTranslator) portname(ep *v1.Endpoints, p v1.EndpointPort) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	svc, ok := e.services[ep.Namespace+"/"+ep.Name]
+	if !ok {
+		return ""
+	}
+	for _, sp := range svc.Spec.Ports {
+		if sp.Name == "" {
+			continue
+		}
+		if sp.Port == p.Port || (sp.TargetPort.Type == intstr.Int && sp.TargetPort.IntVal == p.Port) {
+			return sp.Name
+		}
+	}
+	return ""
+}
+
 // recomputeClusterLoadAssignment recomputes the EDS cache taking into account old and new endpoints.
 func (e *EndpointsTranslator) recomputeClusterLoadAssignment(oldep, newep *v1.Endpoints) {
 	// skip computation if either old and new services or endpoints are equal (thus also handling nil)
 	if oldep == newep {
 		return
 	}
+	e.update(e.clusterLoadAssignments(oldep, newep))
+}
 
-	defer e.Notify()
+// update applies the ClusterLoadAssignments to add, and the names to
+// remove, to the EDS cache as a single change, notifying watchers only
+// if a ClusterLoadAssignment changed.
+func (e *EndpointsTranslator) update(add []*v2.ClusterLoadAssignment, remove []string) {
+	if e.Update(add, remove) {
+		e.Notify()
+	}
+}
 
+// clusterLoadAssignments returns the ClusterLoadAssignments to add, and
+// the names of those to remove, when oldep is replaced by newep.
+func (e *EndpointsTranslator) clusterLoadAssignments(oldep, newep *v1.Endpoints) ([]*v2.ClusterLoadAssignment, []string) {
 	if oldep == nil {
 		oldep = &v1.Endpoints{
 			ObjectMeta: newep.ObjectMeta,
@@ -106,8 +505,8 @@ func (e *EndpointsTranslator) recomputeClusterLoadAssignment(oldep, newep *v1.En
 	clas := make(map[string]*v2.ClusterLoadAssignment)
 	// add or update endpoints
 	for _, s := range newep.Subsets {
-		// skip any subsets that don't have ready addresses
-		if len(s.Addresses) == 0 {
+		// skip any subsets that don't have addresses
+		if !e.hasAddresses(s) {
 			continue
 		}
 
@@ -116,41 +515,75 @@ func (e *EndpointsTranslator) recomputeClusterLoadAssignment(oldep, newep *v1.En
 
 			// if this endpoint's service's port has a name, then the endpoint
 			// controller will apply the name here. The name may appear once per subset.
-			portname := p.Name
+			portname := e.portname(newep, p)
 			cla, ok := clas[portname]
 			if !ok {
 				cla = clusterloadassignment(servicename(newep.ObjectMeta.Namespace, newep.ObjectMeta.Name, portname))
 				clas[portname] = cla
 			}
 			for _, a := range s.Addresses {
-				cla.Endpoints[0].LbEndpoints = append(cla.Endpoints[0].LbEndpoints, lbendpoint(a.IP, p.Port))
+				lb := lbendpoint(a.IP, p.Port)
+				lb.Metadata = e.metadata(newep.Namespace, a)
+				cla.Endpoints[0].LbEndpoints = append(cla.Endpoints[0].LbEndpoints, lb)
+			}
+			if e.NotReadyAddresses {
+				for _, a := range s.NotReadyAddresses {
+					lb := unhealthyendpoint(a.IP, p.Port)
+					lb.Metadata = e.metadata(newep.Namespace, a)
+					cla.Endpoints[0].LbEndpoints = append(cla.Endpoints[0].LbEndpoints, lb)
+				}
 			}
 		}
 	}
 
+	expired := e.drain(oldep, newep, clas)
+
 	// iterate all the defined clusters and add or update them.
+	var add []*v2.ClusterLoadAssignment
 	for _, c := range clas {
-		e.Add(c)
+		add = append(add, c)
+	}
+	var remove []string
+
+	// remove any clusters which only held addresses that have finished draining.
+	for _, d := range expired {
+		for _, p := range d.ports {
+			portname := e.portname(newep, p)
+			if _, ok := clas[portname]; !ok {
+				remove = append(remove, servicename(newep.ObjectMeta.Namespace, newep.ObjectMeta.Name, portname))
+			}
+		}
 	}
 
 	// iterate over the ports in the old spec, remove any that are not
 	// mentioned in clas
 	for _, s := range oldep.Subsets {
-		if len(s.Addresses) == 0 {
+		if !e.hasAddresses(s) {
 			continue
 		}
 		for _, p := range s.Ports {
 			// if this endpoint's service's port has a name, then the endpoint
 			// controller will apply the name here. The name may appear once per subset.
-			portname := p.Name
+			portname := e.portname(oldep, p)
 			if _, ok := clas[portname]; !ok {
 				// port is not present in the list added / updated, so remove it
-				e.Remove(servicename(oldep.ObjectMeta.Namespace, oldep.Name, portname))
+				remove = append(remove, servicename(oldep.ObjectMeta.Namespace, oldep.Name, portname))
 			}
 		}
 	}
+	return add, remove
+}
+
+// hasAddresses returns true if s has any addresses which should be
+// added to its ClusterLoadAssignments.
+func (e *EndpointsTranslator) hasAddresses(s v1.EndpointSubset) bool {
+	return len(s.Addresses) > 0 || (e.NotReadyAddresses && len(s.NotReadyAddresses) > 0)
 }
 
+// TODO a configurable overprovisioning factor, see docs/deploy-options.md.
+// Until then Envoy's default of 140 applies, which only affects priority
+// and locality weighted load balancing, and every assignment Contour
+// builds has a single locality at priority zero.
 func clusterloadassignment(name string, lbendpoints ...endpoint.LbEndpoint) *v2.ClusterLoadAssignment {
 	return &v2.ClusterLoadAssignment{
 		ClusterName: name,
@@ -160,8 +593,26 @@ func clusterloadassignment(name string, lbendpoints ...endpoint.LbEndpoint) *v2.
 	}
 }
 
+// drainingendpoint returns an LbEndpoint which Envoy will not send
+// new requests to, at the lowest weight Envoy accepts.
+func drainingendpoint(addr string, port int32) endpoint.LbEndpoint {
+	lb := lbendpoint(addr, port)
+	lb.HealthStatus = core.HealthStatus_DRAINING
+	lb.LoadBalancingWeight = &types.UInt32Value{Value: 1}
+	return lb
+}
+
+// unhealthyendpoint returns an LbEndpoint for a not-ready address,
+// which Envoy will not send requests to.
+func unhealthyendpoint(addr string, port int32) endpoint.LbEndpoint {
+	lb := lbendpoint(addr, port)
+	lb.HealthStatus = core.HealthStatus_UNHEALTHY
+	return lb
+}
+
 func lbendpoint(addr string, port int32) endpoint.LbEndpoint {
 	return endpoint.LbEndpoint{
+		HealthStatus: core.HealthStatus_HEALTHY,
 		Endpoint: &endpoint.Endpoint{
 			Address: &core.Address{
 				Address: &core.Address_SocketAddress{
@@ -14,13 +14,19 @@
 package contour
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestEndpointsTranslatorAddEndpoints(t *testing.T) {
@@ -259,6 +265,309 @@ func TestEndpointsTranslatorScaleToZeroEndpoints(t *testing.T) {
 	}
 }
 
+func TestEndpointsTranslatorHeadlessServiceUnnamedPort(t *testing.T) {
+	s1 := service("default", "headless", v1.ServicePort{
+		Name: "http",
+		Port: 8080,
+	})
+	s1.Spec.ClusterIP = "None"
+	e1 := endpoints("default", "headless", v1.EndpointSubset{
+		Addresses: addresses("192.168.183.24"),
+		Ports:     ports(8080),
+	})
+	want := []proto.Message{
+		clusterloadassignment("default/headless/http", lbendpoint("192.168.183.24", 8080)),
+	}
+
+	tests := map[string][]interface{}{
+		"service before endpoints": {s1, e1},
+		"endpoints before service": {e1, s1},
+	}
+
+	for name, objs := range tests {
+		t.Run(name, func(t *testing.T) {
+			var et EndpointsTranslator
+			for _, o := range objs {
+				et.OnAdd(o)
+			}
+			got := contents(&et)
+			if !reflect.DeepEqual(want, got) {
+				t.Fatalf("expected:\n%v\ngot:\n%v\n", want, got)
+			}
+		})
+	}
+}
+
+func TestEndpointsTranslatorUpdateServiceUnchanged(t *testing.T) {
+	s1 := service("default", "headless", v1.ServicePort{
+		Name: "http",
+		Port: 8080,
+	})
+	s1.Spec.ClusterIP = "None"
+	e1 := endpoints("default", "headless", v1.EndpointSubset{
+		Addresses: addresses("192.168.183.24"),
+		Ports:     ports(8080),
+	})
+
+	var et EndpointsTranslator
+	et.OnAdd(e1)
+	et.OnAdd(s1)
+	last := et.Cond.last
+
+	// an update which does not rename the service's ports must neither
+	// remove its ClusterLoadAssignment nor notify watchers.
+	s2 := s1.DeepCopy()
+	s2.Annotations = map[string]string{"example.com/owner": "team-a"}
+	et.OnUpdate(s1, s2)
+
+	if et.Cond.last != last {
+		t.Fatalf("expected no notification, got %d", et.Cond.last-last)
+	}
+	want := []proto.Message{
+		clusterloadassignment("default/headless/http", lbendpoint("192.168.183.24", 8080)),
+	}
+	got := contents(&et)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected:\n%v\ngot:\n%v\n", want, got)
+	}
+}
+
+func TestEndpointsTranslatorNotReadyAddresses(t *testing.T) {
+	e1 := endpoints("default", "simple", v1.EndpointSubset{
+		Addresses:         addresses("192.168.183.24"),
+		NotReadyAddresses: addresses("192.168.183.25"),
+		Ports:             ports(8080),
+	}, v1.EndpointSubset{
+		NotReadyAddresses: addresses("192.168.183.26"),
+		Ports:             ports(8443),
+	})
+
+	tests := map[string]struct {
+		notready bool
+		want     map[string]core.HealthStatus
+	}{
+		"not ready addresses excluded": {
+			notready: false,
+			want: map[string]core.HealthStatus{
+				"192.168.183.24:8080": core.HealthStatus_HEALTHY,
+			},
+		},
+		"not ready addresses included": {
+			notready: true,
+			want: map[string]core.HealthStatus{
+				"192.168.183.24:8080": core.HealthStatus_HEALTHY,
+				"192.168.183.25:8080": core.HealthStatus_UNHEALTHY,
+				"192.168.183.26:8443": core.HealthStatus_UNHEALTHY,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			et := EndpointsTranslator{
+				NotReadyAddresses: tc.notready,
+			}
+			et.OnAdd(e1)
+			got := make(map[string]core.HealthStatus)
+			for _, m := range contents(&et) {
+				for _, lb := range m.(*v2.ClusterLoadAssignment).Endpoints[0].LbEndpoints {
+					sa := lb.Endpoint.Address.GetSocketAddress()
+					got[fmt.Sprintf("%s:%d", sa.Address, sa.GetPortValue())] = lb.HealthStatus
+				}
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot:\n%v\n", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEndpointsTranslatorDrainTerminatingPod(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "kuard-1",
+			Namespace:         "default",
+			DeletionTimestamp: &metav1.Time{Time: time.Now().Add(time.Minute)},
+		},
+	}
+	e1 := endpoints("default", "simple", v1.EndpointSubset{
+		Addresses: []v1.EndpointAddress{{
+			IP: "192.168.183.24",
+			TargetRef: &v1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: "default",
+				Name:      "kuard-1",
+			},
+		}, {
+			IP: "192.168.183.25",
+			TargetRef: &v1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: "default",
+				Name:      "kuard-2",
+			},
+		}},
+		Ports: ports(8080),
+	})
+	// e2 is e1 after kuard-1 began terminating
+	e2 := endpoints("default", "simple", v1.EndpointSubset{
+		Addresses: e1.Subsets[0].Addresses[1:],
+		Ports:     ports(8080),
+	})
+
+	tests := map[string]struct {
+		drainTimeout time.Duration
+		want         []proto.Message
+	}{
+		"draining disabled": {
+			want: []proto.Message{
+				clusterloadassignment("default/simple", lbendpoint("192.168.183.25", 8080)),
+			},
+		},
+		"draining enabled": {
+			drainTimeout: 30 * time.Second,
+			want: []proto.Message{
+				clusterloadassignment("default/simple",
+					lbendpoint("192.168.183.25", 8080),
+					drainingendpoint("192.168.183.24", 8080),
+				),
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			et := EndpointsTranslator{
+				DrainTimeout: tc.drainTimeout,
+			}
+			et.OnAdd(e1)
+			et.OnAdd(pod)
+			et.OnUpdate(e1, e2)
+			got := contents(&et)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot:\n%v\n", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEndpointsTranslatorSubsetLabels(t *testing.T) {
+	pod := func(name string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    labels,
+			},
+		}
+	}
+	e1 := endpoints("default", "simple", v1.EndpointSubset{
+		Addresses: []v1.EndpointAddress{{
+			IP: "192.168.183.24",
+			TargetRef: &v1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: "default",
+				Name:      "kuard-1",
+			},
+		}, {
+			IP: "192.168.183.25",
+			TargetRef: &v1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: "default",
+				Name:      "kuard-2",
+			},
+		}},
+		Ports: ports(8080),
+	})
+	withmetadata := func(lb endpoint.LbEndpoint, version string) endpoint.LbEndpoint {
+		lb.Metadata = &core.Metadata{
+			FilterMetadata: map[string]*types.Struct{
+				"envoy.lb": {
+					Fields: map[string]*types.Value{
+						"version": sv(version),
+					},
+				},
+			},
+		}
+		return lb
+	}
+
+	tests := map[string]struct {
+		subsetLabels []string
+		objs         []interface{}
+		want         []proto.Message
+	}{
+		"subset labels disabled": {
+			objs: []interface{}{
+				e1,
+				pod("kuard-1", map[string]string{"app": "kuard", "version": "v1"}),
+			},
+			want: []proto.Message{
+				clusterloadassignment("default/simple",
+					lbendpoint("192.168.183.24", 8080),
+					lbendpoint("192.168.183.25", 8080),
+				),
+			},
+		},
+		"pods labelled after their endpoints": {
+			subsetLabels: []string{"version"},
+			objs: []interface{}{
+				e1,
+				pod("kuard-1", map[string]string{"app": "kuard", "version": "v1"}),
+				pod("kuard-2", map[string]string{"app": "kuard"}),
+			},
+			want: []proto.Message{
+				clusterloadassignment("default/simple",
+					withmetadata(lbendpoint("192.168.183.24", 8080), "v1"),
+					lbendpoint("192.168.183.25", 8080),
+				),
+			},
+		},
+		"pods labelled before their endpoints": {
+			subsetLabels: []string{"version"},
+			objs: []interface{}{
+				pod("kuard-1", map[string]string{"version": "v1"}),
+				pod("kuard-2", map[string]string{"version": "v2"}),
+				e1,
+			},
+			want: []proto.Message{
+				clusterloadassignment("default/simple",
+					withmetadata(lbendpoint("192.168.183.24", 8080), "v1"),
+					withmetadata(lbendpoint("192.168.183.25", 8080), "v2"),
+				),
+			},
+		},
+		"pod relabelled": {
+			subsetLabels: []string{"version"},
+			objs: []interface{}{
+				e1,
+				pod("kuard-1", map[string]string{"version": "v1"}),
+				pod("kuard-1", map[string]string{"version": "v2"}),
+			},
+			want: []proto.Message{
+				clusterloadassignment("default/simple",
+					withmetadata(lbendpoint("192.168.183.24", 8080), "v2"),
+					lbendpoint("192.168.183.25", 8080),
+				),
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			et := EndpointsTranslator{
+				SubsetLabels: tc.subsetLabels,
+			}
+			for _, o := range tc.objs {
+				et.OnAdd(o)
+			}
+			got := contents(&et)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot:\n%v\n", tc.want, got)
+			}
+		})
+	}
+}
+
 type clusterLoadAssignmentsByName []proto.Message
 
 func (c clusterLoadAssignmentsByName) Len() int      { return len(c) }
@@ -266,3 +575,38 @@ func (c clusterLoadAssignmentsByName) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
 func (c clusterLoadAssignmentsByName) Less(i, j int) bool {
 	return c[i].(*v2.ClusterLoadAssignment).ClusterName < c[j].(*v2.ClusterLoadAssignment).ClusterName
 }
+
+func TestEndpointsTranslatorRemovalGracePeriod(t *testing.T) {
+	e1 := endpoints("default", "simple", v1.EndpointSubset{
+		Addresses: addresses("192.168.183.24"),
+		Ports:     ports(8080),
+	})
+	want := []proto.Message{
+		clusterloadassignment("default/simple", lbendpoint("192.168.183.24", 8080)),
+	}
+
+	var fc fakeClock
+	et := EndpointsTranslator{
+		RemovalGracePeriod: time.Minute,
+		removals:           scheduler{clock: &fc},
+	}
+	et.OnAdd(e1)
+	et.OnDelete(e1)
+	fc.Advance(59 * time.Second)
+	if got := contents(&et); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected:\n%v\ngot:\n%v\n", want, got)
+	}
+	fc.Advance(time.Second)
+	if got := contents(&et); len(got) != 0 {
+		t.Fatalf("expected no ClusterLoadAssignments, got:\n%v\n", got)
+	}
+
+	// recreating the endpoints cancels their removal.
+	et.OnAdd(e1)
+	et.OnDelete(e1)
+	et.OnAdd(e1)
+	fc.Advance(time.Hour)
+	if got := contents(&et); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected:\n%v\ngot:\n%v\n", want, got)
+	}
+}
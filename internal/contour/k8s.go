@@ -17,9 +17,12 @@
 package contour
 
 import (
+	"strings"
+
 	"github.com/heptio/contour/internal/dag"
 	"github.com/heptio/contour/internal/metrics"
 	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 )
 
@@ -30,7 +33,8 @@ const DEFAULT_INGRESS_CLASS = "contour"
 // same interface) and calls through to the CacheHandler to notify it
 // that the contents of the dag.Builder have changed.
 type ResourceEventHandler struct {
-	// Contour's IngressClass.
+	// Contour's IngressClass, or a comma separated list of
+	// IngressClasses, any of which Contour serves.
 	// If not set, defaults to DEFAULT_INGRESS_CLASS.
 	IngressClass string
 
@@ -56,10 +60,24 @@ func (reh *ResourceEventHandler) OnAdd(obj interface{}) {
 		return
 	}
 	reh.Insert(obj)
+	if ep, ok := obj.(*v1.Endpoints); ok && !dag.HasReadyAddresses(ep) {
+		// no different to the Endpoints not existing.
+		return
+	}
 	reh.update()
 }
 
 func (reh *ResourceEventHandler) OnUpdate(oldObj, newObj interface{}) {
+	if oldEp, ok := oldObj.(*v1.Endpoints); ok {
+		// Endpoints change often, the DAG only depends on whether
+		// they have ready addresses.
+		newEp := newObj.(*v1.Endpoints)
+		reh.Insert(newEp)
+		if dag.HasReadyAddresses(oldEp) != dag.HasReadyAddresses(newEp) {
+			reh.update()
+		}
+		return
+	}
 	oldValid, newValid := reh.validIngressClass(oldObj), reh.validIngressClass(newObj)
 	switch {
 	case !oldValid && !newValid:
@@ -83,6 +101,15 @@ func (reh *ResourceEventHandler) OnDelete(obj interface{}) {
 	defer timer.ObserveDuration()
 	// no need to check ingress class here
 	reh.Remove(obj)
+	if ep, ok := obj.(*v1.Endpoints); ok && !dag.HasReadyAddresses(ep) {
+		return
+	}
+	reh.update()
+}
+
+// Recompute forces the contents of the dag.Builder to be rebuilt and
+// the caches updated, even if no Kubernetes objects have changed.
+func (reh *ResourceEventHandler) Recompute() {
 	reh.update()
 }
 
@@ -94,21 +121,35 @@ func (reh *ResourceEventHandler) update() {
 //
 // 1. obj is not of type *v1beta1.Ingress.
 // 2. obj has no ingress.class annotation.
-// 2. obj's ingress.class annotation matches d.IngressClass.
+// 2. obj's ingress.class annotation matches one of d.IngressClass.
 func (reh *ResourceEventHandler) validIngressClass(obj interface{}) bool {
 	i, ok := obj.(*v1beta1.Ingress)
 	if !ok {
 		return true
 	}
 	class, ok := i.Annotations["kubernetes.io/ingress.class"]
-	return !ok || class == reh.ingressClass()
+	if !ok {
+		return true
+	}
+	for _, c := range reh.ingressClasses() {
+		if class == c {
+			return true
+		}
+	}
+	return false
 }
 
-// ingressClass returns the IngressClass
-// or DEFAULT_INGRESS_CLASS if not configured.
-func (reh *ResourceEventHandler) ingressClass() string {
-	if reh.IngressClass != "" {
-		return reh.IngressClass
+// ingressClasses returns the classes listed in IngressClass
+// or DEFAULT_INGRESS_CLASS if none are configured.
+func (reh *ResourceEventHandler) ingressClasses() []string {
+	var classes []string
+	for _, c := range strings.Split(reh.IngressClass, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			classes = append(classes, c)
+		}
+	}
+	if len(classes) == 0 {
+		return []string{DEFAULT_INGRESS_CLASS}
 	}
-	return DEFAULT_INGRESS_CLASS
+	return classes
 }
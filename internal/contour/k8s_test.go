@@ -0,0 +1,246 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/gogo/protobuf/proto"
+	"github.com/heptio/contour/internal/dag"
+	"github.com/heptio/contour/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestResourceEventHandlerRecompute(t *testing.T) {
+	m := metrics.NewMetrics(prometheus.NewRegistry())
+	ch := &CacheHandler{
+		Metrics: m,
+	}
+	reh := ResourceEventHandler{
+		Notifier: ch,
+		Metrics:  m,
+	}
+
+	before := ch.RouteCache.last
+	// no objects have changed, but the caches must still be updated.
+	reh.Recompute()
+	if got := ch.RouteCache.last; got <= before {
+		t.Fatalf("expected route cache version to advance past %d, got %d", before, got)
+	}
+	if got := ch.ListenerCache.last; got < 1 {
+		t.Fatalf("expected listener cache version to advance, got %d", got)
+	}
+	if got := ch.clusterCache.last; got < 1 {
+		t.Fatalf("expected cluster cache version to advance, got %d", got)
+	}
+}
+
+func TestResourceEventHandlerForeignIngressClassProducesNothing(t *testing.T) {
+	m := metrics.NewMetrics(prometheus.NewRegistry())
+	ch := &CacheHandler{
+		Metrics: m,
+	}
+	reh := ResourceEventHandler{
+		Notifier: ch,
+		Metrics:  m,
+	}
+
+	ingress := func(class string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kuard",
+				Namespace: "default",
+				Annotations: map[string]string{
+					"kubernetes.io/ingress.class": class,
+				},
+			},
+			Spec: v1beta1.IngressSpec{
+				TLS: []v1beta1.IngressTLS{{
+					Hosts:      []string{"kuard.example.com"},
+					SecretName: "kuard",
+				}},
+				Rules: []v1beta1.IngressRule{{
+					Host: "kuard.example.com",
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{
+							Paths: []v1beta1.HTTPIngressPath{{
+								Backend: v1beta1.IngressBackend{
+									ServiceName: "kuard",
+									ServicePort: intstr.FromInt(80),
+								},
+							}},
+						},
+					},
+				}},
+			},
+		}
+	}
+	reh.OnAdd(service("default", "kuard", v1.ServicePort{
+		Protocol: "TCP",
+		Port:     80,
+	}))
+	reh.OnAdd(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Data: secretdata("certificate", "key"),
+	})
+
+	assertNothing := func(t *testing.T) {
+		t.Helper()
+		if got := contents(&ch.ListenerCache); len(got) != 0 {
+			t.Fatalf("expected no listeners, got %v", got)
+		}
+		if got := contents(&ch.ClusterCache); len(got) != 0 {
+			t.Fatalf("expected no clusters, got %v", got)
+		}
+		if got := contents(&ch.SecretCache); len(got) != 0 {
+			t.Fatalf("expected no secrets, got %v", got)
+		}
+		// ingress_http is always present, but has no virtual hosts.
+		want := []proto.Message{&v2.RouteConfiguration{Name: "ingress_http"}}
+		if got := contents(&ch.RouteCache); !reflect.DeepEqual(want, got) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	foreign := ingress("nginx")
+	reh.OnAdd(foreign)
+	assertNothing(t)
+
+	// an ingress claimed by contour, then moved to another class,
+	// leaves nothing behind.
+	ours := ingress("contour")
+	reh.OnUpdate(foreign, ours)
+	if got := contents(&ch.ClusterCache); len(got) != 1 {
+		t.Fatalf("expected one cluster, got %v", got)
+	}
+	reh.OnUpdate(ours, foreign)
+	assertNothing(t)
+}
+
+type countingNotifier int
+
+func (c *countingNotifier) OnChange(*dag.Builder) { *c++ }
+
+func TestResourceEventHandlerEndpointsReadiness(t *testing.T) {
+	var changes countingNotifier
+	reh := ResourceEventHandler{
+		Notifier: &changes,
+		Metrics:  metrics.NewMetrics(prometheus.NewRegistry()),
+	}
+	endpoints := func(addresses ...string) *v1.Endpoints {
+		ep := &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kuard",
+				Namespace: "default",
+			},
+		}
+		if len(addresses) > 0 {
+			var subset v1.EndpointSubset
+			for _, a := range addresses {
+				subset.Addresses = append(subset.Addresses, v1.EndpointAddress{IP: a})
+			}
+			ep.Subsets = append(ep.Subsets, subset)
+		}
+		return ep
+	}
+
+	assertChanges := func(t *testing.T, want countingNotifier) {
+		t.Helper()
+		if changes != want {
+			t.Fatalf("expected %d changes, got %d", want, changes)
+		}
+	}
+
+	reh.OnAdd(endpoints())
+	assertChanges(t, 0) // no different to no endpoints.
+	reh.OnUpdate(endpoints(), endpoints("192.168.1.1"))
+	assertChanges(t, 1) // became ready.
+	reh.OnUpdate(endpoints("192.168.1.1"), endpoints("192.168.1.1", "192.168.1.2"))
+	assertChanges(t, 1) // still ready.
+	reh.OnDelete(endpoints("192.168.1.1", "192.168.1.2"))
+	assertChanges(t, 2) // no longer ready.
+}
+
+func TestResourceEventHandlerValidIngressClass(t *testing.T) {
+	ingress := func(class string) *v1beta1.Ingress {
+		ing := &v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kuard",
+				Namespace: "default",
+			},
+		}
+		if class != "" {
+			ing.Annotations = map[string]string{
+				"kubernetes.io/ingress.class": class,
+			}
+		}
+		return ing
+	}
+
+	tests := map[string]struct {
+		ingressClass string
+		obj          interface{}
+		want         bool
+	}{
+		"default class": {
+			obj:  ingress("contour"),
+			want: true,
+		},
+		"no class": {
+			ingressClass: "contour,contour-internal",
+			obj:          ingress(""),
+			want:         true,
+		},
+		"primary class": {
+			ingressClass: "contour,contour-internal",
+			obj:          ingress("contour"),
+			want:         true,
+		},
+		"secondary class": {
+			ingressClass: "contour, contour-internal",
+			obj:          ingress("contour-internal"),
+			want:         true,
+		},
+		"foreign class": {
+			ingressClass: "contour,contour-internal",
+			obj:          ingress("nginx"),
+			want:         false,
+		},
+		"default class not configured": {
+			ingressClass: "contour-internal",
+			obj:          ingress("contour"),
+			want:         false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reh := ResourceEventHandler{
+				IngressClass: tc.ingressClass,
+			}
+			if got := reh.validIngressClass(tc.obj); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
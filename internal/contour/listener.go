@@ -14,18 +14,36 @@
 package contour
 
 import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	"github.com/gogo/protobuf/jsonpb"
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
 	"github.com/heptio/contour/internal/dag"
 	"k8s.io/api/core/v1"
 )
 
+// HTTPListener is the address and port of an additional HTTP listener.
+type HTTPListener struct {
+	// Address is the listener's address.
+	// If not set, defaults to the HTTP listener's address.
+	Address string
+
+	// Port is the listener's port.
+	Port int
+}
+
 // ListenerCache manages the contents of the gRPC LDS cache.
 type ListenerCache struct {
 	// Envoy's HTTP (non TLS) listener address.
@@ -36,6 +54,20 @@ type ListenerCache struct {
 	// If not set, defaults to DEFAULT_HTTP_LISTENER_PORT.
 	HTTPPort int
 
+	// AdditionalHTTPListeners are further HTTP (non TLS) listeners
+	// which serve the same routes as the HTTP listener, each named
+	// for its port.
+	AdditionalHTTPListeners []HTTPListener
+
+	// Envoy's internal HTTP (non TLS) listener address, which serves
+	// only the virtual hosts of internal visibility.
+	// If not set, defaults to the HTTP listener's address.
+	InternalHTTPAddress string
+
+	// Envoy's internal HTTP (non TLS) listener port.
+	// If not set, the internal listener is not created.
+	InternalHTTPPort int
+
 	// Envoy's HTTP (non TLS) access log path.
 	// If not set, defaults to DEFAULT_HTTP_ACCESS_LOG.
 	HTTPAccessLog string
@@ -52,14 +84,105 @@ type ListenerCache struct {
 	// If not set, defaults to DEFAULT_HTTPS_ACCESS_LOG.
 	HTTPSAccessLog string
 
+	// AccessLogFormat selects the format of the HTTP and HTTPS access
+	// logs, either ACCESS_LOG_FORMAT_ENVOY or ACCESS_LOG_FORMAT_JSON.
+	// If not set, defaults to ACCESS_LOG_FORMAT_ENVOY.
+	AccessLogFormat string
+
+	// AccessLogJSONFields maps each key of the JSON access log to the
+	// command operator, eg. REQ(:METHOD), which supplies its value.
+	// If not set, defaults to DEFAULT_ACCESS_LOG_JSON_FIELDS.
+	AccessLogJSONFields map[string]string
+
+	// AccessLogExcludePaths are the request paths, eg. the health check
+	// of a load balancer, which are not access logged for any host.
+	// Virtual hosts may exclude further paths of their own.
+	// If not set, every request is logged.
+	AccessLogExcludePaths []string
+
+	// AccessLogSampleRate, if more than one, access logs one in this
+	// many requests. Virtual hosts may set a rate of their own.
+	// If not set, every request is logged.
+	AccessLogSampleRate uint32
+
+	// AccessLogErrorsOnly, if true, access logs only requests whose
+	// response status is 400 or above.
+	// If not set, defaults to false.
+	AccessLogErrorsOnly bool
+
 	// UseProxyProto configurs all listeners to expect a PROXY protocol
 	// V1 header on new connections.
 	// If not set, defaults to false.
 	UseProxyProto bool
 
+	// TCPFastOpenQueueLength configures all listeners to accept TCP
+	// Fast Open connections with a pending queue of this length.
+	// If not set, TCP Fast Open is not configured.
+	TCPFastOpenQueueLength int
+
+	// StreamIdleTimeout configures the HTTP connection manager to reset
+	// a request stream which has been idle for this duration, independent
+	// of the connection's idle timeout.
+	// If not set, Envoy's default of five minutes applies.
+	StreamIdleTimeout time.Duration
+
+	// UseSDS configures the HTTPS listener to refer to its TLS
+	// certificates by name, fetching them over SDS, rather than
+	// carrying them inline. A certificate rotation then updates
+	// only the secret, not the listener.
+	// If not set, defaults to false.
+	UseSDS bool
+
+	// EnvoyAPICompat selects how the TLS configuration of the HTTPS
+	// listener's filter chains is emitted, either ENVOY_API_COMPAT_TLS_CONTEXT
+	// or ENVOY_API_COMPAT_TRANSPORT_SOCKET.
+	// If not set, defaults to ENVOY_API_COMPAT_TLS_CONTEXT.
+	EnvoyAPICompat string
+
+	// Gzip, if true, compresses responses to clients which accept
+	// gzip encoding.
+	Gzip bool
+
+	// GzipContentTypes is the set of response content types which
+	// are compressed.
+	// If not set, Envoy's default set of text types applies.
+	GzipContentTypes []string
+
+	// GzipMinContentLength is the minimum length, in bytes, of the
+	// responses which are compressed.
+	// If not set, Envoy's default of 30 bytes applies.
+	GzipMinContentLength int
+
+	// StatsPrefix, if set, is prepended to the stat_prefix of every
+	// network filter, eg. the name of the Contour pod, so the stats of
+	// several deployments sharing a stats sink do not collide.
+	StatsPrefix string
+
+	// TODO path normalization flags, see docs/deploy-options.md.
+
+	// TODO a maximum downstream connection duration, see
+	// docs/deploy-options.md.
+
+	// TODO replacing the bodies of Envoy's local replies, see
+	// docs/deploy-options.md.
+
+	// TODO limits on the number of downstream connections, per listener
+	// and across the whole Envoy; see docs/deploy-options.md.
+
 	listenerCache
 }
 
+// Envoy API compatibility modes.
+const (
+	// ENVOY_API_COMPAT_TLS_CONTEXT sets FilterChain.TlsContext, which
+	// the Envoy versions Contour deploys understand.
+	ENVOY_API_COMPAT_TLS_CONTEXT = "tls-context"
+
+	// ENVOY_API_COMPAT_TRANSPORT_SOCKET sets FilterChain.TransportSocket
+	// instead, as newer Envoys deprecate TlsContext.
+	ENVOY_API_COMPAT_TRANSPORT_SOCKET = "transport-socket"
+)
+
 // httpAddress returns the port for the HTTP (non TLS)
 // listener or DEFAULT_HTTP_LISTENER_ADDRESS if not configured.
 func (lc *ListenerCache) httpAddress() string {
@@ -114,6 +237,15 @@ func (lc *ListenerCache) httpsAccessLog() string {
 	return DEFAULT_HTTPS_ACCESS_LOG
 }
 
+// accessLogJSONFields returns the fields of the JSON access log
+// or DEFAULT_ACCESS_LOG_JSON_FIELDS if not configured.
+func (lc *ListenerCache) accessLogJSONFields() map[string]string {
+	if len(lc.AccessLogJSONFields) > 0 {
+		return lc.AccessLogJSONFields
+	}
+	return DEFAULT_ACCESS_LOG_JSON_FIELDS
+}
+
 type listenerCache struct {
 	mu      sync.Mutex
 	values  map[string]*v2.Listener
@@ -142,14 +274,26 @@ func (c *listenerCache) Register(ch chan int, last int) {
 }
 
 // Update replaces the contents of the cache with the supplied map.
+// If v is unchanged, registered waiters are not notified.
 func (c *listenerCache) Update(v map[string]*v2.Listener) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.values != nil && reflect.DeepEqual(c.values, v) {
+		return
+	}
 	c.values = v
 	c.notify()
 }
 
+// Notify notifies all registered waiters, even if the contents of
+// the cache have not changed.
+func (c *listenerCache) Notify() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify()
+}
+
 // notify notifies all registered waiters that an event has occurred.
 func (c *listenerCache) notify() {
 	c.last++
@@ -160,15 +304,20 @@ func (c *listenerCache) notify() {
 	c.waiters = c.waiters[:0]
 }
 
-// Values returns a slice of the value stored in the cache.
+// Values returns a slice of the value stored in the cache, sorted by name.
 func (c *listenerCache) Values(filter func(string) bool) []proto.Message {
 	c.mu.Lock()
-	values := make([]proto.Message, 0, len(c.values))
-	for _, v := range c.values {
-		if filter(v.Name) {
-			values = append(values, v)
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		if filter(name) {
+			names = append(names, name)
 		}
 	}
+	sort.Strings(names)
+	values := make([]proto.Message, 0, len(names))
+	for _, name := range names {
+		values = append(values, c.values[name])
+	}
 	c.mu.Unlock()
 	return values
 }
@@ -176,8 +325,10 @@ func (c *listenerCache) Values(filter func(string) bool) []proto.Message {
 const (
 	ENVOY_HTTP_LISTENER            = "ingress_http"
 	ENVOY_HTTPS_LISTENER           = "ingress_https"
+	ENVOY_HTTP_INTERNAL_LISTENER   = "ingress_http_internal"
 	DEFAULT_HTTP_ACCESS_LOG        = "/dev/stdout"
 	DEFAULT_HTTP_LISTENER_ADDRESS  = "0.0.0.0"
+	LOOPBACK_LISTENER_ADDRESS      = "127.0.0.1"
 	DEFAULT_HTTP_LISTENER_PORT     = 8080
 	DEFAULT_HTTPS_ACCESS_LOG       = "/dev/stdout"
 	DEFAULT_HTTPS_LISTENER_ADDRESS = DEFAULT_HTTP_LISTENER_ADDRESS
@@ -185,33 +336,80 @@ const (
 
 	router     = "envoy.router"
 	grpcWeb    = "envoy.grpc_web"
+	buffer     = "envoy.buffer"
+	cors       = "envoy.cors"
+	gzip       = "envoy.gzip"
 	httpFilter = "envoy.http_connection_manager"
 	accessLog  = "envoy.file_access_log"
+
+	tcpProxy     = "envoy.tcp_proxy"
+	tlsInspector = "envoy.listener.tls_inspector"
 )
 
 type listenerVisitor struct {
 	*ListenerCache
 	dag.Visitable
+
+	// buffered is true if any route limits its request body size.
+	buffered bool
+
+	// cors is true if any virtual host or route has a CORS policy.
+	cors bool
+
+	// accessLogFilter, if not nil, filters the requests which are
+	// access logged.
+	accessLogFilter *types.Value
+
+	names RouteConfigNames
 }
 
 func (v *listenerVisitor) Visit() map[string]*v2.Listener {
 	m := make(map[string]*v2.Listener)
-	http := 0
+	v.buffered = buffered(v.Visitable)
+	v.cors = corsenabled(v.Visitable)
+	v.accessLogFilter = accesslogfilter(
+		accesslogexclusions(v.AccessLogExcludePaths, v.Visitable),
+		accesslogsamples(v.AccessLogSampleRate, v.Visitable),
+		v.AccessLogErrorsOnly,
+	)
+	http, internal := 0, 0
 	ingress_https := v2.Listener{
-		Name:    ENVOY_HTTPS_LISTENER,
-		Address: socketaddress(v.httpsAddress(), v.httpsPort()),
+		Name:                   ENVOY_HTTPS_LISTENER,
+		Address:                socketaddress(v.httpsAddress(), v.httpsPort()),
+		TcpFastOpenQueueLength: uint32OrNil(v.TCPFastOpenQueueLength),
 	}
 	filters := []listener.Filter{
-		httpfilter(ENVOY_HTTPS_LISTENER, v.httpsAccessLog()),
+		v.httpfilter(ENVOY_HTTPS_LISTENER, v.httpsAccessLog()),
 	}
+	// TODO serve the secure virtual hosts over HTTP/3 once Contour can
+	// describe a QUIC listener; see docs/deploy-options.md.
 	v.Visitable.Visit(func(vh dag.Vertex) {
 		switch vh := vh.(type) {
 		case *dag.VirtualHost:
 			// we only create on http listener so record the fact
 			// that we need to then double back at the end and add
 			// the listener properly.
+			if vh.Internal {
+				internal++
+				return
+			}
 			http++
 		case *dag.SecureVirtualHost:
+			if vh.TCPProxy != nil {
+				fc, ok := v.tcpproxyfilterchain(vh)
+				if !ok {
+					return
+				}
+				if vh.TCPProxy.Passthrough {
+					// without a TLS context Envoy must be told to
+					// inspect the ClientHello for the SNI hostname.
+					ingress_https.ListenerFilters = []listener.ListenerFilter{{
+						Name: tlsInspector,
+					}}
+				}
+				ingress_https.FilterChains = append(ingress_https.FilterChains, fc)
+				return
+			}
 			data := vh.Data()
 			if data == nil {
 				// no secret for this vhost, skip it
@@ -224,10 +422,23 @@ func (v *listenerVisitor) Visit() map[string]*v2.Listener {
 				TlsContext: tlscontext(data, vh.MinProtoVersion, "h2", "http/1.1"),
 				Filters:    filters,
 			}
+			if v.UseSDS {
+				fc.TlsContext = sdstlscontext(vh.Secret(), vh.MinProtoVersion, "h2", "http/1.1")
+			}
+			if sec := vh.SecondarySecret(); sec != nil {
+				// Envoy selects between certificates based on the
+				// client's supported signature algorithms.
+				ctx := fc.TlsContext.CommonTlsContext
+				if v.UseSDS {
+					ctx.TlsCertificateSdsSecretConfigs = append(ctx.TlsCertificateSdsSecretConfigs, sdssecretconfig(sec))
+				} else {
+					ctx.TlsCertificates = append(ctx.TlsCertificates, tlscertificate(sec.Data()))
+				}
+			}
 			if v.UseProxyProto {
 				fc.UseProxyProto = &types.BoolValue{Value: true}
 			}
-			ingress_https.FilterChains = append(ingress_https.FilterChains, fc)
+			ingress_https.FilterChains = append(ingress_https.FilterChains, v.transportsocket(fc))
 		}
 	})
 	if http > 0 {
@@ -235,16 +446,218 @@ func (v *listenerVisitor) Visit() map[string]*v2.Listener {
 			Name:    ENVOY_HTTP_LISTENER,
 			Address: socketaddress(v.httpAddress(), v.httpPort()),
 			FilterChains: []listener.FilterChain{
-				filterchain(v.UseProxyProto, httpfilter(ENVOY_HTTP_LISTENER, v.httpAccessLog())),
+				filterchain(v.UseProxyProto, v.httpfilter(ENVOY_HTTP_LISTENER, v.httpAccessLog())),
 			},
+			TcpFastOpenQueueLength: uint32OrNil(v.TCPFastOpenQueueLength),
+		}
+		for _, l := range v.AdditionalHTTPListeners {
+			address := l.Address
+			if address == "" {
+				address = v.httpAddress()
+			}
+			name := additionalhttplistener(l.Port)
+			// the listener shares the HTTP listener's route
+			// configuration, but not its stats.
+			f := v.httpfilter(ENVOY_HTTP_LISTENER, v.httpAccessLog())
+			f.Config.Fields["stat_prefix"] = sv(v.statprefix(name))
+			m[name] = &v2.Listener{
+				Name:    name,
+				Address: socketaddress(address, uint32(l.Port)),
+				FilterChains: []listener.FilterChain{
+					filterchain(v.UseProxyProto, f),
+				},
+				TcpFastOpenQueueLength: uint32OrNil(v.TCPFastOpenQueueLength),
+			}
+		}
+	}
+	if internal > 0 && v.InternalHTTPPort != 0 {
+		address := v.InternalHTTPAddress
+		if address == "" {
+			address = v.httpAddress()
+		}
+		m[ENVOY_HTTP_INTERNAL_LISTENER] = &v2.Listener{
+			Name:    ENVOY_HTTP_INTERNAL_LISTENER,
+			Address: socketaddress(address, uint32(v.InternalHTTPPort)),
+			FilterChains: []listener.FilterChain{
+				filterchain(v.UseProxyProto, v.httpfilter(ENVOY_HTTP_INTERNAL_LISTENER, v.httpAccessLog())),
+			},
+			TcpFastOpenQueueLength: uint32OrNil(v.TCPFastOpenQueueLength),
 		}
 	}
 	if len(ingress_https.FilterChains) > 0 {
+		// the dag is not ordered, sort the filter chains so the
+		// listener does not change unless its vhosts do.
+		sort.Stable(filterChainsBySNI(ingress_https.FilterChains))
 		m[ENVOY_HTTPS_LISTENER] = &ingress_https
 	}
 	return m
 }
 
+// additionalhttplistener returns the name of the additional HTTP
+// listener on port.
+func additionalhttplistener(port int) string {
+	return ENVOY_HTTP_LISTENER + "_" + strconv.Itoa(port)
+}
+
+type filterChainsBySNI []listener.FilterChain
+
+func (f filterChainsBySNI) Len() int      { return len(f) }
+func (f filterChainsBySNI) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f filterChainsBySNI) Less(i, j int) bool {
+	return f[i].FilterChainMatch.SniDomains[0] < f[j].FilterChainMatch.SniDomains[0]
+}
+
+// tcpproxyfilterchain returns the filter chain which proxies the TLS
+// connections of vh, matched by SNI hostname, to its TCPProxy's service.
+// It returns false if vh terminates TLS but has no secret.
+func (v *listenerVisitor) tcpproxyfilterchain(vh *dag.SecureVirtualHost) (listener.FilterChain, bool) {
+	fc := listener.FilterChain{
+		FilterChainMatch: &listener.FilterChainMatch{
+			SniDomains: []string{vh.FQDN()},
+		},
+		Filters: []listener.Filter{
+			tcpproxy(v.statprefix(tcpstatprefix(vh.FQDN())), vh.TCPProxy.Service),
+		},
+	}
+	if !vh.TCPProxy.Passthrough {
+		data := vh.Data()
+		if data == nil {
+			return fc, false
+		}
+		fc.TlsContext = tlscontext(data, vh.MinProtoVersion)
+		if v.UseSDS {
+			fc.TlsContext = sdstlscontext(vh.Secret(), vh.MinProtoVersion)
+		}
+	}
+	if v.UseProxyProto {
+		fc.UseProxyProto = &types.BoolValue{Value: true}
+	}
+	return v.transportsocket(fc), true
+}
+
+// statprefix returns the stat_prefix of the filter named name,
+// qualified by the ListenerCache's StatsPrefix if set.
+func (v *listenerVisitor) statprefix(name string) string {
+	if v.StatsPrefix == "" {
+		return name
+	}
+	return v.StatsPrefix + "_" + name
+}
+
+// tcpstatprefix returns the stat_prefix of the TCP proxy for the
+// virtual host fqdn, distinct from that of every other virtual host.
+// Envoy splits stat names on '.', so those of fqdn are replaced.
+func tcpstatprefix(fqdn string) string {
+	return ENVOY_HTTPS_LISTENER + "_" + strings.Replace(fqdn, ".", "_", -1)
+}
+
+// transportsocket returns fc with its TLS context, if any, moved to a
+// TLS transport socket when the ListenerCache is configured for Envoys
+// which deprecate FilterChain.TlsContext. Both forms carry the same
+// DownstreamTlsContext.
+func (v *listenerVisitor) transportsocket(fc listener.FilterChain) listener.FilterChain {
+	if v.EnvoyAPICompat != ENVOY_API_COMPAT_TRANSPORT_SOCKET || fc.TlsContext == nil {
+		return fc
+	}
+	// TODO carry the context in typed_config, rather than as a Struct,
+	// once TransportSocket has it.
+	fc.TransportSocket = &core.TransportSocket{
+		Name:   "envoy.transport_sockets.tls",
+		Config: messagestruct(fc.TlsContext),
+	}
+	fc.TlsContext = nil
+	return fc
+}
+
+// messagestruct encodes pb, which must be a message Contour built,
+// into a Struct by way of its JSON representation.
+func messagestruct(pb proto.Message) *types.Struct {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{OrigName: true}).Marshal(&buf, pb); err != nil {
+		panic(err)
+	}
+	var s types.Struct
+	if err := jsonpb.Unmarshal(&buf, &s); err != nil {
+		panic(err)
+	}
+	return &s
+}
+
+// httpfilter returns the HTTP connection manager filter for the named
+// listener, with the connection options of the ListenerCache applied.
+func (v *listenerVisitor) httpfilter(name, accessLogPath string) listener.Filter {
+	f := httpfilter(v.names.Name(name), accessLogPath)
+	f.Config.Fields["stat_prefix"] = sv(v.statprefix(name))
+	if v.AccessLogFormat == ACCESS_LOG_FORMAT_JSON {
+		f.Config.Fields["access_log"] = jsonaccesslog(accessLogPath, v.accessLogJSONFields())
+	}
+	if v.accessLogFilter != nil {
+		for _, al := range f.Config.Fields["access_log"].GetListValue().GetValues() {
+			al.GetStructValue().Fields["filter"] = v.accessLogFilter
+		}
+	}
+	if v.StreamIdleTimeout > 0 {
+		f.Config.Fields["stream_idle_timeout"] = dv(v.StreamIdleTimeout)
+	}
+	if v.cors {
+		// answer preflight requests before they are buffered or routed.
+		insertfilter(f, st(map[string]*types.Value{
+			"name": sv(cors),
+		}))
+	}
+	if v.buffered {
+		insertfilter(f, bufferfilter())
+	}
+	if v.Gzip {
+		insertfilter(f, v.gzipfilter())
+	}
+	return f
+}
+
+// gzipfilter returns the envoy.gzip HTTP filter configured with the
+// content types and minimum length of the ListenerCache.
+func (v *listenerVisitor) gzipfilter() *types.Value {
+	config := make(map[string]*types.Value)
+	if len(v.GzipContentTypes) > 0 {
+		var cts []*types.Value
+		for _, ct := range v.GzipContentTypes {
+			cts = append(cts, sv(ct))
+		}
+		config["content_type"] = lv(cts...)
+	}
+	if v.GzipMinContentLength > 0 {
+		config["content_length"] = nv(float64(v.GzipMinContentLength))
+	}
+	return st(map[string]*types.Value{
+		"name":   sv(gzip),
+		"config": st(config),
+	})
+}
+
+// insertfilter adds the HTTP filter hf to the connection manager f, after
+// any previously inserted filters. The router, which must always be the
+// last filter, remains last.
+func insertfilter(f listener.Filter, hf *types.Value) {
+	filters := f.Config.Fields["http_filters"].GetListValue()
+	n := len(filters.Values)
+	filters.Values = append(filters.Values[:n-1:n-1], hf, filters.Values[n-1])
+}
+
+// bufferfilter returns the envoy.buffer HTTP filter. Its listener level
+// configuration never applies; every virtual host disables the filter and
+// routes which limit their request body size enable it again, see
+// bufferperroute. The values below are only present because Envoy
+// requires them.
+func bufferfilter() *types.Value {
+	return st(map[string]*types.Value{
+		"name": sv(buffer),
+		"config": st(map[string]*types.Value{
+			"max_request_bytes": nv(math.MaxUint32),
+			"max_request_time":  dv(defaultMaxRequestTime),
+		}),
+	})
+}
+
 func socketaddress(address string, port uint32) core.Address {
 	return core.Address{
 		Address: &core.Address_SocketAddress{
@@ -269,6 +682,20 @@ func filterchain(useproxy bool, filters ...listener.Filter) listener.FilterChain
 	return fc
 }
 
+// tcpproxy returns a TCP proxy filter which forwards connections to
+// the cluster of svc.
+func tcpproxy(statPrefix string, svc *dag.Service) listener.Filter {
+	return listener.Filter{
+		Name: tcpProxy,
+		Config: &types.Struct{
+			Fields: map[string]*types.Value{
+				"stat_prefix": sv(statPrefix),
+				"cluster":     sv(clustername(svc)),
+			},
+		},
+	}
+}
+
 func httpfilter(routename, accessLogPath string) listener.Filter {
 	return listener.Filter{
 		Name: httpFilter,
@@ -314,23 +741,52 @@ func tlscontext(data map[string][]byte, tlsMinProtoVersion auth.TlsParameters_Tl
 			TlsParams: &auth.TlsParameters{
 				TlsMinimumProtocolVersion: tlsMinProtoVersion,
 			},
-			TlsCertificates: []*auth.TlsCertificate{{
-				CertificateChain: &core.DataSource{
-					Specifier: &core.DataSource_InlineBytes{
-						InlineBytes: data[v1.TLSCertKey],
-					},
-				},
-				PrivateKey: &core.DataSource{
-					Specifier: &core.DataSource_InlineBytes{
-						InlineBytes: data[v1.TLSPrivateKeyKey],
-					},
-				},
-			}},
+			TlsCertificates: []*auth.TlsCertificate{
+				tlscertificate(data),
+			},
 			AlpnProtocols: alpnprotos,
 		},
 	}
 }
 
+// sdstlscontext returns a DownstreamTlsContext which fetches the
+// certificate of secret over SDS.
+func sdstlscontext(secret *dag.Secret, tlsMinProtoVersion auth.TlsParameters_TlsProtocol, alpnprotos ...string) *auth.DownstreamTlsContext {
+	return &auth.DownstreamTlsContext{
+		CommonTlsContext: &auth.CommonTlsContext{
+			TlsParams: &auth.TlsParameters{
+				TlsMinimumProtocolVersion: tlsMinProtoVersion,
+			},
+			TlsCertificateSdsSecretConfigs: []*auth.SdsSecretConfig{
+				sdssecretconfig(secret),
+			},
+			AlpnProtocols: alpnprotos,
+		},
+	}
+}
+
+func sdssecretconfig(secret *dag.Secret) *auth.SdsSecretConfig {
+	return &auth.SdsSecretConfig{
+		Name:      secretname(secret),
+		SdsConfig: apiconfigsource("contour"), // hard coded by initconfig
+	}
+}
+
+func tlscertificate(data map[string][]byte) *auth.TlsCertificate {
+	return &auth.TlsCertificate{
+		CertificateChain: &core.DataSource{
+			Specifier: &core.DataSource_InlineBytes{
+				InlineBytes: data[v1.TLSCertKey],
+			},
+		},
+		PrivateKey: &core.DataSource{
+			Specifier: &core.DataSource_InlineBytes{
+				InlineBytes: data[v1.TLSPrivateKeyKey],
+			},
+		},
+	}
+}
+
 func accesslog(path string) *types.Value {
 	return lv(
 		st(map[string]*types.Value{
@@ -346,6 +802,15 @@ func sv(s string) *types.Value {
 	return &types.Value{Kind: &types.Value_StringValue{StringValue: s}}
 }
 
+// dv returns d as a protobuf JSON duration, eg. "1.5s".
+func dv(d time.Duration) *types.Value {
+	return sv(strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s")
+}
+
+func nv(n float64) *types.Value {
+	return &types.Value{Kind: &types.Value_NumberValue{NumberValue: n}}
+}
+
 func bv(b bool) *types.Value {
 	return &types.Value{Kind: &types.Value_BoolValue{BoolValue: b}}
 }
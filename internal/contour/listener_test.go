@@ -16,9 +16,11 @@ package contour
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
 	"github.com/gogo/protobuf/types"
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
@@ -148,6 +150,156 @@ func TestListenerVisit(t *testing.T) {
 				},
 			},
 		},
+		"ingress with two tls blocks with different secrets": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"a.example.com"},
+							SecretName: "secret-a",
+						}, {
+							Hosts:      []string{"b.example.com"},
+							SecretName: "secret-b",
+						}},
+						Rules: []v1beta1.IngressRule{{
+							Host: "a.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: v1beta1.IngressBackend{
+											ServiceName: "kuard",
+											ServicePort: intstr.FromInt(8080),
+										},
+									}},
+								},
+							},
+						}, {
+							Host: "b.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: v1beta1.IngressBackend{
+											ServiceName: "kuard",
+											ServicePort: intstr.FromInt(8080),
+										},
+									}},
+								},
+							},
+						}},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret-a",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate-a", "key-a"),
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret-b",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate-b", "key-b"),
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+				},
+				ENVOY_HTTPS_LISTENER: {
+					Name:    ENVOY_HTTPS_LISTENER,
+					Address: socketaddress("0.0.0.0", 8443),
+					FilterChains: []listener.FilterChain{{
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"a.example.com"},
+						},
+						TlsContext: tlscontext(secretdata("certificate-a", "key-a"), auth.TlsParameters_TLSv1_1, "h2", "http/1.1"),
+						Filters: []listener.Filter{
+							httpfilter(ENVOY_HTTPS_LISTENER, DEFAULT_HTTPS_ACCESS_LOG),
+						},
+					}, {
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"b.example.com"},
+						},
+						TlsContext: tlscontext(secretdata("certificate-b", "key-b"), auth.TlsParameters_TLSv1_1, "h2", "http/1.1"),
+						Filters: []listener.Filter{
+							httpfilter(ENVOY_HTTPS_LISTENER, DEFAULT_HTTPS_ACCESS_LOG),
+						},
+					}},
+				},
+			},
+		},
+		"ingress with secondary secret": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/tls-secondary-secret": "secret-ecdsa",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"whatever.example.com"},
+							SecretName: "secret",
+						}},
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret-ecdsa",
+						Namespace: "default",
+					},
+					Data: secretdata("ecdsa-certificate", "ecdsa-key"),
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+				},
+				ENVOY_HTTPS_LISTENER: {
+					Name:    ENVOY_HTTPS_LISTENER,
+					Address: socketaddress("0.0.0.0", 8443),
+					FilterChains: []listener.FilterChain{{
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"whatever.example.com"},
+						},
+						TlsContext: withcertificate(
+							tlscontext(secretdata("certificate", "key"), auth.TlsParameters_TLSv1_1, "h2", "http/1.1"),
+							secretdata("ecdsa-certificate", "ecdsa-key"),
+						),
+						Filters: []listener.Filter{
+							httpfilter(ENVOY_HTTPS_LISTENER, DEFAULT_HTTPS_ACCESS_LOG),
+						},
+					}},
+				},
+			},
+		},
 		"simple ingress with missing secret": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
@@ -241,6 +393,90 @@ func TestListenerVisit(t *testing.T) {
 				},
 			},
 		},
+		"two tcpproxy ingressroutes routed by sni": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard-tcp",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "kuard.example.com",
+							TLS: &ingressroutev1.TLS{
+								Passthrough: true,
+							},
+						},
+						TCPProxy: &ingressroutev1.TCPProxy{
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 8443,
+							}},
+						},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "nginx-tcp",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "nginx.example.com",
+							TLS: &ingressroutev1.TLS{
+								SecretName: "secret",
+							},
+						},
+						TCPProxy: &ingressroutev1.TCPProxy{
+							Services: []ingressroutev1.Service{{
+								Name: "nginx",
+								Port: 9000,
+							}},
+						},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				service("default", "kuard", v1.ServicePort{
+					Protocol: "TCP",
+					Port:     8443,
+				}),
+				service("default", "nginx", v1.ServicePort{
+					Protocol: "TCP",
+					Port:     9000,
+				}),
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTPS_LISTENER: {
+					Name:    ENVOY_HTTPS_LISTENER,
+					Address: socketaddress("0.0.0.0", 8443),
+					ListenerFilters: []listener.ListenerFilter{{
+						Name: "envoy.listener.tls_inspector",
+					}},
+					FilterChains: []listener.FilterChain{{
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"kuard.example.com"},
+						},
+						Filters: []listener.Filter{
+							tcpproxyfilter("ingress_https_kuard_example_com", "default/kuard/8443"),
+						},
+					}, {
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"nginx.example.com"},
+						},
+						TlsContext: tlscontext(secretdata("certificate", "key"), auth.TlsParameters_TLSv1_1),
+						Filters: []listener.Filter{
+							tcpproxyfilter("ingress_https_nginx_example_com", "default/nginx/9000"),
+						},
+					}},
+				},
+			},
+		},
 		"ingress with allow-http: false": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
@@ -361,6 +597,34 @@ func TestListenerVisit(t *testing.T) {
 				},
 			},
 		},
+		"http listener on loopback": {
+			ListenerCache: &ListenerCache{
+				HTTPAddress: LOOPBACK_LISTENER_ADDRESS,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("127.0.0.1", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+				},
+			},
+		},
 		"use proxy proto": {
 			ListenerCache: &ListenerCache{
 				UseProxyProto: true,
@@ -414,36 +678,830 @@ func TestListenerVisit(t *testing.T) {
 				},
 			},
 		},
-	}
-
-	for name, tc := range tests {
-		t.Run(name, func(t *testing.T) {
-			reh := ResourceEventHandler{
-				Notifier: new(nullNotifier),
-				Metrics:  metrics.NewMetrics(prometheus.NewRegistry()),
-			}
-			for _, o := range tc.objs {
-				reh.OnAdd(o)
-			}
-			lc := tc.ListenerCache
-			if lc == nil {
-				lc = new(ListenerCache)
-			}
-			v := listenerVisitor{
-				ListenerCache: lc,
-				Visitable:     reh.Build(),
-			}
-			got := v.Visit()
-			if !reflect.DeepEqual(tc.want, got) {
-				t.Fatalf("expected:\n%+v\ngot:\n%+v", tc.want, got)
-			}
-		})
-	}
-}
-
-func secretdata(cert, key string) map[string][]byte {
-	return map[string][]byte{
-		v1.TLSCertKey:       []byte(cert),
-		v1.TLSPrivateKeyKey: []byte(key),
-	}
+		"tcp fast open": {
+			ListenerCache: &ListenerCache{
+				TCPFastOpenQueueLength: 32,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"whatever.example.com"},
+							SecretName: "secret",
+						}},
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+					TcpFastOpenQueueLength: &types.UInt32Value{Value: 32},
+				},
+				ENVOY_HTTPS_LISTENER: {
+					Name:    ENVOY_HTTPS_LISTENER,
+					Address: socketaddress("0.0.0.0", 8443),
+					FilterChains: []listener.FilterChain{{
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"whatever.example.com"},
+						},
+						TlsContext: tlscontext(secretdata("certificate", "key"), auth.TlsParameters_TLSv1_1, "h2", "http/1.1"),
+						Filters: []listener.Filter{
+							httpfilter(ENVOY_HTTPS_LISTENER, DEFAULT_HTTPS_ACCESS_LOG),
+						},
+					}},
+					TcpFastOpenQueueLength: &types.UInt32Value{Value: 32},
+				},
+			},
+		},
+		"websocket routes": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/websocket-routes": "/",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						// websockets are enabled per route.
+						filterchain(false, httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+				},
+			},
+		},
+		"json access log": {
+			ListenerCache: &ListenerCache{
+				AccessLogFormat: ACCESS_LOG_FORMAT_JSON,
+				AccessLogJSONFields: map[string]string{
+					"method":   "REQ(:METHOD)",
+					"status":   "RESPONSE_CODE",
+					"upstream": "UPSTREAM_HOST",
+				},
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withjsonaccesslog(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG), map[string]*types.Value{
+							"method":   sv("%REQ(:METHOD)%"),
+							"status":   sv("%RESPONSE_CODE%"),
+							"upstream": sv("%UPSTREAM_HOST%"),
+						})),
+					},
+				},
+			},
+		},
+		"access log exclude path": {
+			ListenerCache: &ListenerCache{
+				AccessLogExcludePaths: []string{"/healthz"},
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withaccesslogfilter(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG),
+							notheader(":path", "/healthz"),
+						)),
+					},
+				},
+			},
+		},
+		"access log exclude paths annotation": {
+			ListenerCache: &ListenerCache{
+				AccessLogExcludePaths: []string{"/healthz"},
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/access-log-exclude-paths": "/ping",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Rules: []v1beta1.IngressRule{{
+							Host: "www.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: v1beta1.IngressBackend{
+											ServiceName: "kuard",
+											ServicePort: intstr.FromInt(8080),
+										},
+									}},
+								},
+							},
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withaccesslogfilter(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG),
+							st(map[string]*types.Value{
+								"and_filter": st(map[string]*types.Value{
+									"filters": lv(
+										notheader(":path", "/healthz"),
+										st(map[string]*types.Value{
+											"or_filter": st(map[string]*types.Value{
+												"filters": lv(
+													notheader(":authority", "www.example.com"),
+													notheader(":path", "/ping"),
+												),
+											}),
+										}),
+									),
+								}),
+							}),
+						)),
+					},
+				},
+			},
+		},
+		"access log sample rate": {
+			ListenerCache: &ListenerCache{
+				AccessLogSampleRate: 10,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withaccesslogfilter(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG),
+							sampled("contour.access_log.sample_rate", 100000),
+						)),
+					},
+				},
+			},
+		},
+		"access log sample rate annotation, errors only, and exclude path": {
+			ListenerCache: &ListenerCache{
+				AccessLogExcludePaths: []string{"/healthz"},
+				AccessLogSampleRate:   10,
+				AccessLogErrorsOnly:   true,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/access-log-sample-rate": "1",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Rules: []v1beta1.IngressRule{{
+							Host: "www.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: v1beta1.IngressBackend{
+											ServiceName: "kuard",
+											ServicePort: intstr.FromInt(8080),
+										},
+									}},
+								},
+							},
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withaccesslogfilter(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG),
+							st(map[string]*types.Value{
+								"and_filter": st(map[string]*types.Value{
+									"filters": lv(
+										notheader(":path", "/healthz"),
+										st(map[string]*types.Value{
+											"or_filter": st(map[string]*types.Value{
+												"filters": lv(
+													header(":authority", "www.example.com"),
+													st(map[string]*types.Value{
+														"and_filter": st(map[string]*types.Value{
+															"filters": lv(
+																sampled("contour.access_log.sample_rate", 100000),
+																notheader(":authority", "www.example.com"),
+															),
+														}),
+													}),
+												),
+											}),
+										}),
+										st(map[string]*types.Value{
+											"status_code_filter": st(map[string]*types.Value{
+												"comparison": st(map[string]*types.Value{
+													"op": sv("GE"),
+													"value": st(map[string]*types.Value{
+														"default_value": nv(400),
+														"runtime_key":   sv("contour.access_log.min_status"),
+													}),
+												}),
+											}),
+										}),
+									),
+								}),
+							}),
+						)),
+					},
+				},
+			},
+		},
+		"cors policy": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+							CorsPolicy: &ingressroutev1.CorsPolicy{
+								AllowOrigin: []string{"*"},
+							},
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withcors(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG))),
+					},
+				},
+			},
+		},
+		"max request bytes": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/max-request-bytes": "1024",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withbuffer(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG))),
+					},
+				},
+			},
+		},
+		"transport socket": {
+			ListenerCache: &ListenerCache{
+				EnvoyAPICompat: ENVOY_API_COMPAT_TRANSPORT_SOCKET,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"whatever.example.com"},
+							SecretName: "secret",
+						}},
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+				},
+				ENVOY_HTTPS_LISTENER: {
+					Name:    ENVOY_HTTPS_LISTENER,
+					Address: socketaddress("0.0.0.0", 8443),
+					FilterChains: []listener.FilterChain{{
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"whatever.example.com"},
+						},
+						TransportSocket: &core.TransportSocket{
+							Name:   "envoy.transport_sockets.tls",
+							Config: messagestruct(tlscontext(secretdata("certificate", "key"), auth.TlsParameters_TLSv1_1, "h2", "http/1.1")),
+						},
+						Filters: []listener.Filter{
+							httpfilter(ENVOY_HTTPS_LISTENER, DEFAULT_HTTPS_ACCESS_LOG),
+						},
+					}},
+				},
+			},
+		},
+		"stats prefix": {
+			ListenerCache: &ListenerCache{
+				StatsPrefix: "contour-0",
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"whatever.example.com"},
+							SecretName: "secret",
+						}},
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard-tcp",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "kuard.example.com",
+							TLS: &ingressroutev1.TLS{
+								Passthrough: true,
+							},
+						},
+						TCPProxy: &ingressroutev1.TCPProxy{
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 8443,
+							}},
+						},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				service("default", "kuard", v1.ServicePort{
+					Protocol: "TCP",
+					Port:     8443,
+				}),
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, statprefix(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG), "contour-0_ingress_http")),
+					},
+				},
+				ENVOY_HTTPS_LISTENER: {
+					Name:    ENVOY_HTTPS_LISTENER,
+					Address: socketaddress("0.0.0.0", 8443),
+					ListenerFilters: []listener.ListenerFilter{{
+						Name: "envoy.listener.tls_inspector",
+					}},
+					FilterChains: []listener.FilterChain{{
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"kuard.example.com"},
+						},
+						Filters: []listener.Filter{
+							tcpproxyfilter("contour-0_ingress_https_kuard_example_com", "default/kuard/8443"),
+						},
+					}, {
+						FilterChainMatch: &listener.FilterChainMatch{
+							SniDomains: []string{"whatever.example.com"},
+						},
+						TlsContext: tlscontext(secretdata("certificate", "key"), auth.TlsParameters_TLSv1_1, "h2", "http/1.1"),
+						Filters: []listener.Filter{
+							statprefix(httpfilter(ENVOY_HTTPS_LISTENER, DEFAULT_HTTPS_ACCESS_LOG), "contour-0_ingress_https"),
+						},
+					}},
+				},
+			},
+		},
+		"gzip": {
+			ListenerCache: &ListenerCache{
+				Gzip:                 true,
+				GzipContentTypes:     []string{"application/json", "text/html"},
+				GzipMinContentLength: 1024,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, withgzip(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG), map[string]*types.Value{
+							"content_type":   lv(sv("application/json"), sv("text/html")),
+							"content_length": nv(1024),
+						})),
+					},
+				},
+			},
+		},
+		"additional http listeners": {
+			ListenerCache: &ListenerCache{
+				AdditionalHTTPListeners: []HTTPListener{
+					{Port: 8081},
+					{Address: "10.0.0.1", Port: 8082},
+				},
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+				},
+				"ingress_http_8081": {
+					Name:    "ingress_http_8081",
+					Address: socketaddress("0.0.0.0", 8081),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, statprefix(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG), "ingress_http_8081")),
+					},
+				},
+				"ingress_http_8082": {
+					Name:    "ingress_http_8082",
+					Address: socketaddress("10.0.0.1", 8082),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, statprefix(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG), "ingress_http_8082")),
+					},
+				},
+			},
+		},
+		"internal virtual host": {
+			ListenerCache: &ListenerCache{
+				InternalHTTPAddress: "10.0.0.1",
+				InternalHTTPPort:    8081,
+			},
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "admin",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn:       "admin.example.com",
+							Visibility: "internal",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				service("default", "backend", v1.ServicePort{
+					Protocol: "TCP",
+					Port:     80,
+				}),
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_INTERNAL_LISTENER: {
+					Name:    ENVOY_HTTP_INTERNAL_LISTENER,
+					Address: socketaddress("10.0.0.1", 8081),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, httpfilter(ENVOY_HTTP_INTERNAL_LISTENER, DEFAULT_HTTP_ACCESS_LOG)),
+					},
+				},
+			},
+		},
+		"stream idle timeout": {
+			ListenerCache: &ListenerCache{
+				StreamIdleTimeout: 1 * time.Hour,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+			},
+			want: map[string]*v2.Listener{
+				ENVOY_HTTP_LISTENER: {
+					Name:    ENVOY_HTTP_LISTENER,
+					Address: socketaddress("0.0.0.0", 8080),
+					FilterChains: []listener.FilterChain{
+						filterchain(false, streamidletimeout(httpfilter(ENVOY_HTTP_LISTENER, DEFAULT_HTTP_ACCESS_LOG), "3600s")),
+					},
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reh := ResourceEventHandler{
+				Notifier: new(nullNotifier),
+				Metrics:  metrics.NewMetrics(prometheus.NewRegistry()),
+			}
+			lc := tc.ListenerCache
+			if lc == nil {
+				lc = new(ListenerCache)
+			}
+			reh.InternalListener = lc.InternalHTTPPort != 0
+			for _, o := range tc.objs {
+				reh.OnAdd(o)
+			}
+			v := listenerVisitor{
+				ListenerCache: lc,
+				Visitable:     reh.Build(),
+			}
+			got := v.Visit()
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%+v\ngot:\n%+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func tcpproxyfilter(statPrefix, cluster string) listener.Filter {
+	return listener.Filter{
+		Name: "envoy.tcp_proxy",
+		Config: &types.Struct{
+			Fields: map[string]*types.Value{
+				"stat_prefix": sv(statPrefix),
+				"cluster":     sv(cluster),
+			},
+		},
+	}
+}
+
+func secretdata(cert, key string) map[string][]byte {
+	return map[string][]byte{
+		v1.TLSCertKey:       []byte(cert),
+		v1.TLSPrivateKeyKey: []byte(key),
+	}
+}
+
+func statprefix(f listener.Filter, prefix string) listener.Filter {
+	f.Config.Fields["stat_prefix"] = sv(prefix)
+	return f
+}
+
+func streamidletimeout(f listener.Filter, d string) listener.Filter {
+	f.Config.Fields["stream_idle_timeout"] = sv(d)
+	return f
+}
+
+func withcertificate(tc *auth.DownstreamTlsContext, data map[string][]byte) *auth.DownstreamTlsContext {
+	tc.CommonTlsContext.TlsCertificates = append(tc.CommonTlsContext.TlsCertificates, tlscertificate(data))
+	return tc
+}
+
+func withbuffer(f listener.Filter) listener.Filter {
+	f.Config.Fields["http_filters"] = lv(
+		st(map[string]*types.Value{
+			"name": sv(grpcWeb),
+		}),
+		st(map[string]*types.Value{
+			"name": sv(buffer),
+			"config": st(map[string]*types.Value{
+				"max_request_bytes": nv(4294967295),
+				"max_request_time":  sv("15s"),
+			}),
+		}),
+		st(map[string]*types.Value{
+			"name": sv(router),
+		}),
+	)
+	return f
+}
+
+func withjsonaccesslog(f listener.Filter, format map[string]*types.Value) listener.Filter {
+	f.Config.Fields["access_log"] = lv(
+		st(map[string]*types.Value{
+			"name": sv(accessLog),
+			"config": st(map[string]*types.Value{
+				"path":        sv(DEFAULT_HTTP_ACCESS_LOG),
+				"json_format": st(format),
+			}),
+		}),
+	)
+	return f
+}
+
+func withaccesslogfilter(f listener.Filter, filter *types.Value) listener.Filter {
+	for _, al := range f.Config.Fields["access_log"].GetListValue().GetValues() {
+		al.GetStructValue().Fields["filter"] = filter
+	}
+	return f
+}
+
+func header(name, value string) *types.Value {
+	return st(map[string]*types.Value{
+		"header_filter": st(map[string]*types.Value{
+			"header": st(map[string]*types.Value{
+				"name":        sv(name),
+				"exact_match": sv(value),
+			}),
+		}),
+	})
+}
+
+func sampled(key string, numerator float64) *types.Value {
+	return st(map[string]*types.Value{
+		"runtime_filter": st(map[string]*types.Value{
+			"runtime_key": sv(key),
+			"percent_sampled": st(map[string]*types.Value{
+				"numerator":   nv(numerator),
+				"denominator": sv("MILLION"),
+			}),
+		}),
+	})
+}
+
+func notheader(name, value string) *types.Value {
+	return st(map[string]*types.Value{
+		"header_filter": st(map[string]*types.Value{
+			"header": st(map[string]*types.Value{
+				"name":         sv(name),
+				"exact_match":  sv(value),
+				"invert_match": bv(true),
+			}),
+		}),
+	})
+}
+
+func withgzip(f listener.Filter, config map[string]*types.Value) listener.Filter {
+	f.Config.Fields["http_filters"] = lv(
+		st(map[string]*types.Value{
+			"name": sv(grpcWeb),
+		}),
+		st(map[string]*types.Value{
+			"name":   sv(gzip),
+			"config": st(config),
+		}),
+		st(map[string]*types.Value{
+			"name": sv(router),
+		}),
+	)
+	return f
+}
+
+func withcors(f listener.Filter) listener.Filter {
+	f.Config.Fields["http_filters"] = lv(
+		st(map[string]*types.Value{
+			"name": sv(grpcWeb),
+		}),
+		st(map[string]*types.Value{
+			"name": sv(cors),
+		}),
+		st(map[string]*types.Value{
+			"name": sv(router),
+		}),
+	)
+	return f
 }
@@ -23,14 +23,55 @@ import (
 	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	envoy_type "github.com/envoyproxy/go-control-plane/envoy/type"
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
+	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
 	"github.com/heptio/contour/internal/dag"
+	"k8s.io/api/extensions/v1beta1"
 )
 
 // RouteCache manages the contents of the gRPC RDS cache.
 type RouteCache struct {
+	// ResponseHeadersToAdd is a map of header names to values
+	// which are added to every response on every virtual host.
+	ResponseHeadersToAdd map[string]string
+
+	// MaxGRPCTimeout is the default maximum grpc-timeout a gRPC
+	// client may request. Routes may override this value.
+	// If not set, the grpc-timeout header is ignored.
+	MaxGRPCTimeout time.Duration
+
+	// RequestTimeout is the default timeout of the routes which do
+	// not specify their own. If not set, Envoy's default applies.
+	RequestTimeout time.Duration
+
+	// ForwardingHeaders, if true, adds x-forwarded-proto and
+	// x-forwarded-port to the requests proxied for every virtual
+	// host. Virtual hosts may override this value.
+	ForwardingHeaders bool
+
+	// DisableHTTPSRedirect, if true, serves the routes of Ingresses
+	// which request a redirect to HTTPS over plain HTTP instead,
+	// overriding their force-ssl-redirect annotation. TLS is still
+	// terminated for their secure virtual hosts.
+	DisableHTTPSRedirect bool
+
+	// ValidateClusters, if set, controls whether Envoy rejects a route
+	// configuration which refers to a cluster it does not know of.
+	// Disabling it stops one missing cluster from rejecting every
+	// route; Envoy answers requests for such a route with a 503.
+	// If not set, Envoy's default applies.
+	ValidateClusters *bool
+
+	// RouteMetadata, if true, names the Kubernetes object which
+	// produced each route, and its match, in the route's metadata
+	// under the contour filter metadata namespace, so that access logs
+	// and tracing can refer to them.
+	RouteMetadata bool
+
 	routeCache
 }
 
@@ -80,35 +121,78 @@ func (c *routeCache) notify() {
 	c.waiters = c.waiters[:0]
 }
 
-// Values returns a slice of the value stored in the cache.
+// Values returns a slice of the value stored in the cache, sorted by name.
 func (c *routeCache) Values(filter func(string) bool) []proto.Message {
 	c.mu.Lock()
-	values := make([]proto.Message, 0, len(c.values))
-	for _, v := range c.values {
-		if filter(v.Name) {
-			values = append(values, v)
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		if filter(name) {
+			names = append(names, name)
 		}
 	}
+	sort.Strings(names)
+	values := make([]proto.Message, 0, len(names))
+	for _, name := range names {
+		values = append(values, c.values[name])
+	}
 	c.mu.Unlock()
 	return values
 }
 
+// RouteConfigNames names the route configurations served over RDS.
+// The HTTP connection manager of each listener fetches its route
+// configuration by the same name, so both take it from here.
+type RouteConfigNames struct {
+	// Prefix, if set, is prepended to every name, so that Envoys fed
+	// by more than one Contour fetch distinct route configurations.
+	Prefix string
+}
+
+// Name returns the name of the route configuration of the listener
+// named listener.
+func (r RouteConfigNames) Name(listener string) string {
+	if r.Prefix == "" {
+		return listener
+	}
+	return r.Prefix + "_" + listener
+}
+
 type routeVisitor struct {
 	*RouteCache
 	dag.Visitable
+
+	names RouteConfigNames
 }
 
+// defaultMaxRequestTime is the time allowed to receive a request body
+// on a route which limits its size but does not have a finite timeout.
+// It matches Envoy's default route timeout.
+const defaultMaxRequestTime = 15 * time.Second
+
 func (v *routeVisitor) Visit() map[string]*v2.RouteConfiguration {
 	ingress_http := &v2.RouteConfiguration{
-		Name: "ingress_http",
+		Name:                 v.names.Name(ENVOY_HTTP_LISTENER),
+		ResponseHeadersToAdd: headervalueoptions(v.ResponseHeadersToAdd),
+		ValidateClusters:     validateclusters(v.ValidateClusters),
 	}
 	ingress_https := &v2.RouteConfiguration{
-		Name: "ingress_https",
+		Name:                 v.names.Name(ENVOY_HTTPS_LISTENER),
+		ResponseHeadersToAdd: headervalueoptions(v.ResponseHeadersToAdd),
+		ValidateClusters:     validateclusters(v.ValidateClusters),
+	}
+	ingress_http_internal := &v2.RouteConfiguration{
+		Name:                 v.names.Name(ENVOY_HTTP_INTERNAL_LISTENER),
+		ResponseHeadersToAdd: headervalueoptions(v.ResponseHeadersToAdd),
+		ValidateClusters:     validateclusters(v.ValidateClusters),
 	}
 	m := map[string]*v2.RouteConfiguration{
-		ingress_http.Name:  ingress_http,
-		ingress_https.Name: ingress_https,
+		ingress_http.Name:          ingress_http,
+		ingress_https.Name:         ingress_https,
+		ingress_http_internal.Name: ingress_http_internal,
 	}
+	https := 0
+	var catchall []route.VirtualHost
+	buffered := buffered(v.Visitable)
 	v.Visitable.Visit(func(vh dag.Vertex) {
 		switch vh := vh.(type) {
 		case *dag.VirtualHost:
@@ -122,12 +206,24 @@ func (v *routeVisitor) Visit() map[string]*v2.RouteConfiguration {
 				}
 			}
 			vhost := route.VirtualHost{
-				Name:    hashname(60, hostname),
-				Domains: domains,
+				Name:                hashname(60, hostname),
+				Domains:             domains,
+				PerFilterConfig:     vhostfilterconfig(vh.PerFilterConfig, buffered),
+				Cors:                corspolicy(vh.CorsPolicy),
+				RequestHeadersToAdd: v.forwardingheaders(vh.ForwardingHeaders, "http", vh.Port),
 			}
 			vh.Visit(func(r dag.Vertex) {
 				switch r := r.(type) {
 				case *dag.Route:
+					if r.DirectResponse != 0 {
+						vhost.Routes = append(vhost.Routes, route.Route{
+							Match:     routematch(r),
+							Action:    directresponse(r.DirectResponse),
+							Metadata:  v.metadata(r),
+							Decorator: decorator(r),
+						})
+						return
+					}
 					var svcs []*dag.Service
 					r.Visit(func(s dag.Vertex) {
 						if s, ok := s.(*dag.Service); ok {
@@ -139,14 +235,16 @@ func (v *routeVisitor) Visit() map[string]*v2.RouteConfiguration {
 						return
 					}
 					rr := route.Route{
-						Match: prefixmatch(r.Prefix()),
-						Action: actionroute(
-							svcs,
-							r.Websocket,
-							r.Timeout),
+						Match:           routematch(r),
+						Action:          v.action(r, svcs),
+						Metadata:        v.metadata(r),
+						PerFilterConfig: routefilterconfig(r, v.timeout(r)),
+						Decorator:       decorator(r),
 					}
 
-					if r.HTTPSUpgrade {
+					// the redirect wins over a websocket upgrade, so
+					// ws:// clients of the route must use wss://.
+					if r.HTTPSUpgrade && !v.DisableHTTPSRedirect {
 						rr.Action = &route.Route_Redirect{
 							Redirect: &route.RedirectAction{
 								HttpsRedirect: true,
@@ -159,9 +257,34 @@ func (v *routeVisitor) Visit() map[string]*v2.RouteConfiguration {
 			if len(vhost.Routes) < 1 {
 				return
 			}
+			if vh.CaseInsensitive {
+				caseinsensitive(vhost.Routes)
+			}
 			sort.Stable(sort.Reverse(longestRouteFirst(vhost.Routes)))
+			if vh.Default {
+				// the catch-all vhost is added after all user vhosts below.
+				catchall = append(catchall, vhost)
+				return
+			}
+			if vh.Internal {
+				ingress_http_internal.VirtualHosts = append(ingress_http_internal.VirtualHosts, vhost)
+				return
+			}
 			ingress_http.VirtualHosts = append(ingress_http.VirtualHosts, vhost)
 		case *dag.SecureVirtualHost:
+			if vh.TCPProxy != nil {
+				// connections to this vhost are proxied by the
+				// listener, they never reach ingress_https.
+				return
+			}
+			if vh.Data() == nil {
+				// no secret for this vhost, the listener visitor
+				// will skip it so we must too.
+				return
+			}
+			// record that there is at least one secure vhost so
+			// ingress_https is advertised alongside its listener.
+			https++
 			hostname := vh.FQDN()
 			aliases := vh.Aliases()
 			domains := append(aliases, hostname)
@@ -172,12 +295,24 @@ func (v *routeVisitor) Visit() map[string]*v2.RouteConfiguration {
 				}
 			}
 			vhost := route.VirtualHost{
-				Name:    hashname(60, hostname),
-				Domains: domains,
+				Name:                hashname(60, hostname),
+				Domains:             domains,
+				PerFilterConfig:     vhostfilterconfig(vh.PerFilterConfig, buffered),
+				Cors:                corspolicy(vh.CorsPolicy),
+				RequestHeadersToAdd: v.forwardingheaders(vh.ForwardingHeaders, "https", vh.Port),
 			}
 			vh.Visit(func(r dag.Vertex) {
 				switch r := r.(type) {
 				case *dag.Route:
+					if r.DirectResponse != 0 {
+						vhost.Routes = append(vhost.Routes, route.Route{
+							Match:     routematch(r),
+							Action:    directresponse(r.DirectResponse),
+							Metadata:  v.metadata(r),
+							Decorator: decorator(r),
+						})
+						return
+					}
 					var svcs []*dag.Service
 					r.Visit(func(s dag.Vertex) {
 						if s, ok := s.(*dag.Service); ok {
@@ -188,29 +323,428 @@ func (v *routeVisitor) Visit() map[string]*v2.RouteConfiguration {
 						// no services for this route, skip it.
 						return
 					}
-					vhost.Routes = append(vhost.Routes, route.Route{
-						Match: prefixmatch(r.Prefix()),
-						Action: actionroute(
-							svcs,
-							r.Websocket,
-							r.Timeout),
-					})
+					rr := route.Route{
+						Match:           routematch(r),
+						Action:          v.action(r, svcs),
+						Metadata:        v.metadata(r),
+						PerFilterConfig: routefilterconfig(r, v.timeout(r)),
+						Decorator:       decorator(r),
+					}
+					vhost.Routes = append(vhost.Routes, rr)
 				}
 			})
 			if len(vhost.Routes) < 1 {
 				return
 			}
+			if vh.CaseInsensitive {
+				caseinsensitive(vhost.Routes)
+			}
 			sort.Stable(sort.Reverse(longestRouteFirst(vhost.Routes)))
 			ingress_https.VirtualHosts = append(ingress_https.VirtualHosts, vhost)
 		}
 	})
 
+	if https == 0 {
+		// the ingress_https listener will not be present, so don't
+		// advertise a route configuration that nothing refers to.
+		delete(m, ingress_https.Name)
+	}
+	if len(ingress_http_internal.VirtualHosts) == 0 {
+		// likewise the internal listener.
+		delete(m, ingress_http_internal.Name)
+	}
+
 	for _, v := range m {
 		sort.Stable(virtualHostsByName(v.VirtualHosts))
 	}
+	ingress_http.VirtualHosts = append(ingress_http.VirtualHosts, catchall...)
 	return m
 }
 
+// directresponse returns a route action which responds
+// directly with the supplied HTTP status.
+func directresponse(status int) *route.Route_DirectResponse {
+	return &route.Route_DirectResponse{
+		DirectResponse: &route.DirectResponseAction{
+			Status: uint32(status),
+		},
+	}
+}
+
+// decorator returns the decorator naming the spans traced for the
+// supplied route, or nil if the route does not name them.
+func decorator(r *dag.Route) *route.Decorator {
+	if r.DecoratorOperation == "" {
+		return nil
+	}
+	return &route.Decorator{
+		Operation: r.DecoratorOperation,
+	}
+}
+
+// metadata returns the metadata of the supplied route, naming the kind,
+// namespace, and name of the object which produced it and its match, or
+// nil if the RouteCache does not emit route metadata. Access logs may
+// refer to them as %METADATA(ROUTE:contour:name)% and so on.
+func (v *routeVisitor) metadata(r *dag.Route) *core.Metadata {
+	if !v.RouteMetadata {
+		return nil
+	}
+	var kind, namespace, name string
+	switch o := r.Object.(type) {
+	case *v1beta1.Ingress:
+		kind, namespace, name = "Ingress", o.Namespace, o.Name
+	case *ingressroutev1.IngressRoute:
+		kind, namespace, name = "IngressRoute", o.Namespace, o.Name
+	default:
+		return nil
+	}
+	return &core.Metadata{
+		FilterMetadata: map[string]*types.Struct{
+			routeMetadataNamespace: {
+				Fields: map[string]*types.Value{
+					"kind":      sv(kind),
+					"namespace": sv(namespace),
+					"name":      sv(name),
+					"match":     sv(r.Prefix()),
+				},
+			},
+		},
+	}
+}
+
+// routeMetadataNamespace is the filter metadata namespace of the
+// metadata of each route.
+const routeMetadataNamespace = "contour"
+
+// metadatamatch returns the metadata_match of a route which selects
+// the endpoints whose load balancing metadata has each of the values of
+// match, or nil if match is empty.
+func metadatamatch(match map[string]string) *core.Metadata {
+	if len(match) == 0 {
+		return nil
+	}
+	fields := make(map[string]*types.Value)
+	for k, v := range match {
+		fields[k] = sv(v)
+	}
+	return &core.Metadata{
+		FilterMetadata: map[string]*types.Struct{
+			lbMetadataNamespace: {Fields: fields},
+		},
+	}
+}
+
+// action returns the route action for the supplied route and its services.
+func (v *routeVisitor) action(r *dag.Route, svcs []*dag.Service) *route.Route_Route {
+	rr := actionroute(svcs, r.RequestHeadersToAdd, r.Websocket, v.timeout(r))
+	rr.Route.MaxGrpcTimeout = v.maxgrpctimeout(r)
+	rr.Route.RetryPolicy = retrypolicy(r)
+	rr.Route.Cors = corspolicy(r.CorsPolicy)
+	rr.Route.WebsocketConfig = websocketconfig(r)
+	rr.Route.MetadataMatch = metadatamatch(r.MetadataMatch)
+	// TODO a route idle timeout, see docs/deploy-options.md.
+	// TODO internal redirects, see docs/deploy-options.md.
+	return rr
+}
+
+// actiontimeout returns the timeout of the supplied route. Envoy bounds
+// the lifetime of an upgraded connection by its route's timeout, so the
+// maximum connection duration of a websocket route, if set, replaces
+// its request timeout. The DAG ensures the request timeout is then not
+// finite.
+func actiontimeout(r *dag.Route) time.Duration {
+	if r.Websocket && r.WebsocketMaxConnectionDuration != 0 {
+		return r.WebsocketMaxConnectionDuration
+	}
+	return r.Timeout
+}
+
+// timeout returns the timeout of the supplied route, or the RouteCache
+// default if the route does not specify one.
+func (v *routeVisitor) timeout(r *dag.Route) time.Duration {
+	if timeout := actiontimeout(r); timeout != 0 {
+		return timeout
+	}
+	return v.RequestTimeout
+}
+
+// websocketconfig returns the configuration of the upgraded connections
+// of a websocket route, or nil if the route uses Envoy's defaults.
+func websocketconfig(r *dag.Route) *route.RouteAction_WebSocketProxyConfig {
+	if !r.Websocket {
+		return nil
+	}
+	idle := r.WebsocketIdleTimeout
+	switch idle {
+	case 0:
+		// not set, use envoy's default.
+		return nil
+	case -1:
+		// infinite, envoy expects a value of zero.
+		idle = 0
+	}
+	return &route.RouteAction_WebSocketProxyConfig{
+		IdleTimeout: &idle,
+	}
+}
+
+// retrypolicy returns a RetryPolicy carrying the per try timeout
+// of the supplied route, or nil if the route has none.
+func retrypolicy(r *dag.Route) *route.RouteAction_RetryPolicy {
+	if r.PerTryTimeout <= 0 {
+		// unset or infinite, no per try timeout applies.
+		return nil
+	}
+	if r.Websocket {
+		// an upgrade cannot be retried; the websocket wins. The
+		// DAG has already reported the perTry timeout as ignored.
+		return nil
+	}
+	timeout := r.PerTryTimeout
+	return &route.RouteAction_RetryPolicy{
+		PerTryTimeout: &timeout,
+	}
+}
+
+// maxgrpctimeout returns the MaxGrpcTimeout for the supplied route, or nil
+// if the grpc-timeout header should be ignored. The route's own value
+// takes precedence over the RouteCache default.
+//
+// When MaxGrpcTimeout is present Envoy uses the client's grpc-timeout
+// header, capped by MaxGrpcTimeout, in place of the route's timeout for
+// gRPC requests. So that the request timeout remains the upper bound for
+// every request on the route, a finite request timeout also caps the
+// returned value.
+func (v *routeVisitor) maxgrpctimeout(r *dag.Route) *time.Duration {
+	max := r.MaxGRPCTimeout
+	if max == 0 {
+		max = v.MaxGRPCTimeout
+	}
+	switch max {
+	case 0:
+		// not set, gRPC requests use the route timeout.
+		return nil
+	case -1:
+		// infinite, envoy expects a value of zero.
+		max = 0
+	}
+	if timeout := v.timeout(r); timeout > 0 && (max == 0 || max > timeout) {
+		max = timeout
+	}
+	return &max
+}
+
+// corspolicy returns the Envoy CorsPolicy for the supplied policy,
+// or nil if cp is nil. The policy has been validated by the DAG.
+func corspolicy(cp *ingressroutev1.CorsPolicy) *route.CorsPolicy {
+	if cp == nil {
+		return nil
+	}
+	c := &route.CorsPolicy{
+		AllowOrigin:   cp.AllowOrigin,
+		AllowMethods:  strings.Join(cp.AllowMethods, ","),
+		AllowHeaders:  strings.Join(cp.AllowHeaders, ","),
+		ExposeHeaders: strings.Join(cp.ExposeHeaders, ","),
+	}
+	if cp.MaxAge != "" {
+		d, _ := time.ParseDuration(cp.MaxAge)
+		c.MaxAge = strconv.Itoa(int(d.Seconds()))
+	}
+	if cp.AllowCredentials {
+		c.AllowCredentials = &types.BoolValue{Value: true}
+	}
+	return c
+}
+
+// corsenabled returns true if any virtual host or route in the DAG
+// has a CORS policy, in which case the cors filter is added to the
+// HTTP listeners.
+func corsenabled(root dag.Visitable) bool {
+	var found bool
+	root.Visit(func(vh dag.Vertex) {
+		switch vh := vh.(type) {
+		case *dag.VirtualHost:
+			found = found || vh.CorsPolicy != nil
+		case *dag.SecureVirtualHost:
+			found = found || vh.CorsPolicy != nil
+		}
+	})
+	return found || anyroute(root, func(r *dag.Route) bool { return r.CorsPolicy != nil })
+}
+
+// anyroute returns true if f returns true for any route in the DAG.
+func anyroute(root dag.Visitable, f func(*dag.Route) bool) bool {
+	var found bool
+	root.Visit(func(vh dag.Vertex) {
+		vh.Visit(func(r dag.Vertex) {
+			if r, ok := r.(*dag.Route); ok && f(r) {
+				found = true
+			}
+		})
+	})
+	return found
+}
+
+// buffered returns true if any route in the DAG limits its request body
+// size, or buffers it for retries, in which case the buffer filter is
+// added to the HTTP listeners.
+func buffered(root dag.Visitable) bool {
+	return anyroute(root, func(r *dag.Route) bool { return bufferbytes(r) > 0 })
+}
+
+// bufferbytes returns the largest request body the buffer filter
+// accepts for r, or zero if r is not buffered. A route which both
+// limits its request body size and buffers it for retries is held to
+// the smaller of the two.
+func bufferbytes(r *dag.Route) uint32 {
+	max := r.MaxRequestBytes
+	if r.RetryBufferBytes > 0 && (max == 0 || r.RetryBufferBytes < max) {
+		max = r.RetryBufferBytes
+	}
+	return max
+}
+
+// vhostfilterconfig returns the per filter configuration of a virtual
+// host. If buffered is true the buffer filter is disabled, routes which
+// limit their request body size enable it again.
+func vhostfilterconfig(pfc map[string]ingressroutev1.FilterConfig, buffered bool) map[string]*types.Struct {
+	m := perfilterconfig(pfc)
+	if !buffered {
+		return m
+	}
+	if m == nil {
+		m = make(map[string]*types.Struct)
+	}
+	m[buffer] = &types.Struct{Fields: map[string]*types.Value{
+		"disabled": bv(true),
+	}}
+	return m
+}
+
+// routefilterconfig returns the per filter configuration of a route,
+// including its request body buffering, if any. timeout is the
+// route's request timeout.
+func routefilterconfig(r *dag.Route, timeout time.Duration) map[string]*types.Struct {
+	m := perfilterconfig(r.PerFilterConfig)
+	max := bufferbytes(r)
+	if max == 0 {
+		return m
+	}
+	if m == nil {
+		m = make(map[string]*types.Struct)
+	}
+	m[buffer] = bufferperroute(max, timeout)
+	return m
+}
+
+// bufferperroute returns the buffer filter configuration for a route
+// which limits its request body size to max bytes, received within its
+// request timeout.
+func bufferperroute(max uint32, timeout time.Duration) *types.Struct {
+	if timeout <= 0 {
+		timeout = defaultMaxRequestTime
+	}
+	return &types.Struct{Fields: map[string]*types.Value{
+		"buffer": st(map[string]*types.Value{
+			"max_request_bytes": nv(float64(max)),
+			"max_request_time":  dv(timeout),
+		}),
+	}}
+}
+
+// perfilterconfig returns the per filter configuration of a virtual
+// host or route, or nil if pfc is empty. The filter names and their
+// fields have been validated by the DAG.
+func perfilterconfig(pfc map[string]ingressroutev1.FilterConfig) map[string]*types.Struct {
+	if len(pfc) == 0 {
+		return nil
+	}
+	m := make(map[string]*types.Struct, len(pfc))
+	for name, fc := range pfc {
+		fields := make(map[string]*types.Value)
+		if fc.Disabled {
+			fields["disabled"] = bv(true)
+		}
+		if fc.Stage > 0 {
+			fields["stage"] = nv(float64(fc.Stage))
+		}
+		m[name] = &types.Struct{Fields: fields}
+	}
+	return m
+}
+
+// headervalueoptions returns a slice of HeaderValueOptions, sorted by
+// header name, for the supplied map of headers. If headers is empty,
+// nil is returned.
+func headervalueoptions(headers map[string]string) []*core.HeaderValueOption {
+	if len(headers) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hvo := make([]*core.HeaderValueOption, 0, len(keys))
+	for _, k := range keys {
+		hvo = append(hvo, &core.HeaderValueOption{
+			Header: &core.HeaderValue{
+				Key:   k,
+				Value: headers[k],
+			},
+			Append: &types.BoolValue{Value: true},
+		})
+	}
+	return hvo
+}
+
+// validateclusters returns the RouteConfiguration's ValidateClusters
+// for the supplied setting, or nil if it is not set.
+func validateclusters(validate *bool) *types.BoolValue {
+	if validate == nil {
+		return nil
+	}
+	return &types.BoolValue{Value: *validate}
+}
+
+// forwardingheaders returns the headers added to the requests proxied
+// for a virtual host reached over proto on port, or nil if forwarding
+// headers are disabled for the virtual host. override, if not nil,
+// takes precedence over the RouteCache default.
+//
+// A host served over HTTP and HTTPS is emitted once into each route
+// configuration, so proto is fixed for each copy. port is the port
+// clients connect to rather than Envoy's listening port, which is what
+// a %DOWNSTREAM_LOCAL_ADDRESS% header formatter would report, so it is
+// added as a literal. x-request-id is not added here; the connection
+// manager generates it if absent and otherwise propagates the client's
+// value.
+func (v *routeVisitor) forwardingheaders(override *bool, proto string, port int) []*core.HeaderValueOption {
+	enabled := v.ForwardingHeaders
+	if override != nil {
+		enabled = *override
+	}
+	if !enabled {
+		return nil
+	}
+	// TODO letting the headers a service adds win over these, see
+	// docs/deploy-options.md.
+	return []*core.HeaderValueOption{{
+		Header: &core.HeaderValue{
+			Key:   "x-forwarded-proto",
+			Value: proto,
+		},
+		Append: &types.BoolValue{Value: false},
+	}, {
+		Header: &core.HeaderValue{
+			Key:   "x-forwarded-port",
+			Value: strconv.Itoa(port),
+		},
+		Append: &types.BoolValue{Value: false},
+	}}
+}
+
 type virtualHostsByName []route.VirtualHost
 
 func (v virtualHostsByName) Len() int           { return len(v) }
@@ -222,19 +756,124 @@ type longestRouteFirst []route.Route
 func (l longestRouteFirst) Len() int      { return len(l) }
 func (l longestRouteFirst) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
 func (l longestRouteFirst) Less(i, j int) bool {
-	a, ok := l[i].Match.PathSpecifier.(*route.RouteMatch_Prefix)
-	if !ok {
-		// ignore non prefix matches
-		return false
+	// compare without regard to case so that a case insensitive prefix
+	// sorts after every longer prefix it would otherwise shadow.
+	pi, pj := pathspecifier(l[i].Match), pathspecifier(l[j].Match)
+	if li, lj := strings.ToLower(pi), strings.ToLower(pj); li != lj {
+		return li < lj
+	}
+	if pi != pj {
+		return pi < pj
 	}
+	// the same path matched as both a prefix and a regex; order by
+	// match type so the result does not depend on the visit order.
+	_, ri := l[i].Match.PathSpecifier.(*route.RouteMatch_Regex)
+	_, rj := l[j].Match.PathSpecifier.(*route.RouteMatch_Regex)
+	if ri != rj {
+		return !ri && rj
+	}
+	// the same match with more header matchers is more specific, so
+	// must sort before the route it would otherwise be shadowed by.
+	if hi, hj := len(l[i].Match.Headers), len(l[j].Match.Headers); hi != hj {
+		return hi < hj
+	}
+	// an identical match, eg. the same path claimed by two Ingresses;
+	// order by target so the result does not depend on map iteration
+	// order while building the DAG.
+	return routetarget(&l[i]) < routetarget(&l[j])
+}
 
-	b, ok := l[j].Match.PathSpecifier.(*route.RouteMatch_Prefix)
-	if !ok {
-		// ignore non prefix matches
-		return false
+// routetarget returns a string describing where r sends a request.
+func routetarget(r *route.Route) string {
+	switch a := r.Action.(type) {
+	case *route.Route_Route:
+		switch c := a.Route.ClusterSpecifier.(type) {
+		case *route.RouteAction_Cluster:
+			return c.Cluster
+		case *route.RouteAction_WeightedClusters:
+			var names []string
+			for _, w := range c.WeightedClusters.Clusters {
+				names = append(names, w.Name)
+			}
+			return strings.Join(names, ",")
+		}
+	case *route.Route_Redirect:
+		return a.Redirect.String()
+	case *route.Route_DirectResponse:
+		return a.DirectResponse.String()
 	}
+	return ""
+}
 
-	return a.Prefix < b.Prefix
+// caseinsensitive matches each of routes without regard to case.
+func caseinsensitive(routes []route.Route) {
+	for i := range routes {
+		routes[i].Match.CaseSensitive = &types.BoolValue{Value: false}
+	}
+}
+
+// pathspecifier returns the prefix or regex of the RouteMatch.
+func pathspecifier(m route.RouteMatch) string {
+	switch p := m.PathSpecifier.(type) {
+	case *route.RouteMatch_Prefix:
+		return p.Prefix
+	case *route.RouteMatch_Regex:
+		return p.Regex
+	default:
+		return ""
+	}
+}
+
+// routematch returns a RouteMatch for the supplied route,
+// either a regex or a prefix match.
+func routematch(r *dag.Route) route.RouteMatch {
+	m := prefixmatch(r.Prefix())
+	if r.Regex {
+		m = regexmatch(r.Prefix())
+	}
+	if r.CaseInsensitive {
+		m.CaseSensitive = &types.BoolValue{Value: false}
+	}
+	m.Headers = headermatchers(r.HeaderMatches)
+	return m
+}
+
+// headermatchers returns the HeaderMatchers for the supplied header
+// matches, in the order given. If hms is empty, nil is returned.
+func headermatchers(hms []ingressroutev1.HeaderMatch) []*route.HeaderMatcher {
+	if len(hms) == 0 {
+		return nil
+	}
+	matchers := make([]*route.HeaderMatcher, 0, len(hms))
+	for _, hm := range hms {
+		m := &route.HeaderMatcher{Name: hm.Name}
+		switch {
+		case hm.Exact != "":
+			m.HeaderMatchSpecifier = &route.HeaderMatcher_ExactMatch{ExactMatch: hm.Exact}
+		case hm.Present:
+			m.HeaderMatchSpecifier = &route.HeaderMatcher_PresentMatch{PresentMatch: true}
+		case hm.Regex != "":
+			m.HeaderMatchSpecifier = &route.HeaderMatcher_RegexMatch{RegexMatch: hm.Regex}
+		case hm.Range != nil:
+			m.HeaderMatchSpecifier = &route.HeaderMatcher_RangeMatch{
+				RangeMatch: &envoy_type.Int64Range{
+					Start: hm.Range.Start,
+					End:   hm.Range.End,
+				},
+			}
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers
+}
+
+// regexmatch returns a RouteMatch for the supplied regex.
+func regexmatch(regex string) route.RouteMatch {
+	return route.RouteMatch{
+		PathSpecifier: &route.RouteMatch_Regex{
+			Regex: regex,
+		},
+	}
 }
 
 // prefixmatch returns a RouteMatch for the supplied prefix.
@@ -247,8 +886,9 @@ func prefixmatch(prefix string) route.RouteMatch {
 }
 
 // action computes the cluster route action, a *route.Route_route for the
-// supplied ingress and backend.
-func actionroute(services []*dag.Service, ws bool, timeout time.Duration) *route.Route_Route {
+// supplied ingress and backend. headers, if not nil, holds the request
+// headers each service's weighted cluster adds.
+func actionroute(services []*dag.Service, headers map[*dag.Service]map[string]string, ws bool, timeout time.Duration) *route.Route_Route {
 	var totalWeight int
 	upstreams := []*route.WeightedCluster_ClusterWeight{}
 
@@ -256,8 +896,9 @@ func actionroute(services []*dag.Service, ws bool, timeout time.Duration) *route
 	for _, svc := range services {
 		// Create the upstream
 		upstreams = append(upstreams, &route.WeightedCluster_ClusterWeight{
-			Name:   hashname(60, svc.Namespace(), svc.Name(), strconv.Itoa(int(svc.Port))),
-			Weight: &types.UInt32Value{Value: uint32(svc.Weight)},
+			Name:                clustername(svc),
+			Weight:              &types.UInt32Value{Value: uint32(svc.Weight)},
+			RequestHeadersToAdd: headervalueoptions(headers[svc]),
 		})
 		totalWeight += svc.Weight
 	}
@@ -300,6 +941,9 @@ func actionroute(services []*dag.Service, ws bool, timeout time.Duration) *route
 		rr.Route.Timeout = &timeout
 	}
 
+	// TODO rewriting the Host header from a request header, see
+	// docs/deploy-options.md.
+
 	return &rr
 }
 
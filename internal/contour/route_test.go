@@ -14,12 +14,16 @@
 package contour
 
 import (
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	envoy_type "github.com/envoyproxy/go-control-plane/envoy/type"
 	"github.com/gogo/protobuf/types"
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
 	"github.com/heptio/contour/internal/dag"
@@ -35,12 +39,16 @@ func TestRouteVisit(t *testing.T) {
 	var (
 		infinity     = time.Duration(0)
 		nintyseconds = time.Duration(90 * time.Second)
+		tenseconds   = time.Duration(10 * time.Second)
+		enabled      = true
+		disabled     = false
 	)
 
 	tests := map[string]struct {
 		*RouteCache
-		objs []interface{}
-		want map[string]*v2.RouteConfiguration
+		internal bool
+		objs     []interface{}
+		want     map[string]*v2.RouteConfiguration
 	}{
 		"nothing": {
 			objs: nil,
@@ -48,9 +56,6 @@ func TestRouteVisit(t *testing.T) {
 				"ingress_http": {
 					Name: "ingress_http",
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 		"one http only ingress with service": {
@@ -93,42 +98,1587 @@ func TestRouteVisit(t *testing.T) {
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
+			},
+		},
+		"validate clusters enabled": {
+			RouteCache: &RouteCache{
+				ValidateClusters: &enabled,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
+					ValidateClusters: &types.BoolValue{Value: true},
+				},
+			},
+		},
+		"validate clusters disabled": {
+			RouteCache: &RouteCache{
+				ValidateClusters: &disabled,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
+					ValidateClusters: &types.BoolValue{Value: false},
+				},
+			},
+		},
+		"ingress with route metadata": {
+			RouteCache: &RouteCache{
+				RouteMetadata: true,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
+						Routes: []route.Route{{
+							Match:    prefixmatch("/"),
+							Action:   routeroute("default/kuard/8080"),
+							Metadata: routemetadata("Ingress", "default", "kuard", "/"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with route metadata": {
+			RouteCache: &RouteCache{
+				RouteMetadata: true,
+			},
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:    prefixmatch("/"),
+							Action:   routeroute("default/backend/80"),
+							Metadata: routemetadata("IngressRoute", "default", "simple", "/"),
+						}},
+					}},
+				},
+			},
+		},
+		"one http only ingressroute": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with per filter config": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+							PerFilterConfig: map[string]ingressroutev1.FilterConfig{
+								"envoy.ext_authz": {Disabled: true},
+							},
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							PerFilterConfig: map[string]ingressroutev1.FilterConfig{
+								"envoy.rate_limit": {Stage: 2},
+							},
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						PerFilterConfig: map[string]*types.Struct{
+							"envoy.ext_authz": {
+								Fields: map[string]*types.Value{
+									"disabled": {Kind: &types.Value_BoolValue{BoolValue: true}},
+								},
+							},
+						},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+							PerFilterConfig: map[string]*types.Struct{
+								"envoy.rate_limit": {
+									Fields: map[string]*types.Value{
+										"stage": {Kind: &types.Value_NumberValue{NumberValue: 2}},
+									},
+								},
+							},
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with internal visibility": {
+			internal: true,
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "admin",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn:       "admin.example.com",
+							Visibility: "internal",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Delegate: ingressroutev1.Delegate{
+								Name: "child",
+							},
+						}},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "child",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "public",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+				"ingress_http_internal": {
+					Name: "ingress_http_internal",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "admin.example.com",
+						Domains: []string{"admin.example.com", "admin.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with internal visibility, no internal listener": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "admin",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn:       "admin.example.com",
+							Visibility: "internal",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Delegate: ingressroutev1.Delegate{
+								Name: "child",
+							},
+						}},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "child",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "public",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with max request bytes": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}, {
+							Match:           "/api",
+							MaxRequestBytes: 10 << 20,
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:            "www.example.com",
+						Domains:         []string{"www.example.com", "www.example.com:80"},
+						PerFilterConfig: bufferdisabled(),
+						Routes: []route.Route{{
+							Match:           prefixmatch("/api"),
+							Action:          routeroute("default/backend/80"),
+							PerFilterConfig: bufferlimit(10<<20, "15s"),
+						}, {
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with retry buffer": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match:           "/api",
+							MaxRequestBytes: 10 << 20,
+							TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+								Request: "1m30s",
+								PerTry:  "10s",
+							},
+							RetryBuffer: &ingressroutev1.RetryBuffer{
+								MaxBytes: 64 << 10,
+							},
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:            "www.example.com",
+						Domains:         []string{"www.example.com", "www.example.com:80"},
+						PerFilterConfig: bufferdisabled(),
+						Routes: []route.Route{{
+							Match:           prefixmatch("/api"),
+							Action:          routeretry("default/backend/80", &nintyseconds, &tenseconds),
+							PerFilterConfig: bufferlimit(64<<10, "90s"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with metadata match": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							MetadataMatch: map[string]string{
+								"version": "v2",
+							},
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match: prefixmatch("/"),
+							Action: routemetadatamatch("default/backend/80", map[string]*types.Value{
+								"version": sv("v2"),
+							}),
+						}},
+					}},
+				},
+			},
+		},
+		"ingress with max request bytes annotation": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/max-request-bytes": "1024",
+							"contour.heptio.com/request-timeout":   "90s",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "backend",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:            "*",
+						Domains:         []string{"*"},
+						PerFilterConfig: bufferdisabled(),
+						Routes: []route.Route{{
+							Match:           prefixmatch("/"),
+							Action:          routetimeout("default/backend/80", &nintyseconds),
+							PerFilterConfig: bufferlimit(1024, "90s"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingress with default request timeout": {
+			RouteCache: &RouteCache{
+				RequestTimeout: 90 * time.Second,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "backend",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routetimeout("default/backend/80", &nintyseconds),
+						}},
+					}},
+				},
+			},
+		},
+		"ingress request timeout overrides default": {
+			RouteCache: &RouteCache{
+				RequestTimeout: 90 * time.Second,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/request-timeout": "10s",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "backend",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routetimeout("default/backend/80", &tenseconds),
+						}},
+					}},
+				},
+			},
+		},
+		"ingress infinite request timeout overrides default": {
+			RouteCache: &RouteCache{
+				RequestTimeout: 90 * time.Second,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/request-timeout": "infinity",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "backend",
+							ServicePort: intstr.FromInt(80),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routetimeout("default/backend/80", &infinity),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with cors policy": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+							CorsPolicy: &ingressroutev1.CorsPolicy{
+								AllowOrigin:  []string{"*"},
+								AllowMethods: []string{"GET", "POST"},
+							},
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}, {
+							Match: "/internal",
+							CorsPolicy: &ingressroutev1.CorsPolicy{
+								AllowOrigin:      []string{"https://admin.example.com"},
+								AllowMethods:     []string{"GET"},
+								AllowHeaders:     []string{"authorization", "content-type"},
+								MaxAge:           "10m",
+								AllowCredentials: true,
+							},
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Cors: &route.CorsPolicy{
+							AllowOrigin:  []string{"*"},
+							AllowMethods: "GET,POST",
+						},
+						Routes: []route.Route{{
+							Match: prefixmatch("/internal"),
+							Action: routecors("default/backend/80", &route.CorsPolicy{
+								AllowOrigin:      []string{"https://admin.example.com"},
+								AllowMethods:     "GET",
+								AllowHeaders:     "authorization,content-type",
+								MaxAge:           "600",
+								AllowCredentials: &types.BoolValue{Value: true},
+							}),
+						}, {
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with case insensitive prefix": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match:         "/foo",
+							CaseSensitive: new(bool), // false
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}, {
+							Match: "/Foo/bar",
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							// sorted before /foo, which would otherwise match it.
+							Match:  prefixmatch("/Foo/bar"),
+							Action: routeroute("default/backend/80"),
+						}, {
+							Match: route.RouteMatch{
+								PathSpecifier: &route.RouteMatch_Prefix{
+									Prefix: "/foo",
+								},
+								CaseSensitive: &types.BoolValue{Value: false},
+							},
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with case insensitive virtual host": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "legacy",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn:            "legacy.example.com",
+							CaseInsensitive: true,
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/api",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/api",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "legacy.example.com",
+						Domains: []string{"legacy.example.com", "legacy.example.com:80"},
+						Routes: []route.Route{{
+							Match: route.RouteMatch{
+								PathSpecifier: &route.RouteMatch_Prefix{
+									Prefix: "/api",
+								},
+								CaseSensitive: &types.BoolValue{Value: false},
+							},
+							Action: routeroute("default/backend/80"),
+						}},
+					}, {
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/api"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with timeout policy": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+								Request: "1m30s",
+								PerTry:  "10s",
+							},
+							Services: []ingressroutev1.Service{
+								{
+									Name: "backend",
+									Port: 80,
+								},
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeretry("default/backend/80", &nintyseconds, &tenseconds),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with regex match": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}, {
+							Match:     "/v[0-9]+/api",
+							MatchType: "Regex",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  regexmatch("/v[0-9]+/api"),
+							Action: routeroute("default/backend/80"),
+						}, {
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute with invalid regex match": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+						},
+						Routes: []ingressroutev1.Route{{
+							Match:     "/v[0-9+/api",
+							MatchType: "Regex",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}, {
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingress with forwarding headers": {
+			RouteCache: &RouteCache{
+				ForwardingHeaders: true,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"www.example.com"},
+							SecretName: "secret",
+						}},
+						Rules: []v1beta1.IngressRule{{
+							Host: "www.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: v1beta1.IngressBackend{
+											ServiceName: "kuard",
+											ServicePort: intstr.FromInt(8080),
+										},
+									}},
+								},
+							},
+						}},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+						RequestHeadersToAdd: xforwarded("http", "80"),
+					}},
+				},
+				"ingress_https": {
+					Name: "ingress_https",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:443"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+						RequestHeadersToAdd: xforwarded("https", "443"),
+					}},
+				},
+			},
+		},
+		"ingressroute enables forwarding headers": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+							TLS: &ingressroutev1.TLS{
+								SecretName: "secret",
+							},
+							ForwardingHeaders: &enabled,
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 8080,
+							}},
+						}},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+						RequestHeadersToAdd: xforwarded("http", "80"),
+					}},
+				},
+				"ingress_https": {
+					Name: "ingress_https",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:443"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+						RequestHeadersToAdd: xforwarded("https", "443"),
+					}},
+				},
+			},
+		},
+		"ingressroute with tls falls back to a status": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+							TLS: &ingressroutev1.TLS{
+								SecretName: "secret",
+							},
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 8080,
+							}},
+							Fallback: &ingressroutev1.Fallback{
+								Status: 503,
+							},
+						}},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: directresponse(503),
+						}},
+					}},
+				},
+				"ingress_https": {
+					Name: "ingress_https",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:443"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: directresponse(503),
+						}},
+					}},
+				},
+			},
+		},
+		"ingressroute disables forwarding headers": {
+			RouteCache: &RouteCache{
+				ForwardingHeaders: true,
+			},
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{
+							Fqdn: "www.example.com",
+							TLS: &ingressroutev1.TLS{
+								SecretName: "secret",
+							},
+							ForwardingHeaders: &disabled,
+						},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "kuard",
+								Port: 8080,
+							}},
+						}},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
+				},
+				"ingress_https": {
+					Name: "ingress_https",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:443"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
 				},
 			},
 		},
-		"one http only ingressroute": {
+		"default backend ingress with secret": {
 			objs: []interface{}{
-				&ingressroutev1.IngressRoute{
+				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "simple",
 						Namespace: "default",
 					},
-					Spec: ingressroutev1.IngressRouteSpec{
-						VirtualHost: &ingressroutev1.VirtualHost{
-							Fqdn: "www.example.com",
-						},
-						Routes: []ingressroutev1.Route{{
-							Match: "/",
-							Services: []ingressroutev1.Service{
-								{
-									Name: "backend",
-									Port: 80,
-								},
-							},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"whatever.example.com"},
+							SecretName: "secret",
 						}},
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
 					},
 				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
 				&v1.Service{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      "backend",
+						Name:      "kuard",
 						Namespace: "default",
 					},
 					Spec: v1.ServiceSpec{
 						Ports: []v1.ServicePort{{
 							Protocol:   "TCP",
-							Port:       80,
+							Port:       8080,
 							TargetPort: intstr.FromInt(8080),
 						}},
 					},
@@ -138,20 +1688,20 @@ func TestRouteVisit(t *testing.T) {
 				"ingress_http": {
 					Name: "ingress_http",
 					VirtualHosts: []route.VirtualHost{{
-						Name:    "www.example.com",
-						Domains: []string{"www.example.com", "www.example.com:80"},
+						Name:    "*", // default backend
+						Domains: []string{"*"},
 						Routes: []route.Route{{
 							Match:  prefixmatch("/"),
-							Action: routeroute("default/backend/80"),
+							Action: routeroute("default/kuard/8080"),
 						}},
 					}},
 				},
 				"ingress_https": {
-					Name: "ingress_https",
+					Name: "ingress_https", // no https for default backend
 				},
 			},
 		},
-		"default backend ingress with secret": {
+		"vhost ingress with secret": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
@@ -160,13 +1710,22 @@ func TestRouteVisit(t *testing.T) {
 					},
 					Spec: v1beta1.IngressSpec{
 						TLS: []v1beta1.IngressTLS{{
-							Hosts:      []string{"whatever.example.com"},
+							Hosts:      []string{"www.example.com"},
 							SecretName: "secret",
 						}},
-						Backend: &v1beta1.IngressBackend{
-							ServiceName: "kuard",
-							ServicePort: intstr.FromInt(8080),
-						},
+						Rules: []v1beta1.IngressRule{{
+							Host: "www.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: v1beta1.IngressBackend{
+											ServiceName: "kuard",
+											ServicePort: intstr.FromString("www"),
+										},
+									}},
+								},
+							},
+						}},
 					},
 				},
 				&v1.Secret{
@@ -183,6 +1742,7 @@ func TestRouteVisit(t *testing.T) {
 					},
 					Spec: v1.ServiceSpec{
 						Ports: []v1.ServicePort{{
+							Name:       "www",
 							Protocol:   "TCP",
 							Port:       8080,
 							TargetPort: intstr.FromInt(8080),
@@ -194,8 +1754,8 @@ func TestRouteVisit(t *testing.T) {
 				"ingress_http": {
 					Name: "ingress_http",
 					VirtualHosts: []route.VirtualHost{{
-						Name:    "*", // default backend
-						Domains: []string{"*"},
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
 						Routes: []route.Route{{
 							Match:  prefixmatch("/"),
 							Action: routeroute("default/kuard/8080"),
@@ -203,11 +1763,24 @@ func TestRouteVisit(t *testing.T) {
 					}},
 				},
 				"ingress_https": {
-					Name: "ingress_https", // no https for default backend
+					Name: "ingress_https",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:443"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
 				},
 			},
 		},
-		"vhost ingress with secret": {
+		"vhost ingress with secret and response headers": {
+			RouteCache: &RouteCache{
+				ResponseHeadersToAdd: map[string]string{
+					"X-Contour": "true",
+				},
+			},
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
@@ -259,6 +1832,13 @@ func TestRouteVisit(t *testing.T) {
 			want: map[string]*v2.RouteConfiguration{
 				"ingress_http": {
 					Name: "ingress_http",
+					ResponseHeadersToAdd: []*core.HeaderValueOption{{
+						Header: &core.HeaderValue{
+							Key:   "X-Contour",
+							Value: "true",
+						},
+						Append: &types.BoolValue{Value: true},
+					}},
 					VirtualHosts: []route.VirtualHost{{
 						Name:    "www.example.com",
 						Domains: []string{"www.example.com", "www.example.com:80"},
@@ -270,6 +1850,13 @@ func TestRouteVisit(t *testing.T) {
 				},
 				"ingress_https": {
 					Name: "ingress_https",
+					ResponseHeadersToAdd: []*core.HeaderValueOption{{
+						Header: &core.HeaderValue{
+							Key:   "X-Contour",
+							Value: "true",
+						},
+						Append: &types.BoolValue{Value: true},
+					}},
 					VirtualHosts: []route.VirtualHost{{
 						Name:    "www.example.com",
 						Domains: []string{"www.example.com", "www.example.com:443"},
@@ -502,6 +2089,86 @@ func TestRouteVisit(t *testing.T) {
 				},
 			},
 		},
+		"simple tls ingress with force-ssl-redirect, redirect disabled": {
+			RouteCache: &RouteCache{
+				DisableHTTPSRedirect: true,
+			},
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"ingress.kubernetes.io/force-ssl-redirect": "true",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						TLS: []v1beta1.IngressTLS{{
+							Hosts:      []string{"www.example.com"},
+							SecretName: "secret",
+						}},
+						Rules: []v1beta1.IngressRule{{
+							Host: "www.example.com",
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{
+										Backend: v1beta1.IngressBackend{
+											ServiceName: "kuard",
+											ServicePort: intstr.FromString("www"),
+										},
+									}},
+								},
+							},
+						}},
+					},
+				},
+				&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "secret",
+						Namespace: "default",
+					},
+					Data: secretdata("certificate", "key"),
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Name:       "www",
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
+				},
+				"ingress_https": {
+					Name: "ingress_https",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:443"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
+				},
+			},
+		},
 		"ingress with websocket annotation": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
@@ -554,30 +2221,117 @@ func TestRouteVisit(t *testing.T) {
 				"ingress_http": {
 					Name: "ingress_http",
 					VirtualHosts: []route.VirtualHost{{
-						Name:    "www.example.com",
-						Domains: []string{"www.example.com", "www.example.com:80"},
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/ws1"),
+							Action: websocketroute("default/kuard/8080"),
+						}, {
+							Match:  prefixmatch("/"),
+							Action: routeroute("default/kuard/8080"),
+						}},
+					}},
+				},
+			},
+		},
+		"ingress invalid timeout": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/request-timeout": "heptio",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/"),
+							Action: routetimeout("default/kuard/8080", &infinity),
+						}},
+					}},
+				},
+			},
+		},
+		"ingress infinite timeout": {
+			objs: []interface{}{
+				&v1beta1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"contour.heptio.com/request-timeout": "infinity",
+						},
+					},
+					Spec: v1beta1.IngressSpec{
+						Backend: &v1beta1.IngressBackend{
+							ServiceName: "kuard",
+							ServicePort: intstr.FromInt(8080),
+						},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       8080,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "*",
+						Domains: []string{"*"},
 						Routes: []route.Route{{
-							Match:  prefixmatch("/ws1"),
-							Action: websocketroute("default/kuard/8080"),
-						}, {
 							Match:  prefixmatch("/"),
-							Action: routeroute("default/kuard/8080"),
+							Action: routetimeout("default/kuard/8080", &infinity),
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
-		"ingress invalid timeout": {
+		"ingress 90 second timeout": {
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "kuard",
 						Namespace: "default",
 						Annotations: map[string]string{
-							"contour.heptio.com/request-timeout": "heptio",
+							"contour.heptio.com/request-timeout": "1m30s",
 						},
 					},
 					Spec: v1beta1.IngressSpec{
@@ -609,23 +2363,24 @@ func TestRouteVisit(t *testing.T) {
 						Domains: []string{"*"},
 						Routes: []route.Route{{
 							Match:  prefixmatch("/"),
-							Action: routetimeout("default/kuard/8080", &infinity),
+							Action: routetimeout("default/kuard/8080", &nintyseconds),
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
-		"ingress infinite timeout": {
+		"ingress max grpc timeout capped by request timeout": {
+			RouteCache: &RouteCache{
+				MaxGRPCTimeout: 10 * time.Second,
+			},
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "kuard",
 						Namespace: "default",
 						Annotations: map[string]string{
-							"contour.heptio.com/request-timeout": "infinity",
+							"contour.heptio.com/request-timeout":  "1m30s",
+							"contour.heptio.com/max-grpc-timeout": "infinity",
 						},
 					},
 					Spec: v1beta1.IngressSpec{
@@ -657,24 +2412,21 @@ func TestRouteVisit(t *testing.T) {
 						Domains: []string{"*"},
 						Routes: []route.Route{{
 							Match:  prefixmatch("/"),
-							Action: routetimeout("default/kuard/8080", &infinity),
+							Action: routemaxgrpctimeout("default/kuard/8080", &nintyseconds, &nintyseconds),
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
-		"ingress 90 second timeout": {
+		"ingress default max grpc timeout": {
+			RouteCache: &RouteCache{
+				MaxGRPCTimeout: 10 * time.Second,
+			},
 			objs: []interface{}{
 				&v1beta1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "kuard",
 						Namespace: "default",
-						Annotations: map[string]string{
-							"contour.heptio.com/request-timeout": "1m30s",
-						},
 					},
 					Spec: v1beta1.IngressSpec{
 						Backend: &v1beta1.IngressBackend{
@@ -705,13 +2457,10 @@ func TestRouteVisit(t *testing.T) {
 						Domains: []string{"*"},
 						Routes: []route.Route{{
 							Match:  prefixmatch("/"),
-							Action: routetimeout("default/kuard/8080", &nintyseconds),
+							Action: routemaxgrpctimeout("default/kuard/8080", nil, &tenseconds),
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 		"vhost name exceeds 60 chars": { // heptio/contour#25
@@ -765,9 +2514,6 @@ func TestRouteVisit(t *testing.T) {
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 		"incorrect ingress class": {
@@ -805,9 +2551,6 @@ func TestRouteVisit(t *testing.T) {
 				"ingress_http": {
 					Name: "ingress_http", // expected to be empty, the ingress class is ignored
 				},
-				"ingress_https": {
-					Name: "ingress_https", // expected to be empty, the ingress class is ignored
-				},
 			},
 		},
 		"explicit ingress class": {
@@ -817,7 +2560,7 @@ func TestRouteVisit(t *testing.T) {
 						Name:      "incorrect",
 						Namespace: "default",
 						Annotations: map[string]string{
-							"kubernetes.io/ingress.class": new(ResourceEventHandler).ingressClass(),
+							"kubernetes.io/ingress.class": new(ResourceEventHandler).ingressClasses()[0],
 						},
 					},
 					Spec: v1beta1.IngressSpec{
@@ -853,9 +2596,6 @@ func TestRouteVisit(t *testing.T) {
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 		"ingressroute no weights defined": {
@@ -940,9 +2680,6 @@ func TestRouteVisit(t *testing.T) {
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 		"ingressroute one weight defined": {
@@ -1028,9 +2765,6 @@ func TestRouteVisit(t *testing.T) {
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 		"ingressroute aliases defined": {
@@ -1120,9 +2854,6 @@ func TestRouteVisit(t *testing.T) {
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 		"ingressroute all weights defined": {
@@ -1209,8 +2940,66 @@ func TestRouteVisit(t *testing.T) {
 						}},
 					}},
 				},
-				"ingress_https": {
-					Name: "ingress_https",
+			},
+		},
+		"ingressroute decorator": {
+			objs: []interface{}{
+				&ingressroutev1.IngressRoute{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "simple",
+						Namespace: "default",
+					},
+					Spec: ingressroutev1.IngressRouteSpec{
+						VirtualHost: &ingressroutev1.VirtualHost{Fqdn: "www.example.com"},
+						Routes: []ingressroutev1.Route{{
+							Match: "/",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+						}, {
+							Match: "/checkout",
+							Services: []ingressroutev1.Service{{
+								Name: "backend",
+								Port: 80,
+							}},
+							Decorator: &ingressroutev1.Decorator{
+								Operation: "checkout",
+							},
+						}},
+					},
+				},
+				&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "backend",
+						Namespace: "default",
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol:   "TCP",
+							Port:       80,
+							TargetPort: intstr.FromInt(8080),
+						}},
+					},
+				},
+			},
+			want: map[string]*v2.RouteConfiguration{
+				"ingress_http": {
+					Name: "ingress_http",
+					VirtualHosts: []route.VirtualHost{{
+						Name:    "www.example.com",
+						Domains: []string{"www.example.com", "www.example.com:80"},
+						Routes: []route.Route{{
+							Match:  prefixmatch("/checkout"),
+							Action: routeroute("default/backend/80"),
+							Decorator: &route.Decorator{
+								Operation: "checkout",
+							},
+						}, {
+							Match:  prefixmatch("/"), // match all
+							Action: routeroute("default/backend/80"),
+						}},
+					}},
 				},
 			},
 		},
@@ -1252,9 +3041,6 @@ func TestRouteVisit(t *testing.T) {
 				"ingress_http": {
 					Name: "ingress_http", // should be blank, no fqdn defined.
 				},
-				"ingress_https": {
-					Name: "ingress_https",
-				},
 			},
 		},
 	}
@@ -1265,6 +3051,7 @@ func TestRouteVisit(t *testing.T) {
 				Notifier: new(nullNotifier),
 				Metrics:  metrics.NewMetrics(prometheus.NewRegistry()),
 			}
+			reh.InternalListener = tc.internal
 			for _, o := range tc.objs {
 				reh.OnAdd(o)
 			}
@@ -1308,15 +3095,243 @@ func websocketroute(c string) *route.Route_Route {
 	return cl
 }
 
+func xforwarded(proto, port string) []*core.HeaderValueOption {
+	return []*core.HeaderValueOption{{
+		Header: &core.HeaderValue{
+			Key:   "x-forwarded-proto",
+			Value: proto,
+		},
+		Append: &types.BoolValue{Value: false},
+	}, {
+		Header: &core.HeaderValue{
+			Key:   "x-forwarded-port",
+			Value: port,
+		},
+		Append: &types.BoolValue{Value: false},
+	}}
+}
+
+func routemetadata(kind, namespace, name, match string) *core.Metadata {
+	return &core.Metadata{
+		FilterMetadata: map[string]*types.Struct{
+			"contour": {
+				Fields: map[string]*types.Value{
+					"kind":      sv(kind),
+					"namespace": sv(namespace),
+					"name":      sv(name),
+					"match":     sv(match),
+				},
+			},
+		},
+	}
+}
+
+func routecors(cluster string, cors *route.CorsPolicy) *route.Route_Route {
+	cl := routeroute(cluster)
+	cl.Route.Cors = cors
+	return cl
+}
+
 func routetimeout(cluster string, timeout *time.Duration) *route.Route_Route {
 	cl := routeroute(cluster)
 	cl.Route.Timeout = timeout
 	return cl
 }
 
+func routeretry(cluster string, timeout, perTry *time.Duration) *route.Route_Route {
+	cl := routetimeout(cluster, timeout)
+	cl.Route.RetryPolicy = &route.RouteAction_RetryPolicy{
+		PerTryTimeout: perTry,
+	}
+	return cl
+}
+
+func routemetadatamatch(cluster string, fields map[string]*types.Value) *route.Route_Route {
+	cl := routeroute(cluster)
+	cl.Route.MetadataMatch = &core.Metadata{
+		FilterMetadata: map[string]*types.Struct{
+			"envoy.lb": {Fields: fields},
+		},
+	}
+	return cl
+}
+
+func routemaxgrpctimeout(cluster string, timeout, max *time.Duration) *route.Route_Route {
+	cl := routetimeout(cluster, timeout)
+	cl.Route.MaxGrpcTimeout = max
+	return cl
+}
+
+func TestMaxGRPCTimeout(t *testing.T) {
+	duration := func(d time.Duration) *time.Duration { return &d }
+	tests := map[string]struct {
+		*RouteCache
+		route *dag.Route
+		want  *time.Duration
+	}{
+		"unset": {
+			route: &dag.Route{},
+			want:  nil,
+		},
+		"default": {
+			RouteCache: &RouteCache{MaxGRPCTimeout: 10 * time.Second},
+			route:      &dag.Route{},
+			want:       duration(10 * time.Second),
+		},
+		"route overrides default": {
+			RouteCache: &RouteCache{MaxGRPCTimeout: 10 * time.Second},
+			route:      &dag.Route{MaxGRPCTimeout: 30 * time.Second},
+			want:       duration(30 * time.Second),
+		},
+		"route infinity": {
+			RouteCache: &RouteCache{MaxGRPCTimeout: 10 * time.Second},
+			route:      &dag.Route{MaxGRPCTimeout: -1},
+			want:       duration(0),
+		},
+		"capped by request timeout": {
+			route: &dag.Route{Timeout: 5 * time.Second, MaxGRPCTimeout: 30 * time.Second},
+			want:  duration(5 * time.Second),
+		},
+		"infinity capped by request timeout": {
+			route: &dag.Route{Timeout: 5 * time.Second, MaxGRPCTimeout: -1},
+			want:  duration(5 * time.Second),
+		},
+		"less than request timeout": {
+			route: &dag.Route{Timeout: 5 * time.Second, MaxGRPCTimeout: 2 * time.Second},
+			want:  duration(2 * time.Second),
+		},
+		"infinite request timeout": {
+			route: &dag.Route{Timeout: -1, MaxGRPCTimeout: 30 * time.Second},
+			want:  duration(30 * time.Second),
+		},
+		"capped by default request timeout": {
+			RouteCache: &RouteCache{RequestTimeout: 5 * time.Second},
+			route:      &dag.Route{MaxGRPCTimeout: 30 * time.Second},
+			want:       duration(5 * time.Second),
+		},
+		"capped by websocket max connection duration": {
+			route: &dag.Route{Websocket: true, Timeout: -1, WebsocketMaxConnectionDuration: 5 * time.Second, MaxGRPCTimeout: 30 * time.Second},
+			want:  duration(5 * time.Second),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rc := tc.RouteCache
+			if rc == nil {
+				rc = new(RouteCache)
+			}
+			v := routeVisitor{RouteCache: rc}
+			got := v.maxgrpctimeout(tc.route)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWebsocketTimeouts(t *testing.T) {
+	duration := func(d time.Duration) *time.Duration { return &d }
+	tests := map[string]struct {
+		route       *dag.Route
+		wantTimeout time.Duration
+		wantConfig  *route.RouteAction_WebSocketProxyConfig
+	}{
+		"websocket defaults": {
+			route:       &dag.Route{Websocket: true},
+			wantTimeout: 0,
+			wantConfig:  nil,
+		},
+		"websocket with request timeout": {
+			route:       &dag.Route{Websocket: true, Timeout: 90 * time.Second},
+			wantTimeout: 90 * time.Second,
+			wantConfig:  nil,
+		},
+		"websocket with infinite request timeout": {
+			route:       &dag.Route{Websocket: true, Timeout: -1},
+			wantTimeout: -1,
+			wantConfig:  nil,
+		},
+		"idle timeout": {
+			route:       &dag.Route{Websocket: true, WebsocketIdleTimeout: 5 * time.Minute},
+			wantTimeout: 0,
+			wantConfig: &route.RouteAction_WebSocketProxyConfig{
+				IdleTimeout: duration(5 * time.Minute),
+			},
+		},
+		"infinite idle timeout": {
+			route:       &dag.Route{Websocket: true, WebsocketIdleTimeout: -1},
+			wantTimeout: 0,
+			wantConfig: &route.RouteAction_WebSocketProxyConfig{
+				IdleTimeout: duration(0),
+			},
+		},
+		"max connection duration": {
+			route:       &dag.Route{Websocket: true, WebsocketMaxConnectionDuration: time.Hour},
+			wantTimeout: time.Hour,
+		},
+		"max connection duration replaces infinite request timeout": {
+			route:       &dag.Route{Websocket: true, Timeout: -1, WebsocketMaxConnectionDuration: time.Hour},
+			wantTimeout: time.Hour,
+		},
+		"infinite max connection duration": {
+			route:       &dag.Route{Websocket: true, WebsocketMaxConnectionDuration: -1},
+			wantTimeout: -1,
+		},
+		"infinite max connection duration and infinite request timeout": {
+			route:       &dag.Route{Websocket: true, Timeout: -1, WebsocketMaxConnectionDuration: -1},
+			wantTimeout: -1,
+		},
+		"not a websocket route": {
+			route:       &dag.Route{Timeout: 90 * time.Second, WebsocketIdleTimeout: 5 * time.Minute, WebsocketMaxConnectionDuration: time.Hour},
+			wantTimeout: 90 * time.Second,
+			wantConfig:  nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotTimeout := actiontimeout(tc.route)
+			if tc.wantTimeout != gotTimeout {
+				t.Errorf("timeout: expected: %v, got: %v", tc.wantTimeout, gotTimeout)
+			}
+			gotConfig := websocketconfig(tc.route)
+			if !reflect.DeepEqual(tc.wantConfig, gotConfig) {
+				t.Fatalf("websocket config: expected: %v, got: %v", tc.wantConfig, gotConfig)
+			}
+		})
+	}
+}
+
 func TestActionRoute(t *testing.T) {
+	stable := &dag.Service{
+		Object: &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kuard",
+				Namespace: "default",
+			},
+		},
+		ServicePort: &v1.ServicePort{
+			Port: 8080,
+		},
+		Weight: 80,
+	}
+	canary := &dag.Service{
+		Object: &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx",
+				Namespace: "default",
+			},
+		},
+		ServicePort: &v1.ServicePort{
+			Port: 8080,
+		},
+		Weight: 20,
+	}
+
 	tests := map[string]struct {
 		services  []*dag.Service
+		headers   map[*dag.Service]map[string]string
 		websocket bool
 		timeout   time.Duration
 		want      *route.Route_Route
@@ -1587,6 +3602,59 @@ func TestActionRoute(t *testing.T) {
 				},
 			},
 		},
+		"multiple weighted services with request headers": {
+			services: []*dag.Service{stable, canary},
+			headers: map[*dag.Service]map[string]string{
+				stable: {
+					"x-variant": "stable",
+				},
+				canary: {
+					"x-variant": "canary",
+					"x-canary":  "true",
+				},
+			},
+			want: &route.Route_Route{
+				Route: &route.RouteAction{
+					ClusterSpecifier: &route.RouteAction_WeightedClusters{
+						WeightedClusters: &route.WeightedCluster{
+							Clusters: []*route.WeightedCluster_ClusterWeight{{
+								Name: "default/kuard/8080",
+								Weight: &types.UInt32Value{
+									Value: uint32(80),
+								},
+								RequestHeadersToAdd: []*core.HeaderValueOption{{
+									Header: &core.HeaderValue{
+										Key:   "x-variant",
+										Value: "stable",
+									},
+									Append: &types.BoolValue{Value: true},
+								}}}, {
+								Name: "default/nginx/8080",
+								Weight: &types.UInt32Value{
+									Value: uint32(20),
+								},
+								RequestHeadersToAdd: []*core.HeaderValueOption{{
+									Header: &core.HeaderValue{
+										Key:   "x-canary",
+										Value: "true",
+									},
+									Append: &types.BoolValue{Value: true},
+								}, {
+									Header: &core.HeaderValue{
+										Key:   "x-variant",
+										Value: "canary",
+									},
+									Append: &types.BoolValue{Value: true},
+								}}},
+							},
+							TotalWeight: &types.UInt32Value{
+								Value: uint32(100),
+							},
+						},
+					},
+				},
+			},
+		},
 		"multiple weighted services and one with no weight specified": {
 			services: []*dag.Service{
 				{
@@ -1655,7 +3723,7 @@ func TestActionRoute(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			got := actionroute(tc.services, tc.websocket, tc.timeout)
+			got := actionroute(tc.services, tc.headers, tc.websocket, tc.timeout)
 			if !reflect.DeepEqual(tc.want, got) {
 				t.Errorf("wanted:\n%v\ngot:\n%v\n", tc.want, got)
 			}
@@ -1666,3 +3734,121 @@ func TestActionRoute(t *testing.T) {
 func pduration(d time.Duration) *time.Duration {
 	return &d
 }
+
+func bufferdisabled() map[string]*types.Struct {
+	return map[string]*types.Struct{
+		"envoy.buffer": {
+			Fields: map[string]*types.Value{
+				"disabled": bv(true),
+			},
+		},
+	}
+}
+
+func bufferlimit(bytes float64, timeout string) map[string]*types.Struct {
+	return map[string]*types.Struct{
+		"envoy.buffer": {
+			Fields: map[string]*types.Value{
+				"buffer": st(map[string]*types.Value{
+					"max_request_bytes": nv(bytes),
+					"max_request_time":  sv(timeout),
+				}),
+			},
+		},
+	}
+}
+
+func TestHeaderMatchers(t *testing.T) {
+	tests := map[string]struct {
+		hms  []ingressroutev1.HeaderMatch
+		want []*route.HeaderMatcher
+	}{
+		"none": {
+			hms:  nil,
+			want: nil,
+		},
+		"exact": {
+			hms: []ingressroutev1.HeaderMatch{{Name: "x-tenant", Exact: "acme"}},
+			want: []*route.HeaderMatcher{{
+				Name:                 "x-tenant",
+				HeaderMatchSpecifier: &route.HeaderMatcher_ExactMatch{ExactMatch: "acme"},
+			}},
+		},
+		"present": {
+			hms: []ingressroutev1.HeaderMatch{{Name: "x-debug", Present: true}},
+			want: []*route.HeaderMatcher{{
+				Name:                 "x-debug",
+				HeaderMatchSpecifier: &route.HeaderMatcher_PresentMatch{PresentMatch: true},
+			}},
+		},
+		"regex": {
+			hms: []ingressroutev1.HeaderMatch{{Name: "user-agent", Regex: ".*Mobile.*"}},
+			want: []*route.HeaderMatcher{{
+				Name:                 "user-agent",
+				HeaderMatchSpecifier: &route.HeaderMatcher_RegexMatch{RegexMatch: ".*Mobile.*"},
+			}},
+		},
+		"range": {
+			hms: []ingressroutev1.HeaderMatch{{
+				Name:  "x-version",
+				Range: &ingressroutev1.HeaderRange{Start: 2, End: 100},
+			}},
+			want: []*route.HeaderMatcher{{
+				Name: "x-version",
+				HeaderMatchSpecifier: &route.HeaderMatcher_RangeMatch{
+					RangeMatch: &envoy_type.Int64Range{Start: 2, End: 100},
+				},
+			}},
+		},
+		"several, in order": {
+			hms: []ingressroutev1.HeaderMatch{
+				{Name: "x-version", Regex: "v[23]"},
+				{Name: "x-tenant", Exact: "acme"},
+			},
+			want: []*route.HeaderMatcher{{
+				Name:                 "x-version",
+				HeaderMatchSpecifier: &route.HeaderMatcher_RegexMatch{RegexMatch: "v[23]"},
+			}, {
+				Name:                 "x-tenant",
+				HeaderMatchSpecifier: &route.HeaderMatcher_ExactMatch{ExactMatch: "acme"},
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := headermatchers(tc.hms)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot:\n%v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLongestRouteFirstIsDeterministic(t *testing.T) {
+	// two equal length prefixes, and one prefix claimed twice.
+	want := []route.Route{{
+		Match:  prefixmatch("/foo"),
+		Action: routeroute("default/foo/80"),
+	}, {
+		Match:  prefixmatch("/bar"),
+		Action: routeroute("default/bar/80"),
+	}, {
+		Match:  prefixmatch("/"),
+		Action: routeroute("default/b/80"),
+	}, {
+		Match:  prefixmatch("/"),
+		Action: routeroute("default/a/80"),
+	}}
+
+	for i := 0; i < 50; i++ {
+		got := make([]route.Route, len(want))
+		for j, k := range rand.Perm(len(want)) {
+			got[j] = want[k]
+		}
+		sort.Stable(sort.Reverse(longestRouteFirst(got)))
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("expected:\n%v\ngot:\n%v", want, got)
+		}
+	}
+}
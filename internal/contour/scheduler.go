@@ -0,0 +1,99 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"sync"
+	"time"
+)
+
+// A clock schedules functions to run in the future. It is replaced
+// in tests with a clock which only moves when told to.
+type clock interface {
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// A timer is a function scheduled by a clock.
+type timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer { return time.AfterFunc(d, f) }
+
+// A scheduler calls a function, once, after a delay for each key
+// unless the key is cancelled first. The zero value is ready to use.
+type scheduler struct {
+	clock
+
+	mu      sync.Mutex
+	pending map[string]*scheduled
+}
+
+type scheduled struct {
+	timer
+}
+
+// Schedule arranges for fn to be called after d. If key is already
+// scheduled, Schedule does nothing.
+func (s *scheduler) Schedule(key string, d time.Duration, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[key]; ok {
+		return
+	}
+	if s.pending == nil {
+		s.pending = make(map[string]*scheduled)
+	}
+	c := s.clock
+	if c == nil {
+		c = realClock{}
+	}
+	sc := new(scheduled)
+	sc.timer = c.AfterFunc(d, func() {
+		s.mu.Lock()
+		if s.pending[key] != sc {
+			// cancelled, and possibly scheduled again.
+			s.mu.Unlock()
+			return
+		}
+		delete(s.pending, key)
+		s.mu.Unlock()
+		fn()
+	})
+	s.pending[key] = sc
+}
+
+// Cancel cancels the function scheduled for key. It returns true if
+// key was scheduled.
+func (s *scheduler) Cancel(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.pending[key]
+	if !ok {
+		return false
+	}
+	sc.Stop()
+	delete(s.pending, key)
+	return true
+}
+
+// Pending returns true if key is scheduled.
+func (s *scheduler) Pending(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.pending[key]
+	return ok
+}
@@ -0,0 +1,107 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerSchedule(t *testing.T) {
+	var fc fakeClock
+	s := scheduler{clock: &fc}
+	fired := 0
+	s.Schedule("a", 10*time.Second, func() { fired++ })
+	// scheduling a pending key again does nothing.
+	s.Schedule("a", time.Second, func() { fired++ })
+
+	fc.Advance(9 * time.Second)
+	if fired != 0 || !s.Pending("a") {
+		t.Fatalf("fired %d times before its deadline", fired)
+	}
+	fc.Advance(time.Second)
+	if fired != 1 || s.Pending("a") {
+		t.Fatalf("expected 1 call at the deadline, got %d", fired)
+	}
+	fc.Advance(time.Hour)
+	if fired != 1 {
+		t.Fatalf("expected 1 call, got %d", fired)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	var fc fakeClock
+	s := scheduler{clock: &fc}
+	fired := 0
+	s.Schedule("a", 10*time.Second, func() { fired++ })
+	if !s.Cancel("a") {
+		t.Fatal("expected a to be pending")
+	}
+	if s.Cancel("a") {
+		t.Fatal("expected a to be cancelled")
+	}
+	fc.Advance(10 * time.Second)
+	if fired != 0 {
+		t.Fatalf("cancelled function fired %d times", fired)
+	}
+
+	// a cancelled key can be scheduled again.
+	s.Schedule("a", 10*time.Second, func() { fired++ })
+	fc.Advance(10 * time.Second)
+	if fired != 1 {
+		t.Fatalf("expected 1 call, got %d", fired)
+	}
+}
+
+// fakeClock is a clock which only moves when advanced.
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	wasRunning := !t.stopped
+	t.stopped = true
+	return wasRunning
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	t := &fakeTimer{when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, calling, in order, the
+// functions of the timers which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	timers := c.timers
+	c.timers = nil
+	for _, t := range timers {
+		switch {
+		case t.stopped:
+		case t.when.After(c.now):
+			c.timers = append(c.timers, t)
+		default:
+			t.stopped = true
+			t.f()
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/gogo/protobuf/proto"
+	"github.com/heptio/contour/internal/dag"
+)
+
+// SecretCache manages the contents of the gRPC SDS cache.
+type SecretCache struct {
+	secretCache
+}
+
+type secretCache struct {
+	mu      sync.Mutex
+	values  map[string]*auth.Secret
+	waiters []chan int
+	last    int
+}
+
+// Register registers ch to receive a value when Notify is called.
+// The value of last is the count of the times Notify has been called on this Cache.
+// It functions of a sequence counter, if the value of last supplied to Register
+// is less than the Cache's internal counter, then the caller has missed at least
+// one notification and will fire immediately.
+//
+// Sends by the broadcaster to ch must not block, therefor ch must have a capacity
+// of at least 1.
+func (c *secretCache) Register(ch chan int, last int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if last < c.last {
+		// notify this channel immediately
+		ch <- c.last
+		return
+	}
+	c.waiters = append(c.waiters, ch)
+}
+
+// Update replaces the contents of the cache with the supplied map.
+func (c *secretCache) Update(v map[string]*auth.Secret) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values = v
+	c.notify()
+}
+
+// notify notifies all registered waiters that an event has occurred.
+func (c *secretCache) notify() {
+	c.last++
+
+	for _, ch := range c.waiters {
+		ch <- c.last
+	}
+	c.waiters = c.waiters[:0]
+}
+
+// Values returns a slice of the value stored in the cache, sorted by name.
+func (c *secretCache) Values(filter func(string) bool) []proto.Message {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		if filter(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	values := make([]proto.Message, 0, len(names))
+	for _, name := range names {
+		values = append(values, c.values[name])
+	}
+	c.mu.Unlock()
+	return values
+}
+
+// secretVisitor walks a *dag.DAG and produces a map of *auth.Secrets.
+type secretVisitor struct {
+	*SecretCache
+	dag.Visitable
+
+	secrets map[string]*auth.Secret
+}
+
+func (v *secretVisitor) Visit() map[string]*auth.Secret {
+	v.secrets = make(map[string]*auth.Secret)
+	v.Visitable.Visit(v.visit)
+	return v.secrets
+}
+
+func (v *secretVisitor) visit(vertex dag.Vertex) {
+	if s, ok := vertex.(*dag.Secret); ok && s != nil {
+		name := secretname(s)
+		if _, ok := v.secrets[name]; !ok {
+			v.secrets[name] = &auth.Secret{
+				Name: name,
+				Type: &auth.Secret_TlsCertificate{
+					TlsCertificate: tlscertificate(s.Data()),
+				},
+			}
+		}
+	}
+	// recurse into children of v
+	vertex.Visit(v.visit)
+}
+
+// secretname returns the name by which the HTTPS listener refers to
+// the secret s. It does not change when the contents of s do.
+func secretname(s *dag.Secret) string {
+	return hashname(60, s.Namespace(), s.Name())
+}
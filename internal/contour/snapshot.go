@@ -0,0 +1,147 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/heptio/contour/internal/dag"
+)
+
+// Snapshot describes the state of the CacheHandler as of its last
+// recompute. It is served as JSON by the /debug/status endpoint.
+type Snapshot struct {
+	// LastRecompute is how long the last recompute took.
+	LastRecompute string `json:"lastRecompute"`
+
+	// Generation counts the recomputes performed.
+	Generation int `json:"generation"`
+
+	// Objects counts the vertices in the DAG by kind.
+	Objects map[string]int `json:"objects"`
+
+	IngressRoutes IngressRouteCounts `json:"ingressroutes"`
+
+	// Caches describes each xDS cache by resource type.
+	Caches map[string]CacheSnapshot `json:"caches"`
+}
+
+// IngressRouteCounts counts IngressRoutes by status.
+type IngressRouteCounts struct {
+	Valid    int `json:"valid"`
+	Invalid  int `json:"invalid"`
+	Orphaned int `json:"orphaned"`
+}
+
+// CacheSnapshot describes the contents of an xDS cache.
+type CacheSnapshot struct {
+	Resources int `json:"resources"`
+
+	// Version is a hash of the cache's contents, it changes
+	// only when they do.
+	Version string `json:"version"`
+}
+
+// recompute records the state of the last recompute.
+type recompute struct {
+	duration      time.Duration
+	generation    int
+	objects       map[string]int
+	ingressroutes IngressRouteCounts
+}
+
+// Snapshot returns the state of ch as of its last recompute.
+// Locks are held only long enough to copy the state out, so the
+// Snapshot may be encoded without blocking recomputes or Envoy.
+func (ch *CacheHandler) Snapshot() Snapshot {
+	ch.mu.Lock()
+	r := ch.last
+	ch.mu.Unlock()
+
+	s := Snapshot{
+		LastRecompute: r.duration.String(),
+		Generation:    r.generation,
+		Objects:       make(map[string]int, len(r.objects)),
+		IngressRoutes: r.ingressroutes,
+		Caches: map[string]CacheSnapshot{
+			"listeners": SnapshotCache(&ch.ListenerCache),
+			"routes":    SnapshotCache(&ch.RouteCache),
+			"clusters":  SnapshotCache(&ch.ClusterCache),
+			"secrets":   SnapshotCache(&ch.SecretCache),
+		},
+	}
+	for kind, n := range r.objects {
+		s.Objects[kind] = n
+	}
+	return s
+}
+
+// SnapshotCache returns the number of resources in c and a hash of
+// their contents.
+func SnapshotCache(c interface {
+	Values(func(string) bool) []proto.Message
+}) CacheSnapshot {
+	values := c.Values(func(string) bool { return true })
+	h := sha256.New()
+	for _, v := range values {
+		// the text format orders map keys, the binary format does not.
+		fmt.Fprintln(h, proto.CompactTextString(v))
+	}
+	return CacheSnapshot{
+		Resources: len(values),
+		Version:   fmt.Sprintf("%x", h.Sum(nil))[:16],
+	}
+}
+
+// recordRecompute records the state of the DAG d, which took
+// duration to compute.
+func (ch *CacheHandler) recordRecompute(d *dag.DAG, duration time.Duration) {
+	objects := make(map[string]int)
+	seen := make(map[dag.Vertex]bool)
+	var visit func(dag.Vertex)
+	visit = func(v dag.Vertex) {
+		if seen[v] {
+			return
+		}
+		seen[v] = true
+		objects[strings.TrimPrefix(fmt.Sprintf("%T", v), "*dag.")]++
+		v.Visit(visit)
+	}
+	d.Visit(visit)
+
+	var irs IngressRouteCounts
+	for _, s := range d.Statuses() {
+		switch s.Status {
+		case dag.StatusValid:
+			irs.Valid++
+		case dag.StatusInvalid:
+			irs.Invalid++
+		case dag.StatusOrphaned:
+			irs.Orphaned++
+		}
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.last = recompute{
+		duration:      duration,
+		generation:    ch.last.generation + 1,
+		objects:       objects,
+		ingressroutes: irs,
+	}
+}
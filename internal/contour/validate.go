@@ -0,0 +1,149 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"sort"
+
+	"github.com/heptio/contour/internal/dag"
+)
+
+// A Problem is an error, or a warning, which Validate found in an
+// object, or in an xDS resource generated from the objects.
+type Problem struct {
+	// Kind, Namespace, and Name identify the object; Kind is one of
+	// Ingress, IngressRoute, or Service, or the type of an xDS
+	// resource, which has no namespace.
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+
+	// Warning is true if the object is served, in part.
+	Warning bool `json:"warning,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// validator is implemented by the generated xDS resources.
+type validator interface {
+	Validate() error
+}
+
+// Validate builds the DAG of objs, as reh would were they watched, and
+// runs the visitors of ch over it, without serving the result: no
+// IngressRoute status is written, no event is recorded, and ch's caches
+// are not updated. It returns the problems found, ordered by kind,
+// namespace, and name: each IngressRoute which is invalid, orphaned, or
+// valid with warnings, each Ingress which was skipped or part of which
+// was ignored, each Service annotation which was ignored, and each
+// generated listener, route configuration, or cluster which Envoy would
+// reject.
+//
+// reh should not have been used; objs are inserted into it.
+func Validate(reh *ResourceEventHandler, ch *CacheHandler, objs []interface{}) []Problem {
+	for _, obj := range objs {
+		if reh.validIngressClass(obj) {
+			reh.Insert(obj)
+		}
+	}
+	d := reh.Build()
+
+	var problems []Problem
+	for _, s := range d.Statuses() {
+		p := Problem{
+			Kind:      "IngressRoute",
+			Namespace: s.Object.Namespace,
+			Name:      s.Object.Name,
+			Message:   s.Status + ": " + s.Description,
+		}
+		if s.Status == dag.StatusValid {
+			if s.Description == "valid IngressRoute" {
+				continue
+			}
+			p.Warning = true
+		}
+		problems = append(problems, p)
+	}
+	for _, w := range d.Warnings() {
+		problems = append(problems, Problem{
+			Kind:      "Ingress",
+			Namespace: w.Object.Namespace,
+			Name:      w.Object.Name,
+			Warning:   true,
+			Message:   w.Reason + ": " + w.Message,
+		})
+	}
+	for _, w := range d.ServiceWarnings() {
+		problems = append(problems, Problem{
+			Kind:      "Service",
+			Namespace: w.Object.Namespace,
+			Name:      w.Object.Name,
+			Warning:   true,
+			Message:   w.Reason + ": " + w.Message,
+		})
+	}
+
+	var v dag.Visitable = d
+	if ch.DisableHTTPS {
+		v = insecureOnly{v}
+	}
+	lv := listenerVisitor{
+		ListenerCache: &ch.ListenerCache,
+		Visitable:     v,
+		names:         ch.RouteConfigNames,
+	}
+	for name, l := range lv.Visit() {
+		problems = appendRejected(problems, "Listener", name, l)
+	}
+	rv := routeVisitor{
+		RouteCache: &ch.RouteCache,
+		Visitable:  v,
+		names:      ch.RouteConfigNames,
+	}
+	for name, r := range rv.Visit() {
+		problems = appendRejected(problems, "RouteConfiguration", name, r)
+	}
+	cv := clusterVisitor{
+		ClusterCache: &ch.ClusterCache,
+		Visitable:    v,
+	}
+	for name, c := range cv.Visit() {
+		problems = appendRejected(problems, "Cluster", name, c)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		pi, pj := problems[i], problems[j]
+		if pi.Kind != pj.Kind {
+			return pi.Kind < pj.Kind
+		}
+		if pi.Namespace != pj.Namespace {
+			return pi.Namespace < pj.Namespace
+		}
+		return pi.Name < pj.Name
+	})
+	return problems
+}
+
+// appendRejected appends a Problem to problems if Envoy would reject
+// the named xDS resource r.
+func appendRejected(problems []Problem, kind, name string, r validator) []Problem {
+	if err := r.Validate(); err != nil {
+		problems = append(problems, Problem{
+			Kind:    kind,
+			Name:    name,
+			Message: err.Error(),
+		})
+	}
+	return problems
+}
@@ -0,0 +1,168 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package contour
+
+import (
+	"reflect"
+	"testing"
+
+	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestValidate(t *testing.T) {
+	s1 := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Protocol:   "TCP",
+				Port:       8080,
+				TargetPort: intstr.FromInt(8080),
+			}},
+		},
+	}
+
+	// ir1 is a valid root ingressroute
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir2 is a root ingressroute without an fqdn
+	ir2 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nofqdn",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir3 is not delegated to by any root ingressroute
+	ir3 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "orphan",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			Routes: []ingressroutev1.Route{{
+				Match: "/orphan",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// i1 creates the "*" virtual host
+	i1 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "wildcard",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: &v1beta1.IngressBackend{
+				ServiceName: "kuard",
+				ServicePort: intstr.FromInt(8080),
+			},
+		},
+	}
+
+	// i2 is i1 of another ingress class
+	i2 := i1.DeepCopy()
+	i2.Annotations = map[string]string{
+		"kubernetes.io/ingress.class": "nginx",
+	}
+
+	tests := map[string]struct {
+		disableDefaultBackend bool
+		objs                  []interface{}
+		want                  []Problem
+	}{
+		"valid objects": {
+			objs: []interface{}{s1, ir1, i1},
+			want: nil,
+		},
+		"invalid and orphaned ingressroutes": {
+			objs: []interface{}{s1, ir1, ir3, ir2},
+			want: []Problem{{
+				Kind:      "IngressRoute",
+				Namespace: "default",
+				Name:      "nofqdn",
+				Message:   "invalid: Spec.VirtualHost.Fqdn must be specified",
+			}, {
+				Kind:      "IngressRoute",
+				Namespace: "default",
+				Name:      "orphan",
+				Message:   "orphaned: this IngressRoute is not part of a delegation chain from a root IngressRoute",
+			}},
+		},
+		"skipped ingress": {
+			disableDefaultBackend: true,
+			objs:                  []interface{}{s1, i1},
+			want: []Problem{{
+				Kind:      "Ingress",
+				Namespace: "default",
+				Name:      "wildcard",
+				Warning:   true,
+				Message:   `DefaultBackendNotAllowed: the "*" virtual host is disabled`,
+			}},
+		},
+		"ingress of another class": {
+			disableDefaultBackend: true,
+			objs:                  []interface{}{s1, i2},
+			want:                  nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var reh ResourceEventHandler
+			reh.DisableDefaultBackend = tc.disableDefaultBackend
+			got := Validate(&reh, new(CacheHandler), tc.objs)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %#v, got: %#v", tc.want, got)
+			}
+		})
+	}
+}
@@ -15,11 +15,13 @@ package dag
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gogo/protobuf/types"
+	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 )
 
@@ -28,12 +30,35 @@ const (
 	// are applied by Contour.
 
 	annotationRequestTimeout     = "contour.heptio.com/request-timeout"
+	annotationMaxGRPCTimeout     = "contour.heptio.com/max-grpc-timeout"
+	annotationMaxRequestBytes    = "contour.heptio.com/max-request-bytes"
 	annotationWebsocketRoutes    = "contour.heptio.com/websocket-routes"
 	annotationUpstreamProtocol   = "contour.heptio.com/upstream-protocol"
 	annotationMaxConnections     = "contour.heptio.com/max-connections"
 	annotationMaxPendingRequests = "contour.heptio.com/max-pending-requests"
 	annotationMaxRequests        = "contour.heptio.com/max-requests"
 	annotationMaxRetries         = "contour.heptio.com/max-retries"
+	annotationSecondaryTLSSecret = "contour.heptio.com/tls-secondary-secret"
+	annotationDiscoveryType      = "contour.heptio.com/cluster-discovery-type"
+	annotationBackendNamespace   = "contour.heptio.com/backend-namespace"
+	annotationAllowIngressFrom   = "contour.heptio.com/allow-ingress-from"
+	annotationAccessLogExclude   = "contour.heptio.com/access-log-exclude-paths"
+	annotationAccessLogSample    = "contour.heptio.com/access-log-sample-rate"
+
+	annotationUpstreamConnectTimeout        = "contour.heptio.com/upstream-connect-timeout"
+	annotationUpstreamIdleTimeout           = "contour.heptio.com/upstream-idle-timeout"
+	annotationUpstreamMaxConnectionDuration = "contour.heptio.com/upstream-max-connection-duration"
+
+	annotationDNSLookupFamily = "contour.heptio.com/dns-lookup-family"
+	annotationDNSRefreshRate  = "contour.heptio.com/dns-refresh-rate"
+
+	annotationHTTP2MaxConcurrentStreams        = "contour.heptio.com/http2-max-concurrent-streams"
+	annotationHTTP2InitialStreamWindowSize     = "contour.heptio.com/http2-initial-stream-window-size"
+	annotationHTTP2InitialConnectionWindowSize = "contour.heptio.com/http2-initial-connection-window-size"
+
+	// annotations of other ingress controllers, honoured only
+	// if KubernetesCache.ForeignAnnotations is set.
+	annotationProxyBodySize = "ingress.kubernetes.io/proxy-body-size"
 
 	// By default envoy applies a 15 second timeout to all backend requests.
 	// The explicit value 0 turns off the timeout, implying "never time out"
@@ -42,23 +67,11 @@ const (
 	noTimeout       = 0
 )
 
-// parseAnnotationTimeout parses the annotations map for a contour.heptio.com/request-timeout
-// value. If the value is present, but malformed, the timeout value is valid, and represents
-// infinite timeout.
+// parseAnnotationTimeout parses the annotations map for the supplied timeout annotation,
+// eg. contour.heptio.com/request-timeout. If the value is present, but malformed, the
+// timeout value is valid, and represents infinite timeout.
 func parseAnnotationTimeout(annotations map[string]string, annotation string) time.Duration {
-	timeoutStr := annotations[annotationRequestTimeout]
-	// Error or unspecified is interpreted as no timeout specified, use envoy defaults
-	if timeoutStr == "" {
-		return noTimeout
-	}
-	// Interpret "infinity" explicitly as an infinite timeout, which envoy config
-	// expects as a timeout of 0. This could be specified with the duration string
-	// "0s" but want to give an explicit out for operators.
-	if timeoutStr == "infinity" {
-		return infiniteTimeout
-	}
-
-	timeoutParsed, err := time.ParseDuration(timeoutStr)
+	timeoutParsed, err := parseTimeout(annotations[annotation])
 	if err != nil {
 		// TODO(cmalonty) plumb a logger in here so we can log this error.
 		// Assuming infinite duration is going to surprise people less for
@@ -68,6 +81,18 @@ func parseAnnotationTimeout(annotations map[string]string, annotation string) ti
 	return timeoutParsed
 }
 
+// parsePositiveDuration parses the supplied duration annotation, eg.
+// upstream-connect-timeout, which Envoy requires to be finite and
+// positive so, unlike the timeouts, a value which is malformed,
+// infinite, or not positive is ignored and zero returned.
+func parsePositiveDuration(annotations map[string]string, annotation string) time.Duration {
+	d, err := time.ParseDuration(annotations[annotation])
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
 // parseAnnotation parses the annotation map for the supplied key.
 // If the value is not present, or malformed, then zero is returned.
 func parseAnnotation(annotations map[string]string, annotation string) int {
@@ -75,6 +100,66 @@ func parseAnnotation(annotations map[string]string, annotation string) int {
 	return int(v)
 }
 
+// parseAnnotationInRange parses the annotation map for the supplied key.
+// If the value is not present, malformed, or outside the range min to
+// max inclusive, then zero is returned.
+func parseAnnotationInRange(annotations map[string]string, annotation string, min, max uint32) uint32 {
+	v, err := strconv.ParseUint(strings.TrimSpace(annotations[annotation]), 10, 32)
+	if err != nil || v < uint64(min) || v > uint64(max) {
+		return 0
+	}
+	return uint32(v)
+}
+
+// Ranges of the HTTP/2 settings Envoy accepts for an upstream cluster.
+const (
+	http2MaxConcurrentStreamsMin = 1
+	http2WindowSizeMin           = 65535
+	http2SettingMax              = math.MaxInt32
+)
+
+// accessLogSampleRateMax is the largest access log sample rate, one in
+// a million requests, the finest fraction Envoy can sample.
+const accessLogSampleRateMax = 1000000
+
+// parseHTTP2Settings parses the http2-max-concurrent-streams,
+// http2-initial-stream-window-size, and
+// http2-initial-connection-window-size annotations into s.
+func parseHTTP2Settings(annotations map[string]string, s *Service) {
+	s.HTTP2MaxConcurrentStreams = parseAnnotationInRange(annotations, annotationHTTP2MaxConcurrentStreams, http2MaxConcurrentStreamsMin, http2SettingMax)
+	s.HTTP2InitialStreamWindowSize = parseAnnotationInRange(annotations, annotationHTTP2InitialStreamWindowSize, http2WindowSizeMin, http2SettingMax)
+	s.HTTP2InitialConnectionWindowSize = parseAnnotationInRange(annotations, annotationHTTP2InitialConnectionWindowSize, http2WindowSizeMin, http2SettingMax)
+}
+
+// parseSize parses a size in bytes, optionally suffixed by k, m or g
+// for kibibytes, mebibytes or gibibytes, as accepted by nginx. Zero
+// means no limit.
+func parseSize(size string) (uint32, error) {
+	s := strings.TrimSpace(size)
+	unit := uint64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			unit = 1 << 10
+		case 'm', 'M':
+			unit = 1 << 20
+		case 'g', 'G':
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	if n*unit > math.MaxUint32 {
+		return 0, fmt.Errorf("size %q exceeds %d bytes", size, uint32(math.MaxUint32))
+	}
+	return uint32(n * unit), nil
+}
+
 // parseAnnotationUint32 parsers the annotation map for the supplied annotation key.
 // If the value is not present, or malformed, then nil is returned.
 func parseAnnotationUInt32(annotations map[string]string, annotation string) *types.UInt32Value {
@@ -85,6 +170,20 @@ func parseAnnotationUInt32(annotations map[string]string, annotation string) *ty
 	return &types.UInt32Value{Value: uint32(v)}
 }
 
+// parseAccessLogExcludePaths parses the comma separated list of paths,
+// eg. /healthz, of the access-log-exclude-paths annotation. Values which
+// are not absolute paths are ignored.
+func parseAccessLogExcludePaths(annotations map[string]string) []string {
+	var paths []string
+	for _, p := range strings.Split(annotations[annotationAccessLogExclude], ",") {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "/") {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
 // parseUpstreamProtocols parses the annotations map for a contour.heptio.com/upstream-protocol.{protocol}
 // where 'protocol' identifies which protocol must be used in the upstream.
 // If the value is not present, or malformed, then an empty map is returned.
@@ -102,16 +201,115 @@ func parseUpstreamProtocols(annotations map[string]string, annotation string, pr
 	return up
 }
 
+// Cluster discovery types which may be requested with the
+// contour.heptio.com/cluster-discovery-type annotation.
+const (
+	DiscoveryTypeStrictDNS  = "STRICT_DNS"
+	DiscoveryTypeLogicalDNS = "LOGICAL_DNS"
+	DiscoveryTypeStatic     = "STATIC"
+)
+
+// DNS lookup families which may be requested with the
+// contour.heptio.com/dns-lookup-family annotation.
+const (
+	DNSLookupFamilyAuto = "auto"
+	DNSLookupFamilyV4   = "v4"
+	DNSLookupFamilyV6   = "v6"
+)
+
+// parseDNSLookupFamily returns the DNS lookup family requested by the
+// contour.heptio.com/dns-lookup-family annotation. The empty string,
+// meaning the cluster default, is returned if the annotation is absent
+// or unknown.
+func parseDNSLookupFamily(annotations map[string]string) string {
+	switch f := strings.ToLower(strings.TrimSpace(annotations[annotationDNSLookupFamily])); f {
+	case DNSLookupFamilyAuto, DNSLookupFamilyV4, DNSLookupFamilyV6:
+		return f
+	default:
+		return ""
+	}
+}
+
+// parseDiscoveryType returns the cluster discovery type requested by the
+// contour.heptio.com/cluster-discovery-type annotation on svc. The empty
+// string, meaning EDS, is returned if the annotation is absent, unknown,
+// or svc cannot supply a host for the requested type: STATIC needs a
+// cluster IP, and LOGICAL_DNS needs a name which resolves to a single
+// stable address, which a headless Service's name does not.
+func parseDiscoveryType(svc *v1.Service) string {
+	switch dt := svc.Annotations[annotationDiscoveryType]; dt {
+	case DiscoveryTypeStrictDNS:
+		return dt
+	case DiscoveryTypeLogicalDNS:
+		if svc.Spec.Type != v1.ServiceTypeExternalName && svc.Spec.ClusterIP == v1.ClusterIPNone {
+			return ""
+		}
+		return dt
+	case DiscoveryTypeStatic:
+		if svc.Spec.Type == v1.ServiceTypeExternalName || svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == v1.ClusterIPNone {
+			return ""
+		}
+		return dt
+	default:
+		return ""
+	}
+}
+
+// backendNamespace returns the namespace of the service named by a
+// backend of i. The contour.heptio.com/backend-namespace.{service}
+// annotation names a namespace other than i's for that service.
+func backendNamespace(i *v1beta1.Ingress, service string) string {
+	if ns := strings.TrimSpace(i.Annotations[annotationBackendNamespace+"."+service]); ns != "" {
+		return ns
+	}
+	return i.Namespace
+}
+
+// allowsIngressFrom returns true if svc may be the backend of an
+// Ingress in namespace. A service always permits Ingresses in its own
+// namespace, others must be listed in its
+// contour.heptio.com/allow-ingress-from annotation, or "*" for all.
+func allowsIngressFrom(svc *v1.Service, namespace string) bool {
+	if svc.Namespace == namespace {
+		return true
+	}
+	for _, v := range strings.Split(svc.Annotations[annotationAllowIngressFrom], ",") {
+		switch strings.TrimSpace(v) {
+		case namespace, "*":
+			return true
+		}
+	}
+	return false
+}
+
 // httpAllowed returns true unless the kubernetes.io/ingress.allow-http annotation is
 // present and set to false.
 func httpAllowed(i *v1beta1.Ingress) bool {
 	return !(i.Annotations["kubernetes.io/ingress.allow-http"] == "false")
 }
 
+// sslRedirectAliases are annotations from other ingress controllers which
+// are treated as ingress.kubernetes.io/force-ssl-redirect, in order of
+// precedence.
+var sslRedirectAliases = []string{
+	"nginx.ingress.kubernetes.io/force-ssl-redirect",
+	"ingress.kubernetes.io/ssl-redirect",
+	"nginx.ingress.kubernetes.io/ssl-redirect",
+}
+
 // tlsRequired returns true if the ingress.kubernetes.io/force-ssl-redirect annotation is
-// present and set to true.
+// present and set to true. If it is not present, the first of its aliases which is present
+// decides.
 func tlsRequired(i *v1beta1.Ingress) bool {
-	return i.Annotations["ingress.kubernetes.io/force-ssl-redirect"] == "true"
+	if v, ok := i.Annotations["ingress.kubernetes.io/force-ssl-redirect"]; ok {
+		return v == "true"
+	}
+	for _, alias := range sslRedirectAliases {
+		if v, ok := i.Annotations[alias]; ok {
+			return v == "true"
+		}
+	}
+	return false
 }
 
 func websocketRoutes(i *v1beta1.Ingress) map[string]bool {
@@ -124,3 +322,20 @@ func websocketRoutes(i *v1beta1.Ingress) map[string]bool {
 	}
 	return routes
 }
+
+// parseTimeout parses a timeout value. The empty string is interpreted as
+// no timeout specified, use envoy defaults. The string "infinity" is
+// interpreted explicitly as an infinite timeout, which envoy config expects
+// as a timeout of 0. This could be specified with the duration string "0s"
+// but want to give an explicit out for operators. Otherwise s must be a
+// valid duration.
+func parseTimeout(s string) (time.Duration, error) {
+	switch s {
+	case "":
+		return noTimeout, nil
+	case "infinity":
+		return infiniteTimeout, nil
+	default:
+		return time.ParseDuration(s)
+	}
+}
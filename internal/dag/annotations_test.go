@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/gogo/protobuf/types"
+	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -107,6 +108,141 @@ func TestParseAnnotationUInt32(t *testing.T) {
 	}
 }
 
+func TestParseAnnotationInRange(t *testing.T) {
+	tests := map[string]struct {
+		a    map[string]string
+		want uint32
+	}{
+		"nada": {
+			a:    nil,
+			want: 0,
+		},
+		"in range": {
+			a:    map[string]string{annotationHTTP2InitialStreamWindowSize: "65536"},
+			want: 65536,
+		},
+		"minimum": {
+			a:    map[string]string{annotationHTTP2InitialStreamWindowSize: "65535"},
+			want: 65535,
+		},
+		"maximum": {
+			a:    map[string]string{annotationHTTP2InitialStreamWindowSize: "2147483647"},
+			want: math.MaxInt32,
+		},
+		"below minimum": {
+			a:    map[string]string{annotationHTTP2InitialStreamWindowSize: "1024"},
+			want: 0,
+		},
+		"above maximum": {
+			a:    map[string]string{annotationHTTP2InitialStreamWindowSize: "2147483648"},
+			want: 0,
+		},
+		"negative": {
+			a:    map[string]string{annotationHTTP2InitialStreamWindowSize: "-65536"},
+			want: 0,
+		},
+		"invalid": {
+			a:    map[string]string{annotationHTTP2InitialStreamWindowSize: "64k"},
+			want: 0,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseAnnotationInRange(tc.a, annotationHTTP2InitialStreamWindowSize, http2WindowSizeMin, http2SettingMax)
+			if got != tc.want {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]struct {
+		size    string
+		want    uint32
+		wantErr bool
+	}{
+		"unlimited": {
+			size: "0",
+			want: 0,
+		},
+		"bytes": {
+			size: "1024",
+			want: 1024,
+		},
+		"kibibytes": {
+			size: "8k",
+			want: 8 << 10,
+		},
+		"mebibytes": {
+			size: "8m",
+			want: 8 << 20,
+		},
+		"upper case suffix": {
+			size: "1G",
+			want: 1 << 30,
+		},
+		"too large": {
+			size:    "4g",
+			wantErr: true,
+		},
+		"empty": {
+			size:    "",
+			wantErr: true,
+		},
+		"unknown suffix": {
+			size:    "8mb",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseSize(tc.size)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseSize(%q): expected error: %v, got: %v", tc.size, tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Fatalf("parseSize(%q): want: %d, got: %d", tc.size, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseAccessLogExcludePaths(t *testing.T) {
+	tests := map[string]struct {
+		a    map[string]string
+		want []string
+	}{
+		"nada": {
+			a:    nil,
+			want: nil,
+		},
+		"single path": {
+			a:    map[string]string{annotationAccessLogExclude: "/healthz"},
+			want: []string{"/healthz"},
+		},
+		"multiple paths with spaces": {
+			a:    map[string]string{annotationAccessLogExclude: "/healthz, /ready ,,"},
+			want: []string{"/healthz", "/ready"},
+		},
+		"relative path ignored": {
+			a:    map[string]string{annotationAccessLogExclude: "healthz,/ready"},
+			want: []string{"/ready"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseAccessLogExcludePaths(tc.a)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("parseAccessLogExcludePaths(%q): want: %v, got: %v", tc.a, tc.want, got)
+			}
+		})
+	}
+}
+
 func TestParseUpstreamProtocols(t *testing.T) {
 	tests := map[string]struct {
 		a    map[string]string
@@ -268,3 +404,205 @@ func TestHttpAllowed(t *testing.T) {
 		})
 	}
 }
+
+func TestTLSRequired(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		want        bool
+	}{
+		"no annotations": {
+			want: false,
+		},
+		"force-ssl-redirect true": {
+			annotations: map[string]string{
+				"ingress.kubernetes.io/force-ssl-redirect": "true",
+			},
+			want: true,
+		},
+		"force-ssl-redirect false": {
+			annotations: map[string]string{
+				"ingress.kubernetes.io/force-ssl-redirect": "false",
+			},
+			want: false,
+		},
+		"ssl-redirect true": {
+			annotations: map[string]string{
+				"ingress.kubernetes.io/ssl-redirect": "true",
+			},
+			want: true,
+		},
+		"nginx ssl-redirect true": {
+			annotations: map[string]string{
+				"nginx.ingress.kubernetes.io/ssl-redirect": "true",
+			},
+			want: true,
+		},
+		"nginx force-ssl-redirect true": {
+			annotations: map[string]string{
+				"nginx.ingress.kubernetes.io/force-ssl-redirect": "true",
+			},
+			want: true,
+		},
+		"force-ssl-redirect false wins over ssl-redirect true": {
+			annotations: map[string]string{
+				"ingress.kubernetes.io/force-ssl-redirect": "false",
+				"ingress.kubernetes.io/ssl-redirect":       "true",
+			},
+			want: false,
+		},
+		"force-ssl-redirect true wins over ssl-redirect false": {
+			annotations: map[string]string{
+				"ingress.kubernetes.io/force-ssl-redirect": "true",
+				"ingress.kubernetes.io/ssl-redirect":       "false",
+			},
+			want: true,
+		},
+		"force-ssl-redirect false wins over nginx force-ssl-redirect true": {
+			annotations: map[string]string{
+				"ingress.kubernetes.io/force-ssl-redirect":       "false",
+				"nginx.ingress.kubernetes.io/force-ssl-redirect": "true",
+			},
+			want: false,
+		},
+		"nginx force-ssl-redirect wins over ssl-redirect": {
+			annotations: map[string]string{
+				"nginx.ingress.kubernetes.io/force-ssl-redirect": "true",
+				"ingress.kubernetes.io/ssl-redirect":             "false",
+			},
+			want: true,
+		},
+		"ssl-redirect wins over nginx ssl-redirect": {
+			annotations: map[string]string{
+				"ingress.kubernetes.io/ssl-redirect":       "false",
+				"nginx.ingress.kubernetes.io/ssl-redirect": "true",
+			},
+			want: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			i := &v1beta1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "simple",
+					Namespace:   "default",
+					Annotations: tc.annotations,
+				},
+			}
+			got := tlsRequired(i)
+			if got != tc.want {
+				t.Fatalf("got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseDiscoveryType(t *testing.T) {
+	service := func(annotation string, spec v1.ServiceSpec) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kuard",
+				Namespace: "default",
+				Annotations: map[string]string{
+					annotationDiscoveryType: annotation,
+				},
+			},
+			Spec: spec,
+		}
+	}
+	clusterIP := v1.ServiceSpec{ClusterIP: "10.0.0.10"}
+	headless := v1.ServiceSpec{ClusterIP: v1.ClusterIPNone}
+	externalName := v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "kuard.example.com"}
+
+	tests := map[string]struct {
+		svc  *v1.Service
+		want string
+	}{
+		"no annotation": {
+			svc:  &v1.Service{Spec: clusterIP},
+			want: "",
+		},
+		"unknown type": {
+			svc:  service("ORIGINAL_DST", clusterIP),
+			want: "",
+		},
+		"strict dns": {
+			svc:  service("STRICT_DNS", clusterIP),
+			want: DiscoveryTypeStrictDNS,
+		},
+		"strict dns headless": {
+			svc:  service("STRICT_DNS", headless),
+			want: DiscoveryTypeStrictDNS,
+		},
+		"logical dns": {
+			svc:  service("LOGICAL_DNS", clusterIP),
+			want: DiscoveryTypeLogicalDNS,
+		},
+		"logical dns external name": {
+			svc:  service("LOGICAL_DNS", externalName),
+			want: DiscoveryTypeLogicalDNS,
+		},
+		"logical dns headless": {
+			svc:  service("LOGICAL_DNS", headless),
+			want: "",
+		},
+		"static": {
+			svc:  service("STATIC", clusterIP),
+			want: DiscoveryTypeStatic,
+		},
+		"static headless": {
+			svc:  service("STATIC", headless),
+			want: "",
+		},
+		"static external name": {
+			svc:  service("STATIC", externalName),
+			want: "",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseDiscoveryType(tc.svc)
+			if got != tc.want {
+				t.Fatalf("got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseDNSLookupFamily(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		want        string
+	}{
+		"no annotation": {
+			annotations: nil,
+			want:        "",
+		},
+		"v4": {
+			annotations: map[string]string{annotationDNSLookupFamily: "v4"},
+			want:        DNSLookupFamilyV4,
+		},
+		"v6, mixed case": {
+			annotations: map[string]string{annotationDNSLookupFamily: " V6 "},
+			want:        DNSLookupFamilyV6,
+		},
+		"auto": {
+			annotations: map[string]string{annotationDNSLookupFamily: "auto"},
+			want:        DNSLookupFamilyAuto,
+		},
+		"unknown": {
+			annotations: map[string]string{annotationDNSLookupFamily: "V4_ONLY"},
+			want:        "",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseDNSLookupFamily(tc.annotations)
+			if got != tc.want {
+				t.Fatalf("got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -16,11 +16,17 @@
 package dag
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
@@ -39,12 +45,56 @@ type KubernetesCache struct {
 	// namespace.
 	IngressRouteRootNamespaces []string
 
+	// DefaultResponse, if not nil, configures the catch-all virtual
+	// host for requests to hosts which no Ingress or IngressRoute claims.
+	DefaultResponse *DefaultResponse
+
+	// DefaultBackendNamespace, if not empty, is the only namespace
+	// whose Ingresses and IngressRoutes may create the "*" virtual
+	// host, eg. with a default backend.
+	DefaultBackendNamespace string
+
+	// DisableDefaultBackend, if true, prevents any Ingress or
+	// IngressRoute creating the "*" virtual host.
+	DisableDefaultBackend bool
+
+	// ForeignAnnotations, if true, honours the annotations of other
+	// ingress controllers which Contour can translate, to ease
+	// migration to Contour.
+	ForeignAnnotations bool
+
+	// InternalListener, if true, means Envoy has an internal HTTP
+	// listener to serve the virtual hosts of internal visibility.
+	// Otherwise those virtual hosts are not built.
+	InternalListener bool
+
+	// MaxRetryBufferBytes is the largest retryBuffer an IngressRoute
+	// route may request. If not set, DefaultMaxRetryBufferBytes applies.
+	MaxRetryBufferBytes uint32
+
 	mu sync.RWMutex
 
 	ingresses     map[meta]*v1beta1.Ingress
 	ingressroutes map[meta]*ingressroutev1.IngressRoute
 	secrets       map[meta]*v1.Secret
 	services      map[meta]*v1.Service
+
+	// ready records the services whose Endpoints have at least
+	// one ready address. Only their readiness is kept, Endpoints
+	// are translated by the EndpointsTranslator.
+	ready map[meta]bool
+}
+
+// DefaultResponse describes the response to requests for hosts which no
+// Ingress or IngressRoute claims.
+type DefaultResponse struct {
+	// Status, if non zero, is the HTTP status Envoy responds with directly.
+	Status int
+
+	// Otherwise, requests are routed to this service and port.
+	Namespace string
+	Name      string
+	Port      intstr.IntOrString
 }
 
 // meta holds the name and namespace of a Kubernetes object.
@@ -58,6 +108,32 @@ const (
 	StatusOrphaned = "orphaned"
 )
 
+// IngressRoute route match types.
+const (
+	matchTypePrefix = "Prefix"
+	matchTypeRegex  = "Regex"
+)
+
+// Visibilities of an IngressRoute's virtual host.
+const (
+	visibilityPublic   = "public"
+	visibilityInternal = "internal"
+)
+
+// HTTP filters which may be configured per virtual host or route.
+const (
+	filterExtAuthz  = "envoy.ext_authz"
+	filterRateLimit = "envoy.rate_limit"
+)
+
+// DefaultMaxRetryBufferBytes is the largest retryBuffer an IngressRoute
+// route may request unless KubernetesCache.MaxRetryBufferBytes is set.
+const DefaultMaxRetryBufferBytes = 1 << 20
+
+// maxRingSize is the largest hash ring Envoy will build for the
+// RingHash load balancer.
+const maxRingSize = 8388608
+
 // Insert inserts obj into the KubernetesCache.
 // If an object with a matching type, name, and namespace exists, it will be overwritten.
 func (kc *KubernetesCache) Insert(obj interface{}) {
@@ -88,6 +164,16 @@ func (kc *KubernetesCache) Insert(obj interface{}) {
 			kc.ingressroutes = make(map[meta]*ingressroutev1.IngressRoute)
 		}
 		kc.ingressroutes[m] = obj
+	case *v1.Endpoints:
+		m := meta{name: obj.Name, namespace: obj.Namespace}
+		if !HasReadyAddresses(obj) {
+			delete(kc.ready, m)
+			return
+		}
+		if kc.ready == nil {
+			kc.ready = make(map[meta]bool)
+		}
+		kc.ready[m] = true
 	default:
 		// not an interesting object
 	}
@@ -120,11 +206,24 @@ func (kc *KubernetesCache) remove(obj interface{}) {
 	case *ingressroutev1.IngressRoute:
 		m := meta{name: obj.Name, namespace: obj.Namespace}
 		delete(kc.ingressroutes, m)
+	case *v1.Endpoints:
+		m := meta{name: obj.Name, namespace: obj.Namespace}
+		delete(kc.ready, m)
 	default:
 		// not interesting
 	}
 }
 
+// HasReadyAddresses returns true if ep has at least one ready address.
+func HasReadyAddresses(ep *v1.Endpoints) bool {
+	for _, s := range ep.Subsets {
+		if len(s.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // A Builder builds a *DAGs
 type Builder struct {
 	KubernetesCache
@@ -148,31 +247,111 @@ type builder struct {
 
 	orphaned map[meta]bool
 
-	statuses []Status
+	// checked records the services whose upstream protocol
+	// annotations have been checked against their ports.
+	checked map[meta]bool
+
+	// depths records the depth in its delegation chain of the
+	// IngressRoute which contributed each route, the root being 1.
+	depths map[*Route]int
+
+	statuses        []Status
+	warnings        []Warning
+	serviceWarnings []ServiceWarning
 }
 
 // lookupService returns a Service that matches the meta and port supplied.
 // If no matching Service is found lookup returns nil.
 func (b *builder) lookupService(m meta, port intstr.IntOrString) *Service {
-	if port.Type == intstr.Int {
-		if s, ok := b.services[portmeta{name: m.name, namespace: m.namespace, port: int32(port.IntValue())}]; ok {
-			return s
-		}
+	s, _ := b.resolveService(m, port)
+	return s
+}
+
+// lookupIngressBackend returns the Service named by backend, a backend
+// of ing. If the backend is in another namespace, named by annotation,
+// the Service there must permit ing's namespace else nil is returned.
+func (b *builder) lookupIngressBackend(ing *v1beta1.Ingress, backend *v1beta1.IngressBackend) *Service {
+	m := meta{name: backend.ServiceName, namespace: backendNamespace(ing, backend.ServiceName)}
+	if svc, ok := b.source.services[m]; !ok || !allowsIngressFrom(svc, ing.Namespace) {
+		return nil
+	}
+	s, err := b.resolveService(m, backend.ServicePort)
+	if err != nil {
+		// routed to as if the service were missing.
+		b.setWarning(Warning{Object: ing, Reason: "InvalidBackendPort", Message: fmt.Sprintf("service %q: %v", backend.ServiceName, err)})
 	}
+	return s
+}
+
+// resolveService returns a Service that matches the meta and port supplied.
+// If the Service does not exist resolveService returns nil, nil. If it does
+// exist, but port does not resolve to exactly one of its ports,
+// resolveService returns nil and an error describing why. Services are
+// cached by port number, so references to a port by name and by number
+// share one Service, and so one cluster with the same settings.
+func (b *builder) resolveService(m meta, port intstr.IntOrString) (*Service, error) {
 	svc, ok := b.source.services[m]
 	if !ok {
-		return nil
+		return nil, nil
+	}
+	p, err := servicePort(svc, port)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := b.services[portmeta{name: m.name, namespace: m.namespace, port: p.Port}]; ok {
+		return s, nil
 	}
+	return b.addService(svc, p), nil
+}
+
+// servicePort returns the port of svc which port names or numbers.
+// A numeric port must match exactly one of svc's ports, UDP ports are
+// ignored as Envoy cannot proxy them, so a port name may not name one.
+func servicePort(svc *v1.Service, port intstr.IntOrString) (*v1.ServicePort, error) {
+	if !hasTCPPort(svc) {
+		// eg. a UDP only service, or one being edited.
+		return nil, fmt.Errorf("no TCP ports")
+	}
+	if port.Type == intstr.String {
+		for i := range svc.Spec.Ports {
+			if svc.Spec.Ports[i].Name == port.StrVal {
+				if svc.Spec.Ports[i].Protocol == v1.ProtocolUDP {
+					return nil, fmt.Errorf("port %q is UDP", port.StrVal)
+				}
+				return &svc.Spec.Ports[i], nil
+			}
+		}
+		n, err := strconv.Atoi(port.StrVal)
+		if err != nil {
+			return nil, fmt.Errorf("port %q not found", port.StrVal)
+		}
+		port = intstr.FromInt(n)
+	}
+	var found *v1.ServicePort
 	for i := range svc.Spec.Ports {
 		p := &svc.Spec.Ports[i]
-		if int(p.Port) == port.IntValue() {
-			return b.addService(svc, p)
+		if p.Port != port.IntVal || p.Protocol == v1.ProtocolUDP {
+			continue
 		}
-		if port.String() == p.Name {
-			return b.addService(svc, p)
+		if found != nil {
+			return nil, fmt.Errorf("port %d is ambiguous, it matches ports %q and %q", port.IntVal, found.Name, p.Name)
 		}
+		found = p
 	}
-	return nil
+	if found == nil {
+		return nil, fmt.Errorf("port %d not found", port.IntVal)
+	}
+	return found, nil
+}
+
+// hasTCPPort returns true if svc has a port Envoy can proxy.
+func hasTCPPort(svc *v1.Service) bool {
+	for _, p := range svc.Spec.Ports {
+		if p.Protocol != v1.ProtocolUDP {
+			return true
+		}
+	}
+	return false
 }
 
 func (b *builder) addService(svc *v1.Service, port *v1.ServicePort) *Service {
@@ -180,6 +359,7 @@ func (b *builder) addService(svc *v1.Service, port *v1.ServicePort) *Service {
 		b.services = make(map[portmeta]*Service)
 	}
 	up := parseUpstreamProtocols(svc.Annotations, annotationUpstreamProtocol, "h2", "h2c")
+	b.checkUpstreamProtocols(svc, up)
 	protocol := up[port.Name]
 	if protocol == "" {
 		protocol = up[strconv.Itoa(int(port.Port))]
@@ -194,11 +374,62 @@ func (b *builder) addService(svc *v1.Service, port *v1.ServicePort) *Service {
 		MaxPendingRequests: parseAnnotation(svc.Annotations, annotationMaxPendingRequests),
 		MaxRequests:        parseAnnotation(svc.Annotations, annotationMaxRequests),
 		MaxRetries:         parseAnnotation(svc.Annotations, annotationMaxRetries),
+
+		DiscoveryType:   parseDiscoveryType(svc),
+		DNSLookupFamily: parseDNSLookupFamily(svc.Annotations),
+		DNSRefreshRate:  parsePositiveDuration(svc.Annotations, annotationDNSRefreshRate),
+
+		ConnectTimeout:        parsePositiveDuration(svc.Annotations, annotationUpstreamConnectTimeout),
+		IdleTimeout:           parseAnnotationTimeout(svc.Annotations, annotationUpstreamIdleTimeout),
+		MaxConnectionDuration: parseAnnotationTimeout(svc.Annotations, annotationUpstreamMaxConnectionDuration),
+	}
+	if protocol == "h2" || protocol == "h2c" {
+		parseHTTP2Settings(svc.Annotations, s)
 	}
 	b.services[s.toMeta()] = s
 	return s
 }
 
+// checkUpstreamProtocols warns, once per build, of each port named by
+// svc's upstream protocol annotations, up, which svc does not have.
+// Such a port is ignored; left unreported a typo in the annotation
+// leaves the port speaking HTTP/1.1 with nothing pointing at why.
+func (b *builder) checkUpstreamProtocols(svc *v1.Service, up map[string]string) {
+	m := meta{name: svc.Name, namespace: svc.Namespace}
+	if b.checked[m] {
+		return
+	}
+	if b.checked == nil {
+		b.checked = make(map[meta]bool)
+	}
+	b.checked[m] = true
+
+	var missing []string
+	for port := range up {
+		if !hasPort(svc, port) {
+			missing = append(missing, port)
+		}
+	}
+	sort.Strings(missing)
+	for _, port := range missing {
+		b.setServiceWarning(ServiceWarning{
+			Object:  svc,
+			Reason:  "UpstreamProtocolPortNotFound",
+			Message: fmt.Sprintf("%s.%s: port %q not found, annotation ignored", annotationUpstreamProtocol, up[port], port),
+		})
+	}
+}
+
+// hasPort returns true if svc has a port whose name, or number, is port.
+func hasPort(svc *v1.Service, port string) bool {
+	for _, p := range svc.Spec.Ports {
+		if p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *builder) lookupSecret(m meta) *Secret {
 	if s, ok := b.secrets[m]; ok {
 		return s
@@ -217,6 +448,43 @@ func (b *builder) lookupSecret(m meta) *Secret {
 	return s
 }
 
+// lookupTLSSecrets returns the primary and, if requested, secondary
+// TLS secrets in namespace. Secrets which are missing, or lack a
+// certificate or key, are skipped; if only one of the pair is usable
+// it is returned as the primary along with a warning describing
+// the secret that was dropped. If neither is usable the primary is nil.
+func (b *builder) lookupTLSSecrets(namespace, primary, secondary string) (*Secret, *Secret, string) {
+	sec := b.lookupTLSSecret(meta{name: primary, namespace: namespace})
+	if secondary == "" {
+		return sec, nil, ""
+	}
+	sec2 := b.lookupTLSSecret(meta{name: secondary, namespace: namespace})
+	switch {
+	case sec != nil && sec2 != nil:
+		return sec, sec2, ""
+	case sec != nil:
+		return sec, nil, fmt.Sprintf("secondary secret %q is missing or invalid, serving a single certificate", secondary)
+	case sec2 != nil:
+		return sec2, nil, fmt.Sprintf("secret %q is missing or invalid, serving a single certificate", primary)
+	default:
+		return nil, nil, ""
+	}
+}
+
+// lookupTLSSecret returns the Secret matching m if it contains
+// both a certificate and a private key.
+func (b *builder) lookupTLSSecret(m meta) *Secret {
+	sec := b.lookupSecret(m)
+	if sec == nil {
+		return nil
+	}
+	data := sec.Data()
+	if len(data[v1.TLSCertKey]) == 0 || len(data[v1.TLSPrivateKeyKey]) == 0 {
+		return nil
+	}
+	return sec
+}
+
 func (b *builder) lookupVirtualHost(host string, port int, aliases ...string) *VirtualHost {
 	hp := hostport{host: host, port: port}
 	vh, ok := b.vhosts[hp]
@@ -253,6 +521,114 @@ func (b *builder) lookupSecureVirtualHost(host string, port int, aliases ...stri
 	return svh
 }
 
+// healthcheckvariant returns the Variant of a Service whose health
+// check is overridden by hc. It is derived from the contents of hc so
+// that routes with the same override share a cluster.
+func healthcheckvariant(hc *ingressroutev1.HealthCheck) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%+v", *hc)))
+	return fmt.Sprintf("hc-%x", sum[:4])
+}
+
+// ringsizevariant returns the Variant of a Service, of the variant v,
+// whose hash ring has at least min entries.
+func ringsizevariant(v string, min int64) string {
+	rv := fmt.Sprintf("ring-%d", min)
+	if v == "" {
+		return rv
+	}
+	return v + "-" + rv
+}
+
+// ingresses returns the Ingress objects of the cache ordered by namespace
+// and name, so that conflicts between them are resolved the same way by
+// every compute.
+func (b *builder) ingresses() []*v1beta1.Ingress {
+	ingresses := make([]*v1beta1.Ingress, 0, len(b.source.ingresses))
+	for _, ing := range b.source.ingresses {
+		ingresses = append(ingresses, ing)
+	}
+	sort.Slice(ingresses, func(i, j int) bool {
+		if ingresses[i].Namespace != ingresses[j].Namespace {
+			return ingresses[i].Namespace < ingresses[j].Namespace
+		}
+		return ingresses[i].Name < ingresses[j].Name
+	})
+	return ingresses
+}
+
+// validIngresses returns the ingresses which may be processed. An
+// Ingress which would create the "*" virtual host from a namespace
+// not allowed to is skipped, with a warning.
+func (b *builder) validIngresses() []*v1beta1.Ingress {
+	var valid []*v1beta1.Ingress
+	for _, ing := range b.ingresses() {
+		if createsWildcard(ing) && !b.wildcardAllowed(ing.Namespace) {
+			b.setWarning(Warning{Object: ing, Reason: "DefaultBackendNotAllowed", Message: b.wildcardDenied()})
+			continue
+		}
+		valid = append(valid, ing)
+	}
+	return valid
+}
+
+// maxRequestBytes returns the request body size limit of ing's routes.
+// contour.heptio.com/max-request-bytes wins over the foreign
+// ingress.kubernetes.io/proxy-body-size, an unparseable value of
+// which is reported and means no limit.
+func (b *builder) maxRequestBytes(ing *v1beta1.Ingress) uint32 {
+	if _, ok := ing.Annotations[annotationMaxRequestBytes]; ok || !b.source.ForeignAnnotations {
+		return uint32(parseAnnotationUInt32(ing.Annotations, annotationMaxRequestBytes).GetValue())
+	}
+	size, ok := ing.Annotations[annotationProxyBodySize]
+	if !ok {
+		return 0
+	}
+	n, err := parseSize(size)
+	if err != nil {
+		b.setWarning(Warning{Object: ing, Reason: "InvalidProxyBodySize", Message: fmt.Sprintf("%s: %v, no limit applied", annotationProxyBodySize, err)})
+		return 0
+	}
+	return n
+}
+
+// createsWildcard returns true if ing creates the "*" virtual host.
+func createsWildcard(ing *v1beta1.Ingress) bool {
+	if ing.Spec.Backend != nil {
+		return true
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardAllowed returns true if objects in namespace may create
+// the "*" virtual host.
+func (b *builder) wildcardAllowed(namespace string) bool {
+	if b.source.DisableDefaultBackend {
+		return false
+	}
+	return b.source.DefaultBackendNamespace == "" || b.source.DefaultBackendNamespace == namespace
+}
+
+// maxRetryBufferBytes returns the largest retryBuffer a route may request.
+func (b *builder) maxRetryBufferBytes() uint32 {
+	if b.source.MaxRetryBufferBytes > 0 {
+		return b.source.MaxRetryBufferBytes
+	}
+	return DefaultMaxRetryBufferBytes
+}
+
+// wildcardDenied describes why the "*" virtual host was not created.
+func (b *builder) wildcardDenied() string {
+	if b.source.DisableDefaultBackend {
+		return `the "*" virtual host is disabled`
+	}
+	return fmt.Sprintf(`the "*" virtual host may only be created in namespace %q`, b.source.DefaultBackendNamespace)
+}
+
 type hostport struct {
 	host string
 	port int
@@ -262,16 +638,22 @@ func (b *builder) compute() *DAG {
 	b.source.KubernetesCache.mu.RLock() // blocks mutation of the underlying cache until compute is done.
 	defer b.source.KubernetesCache.mu.RUnlock()
 
+	ingresses := b.validIngresses()
+
 	// setup secure vhosts if there is a matching secret
 	// we do this first so that the set of active secure vhosts is stable
-	// during the second ingress pass
-	for _, ing := range b.source.ingresses {
+	// during the second ingress pass. Each host is bound to the secret
+	// of its own TLS block, and the listener gives each secure vhost a
+	// filter chain of its own, so the blocks of one ingress may name
+	// different secrets.
+	for _, ing := range ingresses {
 		for _, tls := range ing.Spec.TLS {
-			m := meta{name: tls.SecretName, namespace: ing.Namespace}
-			if sec := b.lookupSecret(m); sec != nil {
+			sec, secondary, _ := b.lookupTLSSecrets(ing.Namespace, tls.SecretName, ing.Annotations[annotationSecondaryTLSSecret])
+			if sec != nil {
 				for _, host := range tls.Hosts {
 					svhost := b.lookupSecureVirtualHost(host, 443)
 					svhost.secret = sec
+					svhost.secondary = secondary
 					// process annotations
 					switch ing.ObjectMeta.Annotations["contour.heptio.com/tls-minimum-protocol-version"] {
 					case "1.3":
@@ -288,74 +670,99 @@ func (b *builder) compute() *DAG {
 	}
 
 	// deconstruct each ingress into routes and virtualhost entries
-	for _, ing := range b.source.ingresses {
+	for _, ing := range ingresses {
 		// should we create port 80 routes for this ingress
 		httpAllowed := httpAllowed(ing)
 
 		// compute websocket enabled routes
 		wr := websocketRoutes(ing)
+		if httpAllowed && tlsRequired(ing) && len(wr) > 0 {
+			// the redirect wins over the websocket upgrade on port
+			// 80, so only wss:// clients can reach these routes.
+			b.setWarning(Warning{Object: ing, Reason: "WebsocketRedirected", Message: "websocket routes redirect to HTTPS, ws:// clients must use wss://"})
+		}
 
 		// compute timeout for any routes on this ingress
 		timeout := parseAnnotationTimeout(ing.Annotations, annotationRequestTimeout)
+		maxGRPCTimeout := parseAnnotationTimeout(ing.Annotations, annotationMaxGRPCTimeout)
+
+		// compute the request body size limit for any routes on this ingress
+		maxRequestBytes := b.maxRequestBytes(ing)
 
 		if ing.Spec.Backend != nil {
 			// handle the annoying default ingress
 			r := &Route{
-				path:         "/",
-				Object:       ing,
-				HTTPSUpgrade: tlsRequired(ing),
-				Websocket:    wr["/"],
-				Timeout:      timeout,
-			}
-			m := meta{name: ing.Spec.Backend.ServiceName, namespace: ing.Namespace}
-			if s := b.lookupService(m, ing.Spec.Backend.ServicePort); s != nil {
+				path:            "/",
+				Object:          ing,
+				HTTPSUpgrade:    tlsRequired(ing),
+				Websocket:       wr["/"],
+				Timeout:         timeout,
+				MaxGRPCTimeout:  maxGRPCTimeout,
+				MaxRequestBytes: maxRequestBytes,
+			}
+			if s := b.lookupIngressBackend(ing, ing.Spec.Backend); s != nil {
 				r.addService(s, nil, "", 0)
 			}
 			if httpAllowed {
-				b.lookupVirtualHost("*", 80).routes[r.path] = r
+				b.lookupVirtualHost("*", 80).routes[r.key()] = r
 			}
 		}
 
-		for _, rule := range ing.Spec.Rules {
+		for i, rule := range ing.Spec.Rules {
 			// handle Spec.Rule declarations
 			host := rule.Host
 			if host == "" {
 				host = "default-backend.kirkcloud.com"
 			}
+			if rule.IngressRuleValue.HTTP == nil {
+				b.setWarning(Warning{Object: ing, Reason: "MissingHTTPRule", Message: fmt.Sprintf("spec.rules[%d]: no http block, rule skipped", i)})
+			}
 			for _, httppath := range httppaths(rule) {
+				// TODO every Ingress path is matched as a prefix.
+				// Honouring HTTPIngressPath.PathType, Exact as a path
+				// match and Prefix or ImplementationSpecific as now,
+				// needs the networking/v1beta1 Ingress of Kubernetes
+				// 1.18, which the extensions/v1beta1 Ingress lacks.
 				path := httppath.Path
 				if path == "" {
 					path = "/"
 				}
 				r := &Route{
-					path:         path,
-					Object:       ing,
-					HTTPSUpgrade: tlsRequired(ing),
-					Websocket:    wr[path],
-					Timeout:      timeout,
+					path:            path,
+					Object:          ing,
+					HTTPSUpgrade:    tlsRequired(ing),
+					Websocket:       wr[path],
+					Timeout:         timeout,
+					MaxGRPCTimeout:  maxGRPCTimeout,
+					MaxRequestBytes: maxRequestBytes,
 				}
 
-				m := meta{name: httppath.Backend.ServiceName, namespace: ing.Namespace}
-				if s := b.lookupService(m, httppath.Backend.ServicePort); s != nil {
+				if s := b.lookupIngressBackend(ing, &httppath.Backend); s != nil {
 					r.addService(s, nil, "", s.Weight)
 				}
 				if httpAllowed {
-					b.lookupVirtualHost(host, 80).routes[r.path] = r
+					b.lookupVirtualHost(host, 80).routes[r.key()] = r
 				}
 				if _, ok := b.svhosts[hostport{host: host, port: 443}]; ok && host != "*" {
-					b.lookupSecureVirtualHost(host, 443).routes[r.path] = r
+					b.lookupSecureVirtualHost(host, 443).routes[r.key()] = r
 				}
 			}
+			b.excludeAccessLogPaths(host, parseAccessLogExcludePaths(ing.Annotations))
+			b.sampleAccessLog(host, parseAnnotationInRange(ing.Annotations, annotationAccessLogSample, 1, accessLogSampleRateMax))
 		}
 	}
 
 	// process ingressroute documents
-	for _, ir := range b.validIngressRoutes() {
+	irs := b.validIngressRoutes()
+	for _, ir := range irs {
+		if ir.Spec.VirtualHost == nil {
+			// delegate ingress route. mark as orphaned until a root
+			// delegates to it, whatever order the roots are processed in.
+			b.setOrphaned(ir.Name, ir.Namespace)
+		}
+	}
+	for _, ir := range irs {
 		if ir.Spec.VirtualHost == nil {
-			// delegate ingress route. mark as orphaned if we haven't reached it before.
-			if !b.orphaned[meta{name: ir.Name, namespace: ir.Namespace}] {
-				b.setOrphaned(ir.Name, ir.Namespace)
-			}
 			continue
 		}
 
@@ -370,32 +777,219 @@ func (b *builder) compute() *DAG {
 			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: "Spec.VirtualHost.Fqdn must be specified"})
 			continue
 		}
+		if host == "*" && !b.wildcardAllowed(ir.Namespace) {
+			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: b.wildcardDenied(), Vhost: host})
+			continue
+		}
+		if host == "*" && ir.Spec.VirtualHost.TLS != nil {
+			// SNI matching requires a concrete server name.
+			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: "Spec.VirtualHost.Fqdn must not be a wildcard when tls is enabled", Vhost: host})
+			continue
+		}
+
+		if err := validateFilterConfig(ir.Spec.VirtualHost.PerFilterConfig); err != nil {
+			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("perFilterConfig: %v", err), Vhost: host})
+			continue
+		}
 
+		if err := validateCorsPolicy(ir.Spec.VirtualHost.CorsPolicy); err != nil {
+			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("corsPolicy: %v", err), Vhost: host})
+			continue
+		}
+
+		internal := false
+		switch ir.Spec.VirtualHost.Visibility {
+		case "", visibilityPublic:
+		case visibilityInternal:
+			if ir.Spec.VirtualHost.TLS != nil {
+				b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: "visibility: internal virtual hosts do not support tls", Vhost: host})
+				continue
+			}
+			internal = true
+		default:
+			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("visibility %q must be one of %s or %s", ir.Spec.VirtualHost.Visibility, visibilityPublic, visibilityInternal), Vhost: host})
+			continue
+		}
+
+		if ir.Spec.TCPProxy != nil {
+			b.processTCPProxy(ir, host)
+			continue
+		}
+
+		if tls := ir.Spec.VirtualHost.TLS; tls != nil && tls.Passthrough {
+			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: "tls: passthrough requires tcpproxy", Vhost: host})
+			continue
+		}
+
+		var warning string
 		if tls := ir.Spec.VirtualHost.TLS; tls != nil {
 			// attach secrets to TLS enabled vhosts
-			m := meta{name: tls.SecretName, namespace: ir.Namespace}
-			if sec := b.lookupSecret(m); sec != nil {
+			var sec, secondary *Secret
+			sec, secondary, warning = b.lookupTLSSecrets(ir.Namespace, tls.SecretName, tls.SecondarySecretName)
+			if sec != nil {
 				svhost := b.lookupSecureVirtualHost(host, 443, ir.Spec.VirtualHost.Aliases...)
 				svhost.secret = sec
-				// process min protocol version
-				switch ir.Spec.VirtualHost.TLS.MinimumProtocolVersion {
-				case "1.3":
-					svhost.MinProtoVersion = auth.TlsParameters_TLSv1_3
-				case "1.2":
-					svhost.MinProtoVersion = auth.TlsParameters_TLSv1_2
-				default:
-					// any other value is interpreted as TLS/1.1
-					svhost.MinProtoVersion = auth.TlsParameters_TLSv1_1
-				}
+				svhost.secondary = secondary
+				svhost.MinProtoVersion = minProtoVersion(tls.MinimumProtocolVersion)
 			}
 		}
 
 		b.processIngressRoute(ir, "", nil, host, ir.Spec.VirtualHost.Aliases)
+
+		if internal && !b.source.InternalListener {
+			// internal virtual hosts must not be served publicly,
+			// so without an internal listener they are not served
+			// at all. They have no tls, so no tls warning either.
+			delete(b.vhosts, hostport{host: host, port: 80})
+			warning = "visibility: internal, but there is no internal listener, virtual host not served"
+		}
+
+		if warning != "" {
+			// a degraded TLS configuration does not invalidate the
+			// IngressRoute, but should be visible in its status.
+			for i := range b.statuses {
+				if b.statuses[i].Object == ir && b.statuses[i].Status == StatusValid {
+					b.statuses[i].Description += ", " + warning
+				}
+			}
+		}
+
+		if internal && b.source.InternalListener {
+			// delegated IngressRoutes share the root's virtual
+			// host, so follow its visibility.
+			if vh, ok := b.vhosts[hostport{host: host, port: 80}]; ok {
+				vh.Internal = true
+			}
+		}
+
+		if pfc := ir.Spec.VirtualHost.PerFilterConfig; len(pfc) > 0 {
+			if vh, ok := b.vhosts[hostport{host: host, port: 80}]; ok {
+				vh.PerFilterConfig = pfc
+			}
+			if svh, ok := b.svhosts[hostport{host: host, port: 443}]; ok {
+				svh.PerFilterConfig = pfc
+			}
+		}
+
+		if cp := ir.Spec.VirtualHost.CorsPolicy; cp != nil {
+			if vh, ok := b.vhosts[hostport{host: host, port: 80}]; ok {
+				vh.CorsPolicy = cp
+			}
+			if svh, ok := b.svhosts[hostport{host: host, port: 443}]; ok {
+				svh.CorsPolicy = cp
+			}
+		}
+
+		if fh := ir.Spec.VirtualHost.ForwardingHeaders; fh != nil {
+			if vh, ok := b.vhosts[hostport{host: host, port: 80}]; ok {
+				vh.ForwardingHeaders = fh
+			}
+			if svh, ok := b.svhosts[hostport{host: host, port: 443}]; ok {
+				svh.ForwardingHeaders = fh
+			}
+		}
+
+		if ir.Spec.VirtualHost.CaseInsensitive {
+			if vh, ok := b.vhosts[hostport{host: host, port: 80}]; ok {
+				vh.CaseInsensitive = true
+			}
+			if svh, ok := b.svhosts[hostport{host: host, port: 443}]; ok {
+				svh.CaseInsensitive = true
+			}
+		}
+
+		b.excludeAccessLogPaths(host, parseAccessLogExcludePaths(ir.Annotations))
+		b.sampleAccessLog(host, parseAnnotationInRange(ir.Annotations, annotationAccessLogSample, 1, accessLogSampleRateMax))
 	}
 
+	b.computeDefaultResponse()
+
 	return b.DAG()
 }
 
+// excludeAccessLogPaths adds paths to the paths which are not access
+// logged for the virtual hosts of host, if they exist.
+func (b *builder) excludeAccessLogPaths(host string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	if vh, ok := b.vhosts[hostport{host: host, port: 80}]; ok {
+		vh.AccessLogExcludePaths = append(vh.AccessLogExcludePaths, paths...)
+	}
+	if svh, ok := b.svhosts[hostport{host: host, port: 443}]; ok {
+		svh.AccessLogExcludePaths = append(svh.AccessLogExcludePaths, paths...)
+	}
+}
+
+// sampleAccessLog sets the access log sample rate of the virtual hosts
+// of host, if they exist, unless rate is zero.
+func (b *builder) sampleAccessLog(host string, rate uint32) {
+	if rate == 0 {
+		return
+	}
+	if vh, ok := b.vhosts[hostport{host: host, port: 80}]; ok {
+		vh.AccessLogSampleRate = rate
+	}
+	if svh, ok := b.svhosts[hostport{host: host, port: 443}]; ok {
+		svh.AccessLogSampleRate = rate
+	}
+}
+
+// processTCPProxy proxies the TLS connections of the root IngressRoute
+// ir's virtual host, selected by SNI hostname, to its tcpproxy service.
+func (b *builder) processTCPProxy(ir *ingressroutev1.IngressRoute, host string) {
+	tls := ir.Spec.VirtualHost.TLS
+	if tls == nil {
+		b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: "tcpproxy: spec.virtualhost.tls must be specified", Vhost: host})
+		return
+	}
+	if len(ir.Spec.Routes) > 0 {
+		b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: "cannot specify both tcpproxy and routes", Vhost: host})
+		return
+	}
+	// the tcp_proxy filter of the Envoy API we target has no weighted clusters.
+	if len(ir.Spec.TCPProxy.Services) != 1 {
+		b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: "tcpproxy: exactly one service must be specified", Vhost: host})
+		return
+	}
+	s := ir.Spec.TCPProxy.Services[0]
+	if s.Port < 1 || s.Port > 65535 {
+		b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("tcpproxy: service %q: port must be in the range 1-65535", s.Name), Vhost: host})
+		return
+	}
+	svc, err := b.resolveService(meta{name: s.Name, namespace: ir.Namespace}, intstr.FromInt(s.Port))
+	if err != nil {
+		b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("tcpproxy: service %q: %v", s.Name, err), Vhost: host})
+		return
+	}
+
+	svhost := b.lookupSecureVirtualHost(host, 443, ir.Spec.VirtualHost.Aliases...)
+	if !tls.Passthrough {
+		svhost.secret = b.lookupTLSSecret(meta{name: tls.SecretName, namespace: ir.Namespace})
+		svhost.MinProtoVersion = minProtoVersion(tls.MinimumProtocolVersion)
+	}
+	if svc != nil {
+		svhost.TCPProxy = &TCPProxy{
+			Passthrough: tls.Passthrough,
+			Service:     svc,
+		}
+	}
+	b.setStatus(Status{Object: ir, Status: StatusValid, Description: "valid IngressRoute", Vhost: host})
+}
+
+// minProtoVersion returns the minimum TLS version named by v.
+func minProtoVersion(v string) auth.TlsParameters_TlsProtocol {
+	switch v {
+	case "1.3":
+		return auth.TlsParameters_TLSv1_3
+	case "1.2":
+		return auth.TlsParameters_TLSv1_2
+	default:
+		// any other value is interpreted as TLS/1.1
+		return auth.TlsParameters_TLSv1_1
+	}
+}
+
 // validIngressRoutes returns a slice of *ingressroutev1.IngressRoute objects.
 // invalid IngressRoute objects are excluded from the slice and a corresponding entry
 // added via setStatus.
@@ -428,9 +1022,206 @@ func (b *builder) validIngressRoutes() []*ingressroutev1.IngressRoute {
 			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: msg, Vhost: fqdn})
 		}
 	}
+	// order by namespace and name so that every compute processes
+	// the IngressRoutes which share a service in the same order.
+	sort.Slice(valid, func(i, j int) bool {
+		if valid[i].Namespace != valid[j].Namespace {
+			return valid[i].Namespace < valid[j].Namespace
+		}
+		return valid[i].Name < valid[j].Name
+	})
 	return valid
 }
 
+// validateFilterConfig returns an error if pfc names a HTTP filter which
+// cannot be configured per virtual host or route, or configures a filter
+// with a field it does not support.
+func validateFilterConfig(pfc map[string]ingressroutev1.FilterConfig) error {
+	names := make([]string, 0, len(pfc))
+	for name := range pfc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fc := pfc[name]
+		switch name {
+		case filterExtAuthz:
+			if fc.Stage != 0 {
+				return fmt.Errorf("filter %q does not support stage", name)
+			}
+		case filterRateLimit:
+			if fc.Stage < 0 || fc.Stage > 10 {
+				return fmt.Errorf("filter %q: stage must be in the range 0-10", name)
+			}
+		// TODO envoy.gzip, once it can be disabled per virtual host
+		// or route; see docs/deploy-options.md.
+		default:
+			return fmt.Errorf("unknown filter %q, must be one of %s or %s", name, filterExtAuthz, filterRateLimit)
+		}
+	}
+	return nil
+}
+
+// validateFallback returns an error if fb, which may be nil, does
+// not name exactly one of a service or a status.
+func validateFallback(fb *ingressroutev1.Fallback) error {
+	if fb == nil {
+		return nil
+	}
+	switch {
+	case fb.Service != nil && fb.Status != 0:
+		return fmt.Errorf("only one of service or status may be specified")
+	case fb.Service != nil:
+		if fb.Service.Port < 1 || fb.Service.Port > 65535 {
+			return fmt.Errorf("service %q: port must be in the range 1-65535", fb.Service.Name)
+		}
+	case fb.Status != 0:
+		if fb.Status < 200 || fb.Status > 599 {
+			return fmt.Errorf("status %d must be in the range 200-599", fb.Status)
+		}
+	default:
+		return fmt.Errorf("one of service or status must be specified")
+	}
+	return nil
+}
+
+// validateCorsPolicy returns an error if cp, which may be nil, does
+// not allow any origins or has an invalid maximum age.
+func validateCorsPolicy(cp *ingressroutev1.CorsPolicy) error {
+	if cp == nil {
+		return nil
+	}
+	if len(cp.AllowOrigin) == 0 {
+		return fmt.Errorf("allowOrigin must contain at least one origin")
+	}
+	if cp.MaxAge != "" {
+		d, err := time.ParseDuration(cp.MaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid maxAge: %v", err)
+		}
+		if d < 0 {
+			return fmt.Errorf("maxAge %q must not be negative", cp.MaxAge)
+		}
+	}
+	return nil
+}
+
+// validateRingSize returns an error if min, where zero means unset,
+// is outside the range Envoy accepts.
+func validateRingSize(min int64) error {
+	if min < 0 || min > maxRingSize {
+		return fmt.Errorf("minimumRingSize must be in the range 0-%d", maxRingSize)
+	}
+	return nil
+}
+
+// validateRegex returns an error if expr is not a regex which Envoy's
+// ECMAScript engine accepts. It is conservative: expr must compile as
+// a Go regex, which excludes backreferences and lookarounds, and must
+// not use the RE2 syntax which ECMAScript lacks.
+func validateRegex(expr string) error {
+	if _, err := regexp.Compile(expr); err != nil {
+		return err
+	}
+	inClass := false
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\':
+			// expr compiled, so the escape is complete.
+			i++
+			switch expr[i] {
+			case 'A', 'z', 'Q', 'E', 'p', 'P', 'C':
+				return fmt.Errorf("escape \\%c is not supported by Envoy", expr[i])
+			case 'x':
+				if strings.HasPrefix(expr[i+1:], "{") {
+					return fmt.Errorf("escape \\x{...} is not supported by Envoy")
+				}
+			}
+		case c == '[' && !inClass:
+			inClass = true
+			// a ] first in the class is a literal.
+			if strings.HasPrefix(expr[i+1:], "^") {
+				i++
+			}
+			if strings.HasPrefix(expr[i+1:], "]") {
+				i++
+			}
+		case c == ']' && inClass:
+			inClass = false
+		case c == '(' && !inClass:
+			if strings.HasPrefix(expr[i+1:], "?") && !strings.HasPrefix(expr[i+1:], "?:") {
+				return fmt.Errorf("flags and named groups are not supported by Envoy")
+			}
+		}
+	}
+	return nil
+}
+
+// validateHeaderMatch returns an error if hm does not name a header
+// and exactly one valid way to match its value.
+func validateHeaderMatch(hm ingressroutev1.HeaderMatch) error {
+	if hm.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	n := 0
+	if hm.Exact != "" {
+		n++
+	}
+	if hm.Present {
+		n++
+	}
+	if hm.Regex != "" {
+		if err := validateRegex(hm.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %v", err)
+		}
+		n++
+	}
+	if hm.Range != nil {
+		if hm.Range.Start >= hm.Range.End {
+			return fmt.Errorf("range start %d must be less than end %d", hm.Range.Start, hm.Range.End)
+		}
+		n++
+	}
+	if n != 1 {
+		return fmt.Errorf("exactly one of exact, present, regex or range is required")
+	}
+	return nil
+}
+
+// computeDefaultResponse adds the catch-all route to the "*" virtual host,
+// if one is configured and no Ingress has already claimed it. There is no
+// fallback certificate to present for unclaimed hosts, so the catch-all is
+// only added for port 80.
+func (b *builder) computeDefaultResponse() {
+	dr := b.source.DefaultResponse
+	if dr == nil {
+		return
+	}
+	if vh, ok := b.vhosts[hostport{host: "*", port: 80}]; ok {
+		if _, ok := vh.routes[routeKey("/", false, false, nil)]; ok {
+			// an Ingress default backend takes precedence.
+			return
+		}
+	} else {
+		b.lookupVirtualHost("*", 80).Default = true
+	}
+	r := &Route{
+		path:           "/",
+		DirectResponse: dr.Status,
+	}
+	if r.DirectResponse == 0 {
+		m := meta{name: dr.Name, namespace: dr.Namespace}
+		if s := b.lookupService(m, dr.Port); s != nil {
+			r.addService(s, nil, "", 0)
+		} else {
+			// the default backend is missing, rather than drop
+			// the catch-all, tell the client it is unavailable.
+			r.DirectResponse = http.StatusServiceUnavailable
+		}
+	}
+	b.lookupVirtualHost("*", 80).routes[r.key()] = r
+}
+
 // DAG returns a *DAG representing the current state of this builder.
 func (b *builder) DAG() *DAG {
 	var dag DAG
@@ -438,7 +1229,8 @@ func (b *builder) DAG() *DAG {
 		dag.roots = append(dag.roots, vh)
 	}
 	for _, svh := range b.svhosts {
-		if svh.secret != nil {
+		// a passthrough vhost has no secret, Envoy does not terminate its TLS.
+		if svh.secret != nil || (svh.TCPProxy != nil && svh.TCPProxy.Passthrough) {
 			dag.roots = append(dag.roots, svh)
 		}
 	}
@@ -449,6 +1241,8 @@ func (b *builder) DAG() *DAG {
 		}
 	}
 	dag.statuses = b.statuses
+	dag.warnings = b.warnings
+	dag.serviceWarnings = b.serviceWarnings
 	return &dag
 }
 
@@ -457,6 +1251,16 @@ func (b *builder) setStatus(st Status) {
 	b.statuses = append(b.statuses, st)
 }
 
+// setWarning records a warning about an object.
+func (b *builder) setWarning(w Warning) {
+	b.warnings = append(b.warnings, w)
+}
+
+// setServiceWarning records a warning about a Service.
+func (b *builder) setServiceWarning(w ServiceWarning) {
+	b.serviceWarnings = append(b.serviceWarnings, w)
+}
+
 // setOrphaned marks namespace/name combination as orphaned.
 func (b *builder) setOrphaned(name, namespace string) {
 	if b.orphaned == nil {
@@ -465,6 +1269,16 @@ func (b *builder) setOrphaned(name, namespace string) {
 	b.orphaned[meta{name: name, namespace: namespace}] = true
 }
 
+// ready returns true if any of r's services has a ready endpoint.
+func (b *builder) ready(r *Route) bool {
+	for _, s := range r.services {
+		if b.source.ready[meta{name: s.Object.Name, namespace: s.Object.Namespace}] {
+			return true
+		}
+	}
+	return false
+}
+
 // rootAllowed returns true if the ingressroute lives in a permitted root namespace.
 func (b *builder) rootAllowed(ir *ingressroutev1.IngressRoute) bool {
 	if len(b.source.IngressRouteRootNamespaces) == 0 {
@@ -481,7 +1295,21 @@ func (b *builder) rootAllowed(ir *ingressroutev1.IngressRoute) bool {
 func (b *builder) processIngressRoute(ir *ingressroutev1.IngressRoute, prefixMatch string, visited []*ingressroutev1.IngressRoute, host string, aliases []string) {
 	visited = append(visited, ir)
 
+	// matches records the matches, with their match types, case
+	// sensitivity and header matches, seen so far so that a route
+	// cannot silently shadow an earlier route with the same match.
+	matches := make(map[string]bool)
+	// warnings records the attributes dropped from otherwise valid
+	// routes, which are reported in the IngressRoute's status.
+	var warnings []string
 	for _, route := range ir.Spec.Routes {
+		key := routeKey(route.Match, route.MatchType == matchTypeRegex, route.CaseSensitive != nil && !*route.CaseSensitive, route.Headers)
+		if matches[key] {
+			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: duplicate match", route.Match), Vhost: host})
+			return
+		}
+		matches[key] = true
+
 		// route cannot both delegate and point to services
 		if len(route.Services) > 0 && route.Delegate.Name != "" {
 			b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: cannot specify services and delegate in the same route", route.Match), Vhost: host})
@@ -494,9 +1322,122 @@ func (b *builder) processIngressRoute(ir *ingressroutev1.IngressRoute, prefixMat
 				return
 			}
 			r := &Route{
-				path:      route.Match,
-				Object:    ir,
-				Websocket: route.EnableWebsockets,
+				path:            route.Match,
+				Object:          ir,
+				Websocket:       route.EnableWebsockets,
+				CaseInsensitive: route.CaseSensitive != nil && !*route.CaseSensitive,
+			}
+			switch route.MatchType {
+			case "", matchTypePrefix:
+				// default, match on prefix
+			case matchTypeRegex:
+				if err := validateRegex(route.Match); err != nil {
+					// Envoy would reject the whole route configuration,
+					// so skip only this route and serve the rest.
+					warnings = append(warnings, fmt.Sprintf("route %q: invalid regex: %v, route skipped", route.Match, err))
+					continue
+				}
+				r.Regex = true
+			default:
+				b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: matchType %q must be one of %s or %s", route.Match, route.MatchType, matchTypePrefix, matchTypeRegex), Vhost: host})
+				return
+			}
+			for _, hm := range route.Headers {
+				if err := validateHeaderMatch(hm); err != nil {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: header %q: %v", route.Match, hm.Name, err), Vhost: host})
+					return
+				}
+			}
+			r.HeaderMatches = route.Headers
+			maxGRPCTimeout, err := parseTimeout(route.MaxGRPCTimeout)
+			if err != nil {
+				b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: invalid maxGrpcTimeout: %v", route.Match, err), Vhost: host})
+				return
+			}
+			r.MaxGRPCTimeout = maxGRPCTimeout
+			if err := validateFilterConfig(route.PerFilterConfig); err != nil {
+				b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: perFilterConfig: %v", route.Match, err), Vhost: host})
+				return
+			}
+			r.PerFilterConfig = route.PerFilterConfig
+			if err := validateCorsPolicy(route.CorsPolicy); err != nil {
+				b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: corsPolicy: %v", route.Match, err), Vhost: host})
+				return
+			}
+			r.CorsPolicy = route.CorsPolicy
+			if route.MaxRequestBytes < 0 || route.MaxRequestBytes > math.MaxUint32 {
+				b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: maxRequestBytes must be in the range 0-%d", route.Match, uint32(math.MaxUint32)), Vhost: host})
+				return
+			}
+			r.MaxRequestBytes = uint32(route.MaxRequestBytes)
+			if tp := route.TimeoutPolicy; tp != nil {
+				if r.Timeout, err = parseTimeout(tp.Request); err != nil {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: timeoutPolicy: invalid request timeout: %v", route.Match, err), Vhost: host})
+					return
+				}
+				if r.PerTryTimeout, err = parseTimeout(tp.PerTry); err != nil {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: timeoutPolicy: invalid perTry timeout: %v", route.Match, err), Vhost: host})
+					return
+				}
+				if r.Timeout > 0 && (r.PerTryTimeout == infiniteTimeout || r.PerTryTimeout > r.Timeout) {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: timeoutPolicy: perTry timeout %q must not exceed request timeout %q", route.Match, tp.PerTry, tp.Request), Vhost: host})
+					return
+				}
+				if route.EnableWebsockets && r.PerTryTimeout > 0 {
+					// an upgrade cannot be retried, so the websocket
+					// wins over the retry policy its perTry implies.
+					warnings = append(warnings, fmt.Sprintf("route %q: perTry timeout ignored, websocket upgrades are not retried", route.Match))
+					r.PerTryTimeout = 0
+				}
+			}
+			if rb := route.RetryBuffer; rb != nil {
+				if r.PerTryTimeout <= 0 {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: retryBuffer requires a retry policy, set a finite timeoutPolicy perTry timeout", route.Match), Vhost: host})
+					return
+				}
+				if max := b.maxRetryBufferBytes(); rb.MaxBytes < 1 || rb.MaxBytes > int64(max) {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: retryBuffer: maxBytes must be in the range 1-%d", route.Match, max), Vhost: host})
+					return
+				}
+				r.RetryBufferBytes = uint32(rb.MaxBytes)
+			}
+			for k := range route.MetadataMatch {
+				if k == "" {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: metadataMatch: keys must not be empty", route.Match), Vhost: host})
+					return
+				}
+			}
+			r.MetadataMatch = route.MetadataMatch
+			if wp := route.WebsocketPolicy; wp != nil {
+				if !route.EnableWebsockets {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: websocketPolicy requires enableWebsockets", route.Match), Vhost: host})
+					return
+				}
+				if r.WebsocketIdleTimeout, err = parseTimeout(wp.Idle); err != nil {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: websocketPolicy: invalid idle timeout: %v", route.Match, err), Vhost: host})
+					return
+				}
+				if r.WebsocketMaxConnectionDuration, err = parseTimeout(wp.MaxConnectionDuration); err != nil {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: websocketPolicy: invalid maxConnectionDuration: %v", route.Match, err), Vhost: host})
+					return
+				}
+				// both are emitted as the route's timeout, so only
+				// one of them may be finite.
+				if r.WebsocketMaxConnectionDuration != noTimeout && r.Timeout > 0 {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: websocketPolicy: maxConnectionDuration cannot be combined with a finite request timeout", route.Match), Vhost: host})
+					return
+				}
+			}
+			if d := route.Decorator; d != nil {
+				if d.Operation == "" {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: decorator: operation must be specified", route.Match), Vhost: host})
+					return
+				}
+				r.DecoratorOperation = d.Operation
+			}
+			if err := validateFallback(route.Fallback); err != nil {
+				b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: fallback: %v", route.Match, err), Vhost: host})
+				return
 			}
 			for _, s := range route.Services {
 				if s.Port < 1 || s.Port > 65535 {
@@ -507,16 +1448,71 @@ func (b *builder) processIngressRoute(ir *ingressroutev1.IngressRoute, prefixMat
 					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: service %q: weight must be greater than or equal to zero", route.Match, s.Name), Vhost: host})
 					return
 				}
+				if err := validateRingSize(s.MinimumRingSize); err != nil {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: service %q: %v", route.Match, s.Name, err), Vhost: host})
+					return
+				}
 				m := meta{name: s.Name, namespace: ir.Namespace}
-				if svc := b.lookupService(m, intstr.FromInt(s.Port)); svc != nil {
-					r.addService(svc, s.HealthCheck, s.Strategy, s.Weight)
+				svc, err := b.resolveService(m, intstr.FromInt(s.Port))
+				if err != nil {
+					b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: service %q: %v", route.Match, s.Name, err), Vhost: host})
+					return
+				}
+				if svc != nil {
+					hc := s.HealthCheck
+					if route.HealthCheck != nil && !reflect.DeepEqual(route.HealthCheck, hc) {
+						// the route overrides the service's health
+						// check, so it needs a cluster of its own.
+						v := *svc
+						v.Variant = healthcheckvariant(route.HealthCheck)
+						svc, hc = &v, route.HealthCheck
+					}
+					if s.Strategy == "RingHash" && s.MinimumRingSize > 0 {
+						// the ring size is a property of the cluster,
+						// which other routes to the service share.
+						v := *svc
+						v.Variant = ringsizevariant(svc.Variant, s.MinimumRingSize)
+						v.MinimumRingSize = uint64(s.MinimumRingSize)
+						svc = &v
+					}
+					r.addService(svc, hc, s.Strategy, s.Weight)
+					if len(s.RequestHeadersToAdd) > 0 {
+						if r.RequestHeadersToAdd == nil {
+							r.RequestHeadersToAdd = make(map[*Service]map[string]string)
+						}
+						r.RequestHeadersToAdd[svc] = s.RequestHeadersToAdd
+					}
 				}
 			}
-			b.lookupVirtualHost(host, 80, aliases...).routes[r.path] = r
-
-			if hst := b.lookupSecureVirtualHost(host, 443, aliases...); hst.secret != nil {
-				b.lookupSecureVirtualHost(host, 443, aliases...).routes[r.path] = r
+			if fb := route.Fallback; fb != nil && !b.ready(r) {
+				// none of the route's services can serve it.
+				r.services = nil
+				r.RequestHeadersToAdd = nil
+				if fb.Status != 0 {
+					r.DirectResponse = fb.Status
+				} else {
+					m := meta{name: fb.Service.Name, namespace: ir.Namespace}
+					svc, err := b.resolveService(m, intstr.FromInt(fb.Service.Port))
+					if err != nil {
+						b.setStatus(Status{Object: ir, Status: StatusInvalid, Description: fmt.Sprintf("route %q: fallback: service %q: %v", route.Match, fb.Service.Name, err), Vhost: host})
+						return
+					}
+					if svc != nil {
+						r.addService(svc, fb.Service.HealthCheck, fb.Service.Strategy, 0)
+					}
+				}
+			}
+			depth := len(visited)
+			if vh := b.lookupVirtualHost(host, 80, aliases...); b.claims(vh.routes[r.key()], ir, depth) {
+				vh.routes[r.key()] = r
+			}
+			if hst := b.lookupSecureVirtualHost(host, 443, aliases...); hst.secret != nil && b.claims(hst.routes[r.key()], ir, depth) {
+				hst.routes[r.key()] = r
 			}
+			if b.depths == nil {
+				b.depths = make(map[*Route]int)
+			}
+			b.depths[r] = depth
 			continue
 		}
 
@@ -554,7 +1550,34 @@ func (b *builder) processIngressRoute(ir *ingressroutev1.IngressRoute, prefixMat
 			b.processIngressRoute(dest, route.Match, visited, host, aliases)
 		}
 	}
-	b.setStatus(Status{Object: ir, Status: StatusValid, Description: "valid IngressRoute", Vhost: host})
+	description := "valid IngressRoute"
+	if len(warnings) > 0 {
+		description += ", " + strings.Join(warnings, ", ")
+	}
+	b.setStatus(Status{Object: ir, Status: StatusValid, Description: description, Vhost: host})
+}
+
+// claims reports whether a route of ir, at depth in its delegation
+// chain, replaces existing, a route with the same match contributed
+// earlier. The route of the IngressRoute nearest the root wins, then
+// that of the IngressRoute first by namespace and name, so the outcome
+// does not depend on the order the delegation tree is walked.
+func (b *builder) claims(existing *Route, ir *ingressroutev1.IngressRoute, depth int) bool {
+	if existing == nil {
+		return true
+	}
+	other, ok := existing.Object.(*ingressroutev1.IngressRoute)
+	if !ok {
+		// a route from an Ingress, which an IngressRoute replaces.
+		return true
+	}
+	if d := b.depths[existing]; d != depth {
+		return depth < d
+	}
+	if other.Namespace != ir.Namespace {
+		return ir.Namespace < other.Namespace
+	}
+	return ir.Name <= other.Name
 }
 
 // httppaths returns a slice of HTTPIngressPath values for a given IngressRule.
@@ -562,7 +1585,8 @@ func (b *builder) processIngressRoute(ir *ingressroutev1.IngressRoute, prefixMat
 // nil slice is returned.
 func httppaths(rule v1beta1.IngressRule) []v1beta1.HTTPIngressPath {
 	if rule.IngressRuleValue.HTTP == nil {
-		// rule.IngressRuleValue.HTTP value is optional.
+		// rule.IngressRuleValue.HTTP value is optional. Only this rule
+		// is skipped; the remaining rules of the Ingress still apply.
 		return nil
 	}
 	return rule.IngressRuleValue.HTTP.Paths
@@ -593,3 +1617,19 @@ type Status struct {
 	Description string
 	Vhost       string // SAS: Support `aliases` once merged
 }
+
+// A Warning reports an Ingress which was skipped, or part of which was
+// ignored, while building the DAG.
+type Warning struct {
+	Object  *v1beta1.Ingress
+	Reason  string
+	Message string
+}
+
+// A ServiceWarning reports an annotation of a Service which was
+// ignored while building the DAG.
+type ServiceWarning struct {
+	Object  *v1.Service
+	Reason  string
+	Message string
+}
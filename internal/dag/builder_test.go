@@ -479,6 +479,46 @@ func TestDAGInsert(t *testing.T) {
 		},
 	}
 
+	// i14 has a rule without an http block followed by a valid rule
+	i14 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nil-http",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{{
+				Host: "a.example.com",
+			}, {
+				Host:             "b.example.com",
+				IngressRuleValue: ingressrulevalue(backend("kuard", intstr.FromInt(8080))),
+			}},
+		},
+	}
+
+	// i15 has two TLS blocks, each binding its own host to its own secret
+	i15 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "two-secrets",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			TLS: []v1beta1.IngressTLS{{
+				Hosts:      []string{"a.example.com"},
+				SecretName: "secret",
+			}, {
+				Hosts:      []string{"b.example.com"},
+				SecretName: "secret-ecdsa",
+			}},
+			Rules: []v1beta1.IngressRule{{
+				Host:             "a.example.com",
+				IngressRuleValue: ingressrulevalue(backend("kuard", intstr.FromInt(8080))),
+			}, {
+				Host:             "b.example.com",
+				IngressRuleValue: ingressrulevalue(backend("kuard", intstr.FromInt(8080))),
+			}},
+		},
+	}
+
 	i3a := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "kuard",
@@ -819,6 +859,56 @@ func TestDAGInsert(t *testing.T) {
 		},
 	}
 
+	// ir12 has TLS with a secondary certificate
+	ir12 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example-com",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "foo.com",
+				TLS: &ingressroutev1.TLS{
+					SecretName:          "secret",
+					SecondarySecretName: "secret-ecdsa",
+				},
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir13 has two routes with the same match, the second is dropped
+	ir13 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example-com",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}, {
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard2",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
 	s5 := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "blog-admin",
@@ -924,6 +1014,14 @@ func TestDAGInsert(t *testing.T) {
 		Data: secretdata("certificate", "key"),
 	}
 
+	sec2 := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-ecdsa",
+			Namespace: "default",
+		},
+		Data: secretdata("ecdsa-certificate", "ecdsa-key"),
+	}
+
 	tests := map[string]struct {
 		objs []interface{}
 		want []Vertex
@@ -1243,6 +1341,51 @@ func TestDAGInsert(t *testing.T) {
 				},
 			},
 		},
+		"insert ingress w/ two tls blocks with different secrets": {
+			objs: []interface{}{
+				sec1,
+				sec2,
+				i15,
+			},
+			want: []Vertex{
+				&VirtualHost{
+					Port: 80,
+					host: "a.example.com",
+					routes: routemap(
+						route("/", i15),
+					),
+				},
+				&VirtualHost{
+					Port: 80,
+					host: "b.example.com",
+					routes: routemap(
+						route("/", i15),
+					),
+				},
+				&SecureVirtualHost{
+					Port:            443,
+					MinProtoVersion: auth.TlsParameters_TLSv1_1,
+					host:            "a.example.com",
+					routes: routemap(
+						route("/", i15),
+					),
+					secret: &Secret{
+						object: sec1,
+					},
+				},
+				&SecureVirtualHost{
+					Port:            443,
+					MinProtoVersion: auth.TlsParameters_TLSv1_1,
+					host:            "b.example.com",
+					routes: routemap(
+						route("/", i15),
+					),
+					secret: &Secret{
+						object: sec2,
+					},
+				},
+			},
+		},
 		"insert ingress w/ two vhosts": {
 			objs: []interface{}{
 				i6,
@@ -1264,6 +1407,20 @@ func TestDAGInsert(t *testing.T) {
 				},
 			},
 		},
+		"insert ingress w/ nil http rule followed by valid rule": {
+			objs: []interface{}{
+				i14,
+			},
+			want: []Vertex{
+				&VirtualHost{
+					Port: 80,
+					host: "b.example.com",
+					routes: routemap(
+						route("/", i14),
+					),
+				},
+			},
+		},
 		"insert ingress w/ two vhosts then matching service": {
 			objs: []interface{}{
 				i6,
@@ -1823,6 +1980,130 @@ func TestDAGInsert(t *testing.T) {
 					},
 				}},
 		},
+		"insert ingressroute with secondary secret": {
+			objs: []interface{}{
+				ir12, s1, sec1, sec2,
+			},
+			want: []Vertex{
+				&VirtualHost{
+					Port: 80,
+					host: "foo.com",
+					routes: routemap(
+						route("/", ir12, servicemap(
+							&Service{
+								Object:      s1,
+								ServicePort: &s1.Spec.Ports[0],
+							},
+						)),
+					),
+				},
+				&SecureVirtualHost{
+					Port:            443,
+					MinProtoVersion: auth.TlsParameters_TLSv1_1,
+					host:            "foo.com",
+					routes: routemap(
+						route("/", ir12, servicemap(
+							&Service{
+								Object:      s1,
+								ServicePort: &s1.Spec.Ports[0],
+							},
+						)),
+					),
+					secret: &Secret{
+						object: sec1,
+					},
+					secondary: &Secret{
+						object: sec2,
+					},
+				}},
+		},
+		"insert ingressroute with missing secondary secret": {
+			objs: []interface{}{
+				ir12, s1, sec1,
+			},
+			want: []Vertex{
+				&VirtualHost{
+					Port: 80,
+					host: "foo.com",
+					routes: routemap(
+						route("/", ir12, servicemap(
+							&Service{
+								Object:      s1,
+								ServicePort: &s1.Spec.Ports[0],
+							},
+						)),
+					),
+				},
+				&SecureVirtualHost{
+					Port:            443,
+					MinProtoVersion: auth.TlsParameters_TLSv1_1,
+					host:            "foo.com",
+					routes: routemap(
+						route("/", ir12, servicemap(
+							&Service{
+								Object:      s1,
+								ServicePort: &s1.Spec.Ports[0],
+							},
+						)),
+					),
+					secret: &Secret{
+						object: sec1,
+					},
+				}},
+		},
+		"insert ingressroute with duplicate route match": {
+			objs: []interface{}{
+				ir13, s1,
+			},
+			want: []Vertex{
+				&VirtualHost{
+					Port: 80,
+					host: "example.com",
+					routes: routemap(
+						route("/", ir13, servicemap(
+							&Service{
+								Object:      s1,
+								ServicePort: &s1.Spec.Ports[0],
+							},
+						)),
+					),
+				},
+			},
+		},
+		"insert ingressroute with missing primary secret": {
+			objs: []interface{}{
+				ir12, s1, sec2,
+			},
+			want: []Vertex{
+				&VirtualHost{
+					Port: 80,
+					host: "foo.com",
+					routes: routemap(
+						route("/", ir12, servicemap(
+							&Service{
+								Object:      s1,
+								ServicePort: &s1.Spec.Ports[0],
+							},
+						)),
+					),
+				},
+				&SecureVirtualHost{
+					Port:            443,
+					MinProtoVersion: auth.TlsParameters_TLSv1_1,
+					host:            "foo.com",
+					routes: routemap(
+						route("/", ir12, servicemap(
+							&Service{
+								Object:      s1,
+								ServicePort: &s1.Spec.Ports[0],
+							},
+						)),
+					),
+					secret: &Secret{
+						object: sec2,
+					},
+				}},
+		},
 		"insert ingressroute with invalid tls version": {
 			objs: []interface{}{
 				ir9, s1, sec1,
@@ -3058,668 +3339,3090 @@ func TestBuilderLookupService(t *testing.T) {
 	}
 }
 
-func TestDAGIngressRouteCycle(t *testing.T) {
-	ir1 := &ingressroutev1.IngressRoute{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "default",
-			Name:      "example-com",
-		},
-		Spec: ingressroutev1.IngressRouteSpec{
-			VirtualHost: &ingressroutev1.VirtualHost{
-				Fqdn: "example.com",
+func TestBuilderLookupIngressBackend(t *testing.T) {
+	service := func(namespace string, annotations map[string]string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "kuard",
+				Namespace:   namespace,
+				Annotations: annotations,
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{{
+					Protocol: "TCP",
+					Port:     8080,
+				}},
 			},
-			Routes: []ingressroutev1.Route{{
-				Match: "/finance",
-				Delegate: ingressroutev1.Delegate{
-					Name:      "finance-root",
-					Namespace: "finance",
-				},
-			}},
-		},
+		}
 	}
-	ir2 := &ingressroutev1.IngressRoute{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "finance",
+	ingress := func(annotations map[string]string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "kuard",
+				Namespace:   "default",
+				Annotations: annotations,
+			},
+		}
+	}
+	s1 := service("default", nil)
+	s2 := service("apps", nil)
+	s3 := service("apps", map[string]string{"contour.heptio.com/allow-ingress-from": "other, default"})
+	s4 := service("apps", map[string]string{"contour.heptio.com/allow-ingress-from": "*"})
+	crossnamespace := map[string]string{"contour.heptio.com/backend-namespace.kuard": "apps"}
+
+	tests := map[string]struct {
+		ing      *v1beta1.Ingress
+		services []*v1.Service
+		want     *v1.Service
+	}{
+		"same namespace": {
+			ing:      ingress(nil),
+			services: []*v1.Service{s1, s2},
+			want:     s1,
+		},
+		"other namespace not permitted": {
+			ing:      ingress(crossnamespace),
+			services: []*v1.Service{s1, s2},
+			want:     nil,
+		},
+		"other namespace permitted by name": {
+			ing:      ingress(crossnamespace),
+			services: []*v1.Service{s1, s3},
+			want:     s3,
+		},
+		"other namespace permitted by wildcard": {
+			ing:      ingress(crossnamespace),
+			services: []*v1.Service{s1, s4},
+			want:     s4,
+		},
+		"other namespace missing": {
+			ing:      ingress(crossnamespace),
+			services: []*v1.Service{s1},
+			want:     nil,
+		},
+		"annotation names another service": {
+			ing:      ingress(map[string]string{"contour.heptio.com/backend-namespace.nginx": "apps"}),
+			services: []*v1.Service{s1, s4},
+			want:     s1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			services := make(map[meta]*v1.Service)
+			for _, svc := range tc.services {
+				services[meta{name: svc.Name, namespace: svc.Namespace}] = svc
+			}
+			b := builder{
+				source: &Builder{
+					KubernetesCache: KubernetesCache{
+						services: services,
+					},
+				},
+			}
+			var got *v1.Service
+			if s := b.lookupIngressBackend(tc.ing, backend("kuard", intstr.FromInt(8080))); s != nil {
+				got = s.Object
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%+v\ngot:\n%+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDAGDefaultBackendRestriction(t *testing.T) {
+	ingress := func(namespace string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "default-backend",
+				Namespace: namespace,
+			},
+			Spec: v1beta1.IngressSpec{
+				Backend: &v1beta1.IngressBackend{
+					ServiceName: "kuard",
+					ServicePort: intstr.FromInt(8080),
+				},
+			},
+		}
+	}
+	wildcard := func(namespace string) *ingressroutev1.IngressRoute {
+		return &ingressroutev1.IngressRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "wildcard",
+				Namespace: namespace,
+			},
+			Spec: ingressroutev1.IngressRouteSpec{
+				VirtualHost: &ingressroutev1.VirtualHost{
+					Fqdn: "*",
+				},
+				Routes: []ingressroutev1.Route{{
+					Match: "/",
+					Services: []ingressroutev1.Service{{
+						Name: "kuard",
+						Port: 8080,
+					}},
+				}},
+			},
+		}
+	}
+	i1 := ingress("infra")
+	i2 := ingress("team-a")
+	ir1 := wildcard("team-a")
+
+	tests := map[string]struct {
+		namespace    string
+		disable      bool
+		objs         []interface{}
+		wantWildcard bool
+		wantWarnings []Warning
+		wantStatuses []Status
+	}{
+		"unrestricted": {
+			objs:         []interface{}{i2},
+			wantWildcard: true,
+		},
+		"allowed namespace": {
+			namespace:    "infra",
+			objs:         []interface{}{i1},
+			wantWildcard: true,
+		},
+		"other namespace": {
+			namespace:    "infra",
+			objs:         []interface{}{i2},
+			wantWildcard: false,
+			wantWarnings: []Warning{{
+				Object:  i2,
+				Reason:  "DefaultBackendNotAllowed",
+				Message: `the "*" virtual host may only be created in namespace "infra"`,
+			}},
+		},
+		"disabled": {
+			disable:      true,
+			objs:         []interface{}{i1},
+			wantWildcard: false,
+			wantWarnings: []Warning{{
+				Object:  i1,
+				Reason:  "DefaultBackendNotAllowed",
+				Message: `the "*" virtual host is disabled`,
+			}},
+		},
+		"ingressroute in other namespace": {
+			namespace:    "infra",
+			objs:         []interface{}{ir1},
+			wantWildcard: false,
+			wantStatuses: []Status{{
+				Object:      ir1,
+				Status:      StatusInvalid,
+				Description: `the "*" virtual host may only be created in namespace "infra"`,
+				Vhost:       "*",
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := Builder{
+				KubernetesCache: KubernetesCache{
+					DefaultBackendNamespace: tc.namespace,
+					DisableDefaultBackend:   tc.disable,
+				},
+			}
+			for _, ns := range []string{"infra", "team-a"} {
+				b.Insert(&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kuard",
+						Namespace: ns,
+					},
+					Spec: v1.ServiceSpec{
+						Ports: []v1.ServicePort{{
+							Protocol: "TCP",
+							Port:     8080,
+						}},
+					},
+				})
+			}
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			d := b.Build()
+			gotWildcard := false
+			d.Visit(func(v Vertex) {
+				if vh, ok := v.(*VirtualHost); ok && vh.FQDN() == "*" {
+					gotWildcard = true
+				}
+			})
+			if tc.wantWildcard != gotWildcard {
+				t.Fatalf("expected wildcard vhost: %v, got: %v", tc.wantWildcard, gotWildcard)
+			}
+			if !reflect.DeepEqual(tc.wantWarnings, d.Warnings()) {
+				t.Fatalf("expected warnings:\n%v\ngot:\n%v", tc.wantWarnings, d.Warnings())
+			}
+			if !reflect.DeepEqual(tc.wantStatuses, d.Statuses()) {
+				t.Fatalf("expected statuses:\n%v\ngot:\n%v", tc.wantStatuses, d.Statuses())
+			}
+		})
+	}
+}
+
+func TestDAGWebsocketConflicts(t *testing.T) {
+	ing1 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ws",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"ingress.kubernetes.io/force-ssl-redirect": "true",
+				"contour.heptio.com/websocket-routes":      "/ws",
+			},
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{{
+				Host: "example.com",
+				IngressRuleValue: v1beta1.IngressRuleValue{
+					HTTP: &v1beta1.HTTPIngressRuleValue{
+						Paths: []v1beta1.HTTPIngressPath{{
+							Path: "/ws",
+							Backend: v1beta1.IngressBackend{
+								ServiceName: "kuard",
+								ServicePort: intstr.FromInt(8080),
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ws",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match:            "/ws",
+				EnableWebsockets: true,
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					PerTry: "10s",
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	tests := map[string]struct {
+		obj          interface{}
+		wantWarnings []Warning
+		wantStatuses []Status
+	}{
+		"ingress redirect wins over websocket": {
+			obj: ing1,
+			wantWarnings: []Warning{{
+				Object:  ing1,
+				Reason:  "WebsocketRedirected",
+				Message: "websocket routes redirect to HTTPS, ws:// clients must use wss://",
+			}},
+		},
+		"ingressroute websocket wins over retry": {
+			obj: ir1,
+			wantStatuses: []Status{{
+				Object:      ir1,
+				Status:      StatusValid,
+				Description: `valid IngressRoute, route "/ws": perTry timeout ignored, websocket upgrades are not retried`,
+				Vhost:       "example.com",
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b Builder
+			b.Insert(&v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "kuard",
+					Namespace: "default",
+				},
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{
+						Protocol: "TCP",
+						Port:     8080,
+					}},
+				},
+			})
+			b.Insert(tc.obj)
+			d := b.Build()
+			d.Visit(func(v Vertex) {
+				v.Visit(func(r Vertex) {
+					if r, ok := r.(*Route); ok && r.Websocket && r.PerTryTimeout != 0 {
+						t.Errorf("route %q: expected the perTry timeout of a websocket route to be dropped, got %v", r.Prefix(), r.PerTryTimeout)
+					}
+				})
+			})
+			if !reflect.DeepEqual(tc.wantWarnings, d.Warnings()) {
+				t.Fatalf("expected warnings:\n%v\ngot:\n%v", tc.wantWarnings, d.Warnings())
+			}
+			if !reflect.DeepEqual(tc.wantStatuses, d.Statuses()) {
+				t.Fatalf("expected statuses:\n%v\ngot:\n%v", tc.wantStatuses, d.Statuses())
+			}
+		})
+	}
+}
+
+func TestDAGProxyBodySize(t *testing.T) {
+	ingress := func(annotations map[string]string) *v1beta1.Ingress {
+		return &v1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "kuard",
+				Namespace:   "default",
+				Annotations: annotations,
+			},
+			Spec: v1beta1.IngressSpec{
+				Backend: &v1beta1.IngressBackend{
+					ServiceName: "kuard",
+					ServicePort: intstr.FromInt(8080),
+				},
+			},
+		}
+	}
+	ing1 := ingress(map[string]string{"ingress.kubernetes.io/proxy-body-size": "8m"})
+	ing2 := ingress(map[string]string{
+		"ingress.kubernetes.io/proxy-body-size": "8m",
+		"contour.heptio.com/max-request-bytes":  "1024",
+	})
+	ing3 := ingress(map[string]string{"ingress.kubernetes.io/proxy-body-size": "eight"})
+
+	tests := map[string]struct {
+		foreign      bool
+		ing          *v1beta1.Ingress
+		want         uint32
+		wantWarnings []Warning
+	}{
+		"foreign annotations disabled": {
+			ing:  ing1,
+			want: 0,
+		},
+		"proxy-body-size": {
+			foreign: true,
+			ing:     ing1,
+			want:    8 << 20,
+		},
+		"max-request-bytes wins": {
+			foreign: true,
+			ing:     ing2,
+			want:    1024,
+		},
+		"unparseable proxy-body-size": {
+			foreign: true,
+			ing:     ing3,
+			want:    0,
+			wantWarnings: []Warning{{
+				Object:  ing3,
+				Reason:  "InvalidProxyBodySize",
+				Message: `ingress.kubernetes.io/proxy-body-size: invalid size "eight", no limit applied`,
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := Builder{
+				KubernetesCache: KubernetesCache{
+					ForeignAnnotations: tc.foreign,
+				},
+			}
+			b.Insert(tc.ing)
+			d := b.Build()
+			var got *Route
+			d.Visit(func(v Vertex) {
+				v.Visit(func(r Vertex) {
+					if r, ok := r.(*Route); ok {
+						got = r
+					}
+				})
+			})
+			if got == nil {
+				t.Fatal("expected a route")
+			}
+			if got.MaxRequestBytes != tc.want {
+				t.Fatalf("expected max request bytes %d, got %d", tc.want, got.MaxRequestBytes)
+			}
+			if !reflect.DeepEqual(tc.wantWarnings, d.Warnings()) {
+				t.Fatalf("expected warnings:\n%v\ngot:\n%v", tc.wantWarnings, d.Warnings())
+			}
+		})
+	}
+}
+
+func TestDAGRouteFallback(t *testing.T) {
+	service := func(name string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{{
+					Protocol: "TCP",
+					Port:     8080,
+				}},
+			},
+		}
+	}
+	endpoints := func(name string, subset v1.EndpointSubset) *v1.Endpoints {
+		return &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Subsets: []v1.EndpointSubset{subset},
+		}
+	}
+	ready := v1.EndpointSubset{
+		Addresses: []v1.EndpointAddress{{IP: "192.168.1.1"}},
+	}
+	notready := v1.EndpointSubset{
+		NotReadyAddresses: []v1.EndpointAddress{{IP: "192.168.1.1"}},
+	}
+	ingressroute := func(fb *ingressroutev1.Fallback) *ingressroutev1.IngressRoute {
+		return &ingressroutev1.IngressRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "fallback",
+				Namespace: "default",
+			},
+			Spec: ingressroutev1.IngressRouteSpec{
+				VirtualHost: &ingressroutev1.VirtualHost{
+					Fqdn: "example.com",
+				},
+				Routes: []ingressroutev1.Route{{
+					Match: "/",
+					Services: []ingressroutev1.Service{{
+						Name: "kuard",
+						Port: 8080,
+					}},
+					Fallback: fb,
+				}},
+			},
+		}
+	}
+	status := &ingressroutev1.Fallback{
+		Status: 503,
+	}
+	maintenance := &ingressroutev1.Fallback{
+		Service: &ingressroutev1.Service{
+			Name: "maintenance",
+			Port: 8080,
+		},
+	}
+
+	tests := map[string]struct {
+		objs               []interface{}
+		wantService        string
+		wantDirectResponse int
+	}{
+		"ready endpoints": {
+			objs:        []interface{}{ingressroute(status), endpoints("kuard", ready)},
+			wantService: "kuard",
+		},
+		"no endpoints, fallback status": {
+			objs:               []interface{}{ingressroute(status)},
+			wantDirectResponse: 503,
+		},
+		"not ready endpoints, fallback status": {
+			objs:               []interface{}{ingressroute(status), endpoints("kuard", notready)},
+			wantDirectResponse: 503,
+		},
+		"no endpoints, fallback service": {
+			objs:        []interface{}{ingressroute(maintenance)},
+			wantService: "maintenance",
+		},
+		"no endpoints, no fallback": {
+			objs:        []interface{}{ingressroute(nil)},
+			wantService: "kuard",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b Builder
+			b.Insert(service("kuard"))
+			b.Insert(service("maintenance"))
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			var got *Route
+			b.Build().Visit(func(v Vertex) {
+				v.Visit(func(r Vertex) {
+					if r, ok := r.(*Route); ok {
+						got = r
+					}
+				})
+			})
+			if got == nil {
+				t.Fatal("expected a route")
+			}
+			if got.DirectResponse != tc.wantDirectResponse {
+				t.Fatalf("expected direct response %d, got %d", tc.wantDirectResponse, got.DirectResponse)
+			}
+			var services []string
+			got.Visit(func(s Vertex) {
+				services = append(services, s.(*Service).Name())
+			})
+			var want []string
+			if tc.wantService != "" {
+				want = []string{tc.wantService}
+			}
+			if !reflect.DeepEqual(want, services) {
+				t.Fatalf("expected services %v, got %v", want, services)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteRequestTimeout(t *testing.T) {
+	ingressroute := func(request string) *ingressroutev1.IngressRoute {
+		return &ingressroutev1.IngressRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "timeout",
+				Namespace: "roots",
+			},
+			Spec: ingressroutev1.IngressRouteSpec{
+				VirtualHost: &ingressroutev1.VirtualHost{
+					Fqdn: "example.com",
+				},
+				Routes: []ingressroutev1.Route{{
+					Match: "/",
+					Services: []ingressroutev1.Service{{
+						Name: "kuard",
+						Port: 8080,
+					}},
+					TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+						Request: request,
+					},
+				}},
+			},
+		}
+	}
+
+	tests := map[string]struct {
+		request     string
+		wantTimeout time.Duration
+		wantStatus  string
+	}{
+		"infinity": {
+			request:     "infinity",
+			wantTimeout: -1,
+			wantStatus:  StatusValid,
+		},
+		"valid duration": {
+			request:     "90s",
+			wantTimeout: 90 * time.Second,
+			wantStatus:  StatusValid,
+		},
+		"malformed duration": {
+			request:    "forever",
+			wantStatus: StatusInvalid,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := Builder{
+				KubernetesCache: KubernetesCache{
+					IngressRouteRootNamespaces: []string{"roots"},
+				},
+			}
+			b.Insert(&v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "kuard",
+					Namespace: "roots",
+				},
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{
+						Protocol: "TCP",
+						Port:     8080,
+					}},
+				},
+			})
+			b.Insert(ingressroute(tc.request))
+			d := b.Build()
+
+			var got *Route
+			d.Visit(func(v Vertex) {
+				v.Visit(func(r Vertex) {
+					if r, ok := r.(*Route); ok {
+						got = r
+					}
+				})
+			})
+			statuses := d.Statuses()
+			if len(statuses) != 1 || statuses[0].Status != tc.wantStatus {
+				t.Fatalf("expected status %q, got %v", tc.wantStatus, statuses)
+			}
+			if tc.wantStatus == StatusInvalid {
+				// a malformed timeout is not silently infinite.
+				if got != nil {
+					t.Fatalf("expected no route, got timeout %v", got.Timeout)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("expected a route")
+			}
+			if got.Timeout != tc.wantTimeout {
+				t.Fatalf("expected timeout %v, got %v", tc.wantTimeout, got.Timeout)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteRequestHeadersPerRoute(t *testing.T) {
+	b := Builder{
+		KubernetesCache: KubernetesCache{
+			IngressRouteRootNamespaces: []string{"roots"},
+		},
+	}
+	b.Insert(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "roots",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Protocol: "TCP",
+				Port:     8080,
+			}},
+		},
+	})
+	// both routes send requests to the same service, each
+	// with headers of its own.
+	b.Insert(&ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "headers",
+			Namespace: "roots",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/a",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+					RequestHeadersToAdd: map[string]string{
+						"x-route": "a",
+					},
+				}},
+			}, {
+				Match: "/b",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+					RequestHeadersToAdd: map[string]string{
+						"x-route": "b",
+					},
+				}},
+			}},
+		},
+	})
+	d := b.Build()
+
+	got := make(map[string]map[string]string)
+	d.Visit(func(v Vertex) {
+		v.Visit(func(r Vertex) {
+			if r, ok := r.(*Route); ok {
+				r.Visit(func(s Vertex) {
+					if s, ok := s.(*Service); ok {
+						got[r.Prefix()] = r.RequestHeadersToAdd[s]
+					}
+				})
+			}
+		})
+	})
+	want := map[string]map[string]string{
+		"/a": {"x-route": "a"},
+		"/b": {"x-route": "b"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected request headers %v, got %v", want, got)
+	}
+}
+
+func TestBuilderResolveService(t *testing.T) {
+	s1 := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Name:     "http",
+				Protocol: "TCP",
+				Port:     8080,
+			}, {
+				Name:     "alt",
+				Protocol: "TCP",
+				Port:     8080,
+			}, {
+				Name:     "dns-tcp",
+				Protocol: "TCP",
+				Port:     53,
+			}, {
+				Name:     "dns-udp",
+				Protocol: "UDP",
+				Port:     53,
+			}},
+		},
+	}
+	s2 := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dns",
+			Namespace: "default",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Name:     "dns-udp",
+				Protocol: "UDP",
+				Port:     53,
+			}},
+		},
+	}
+	services := map[meta]*v1.Service{
+		{name: "kuard", namespace: "default"}: s1,
+		{name: "dns", namespace: "default"}:   s2,
+	}
+
+	tests := map[string]struct {
+		meta
+		port    intstr.IntOrString
+		want    *Service
+		wantErr string
+	}{
+		"missing service": {
+			meta: meta{name: "missing", namespace: "default"},
+			port: intstr.FromInt(8080),
+		},
+		"port number matches no service port": {
+			meta:    meta{name: "kuard", namespace: "default"},
+			port:    intstr.FromInt(9999),
+			wantErr: "port 9999 not found",
+		},
+		"port name matches no service port": {
+			meta:    meta{name: "kuard", namespace: "default"},
+			port:    intstr.FromString("https"),
+			wantErr: `port "https" not found`,
+		},
+		"port number matches two service ports": {
+			meta:    meta{name: "kuard", namespace: "default"},
+			port:    intstr.FromInt(8080),
+			wantErr: `port 8080 is ambiguous, it matches ports "http" and "alt"`,
+		},
+		"port name of an ambiguous port number": {
+			meta: meta{name: "kuard", namespace: "default"},
+			port: intstr.FromString("alt"),
+			want: &Service{
+				Object:      s1,
+				ServicePort: &s1.Spec.Ports[1],
+			},
+		},
+		"port name of a udp port": {
+			meta:    meta{name: "kuard", namespace: "default"},
+			port:    intstr.FromString("dns-udp"),
+			wantErr: `port "dns-udp" is UDP`,
+		},
+		"udp only service": {
+			meta:    meta{name: "dns", namespace: "default"},
+			port:    intstr.FromInt(53),
+			wantErr: "no TCP ports",
+		},
+		"udp port of the same number is ignored": {
+			meta: meta{name: "kuard", namespace: "default"},
+			port: intstr.FromInt(53),
+			want: &Service{
+				Object:      s1,
+				ServicePort: &s1.Spec.Ports[2],
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := builder{
+				source: &Builder{
+					KubernetesCache: KubernetesCache{
+						services: services,
+					},
+				},
+			}
+			got, err := b.resolveService(tc.meta, tc.port)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%+v\ngot:\n%+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDAGServiceWithoutTCPPorts(t *testing.T) {
+	service := func(protocols ...v1.Protocol) *v1.Service {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dns",
+				Namespace: "default",
+			},
+		}
+		for _, p := range protocols {
+			svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{
+				Name:     "dns-" + string(p),
+				Protocol: p,
+				Port:     53,
+			})
+		}
+		return svc
+	}
+	ing := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dns",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: &v1beta1.IngressBackend{
+				ServiceName: "dns",
+				ServicePort: intstr.FromInt(53),
+			},
+		},
+	}
+
+	routed := func(d *DAG) bool {
+		found := false
+		d.Visit(func(v Vertex) {
+			v.Visit(func(r Vertex) {
+				r.Visit(func(s Vertex) {
+					found = true
+				})
+			})
+		})
+		return found
+	}
+
+	var b Builder
+	b.Insert(ing)
+	b.Insert(service(v1.ProtocolUDP))
+	d := b.Build()
+	if routed(d) {
+		t.Fatal("expected the route to a udp only service to have no services")
+	}
+	want := []Warning{{
+		Object:  ing,
+		Reason:  "InvalidBackendPort",
+		Message: `service "dns": no TCP ports`,
+	}}
+	if !reflect.DeepEqual(want, d.Warnings()) {
+		t.Fatalf("expected warnings:\n%v\ngot:\n%v", want, d.Warnings())
+	}
+
+	// the service gains a tcp port.
+	b.Insert(service(v1.ProtocolUDP, v1.ProtocolTCP))
+	d = b.Build()
+	if !routed(d) {
+		t.Fatal("expected the route to have a service once it has a tcp port")
+	}
+	if len(d.Warnings()) != 0 {
+		t.Fatalf("expected no warnings, got %v", d.Warnings())
+	}
+}
+
+func TestDAGMissingHTTPRule(t *testing.T) {
+	ing := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nil-http",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{{
+				Host: "a.example.com",
+			}, {
+				Host:             "b.example.com",
+				IngressRuleValue: ingressrulevalue(backend("kuard", intstr.FromInt(8080))),
+			}},
+		},
+	}
+
+	var b Builder
+	b.Insert(ing)
+	d := b.Build()
+
+	var hosts []string
+	d.Visit(func(v Vertex) {
+		if vh, ok := v.(*VirtualHost); ok {
+			hosts = append(hosts, vh.FQDN())
+		}
+	})
+	if want := []string{"b.example.com"}; !reflect.DeepEqual(want, hosts) {
+		t.Fatalf("expected virtual hosts %v, got %v", want, hosts)
+	}
+	want := []Warning{{
+		Object:  ing,
+		Reason:  "MissingHTTPRule",
+		Message: "spec.rules[0]: no http block, rule skipped",
+	}}
+	if !reflect.DeepEqual(want, d.Warnings()) {
+		t.Fatalf("expected warnings:\n%v\ngot:\n%v", want, d.Warnings())
+	}
+}
+
+func TestDAGServiceWarnings(t *testing.T) {
+	service := func(annotations map[string]string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "kuard",
+				Namespace:   "default",
+				Annotations: annotations,
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{{
+					Name:     "http",
+					Protocol: "TCP",
+					Port:     80,
+				}, {
+					Name:     "admin",
+					Protocol: "TCP",
+					Port:     9000,
+				}},
+			},
+		}
+	}
+	ir := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 80,
+				}},
+			}, {
+				Match: "/admin",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 9000,
+				}},
+			}},
+		},
+	}
+
+	tests := map[string]struct {
+		annotations map[string]string
+		want        []ServiceWarning
+	}{
+		"no annotation": {
+			want: nil,
+		},
+		"ports by name and number": {
+			annotations: map[string]string{
+				"contour.heptio.com/upstream-protocol.h2c": "http,9000",
+			},
+			want: nil,
+		},
+		"missing ports": {
+			annotations: map[string]string{
+				"contour.heptio.com/upstream-protocol.h2":  "https",
+				"contour.heptio.com/upstream-protocol.h2c": "http,grpc,8080",
+			},
+			want: []ServiceWarning{{
+				Reason:  "UpstreamProtocolPortNotFound",
+				Message: `contour.heptio.com/upstream-protocol.h2c: port "8080" not found, annotation ignored`,
+			}, {
+				Reason:  "UpstreamProtocolPortNotFound",
+				Message: `contour.heptio.com/upstream-protocol.h2c: port "grpc" not found, annotation ignored`,
+			}, {
+				Reason:  "UpstreamProtocolPortNotFound",
+				Message: `contour.heptio.com/upstream-protocol.h2: port "https" not found, annotation ignored`,
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			svc := service(tc.annotations)
+			for i := range tc.want {
+				tc.want[i].Object = svc
+			}
+			var b Builder
+			b.Insert(ir)
+			b.Insert(svc)
+			got := b.Build().ServiceWarnings()
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteServicePortStatus(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "example",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	s1 := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "home",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Name:     "http",
+				Protocol: "TCP",
+				Port:     8080,
+			}},
+		},
+	}
+
+	// s2 does not define port 8080
+	s2 := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "home",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Name:     "http",
+				Protocol: "TCP",
+				Port:     80,
+			}},
+		},
+	}
+
+	tests := map[string]struct {
+		objs []interface{}
+		want []Status
+	}{
+		"service port present": {
+			objs: []interface{}{ir1, s1},
+			want: []Status{{Object: ir1, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"service port missing": {
+			objs: []interface{}{ir1, s2},
+			want: []Status{{Object: ir1, Status: "invalid", Description: `route "/foo": service "home": port 8080 not found`, Vhost: "example.com"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := Builder{
+				KubernetesCache: KubernetesCache{
+					IngressRouteRootNamespaces: []string{"roots"},
+				},
+			}
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			got := b.Build().Statuses()
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot\n%v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteTCPProxyStatus(t *testing.T) {
+	tcpproxy := func(name string, tls *ingressroutev1.TLS, routes []ingressroutev1.Route, services ...ingressroutev1.Service) *ingressroutev1.IngressRoute {
+		return &ingressroutev1.IngressRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "roots",
+				Name:      name,
+			},
+			Spec: ingressroutev1.IngressRouteSpec{
+				VirtualHost: &ingressroutev1.VirtualHost{
+					Fqdn: "example.com",
+					TLS:  tls,
+				},
+				Routes: routes,
+				TCPProxy: &ingressroutev1.TCPProxy{
+					Services: services,
+				},
+			},
+		}
+	}
+	passthrough := &ingressroutev1.TLS{Passthrough: true}
+	kuard := ingressroutev1.Service{Name: "kuard", Port: 8080}
+
+	ir1 := tcpproxy("valid", passthrough, nil, kuard)
+	ir2 := tcpproxy("notls", nil, nil, kuard)
+	ir3 := tcpproxy("routes", passthrough, []ingressroutev1.Route{{
+		Match:    "/",
+		Services: []ingressroutev1.Service{kuard},
+	}}, kuard)
+	ir4 := tcpproxy("noservices", passthrough, nil)
+	ir5 := tcpproxy("twoservices", passthrough, nil, kuard, kuard)
+	ir6 := tcpproxy("badport", passthrough, nil, ingressroutev1.Service{Name: "kuard", Port: 0})
+	ir7 := tcpproxy("missingport", passthrough, nil, ingressroutev1.Service{Name: "kuard", Port: 9000})
+
+	// ir8 asks for passthrough without a tcpproxy.
+	ir8 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "passthrough",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+				TLS:  passthrough,
+			},
+			Routes: []ingressroutev1.Route{{
+				Match:    "/",
+				Services: []ingressroutev1.Service{kuard},
+			}},
+		},
+	}
+
+	s1 := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "kuard",
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Protocol: "TCP",
+				Port:     8080,
+			}},
+		},
+	}
+
+	tests := map[string]struct {
+		objs []interface{}
+		want []Status
+	}{
+		"valid tcpproxy": {
+			objs: []interface{}{ir1, s1},
+			want: []Status{{Object: ir1, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"tcpproxy without tls": {
+			objs: []interface{}{ir2, s1},
+			want: []Status{{Object: ir2, Status: "invalid", Description: "tcpproxy: spec.virtualhost.tls must be specified", Vhost: "example.com"}},
+		},
+		"tcpproxy and routes": {
+			objs: []interface{}{ir3, s1},
+			want: []Status{{Object: ir3, Status: "invalid", Description: "cannot specify both tcpproxy and routes", Vhost: "example.com"}},
+		},
+		"tcpproxy without services": {
+			objs: []interface{}{ir4, s1},
+			want: []Status{{Object: ir4, Status: "invalid", Description: "tcpproxy: exactly one service must be specified", Vhost: "example.com"}},
+		},
+		"tcpproxy with two services": {
+			objs: []interface{}{ir5, s1},
+			want: []Status{{Object: ir5, Status: "invalid", Description: "tcpproxy: exactly one service must be specified", Vhost: "example.com"}},
+		},
+		"tcpproxy service port out of range": {
+			objs: []interface{}{ir6, s1},
+			want: []Status{{Object: ir6, Status: "invalid", Description: `tcpproxy: service "kuard": port must be in the range 1-65535`, Vhost: "example.com"}},
+		},
+		"tcpproxy service port missing": {
+			objs: []interface{}{ir7, s1},
+			want: []Status{{Object: ir7, Status: "invalid", Description: `tcpproxy: service "kuard": port 9000 not found`, Vhost: "example.com"}},
+		},
+		"passthrough without tcpproxy": {
+			objs: []interface{}{ir8, s1},
+			want: []Status{{Object: ir8, Status: "invalid", Description: "tls: passthrough requires tcpproxy", Vhost: "example.com"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := Builder{
+				KubernetesCache: KubernetesCache{
+					IngressRouteRootNamespaces: []string{"roots"},
+				},
+			}
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			got := b.Build().Statuses()
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot\n%v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteCycle(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "example-com",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/finance",
+				Delegate: ingressroutev1.Delegate{
+					Name:      "finance-root",
+					Namespace: "finance",
+				},
+			}},
+		},
+	}
+	ir2 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "finance",
+			Name:      "finance-root",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			Routes: []ingressroutev1.Route{{
+				Match: "/finance",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+					Port: 8080,
+				}},
+			}, {
+				Match: "/finance/stocks",
+				Delegate: ingressroutev1.Delegate{
+					Name:      "example-com",
+					Namespace: "default",
+				},
+			}},
+		},
+	}
+
+	var b Builder
+	b.Insert(ir2)
+	b.Insert(ir1)
+	dag := b.Build()
+
+	got := make(map[hostport]*VirtualHost)
+	dag.Visit(func(v Vertex) {
+		if v, ok := v.(*VirtualHost); ok {
+			got[hostport{host: v.FQDN(), port: v.Port}] = v
+		}
+	})
+
+	want := make(map[hostport]*VirtualHost)
+	want[hostport{host: "example.com", port: 80}] = &VirtualHost{
+		Port:   80,
+		host:   "example.com",
+		routes: routemap(&Route{path: "/finance", Object: ir2}),
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatal("expected:\n", want, "\ngot:\n", got)
+	}
+}
+
+func TestDAGIngressRouteDelegatedRouteOrder(t *testing.T) {
+	root := func(name string, routes ...ingressroutev1.Route) *ingressroutev1.IngressRoute {
+		return &ingressroutev1.IngressRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      name,
+			},
+			Spec: ingressroutev1.IngressRouteSpec{
+				VirtualHost: &ingressroutev1.VirtualHost{
+					Fqdn: "example.com",
+				},
+				Routes: routes,
+			},
+		}
+	}
+	delegated := func(name string, routes ...ingressroutev1.Route) *ingressroutev1.IngressRoute {
+		return &ingressroutev1.IngressRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      name,
+			},
+			Spec: ingressroutev1.IngressRouteSpec{
+				Routes: routes,
+			},
+		}
+	}
+	services := func(match string) ingressroutev1.Route {
+		return ingressroutev1.Route{
+			Match:    match,
+			Services: []ingressroutev1.Service{{Name: "kuard", Port: 8080}},
+		}
+	}
+	delegate := func(match, name string) ingressroutev1.Route {
+		return ingressroutev1.Route{
+			Match:    match,
+			Delegate: ingressroutev1.Delegate{Name: name},
+		}
+	}
+
+	// child claims / and /api, /api is also claimed by its parent.
+	child := delegated("child", services("/api"), services("/"))
+	// api and fallback both claim /api/v1 at the same depth.
+	api := delegated("api", services("/api/v1"))
+	fallback := delegated("fallback", services("/api/v1"), services("/"))
+
+	parentFirst := root("example-com", services("/api"), delegate("/", "child"))
+	delegateFirst := root("example-com", delegate("/", "child"), services("/api"))
+	apiFirst := root("example-com", delegate("/api", "api"), delegate("/", "fallback"))
+	fallbackFirst := root("example-com", delegate("/", "fallback"), delegate("/api", "api"))
+
+	tests := map[string]struct {
+		objs []*ingressroutev1.IngressRoute
+		want []*Route
+	}{
+		"parent route before delegation": {
+			objs: []*ingressroutev1.IngressRoute{parentFirst, child},
+			want: []*Route{
+				{path: "/api", Object: parentFirst},
+				{path: "/", Object: child},
+			},
+		},
+		"parent route after delegation": {
+			objs: []*ingressroutev1.IngressRoute{delegateFirst, child},
+			want: []*Route{
+				{path: "/api", Object: delegateFirst},
+				{path: "/", Object: child},
+			},
+		},
+		"sibling api delegated first": {
+			objs: []*ingressroutev1.IngressRoute{apiFirst, api, fallback},
+			want: []*Route{
+				{path: "/api/v1", Object: api},
+				{path: "/", Object: fallback},
+			},
+		},
+		"sibling fallback delegated first": {
+			objs: []*ingressroutev1.IngressRoute{fallbackFirst, api, fallback},
+			want: []*Route{
+				{path: "/api/v1", Object: api},
+				{path: "/", Object: fallback},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b Builder
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			dag := b.Build()
+
+			var got []*Route
+			dag.Visit(func(v Vertex) {
+				if v, ok := v.(*VirtualHost); ok {
+					v.Visit(func(v Vertex) {
+						if r, ok := v.(*Route); ok {
+							got = append(got, r)
+						}
+					})
+				}
+			})
+
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot:\n%v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteSameMatchRouteOrder(t *testing.T) {
+	ir := func(routes ...ingressroutev1.Route) *ingressroutev1.IngressRoute {
+		return &ingressroutev1.IngressRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "example-com",
+			},
+			Spec: ingressroutev1.IngressRouteSpec{
+				VirtualHost: &ingressroutev1.VirtualHost{
+					Fqdn: "example.com",
+				},
+				Routes: routes,
+			},
+		}
+	}
+	services := func(match string, headers ...ingressroutev1.HeaderMatch) ingressroutev1.Route {
+		return ingressroutev1.Route{
+			Match:    match,
+			Headers:  headers,
+			Services: []ingressroutev1.Service{{Name: "kuard", Port: 8080}},
+		}
+	}
+	beta := ingressroutev1.HeaderMatch{Name: "x-channel", Exact: "beta"}
+	debug := ingressroutev1.HeaderMatch{Name: "x-debug", Present: true}
+	regex := services("/foo")
+	regex.MatchType = "Regex"
+	anycase := services("/foo")
+	anycase.CaseSensitive = new(bool) // false
+
+	plainFirst := ir(services("/"), services("/", beta), services("/api"))
+	headersFirst := ir(services("/api"), services("/", beta), services("/"))
+	several := ir(services("/"), services("/", beta), services("/", debug, beta))
+	matchTypes := ir(services("/foo"), regex)
+	caseSensitivity := ir(anycase, services("/foo"))
+
+	tests := map[string]struct {
+		objs []*ingressroutev1.IngressRoute
+		want []*Route
+	}{
+		"route without headers first": {
+			objs: []*ingressroutev1.IngressRoute{plainFirst},
+			want: []*Route{
+				{path: "/api", Object: plainFirst},
+				{path: "/", Object: plainFirst, HeaderMatches: []ingressroutev1.HeaderMatch{beta}},
+				{path: "/", Object: plainFirst},
+			},
+		},
+		"route with headers first": {
+			objs: []*ingressroutev1.IngressRoute{headersFirst},
+			want: []*Route{
+				{path: "/api", Object: headersFirst},
+				{path: "/", Object: headersFirst, HeaderMatches: []ingressroutev1.HeaderMatch{beta}},
+				{path: "/", Object: headersFirst},
+			},
+		},
+		"several header matches": {
+			objs: []*ingressroutev1.IngressRoute{several},
+			want: []*Route{
+				{path: "/", Object: several, HeaderMatches: []ingressroutev1.HeaderMatch{debug, beta}},
+				{path: "/", Object: several, HeaderMatches: []ingressroutev1.HeaderMatch{beta}},
+				{path: "/", Object: several},
+			},
+		},
+		"regex and prefix with the same match": {
+			objs: []*ingressroutev1.IngressRoute{matchTypes},
+			want: []*Route{
+				{path: "/foo", Object: matchTypes, Regex: true},
+				{path: "/foo", Object: matchTypes},
+			},
+		},
+		"case sensitive and insensitive with the same match": {
+			objs: []*ingressroutev1.IngressRoute{caseSensitivity},
+			want: []*Route{
+				{path: "/foo", Object: caseSensitivity},
+				{path: "/foo", Object: caseSensitivity, CaseInsensitive: true},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b Builder
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			dag := b.Build()
+
+			var got []*Route
+			dag.Visit(func(v Vertex) {
+				if v, ok := v.(*VirtualHost); ok {
+					v.Visit(func(v Vertex) {
+						if r, ok := v.(*Route); ok {
+							got = append(got, r)
+						}
+					})
+				}
+			})
+
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot:\n%v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteCycleSelfEdge(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "example-com",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/finance",
+				Delegate: ingressroutev1.Delegate{
+					Name:      "example-com",
+					Namespace: "default",
+				},
+			}},
+		},
+	}
+
+	var b Builder
+	b.Insert(ir1)
+	dag := b.Build()
+
+	got := make(map[hostport]*VirtualHost)
+	dag.Visit(func(v Vertex) {
+		if v, ok := v.(*VirtualHost); ok {
+			got[hostport{host: v.FQDN(), port: v.Port}] = v
+		}
+	})
+
+	want := make(map[hostport]*VirtualHost)
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatal("expected:\n", want, "\ngot:\n", got)
+	}
+}
+
+func TestDAGIngressRouteDelegatesToNonExistent(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "example-com",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/finance",
+				Delegate: ingressroutev1.Delegate{
+					Name:      "non-existent",
+					Namespace: "non-existent",
+				},
+			}},
+		},
+	}
+
+	var b Builder
+	b.Insert(ir1)
+	dag := b.Build()
+
+	got := make(map[hostport]*VirtualHost)
+	dag.Visit(func(v Vertex) {
+		if v, ok := v.(*VirtualHost); ok {
+			got[hostport{host: v.FQDN(), port: v.Port}] = v
+		}
+	})
+
+	want := make(map[hostport]*VirtualHost)
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatal("expected:\n", want, "\ngot:\n", got)
+	}
+}
+
+func TestDAGIngressRouteDelegatePrefixDoesntMatch(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "example-com",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/finance",
+				Delegate: ingressroutev1.Delegate{
+					Name:      "finance-root",
+					Namespace: "finance",
+				},
+			}},
+		},
+	}
+	ir2 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "finance",
+			Name:      "finance-root",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			Routes: []ingressroutev1.Route{{
+				Match: "/prefixDoesntMatch",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+				}},
+			}},
+		},
+	}
+
+	var b Builder
+	b.Insert(ir2)
+	b.Insert(ir1)
+	dag := b.Build()
+
+	got := make(map[hostport]*VirtualHost)
+	dag.Visit(func(v Vertex) {
+		if v, ok := v.(*VirtualHost); ok {
+			got[hostport{host: v.FQDN(), port: v.Port}] = v
+		}
+	})
+
+	want := make(map[hostport]*VirtualHost)
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatal("expected:\n", want, "\ngot:\n", got)
+	}
+}
+func TestDAGRootNamespaces(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example-com",
+			Namespace: "allowed1",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir2 is like ir1, but in a different namespace
+	ir2 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example-com",
+			Namespace: "allowed2",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example2.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	tests := map[string]struct {
+		rootNamespaces []string
+		objs           []interface{}
+		want           int
+	}{
+		"nil root namespaces": {
+			objs: []interface{}{ir1},
+			want: 1,
+		},
+		"empty root namespaces": {
+			objs: []interface{}{ir1},
+			want: 1,
+		},
+		"single root namespace with root ingressroute": {
+			rootNamespaces: []string{"allowed1"},
+			objs:           []interface{}{ir1},
+			want:           1,
+		},
+		"multiple root namespaces, one with a root ingressroute": {
+			rootNamespaces: []string{"foo", "allowed1", "bar"},
+			objs:           []interface{}{ir1},
+			want:           1,
+		},
+		"multiple root namespaces, each with a root ingressroute": {
+			rootNamespaces: []string{"foo", "allowed1", "allowed2"},
+			objs:           []interface{}{ir1, ir2},
+			want:           2,
+		},
+		"root ingressroute defined outside single root namespaces": {
+			rootNamespaces: []string{"foo"},
+			objs:           []interface{}{ir1},
+			want:           0,
+		},
+		"root ingressroute defined outside multiple root namespaces": {
+			rootNamespaces: []string{"foo", "bar"},
+			objs:           []interface{}{ir1},
+			want:           0,
+		},
+		"two root ingressroutes, one inside root namespace, one outside": {
+			rootNamespaces: []string{"foo", "allowed2"},
+			objs:           []interface{}{ir1, ir2},
+			want:           1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := Builder{
+				KubernetesCache: KubernetesCache{
+					IngressRouteRootNamespaces: tc.rootNamespaces,
+				},
+			}
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			dag := b.Build()
+
+			var count int
+			dag.Visit(func(v Vertex) {
+				if _, ok := v.(*VirtualHost); ok {
+					count++
+				}
+			})
+
+			if tc.want != count {
+				t.Errorf("wanted %d vertices, but got %d", tc.want, count)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteDelegatePrefixMatchesStringPrefixButNotPathPrefix(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "example-com",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Delegate: ingressroutev1.Delegate{
+					Name:      "finance-root",
+					Namespace: "finance",
+				},
+			}},
+		},
+	}
+	ir2 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "finance",
 			Name:      "finance-root",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			Routes: []ingressroutev1.Route{{
-				Match: "/finance",
+				Match: "/foobar",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+				}},
+			}, {
+				Match: "/foo/bar",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+				}},
+			}},
+		},
+	}
+
+	var b Builder
+	b.Insert(ir2)
+	b.Insert(ir1)
+	dag := b.Build()
+
+	got := make(map[hostport]*VirtualHost)
+	dag.Visit(func(v Vertex) {
+		if v, ok := v.(*VirtualHost); ok {
+			got[hostport{host: v.FQDN(), port: v.Port}] = v
+		}
+	})
+
+	want := make(map[hostport]*VirtualHost)
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatal("expected:\n", want, "\ngot:\n", got)
+	}
+}
+
+func TestValidateRegex(t *testing.T) {
+	tests := map[string]struct {
+		expr string
+		want bool
+	}{
+		"simple":                   {expr: "/v[0-9]+/api", want: true},
+		"non capturing group":      {expr: "/(?:foo|bar)/.*", want: true},
+		"escaped bracket":          {expr: `/foo\[bar`, want: true},
+		"bracket in class":         {expr: "/foo/[]a]", want: true},
+		"paren in class":           {expr: "/foo/[(?]", want: true},
+		"unbalanced bracket":       {expr: "/foo/[bar", want: false},
+		"unbalanced paren":         {expr: "/foo/(bar", want: false},
+		"backreference":            {expr: `/(foo)/\1`, want: false},
+		"flags":                    {expr: "(?i)/foo", want: false},
+		"named group":              {expr: "/(?P<name>foo)", want: false},
+		"unicode class":            {expr: `/\pL+`, want: false},
+		"text anchor":              {expr: `\A/foo\z`, want: false},
+		"quoted literal":           {expr: `/\Q.*\E`, want: false},
+		"braced hex escape":        {expr: `/\x{41}`, want: false},
+		"two digit hex escape":     {expr: `/\x41`, want: true},
+		"negated class with caret": {expr: "/[^]a]", want: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateRegex(tc.expr)
+			if got := err == nil; got != tc.want {
+				t.Fatalf("validateRegex(%q): want valid: %v, got: %v", tc.expr, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestMatchesPathPrefix(t *testing.T) {
+	tests := map[string]struct {
+		path    string
+		prefix  string
+		matches bool
+	}{
+		"no path cannot match the prefix": {
+			prefix:  "/foo",
+			path:    "",
+			matches: false,
+		},
+		"any path has the empty string as the prefix": {
+			prefix:  "",
+			path:    "/foo",
+			matches: true,
+		},
+		"strict match": {
+			prefix:  "/foo",
+			path:    "/foo",
+			matches: true,
+		},
+		"strict match with / at the end": {
+			prefix:  "/foo/",
+			path:    "/foo/",
+			matches: true,
+		},
+		"no match": {
+			prefix:  "/foo",
+			path:    "/bar",
+			matches: false,
+		},
+		"string prefix match should not match": {
+			prefix:  "/foo",
+			path:    "/foobar",
+			matches: false,
+		},
+		"prefix match": {
+			prefix:  "/foo",
+			path:    "/foo/bar",
+			matches: true,
+		},
+		"prefix match with trailing slash in prefix": {
+			prefix:  "/foo/",
+			path:    "/foo/bar",
+			matches: true,
+		},
+		"prefix match with trailing slash in path": {
+			prefix:  "/foo",
+			path:    "/foo/bar/",
+			matches: true,
+		},
+		"prefix match with trailing slashes": {
+			prefix:  "/foo/",
+			path:    "/foo/bar/",
+			matches: true,
+		},
+		"prefix match two levels": {
+			prefix:  "/foo/bar",
+			path:    "/foo/bar",
+			matches: true,
+		},
+		"prefix match two levels trailing slash in prefix": {
+			prefix:  "/foo/bar/",
+			path:    "/foo/bar",
+			matches: true,
+		},
+		"prefix match two levels trailing slash in path": {
+			prefix:  "/foo/bar",
+			path:    "/foo/bar/",
+			matches: true,
+		},
+		"no match two levels": {
+			prefix:  "/foo/bar",
+			path:    "/foo/baz",
+			matches: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := matchesPathPrefix(tc.path, tc.prefix)
+			if got != tc.matches {
+				t.Errorf("expected %v but got %v", tc.matches, got)
+			}
+		})
+	}
+}
+
+func TestDAGIngressRouteStatus(t *testing.T) {
+	// ir1 is a valid ingressroute
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "example",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+					Port: 8080,
+				}},
+			}, {
+				Match: "/prefix",
+				Delegate: ingressroutev1.Delegate{
+					Name: "delegated",
+				}},
+			},
+		},
+	}
+
+	// ir2 is invalid because it contains a service with negative port
+	ir2 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "example",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+					Port: -80,
+				}},
+			}},
+		},
+	}
+
+	// ir3 is invalid because it lives outside the roots namespace
+	ir3 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "finance",
+			Name:      "example",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foobar",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir4 is invalid because its match prefix does not match its parent's (ir1)
+	ir4 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "delegated",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			Routes: []ingressroutev1.Route{{
+				Match: "/doesnotmatch",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir5 is invalid because its service weight is less than zero
+	ir5 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "delegated",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name:   "home",
+					Port:   8080,
+					Weight: -10,
+				}},
+			}},
+		},
+	}
+
+	// ir6 is invalid because it delegates to itself, producing a cycle
+	ir6 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "self",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Delegate: ingressroutev1.Delegate{
+					Name: "self",
+				},
+			}},
+		},
+	}
+
+	// ir7 delegates to ir8, which is invalid because it delegates back to ir7
+	ir7 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "parent",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Delegate: ingressroutev1.Delegate{
+					Name: "child",
+				},
+			}},
+		},
+	}
+
+	ir8 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "child",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Delegate: ingressroutev1.Delegate{
+					Name: "parent",
+				},
+			}},
+		},
+	}
+
+	// ir9 is invalid because it has a route that both delegates and has a list of services
+	ir9 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "parent",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Delegate: ingressroutev1.Delegate{
+					Name: "child",
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "kuard",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir10 delegates to ir11 and ir 12.
+	ir10 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "parent",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Delegate: ingressroutev1.Delegate{
+					Name: "validChild",
+				},
+			}, {
+				Match: "/bar",
+				Delegate: ingressroutev1.Delegate{
+					Name: "invalidChild",
+				},
+			}},
+		},
+	}
+
+	ir11 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "validChild",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir12 is invalid because it contains an invalid port
+	ir12 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "invalidChild",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			Routes: []ingressroutev1.Route{{
+				Match: "/bar",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 12345678,
+				}},
+			}},
+		},
+	}
+
+	// ir13 is invalid because it does not specify and FQDN
+	ir13 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "parent",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir14 delegates tp ir15 but it is invalid because it is missing fqdn
+	ir14 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "invalidParent",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Delegate: ingressroutev1.Delegate{
+					Name: "validChild",
+				},
+			}},
+		},
+	}
+
+	// ir15's only route is skipped because its regex match does not compile
+	ir15 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "regex",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match:     "/foo/(bar",
+				MatchType: "Regex",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir16 is invalid because its match type is unknown
+	ir16 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "regex",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match:     "/foo",
+				MatchType: "Glob",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir17 is a valid regex match
+	ir17 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "regex",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match:     "/foo/(bar|baz)",
+				MatchType: "Regex",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir18 is invalid because its maxGrpcTimeout is not a duration
+	ir18 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "grpc",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match:          "/foo",
+				MaxGRPCTimeout: "ten",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir19 is invalid because its request timeout is not a duration
+	ir19 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "timeout",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					Request: "peanut",
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir20 is invalid because its perTry timeout exceeds its request timeout
+	ir20 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "timeout",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					Request: "10s",
+					PerTry:  "20s",
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir21 is valid because its request timeout is infinite
+	ir21 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "timeout",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					Request: "infinity",
+					PerTry:  "20s",
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir22 is invalid because its route configures an unknown filter
+	ir22 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "filters",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				PerFilterConfig: map[string]ingressroutev1.FilterConfig{
+					"envoy.lua": {Disabled: true},
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir23 is invalid because its virtual host sets a stage on ext_authz
+	ir23 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "filters",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+				PerFilterConfig: map[string]ingressroutev1.FilterConfig{
+					"envoy.ext_authz": {Stage: 1},
+				},
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir24 is valid, disabling ext_authz for its virtual host
+	// and overriding the rate limit stage for its route
+	ir24 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "filters",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+				PerFilterConfig: map[string]ingressroutev1.FilterConfig{
+					"envoy.ext_authz": {Disabled: true},
+				},
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				PerFilterConfig: map[string]ingressroutev1.FilterConfig{
+					"envoy.rate_limit": {Stage: 2},
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	// ir25 is invalid because it has two routes matching /api
+	ir25 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "duplicate",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/api",
 				Services: []ingressroutev1.Service{{
-					Name: "home",
+					Name: "foo",
 					Port: 8080,
 				}},
 			}, {
-				Match: "/finance/stocks",
-				Delegate: ingressroutev1.Delegate{
-					Name:      "example-com",
-					Namespace: "default",
-				},
+				Match: "/api",
+				Services: []ingressroutev1.Service{{
+					Name: "bar",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
 
-	var b Builder
-	b.Insert(ir2)
-	b.Insert(ir1)
-	dag := b.Build()
-
-	got := make(map[hostport]*VirtualHost)
-	dag.Visit(func(v Vertex) {
-		if v, ok := v.(*VirtualHost); ok {
-			got[hostport{host: v.FQDN(), port: v.Port}] = v
-		}
-	})
-
-	want := make(map[hostport]*VirtualHost)
-	want[hostport{host: "example.com", port: 80}] = &VirtualHost{
-		Port:   80,
-		host:   "example.com",
-		routes: routemap(&Route{path: "/finance", Object: ir2}),
-	}
-
-	if !reflect.DeepEqual(want, got) {
-		t.Fatal("expected:\n", want, "\ngot:\n", got)
-	}
-}
-
-func TestDAGIngressRouteCycleSelfEdge(t *testing.T) {
-	ir1 := &ingressroutev1.IngressRoute{
+	// ir26 is valid, its prefixes overlap but are distinct
+	ir26 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "default",
-			Name:      "example-com",
+			Namespace: "roots",
+			Name:      "distinct",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/finance",
-				Delegate: ingressroutev1.Delegate{
-					Name:      "example-com",
-					Namespace: "default",
-				},
+				Match: "/api",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}, {
+				Match: "/api/v1",
+				Services: []ingressroutev1.Service{{
+					Name: "bar",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
 
-	var b Builder
-	b.Insert(ir1)
-	dag := b.Build()
-
-	got := make(map[hostport]*VirtualHost)
-	dag.Visit(func(v Vertex) {
-		if v, ok := v.(*VirtualHost); ok {
-			got[hostport{host: v.FQDN(), port: v.Port}] = v
-		}
-	})
-
-	want := make(map[hostport]*VirtualHost)
-
-	if !reflect.DeepEqual(want, got) {
-		t.Fatal("expected:\n", want, "\ngot:\n", got)
-	}
-}
-
-func TestDAGIngressRouteDelegatesToNonExistent(t *testing.T) {
-	ir1 := &ingressroutev1.IngressRoute{
+	// ir27 is invalid because its request body size limit is negative
+	ir27 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "default",
-			Name:      "example-com",
+			Namespace: "roots",
+			Name:      "body",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/finance",
-				Delegate: ingressroutev1.Delegate{
-					Name:      "non-existent",
-					Namespace: "non-existent",
-				},
+				Match:           "/upload",
+				MaxRequestBytes: -1,
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
 
-	var b Builder
-	b.Insert(ir1)
-	dag := b.Build()
-
-	got := make(map[hostport]*VirtualHost)
-	dag.Visit(func(v Vertex) {
-		if v, ok := v.(*VirtualHost); ok {
-			got[hostport{host: v.FQDN(), port: v.Port}] = v
-		}
-	})
-
-	want := make(map[hostport]*VirtualHost)
-
-	if !reflect.DeepEqual(want, got) {
-		t.Fatal("expected:\n", want, "\ngot:\n", got)
-	}
-}
-
-func TestDAGIngressRouteDelegatePrefixDoesntMatch(t *testing.T) {
-	ir1 := &ingressroutev1.IngressRoute{
+	// ir28 is invalid because its route's CORS policy allows no origins
+	ir28 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "default",
-			Name:      "example-com",
+			Namespace: "roots",
+			Name:      "cors",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/finance",
-				Delegate: ingressroutev1.Delegate{
-					Name:      "finance-root",
-					Namespace: "finance",
+				Match: "/foo",
+				CorsPolicy: &ingressroutev1.CorsPolicy{
+					AllowMethods: []string{"GET"},
 				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
-	ir2 := &ingressroutev1.IngressRoute{
+
+	// ir29 is invalid because its virtual host's CORS policy has an invalid max age
+	ir29 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "finance",
-			Name:      "finance-root",
+			Namespace: "roots",
+			Name:      "cors",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+				CorsPolicy: &ingressroutev1.CorsPolicy{
+					AllowOrigin: []string{"*"},
+					MaxAge:      "-10m",
+				},
+			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/prefixDoesntMatch",
+				Match: "/foo",
 				Services: []ingressroutev1.Service{{
-					Name: "home",
+					Name: "foo",
+					Port: 8080,
 				}},
 			}},
 		},
 	}
 
-	var b Builder
-	b.Insert(ir2)
-	b.Insert(ir1)
-	dag := b.Build()
-
-	got := make(map[hostport]*VirtualHost)
-	dag.Visit(func(v Vertex) {
-		if v, ok := v.(*VirtualHost); ok {
-			got[hostport{host: v.FQDN(), port: v.Port}] = v
-		}
-	})
-
-	want := make(map[hostport]*VirtualHost)
-
-	if !reflect.DeepEqual(want, got) {
-		t.Fatal("expected:\n", want, "\ngot:\n", got)
-	}
-}
-func TestDAGRootNamespaces(t *testing.T) {
-	ir1 := &ingressroutev1.IngressRoute{
+	// ir30 is invalid because its minimum ring size exceeds Envoy's limit
+	ir30 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "example-com",
-			Namespace: "allowed1",
+			Namespace: "roots",
+			Name:      "ringhash",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/",
+				Match: "/foo",
 				Services: []ingressroutev1.Service{{
-					Name: "kuard",
-					Port: 8080,
+					Name:            "foo",
+					Port:            8080,
+					Strategy:        "RingHash",
+					MinimumRingSize: 8388609,
 				}},
 			}},
 		},
 	}
 
-	// ir2 is like ir1, but in a different namespace
-	ir2 := &ingressroutev1.IngressRoute{
+	// ir32 is invalid because its route does not enable websockets
+	ir32 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "example-com",
-			Namespace: "allowed2",
+			Namespace: "roots",
+			Name:      "websocket",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
-				Fqdn: "example2.com",
+				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/",
+				Match: "/ws",
+				WebsocketPolicy: &ingressroutev1.WebsocketPolicy{
+					Idle: "5m",
+				},
 				Services: []ingressroutev1.Service{{
-					Name: "kuard",
+					Name: "foo",
 					Port: 8080,
 				}},
 			}},
 		},
 	}
 
-	tests := map[string]struct {
-		rootNamespaces []string
-		objs           []interface{}
-		want           int
-	}{
-		"nil root namespaces": {
-			objs: []interface{}{ir1},
-			want: 1,
-		},
-		"empty root namespaces": {
-			objs: []interface{}{ir1},
-			want: 1,
-		},
-		"single root namespace with root ingressroute": {
-			rootNamespaces: []string{"allowed1"},
-			objs:           []interface{}{ir1},
-			want:           1,
-		},
-		"multiple root namespaces, one with a root ingressroute": {
-			rootNamespaces: []string{"foo", "allowed1", "bar"},
-			objs:           []interface{}{ir1},
-			want:           1,
-		},
-		"multiple root namespaces, each with a root ingressroute": {
-			rootNamespaces: []string{"foo", "allowed1", "allowed2"},
-			objs:           []interface{}{ir1, ir2},
-			want:           2,
-		},
-		"root ingressroute defined outside single root namespaces": {
-			rootNamespaces: []string{"foo"},
-			objs:           []interface{}{ir1},
-			want:           0,
-		},
-		"root ingressroute defined outside multiple root namespaces": {
-			rootNamespaces: []string{"foo", "bar"},
-			objs:           []interface{}{ir1},
-			want:           0,
-		},
-		"two root ingressroutes, one inside root namespace, one outside": {
-			rootNamespaces: []string{"foo", "allowed2"},
-			objs:           []interface{}{ir1, ir2},
-			want:           1,
+	// ir33 is invalid because its websocket idle timeout is not a duration
+	ir33 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "websocket",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match:            "/ws",
+				EnableWebsockets: true,
+				WebsocketPolicy: &ingressroutev1.WebsocketPolicy{
+					Idle: "peanut",
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
 		},
 	}
 
-	for name, tc := range tests {
-		t.Run(name, func(t *testing.T) {
-			b := Builder{
-				KubernetesCache: KubernetesCache{
-					IngressRouteRootNamespaces: tc.rootNamespaces,
-				},
-			}
-			for _, o := range tc.objs {
-				b.Insert(o)
-			}
-			dag := b.Build()
-
-			var count int
-			dag.Visit(func(v Vertex) {
-				if _, ok := v.(*VirtualHost); ok {
-					count++
-				}
-			})
-
-			if tc.want != count {
-				t.Errorf("wanted %d vertices, but got %d", tc.want, count)
-			}
-		})
-	}
-}
-
-func TestDAGIngressRouteDelegatePrefixMatchesStringPrefixButNotPathPrefix(t *testing.T) {
-	ir1 := &ingressroutev1.IngressRoute{
+	// ir34 is invalid because its max connection duration and request timeout are both finite
+	ir34 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "default",
-			Name:      "example-com",
+			Namespace: "roots",
+			Name:      "websocket",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
-				Delegate: ingressroutev1.Delegate{
-					Name:      "finance-root",
-					Namespace: "finance",
+				Match:            "/ws",
+				EnableWebsockets: true,
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					Request: "10s",
+				},
+				WebsocketPolicy: &ingressroutev1.WebsocketPolicy{
+					MaxConnectionDuration: "1h",
 				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
-	ir2 := &ingressroutev1.IngressRoute{
+
+	// ir35 is valid because its request timeout is infinite
+	ir35 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "finance",
-			Name:      "finance-root",
+			Namespace: "roots",
+			Name:      "websocket",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foobar",
-				Services: []ingressroutev1.Service{{
-					Name: "home",
-				}},
-			}, {
-				Match: "/foo/bar",
+				Match:            "/ws",
+				EnableWebsockets: true,
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					Request: "infinity",
+				},
+				WebsocketPolicy: &ingressroutev1.WebsocketPolicy{
+					Idle:                  "5m",
+					MaxConnectionDuration: "1h",
+				},
 				Services: []ingressroutev1.Service{{
-					Name: "home",
+					Name: "foo",
+					Port: 8080,
 				}},
 			}},
 		},
 	}
 
-	var b Builder
-	b.Insert(ir2)
-	b.Insert(ir1)
-	dag := b.Build()
-
-	got := make(map[hostport]*VirtualHost)
-	dag.Visit(func(v Vertex) {
-		if v, ok := v.(*VirtualHost); ok {
-			got[hostport{host: v.FQDN(), port: v.Port}] = v
-		}
-	})
-
-	want := make(map[hostport]*VirtualHost)
-
-	if !reflect.DeepEqual(want, got) {
-		t.Fatal("expected:\n", want, "\ngot:\n", got)
-	}
-}
-
-func TestMatchesPathPrefix(t *testing.T) {
-	tests := map[string]struct {
-		path    string
-		prefix  string
-		matches bool
-	}{
-		"no path cannot match the prefix": {
-			prefix:  "/foo",
-			path:    "",
-			matches: false,
-		},
-		"any path has the empty string as the prefix": {
-			prefix:  "",
-			path:    "/foo",
-			matches: true,
-		},
-		"strict match": {
-			prefix:  "/foo",
-			path:    "/foo",
-			matches: true,
-		},
-		"strict match with / at the end": {
-			prefix:  "/foo/",
-			path:    "/foo/",
-			matches: true,
-		},
-		"no match": {
-			prefix:  "/foo",
-			path:    "/bar",
-			matches: false,
-		},
-		"string prefix match should not match": {
-			prefix:  "/foo",
-			path:    "/foobar",
-			matches: false,
-		},
-		"prefix match": {
-			prefix:  "/foo",
-			path:    "/foo/bar",
-			matches: true,
+	// ir36 is invalid because its header regex does not compile
+	ir36 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "headers",
 		},
-		"prefix match with trailing slash in prefix": {
-			prefix:  "/foo/",
-			path:    "/foo/bar",
-			matches: true,
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Headers: []ingressroutev1.HeaderMatch{{
+					Name:  "x-version",
+					Regex: "v(1",
+				}},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
 		},
-		"prefix match with trailing slash in path": {
-			prefix:  "/foo",
-			path:    "/foo/bar/",
-			matches: true,
+	}
+
+	// ir37 is invalid because its header range is empty
+	ir37 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "headers",
 		},
-		"prefix match with trailing slashes": {
-			prefix:  "/foo/",
-			path:    "/foo/bar/",
-			matches: true,
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Headers: []ingressroutev1.HeaderMatch{{
+					Name: "x-version",
+					Range: &ingressroutev1.HeaderRange{
+						Start: 3,
+						End:   2,
+					},
+				}},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
 		},
-		"prefix match two levels": {
-			prefix:  "/foo/bar",
-			path:    "/foo/bar",
-			matches: true,
+	}
+
+	// ir38 is invalid because its header match sets two matchers
+	ir38 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "headers",
 		},
-		"prefix match two levels trailing slash in prefix": {
-			prefix:  "/foo/bar/",
-			path:    "/foo/bar",
-			matches: true,
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Headers: []ingressroutev1.HeaderMatch{{
+					Name:    "x-version",
+					Exact:   "2",
+					Present: true,
+				}},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
 		},
-		"prefix match two levels trailing slash in path": {
-			prefix:  "/foo/bar",
-			path:    "/foo/bar/",
-			matches: true,
+	}
+
+	// ir41 is invalid because its visibility is unknown
+	ir41 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "visibility",
 		},
-		"no match two levels": {
-			prefix:  "/foo/bar",
-			path:    "/foo/baz",
-			matches: false,
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn:       "example.com",
+				Visibility: "private",
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
 		},
 	}
 
-	for name, tc := range tests {
-		t.Run(name, func(t *testing.T) {
-			got := matchesPathPrefix(tc.path, tc.prefix)
-			if got != tc.matches {
-				t.Errorf("expected %v but got %v", tc.matches, got)
-			}
-		})
+	// ir42 is invalid because an internal virtual host cannot have tls
+	ir42 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "visibility",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn:       "example.com",
+				Visibility: "internal",
+				TLS: &ingressroutev1.TLS{
+					SecretName: "secret",
+				},
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
+			}},
+		},
 	}
-}
 
-func TestDAGIngressRouteStatus(t *testing.T) {
-	// ir1 is a valid ingressroute
-	ir1 := &ingressroutev1.IngressRoute{
+	// ir43 is invalid because a tls virtual host cannot be the wildcard
+	ir43 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "example",
+			Name:      "wildcard-tls",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
-				Fqdn: "example.com",
+				Fqdn: "*",
+				TLS: &ingressroutev1.TLS{
+					SecretName: "secret",
+				},
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
+				Match: "/",
 				Services: []ingressroutev1.Service{{
-					Name: "home",
+					Name: "foo",
 					Port: 8080,
 				}},
-			}, {
-				Match: "/prefix",
-				Delegate: ingressroutev1.Delegate{
-					Name: "delegated",
-				}},
-			},
+			}},
 		},
 	}
 
-	// ir2 is invalid because it contains a service with negative port
-	ir2 := &ingressroutev1.IngressRoute{
+	// ir44 is invalid because a tls virtual host must specify an fqdn
+	ir44 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "example",
+			Name:      "empty-tls",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
-				Fqdn: "example.com",
+				TLS: &ingressroutev1.TLS{
+					SecretName: "secret",
+				},
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
+				Match: "/",
 				Services: []ingressroutev1.Service{{
-					Name: "home",
-					Port: -80,
+					Name: "foo",
+					Port: 8080,
 				}},
 			}},
 		},
 	}
 
-	// ir3 is invalid because it lives outside the roots namespace
-	ir3 := &ingressroutev1.IngressRoute{
+	// ir45 is invalid because its fallback has both a service and a status
+	ir45 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: "finance",
-			Name:      "example",
+			Namespace: "roots",
+			Name:      "fallback",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foobar",
+				Match: "/",
 				Services: []ingressroutev1.Service{{
-					Name: "home",
+					Name: "foo",
 					Port: 8080,
 				}},
+				Fallback: &ingressroutev1.Fallback{
+					Service: &ingressroutev1.Service{
+						Name: "maintenance",
+						Port: 8080,
+					},
+					Status: 503,
+				},
 			}},
 		},
 	}
 
-	// ir4 is invalid because its match prefix does not match its parent's (ir1)
-	ir4 := &ingressroutev1.IngressRoute{
+	// ir46 is invalid because its decorator has no operation
+	ir46 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "delegated",
+			Name:      "decorator",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/doesnotmatch",
+				Match: "/",
 				Services: []ingressroutev1.Service{{
-					Name: "home",
+					Name: "foo",
 					Port: 8080,
 				}},
+				Decorator: &ingressroutev1.Decorator{},
 			}},
 		},
 	}
 
-	// ir5 is invalid because its service weight is less than zero
-	ir5 := &ingressroutev1.IngressRoute{
+	// ir47's regex route is skipped as its bracket is unbalanced, its
+	// other routes are served
+	ir47 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "delegated",
+			Name:      "regex",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
+				Match:     "/foo/[bar",
+				MatchType: "Regex",
 				Services: []ingressroutev1.Service{{
-					Name:   "home",
-					Port:   8080,
-					Weight: -10,
+					Name: "foo",
+					Port: 8080,
+				}},
+			}, {
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
 				}},
 			}},
 		},
 	}
 
-	// ir6 is invalid because it delegates to itself, producing a cycle
-	ir6 := &ingressroutev1.IngressRoute{
+	// ir48 is invalid because it buffers retries without a retry policy
+	ir48 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "self",
+			Name:      "retrybuffer",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
-				Delegate: ingressroutev1.Delegate{
-					Name: "self",
+				Match: "/upload",
+				RetryBuffer: &ingressroutev1.RetryBuffer{
+					MaxBytes: 64 << 10,
 				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
 
-	// ir7 delegates to ir8, which is invalid because it delegates back to ir7
-	ir7 := &ingressroutev1.IngressRoute{
+	// ir49 is invalid because its retry buffer exceeds the default cap
+	ir49 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "parent",
+			Name:      "retrybuffer",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
-				Delegate: ingressroutev1.Delegate{
-					Name: "child",
+				Match: "/upload",
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					PerTry: "1s",
 				},
+				RetryBuffer: &ingressroutev1.RetryBuffer{
+					MaxBytes: 2 << 20,
+				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
 
-	ir8 := &ingressroutev1.IngressRoute{
+	// ir50 buffers its retried requests
+	ir50 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "child",
+			Name:      "retrybuffer",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
-				Delegate: ingressroutev1.Delegate{
-					Name: "parent",
+				Match: "/upload",
+				TimeoutPolicy: &ingressroutev1.TimeoutPolicy{
+					PerTry: "1s",
+				},
+				RetryBuffer: &ingressroutev1.RetryBuffer{
+					MaxBytes: 64 << 10,
 				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
 
-	// ir9 is invalid because it has a route that both delegates and has a list of services
-	ir9 := &ingressroutev1.IngressRoute{
+	// ir51 is invalid because its metadata match has an empty key
+	ir51 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "parent",
+			Name:      "metadatamatch",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
-				Delegate: ingressroutev1.Delegate{
-					Name: "child",
+				Match: "/",
+				MetadataMatch: map[string]string{
+					"": "v1",
 				},
 				Services: []ingressroutev1.Service{{
-					Name: "kuard",
+					Name: "foo",
 					Port: 8080,
 				}},
 			}},
 		},
 	}
 
-	// ir10 delegates to ir11 and ir 12.
-	ir10 := &ingressroutev1.IngressRoute{
+	// ir52 is valid because its routes with the same match have
+	// different header matches
+	ir52 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "parent",
+			Name:      "headers",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
 			VirtualHost: &ingressroutev1.VirtualHost{
 				Fqdn: "example.com",
 			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
-				Delegate: ingressroutev1.Delegate{
-					Name: "validChild",
-				},
+				Match: "/",
+				Headers: []ingressroutev1.HeaderMatch{{
+					Name:  "x-channel",
+					Exact: "beta",
+				}},
+				Services: []ingressroutev1.Service{{
+					Name: "beta",
+					Port: 8080,
+				}},
 			}, {
-				Match: "/bar",
-				Delegate: ingressroutev1.Delegate{
-					Name: "invalidChild",
-				},
+				Match: "/",
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
 
-	ir11 := &ingressroutev1.IngressRoute{
+	// ir53 is invalid because its routes have the same match and
+	// the same header matches, in a different order
+	ir53 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "validChild",
+			Name:      "headers",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/foo",
+				Match: "/",
+				Headers: []ingressroutev1.HeaderMatch{{
+					Name:  "x-channel",
+					Exact: "beta",
+				}, {
+					Name:    "x-debug",
+					Present: true,
+				}},
+				Services: []ingressroutev1.Service{{
+					Name: "beta",
+					Port: 8080,
+				}},
+			}, {
+				Match: "/",
+				Headers: []ingressroutev1.HeaderMatch{{
+					Name:    "X-Debug",
+					Present: true,
+				}, {
+					Name:  "x-channel",
+					Exact: "beta",
+				}},
 				Services: []ingressroutev1.Service{{
 					Name: "foo",
 					Port: 8080,
@@ -3728,32 +6431,46 @@ func TestDAGIngressRouteStatus(t *testing.T) {
 		},
 	}
 
-	// ir12 is invalid because it contains an invalid port
-	ir12 := &ingressroutev1.IngressRoute{
+	// ir54 is valid, but there is no internal listener to serve it
+	ir54 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "invalidChild",
+			Name:      "internal",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn:       "example.com",
+				Visibility: "internal",
+			},
 			Routes: []ingressroutev1.Route{{
-				Match: "/bar",
+				Match: "/",
 				Services: []ingressroutev1.Service{{
 					Name: "foo",
-					Port: 12345678,
+					Port: 8080,
 				}},
 			}},
 		},
 	}
 
-	// ir13 is invalid because it does not specify and FQDN
-	ir13 := &ingressroutev1.IngressRoute{
+	// ir55 is valid because its routes with the same match have
+	// different match types
+	ir55 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "parent",
+			Name:      "match-types",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
-			VirtualHost: &ingressroutev1.VirtualHost{},
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
 			Routes: []ingressroutev1.Route{{
+				Match:     "/foo",
+				MatchType: "Regex",
+				Services: []ingressroutev1.Service{{
+					Name: "exact",
+					Port: 8080,
+				}},
+			}, {
 				Match: "/foo",
 				Services: []ingressroutev1.Service{{
 					Name: "foo",
@@ -3763,19 +6480,30 @@ func TestDAGIngressRouteStatus(t *testing.T) {
 		},
 	}
 
-	// ir14 delegates tp ir15 but it is invalid because it is missing fqdn
-	ir14 := &ingressroutev1.IngressRoute{
+	// ir56 is valid because its routes with the same match have
+	// different case sensitivity
+	ir56 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: "roots",
-			Name:      "invalidParent",
+			Name:      "case-sensitivity",
 		},
 		Spec: ingressroutev1.IngressRouteSpec{
-			VirtualHost: &ingressroutev1.VirtualHost{},
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+			},
 			Routes: []ingressroutev1.Route{{
+				Match:         "/foo",
+				CaseSensitive: new(bool), // false
+				Services: []ingressroutev1.Service{{
+					Name: "anycase",
+					Port: 8080,
+				}},
+			}, {
 				Match: "/foo",
-				Delegate: ingressroutev1.Delegate{
-					Name: "validChild",
-				},
+				Services: []ingressroutev1.Service{{
+					Name: "foo",
+					Port: 8080,
+				}},
 			}},
 		},
 	}
@@ -3853,6 +6581,162 @@ func TestDAGIngressRouteStatus(t *testing.T) {
 				{Object: ir10, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"},
 			},
 		},
+		"regex match does not compile": {
+			objs: []*ingressroutev1.IngressRoute{ir15},
+			want: []Status{{Object: ir15, Status: "valid", Description: "valid IngressRoute, route \"/foo/(bar\": invalid regex: error parsing regexp: missing closing ): `/foo/(bar`, route skipped", Vhost: "example.com"}},
+		},
+		"unknown match type": {
+			objs: []*ingressroutev1.IngressRoute{ir16},
+			want: []Status{{Object: ir16, Status: "invalid", Description: `route "/foo": matchType "Glob" must be one of Prefix or Regex`, Vhost: "example.com"}},
+		},
+		"valid regex match": {
+			objs: []*ingressroutev1.IngressRoute{ir17},
+			want: []Status{{Object: ir17, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"invalid max grpc timeout": {
+			objs: []*ingressroutev1.IngressRoute{ir18},
+			want: []Status{{Object: ir18, Status: "invalid", Description: `route "/foo": invalid maxGrpcTimeout: time: invalid duration ten`, Vhost: "example.com"}},
+		},
+		"invalid request timeout": {
+			objs: []*ingressroutev1.IngressRoute{ir19},
+			want: []Status{{Object: ir19, Status: "invalid", Description: `route "/foo": timeoutPolicy: invalid request timeout: time: invalid duration peanut`, Vhost: "example.com"}},
+		},
+		"perTry timeout exceeds request timeout": {
+			objs: []*ingressroutev1.IngressRoute{ir20},
+			want: []Status{{Object: ir20, Status: "invalid", Description: `route "/foo": timeoutPolicy: perTry timeout "20s" must not exceed request timeout "10s"`, Vhost: "example.com"}},
+		},
+		"perTry timeout with infinite request timeout": {
+			objs: []*ingressroutev1.IngressRoute{ir21},
+			want: []Status{{Object: ir21, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"unknown route filter": {
+			objs: []*ingressroutev1.IngressRoute{ir22},
+			want: []Status{{Object: ir22, Status: "invalid", Description: `route "/foo": perFilterConfig: unknown filter "envoy.lua", must be one of envoy.ext_authz or envoy.rate_limit`, Vhost: "example.com"}},
+		},
+		"unsupported virtual host filter field": {
+			objs: []*ingressroutev1.IngressRoute{ir23},
+			want: []Status{{Object: ir23, Status: "invalid", Description: `perFilterConfig: filter "envoy.ext_authz" does not support stage`, Vhost: "example.com"}},
+		},
+		"valid per filter config": {
+			objs: []*ingressroutev1.IngressRoute{ir24},
+			want: []Status{{Object: ir24, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"duplicate route match": {
+			objs: []*ingressroutev1.IngressRoute{ir25},
+			want: []Status{{Object: ir25, Status: "invalid", Description: `route "/api": duplicate match`, Vhost: "example.com"}},
+		},
+		"distinct route matches": {
+			objs: []*ingressroutev1.IngressRoute{ir26},
+			want: []Status{{Object: ir26, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"negative max request bytes": {
+			objs: []*ingressroutev1.IngressRoute{ir27},
+			want: []Status{{Object: ir27, Status: "invalid", Description: `route "/upload": maxRequestBytes must be in the range 0-4294967295`, Vhost: "example.com"}},
+		},
+		"retry buffer without retry policy": {
+			objs: []*ingressroutev1.IngressRoute{ir48},
+			want: []Status{{Object: ir48, Status: "invalid", Description: `route "/upload": retryBuffer requires a retry policy, set a finite timeoutPolicy perTry timeout`, Vhost: "example.com"}},
+		},
+		"retry buffer above the cap": {
+			objs: []*ingressroutev1.IngressRoute{ir49},
+			want: []Status{{Object: ir49, Status: "invalid", Description: `route "/upload": retryBuffer: maxBytes must be in the range 1-1048576`, Vhost: "example.com"}},
+		},
+		"retry buffer with retry policy": {
+			objs: []*ingressroutev1.IngressRoute{ir50},
+			want: []Status{{Object: ir50, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"metadata match with an empty key": {
+			objs: []*ingressroutev1.IngressRoute{ir51},
+			want: []Status{{Object: ir51, Status: "invalid", Description: `route "/": metadataMatch: keys must not be empty`, Vhost: "example.com"}},
+		},
+		"route cors policy without origins": {
+			objs: []*ingressroutev1.IngressRoute{ir28},
+			want: []Status{{Object: ir28, Status: "invalid", Description: `route "/foo": corsPolicy: allowOrigin must contain at least one origin`, Vhost: "example.com"}},
+		},
+		"virtual host cors policy with negative max age": {
+			objs: []*ingressroutev1.IngressRoute{ir29},
+			want: []Status{{Object: ir29, Status: "invalid", Description: `corsPolicy: maxAge "-10m" must not be negative`, Vhost: "example.com"}},
+		},
+		"ring size too large": {
+			objs: []*ingressroutev1.IngressRoute{ir30},
+			want: []Status{{Object: ir30, Status: "invalid", Description: `route "/foo": service "foo": minimumRingSize must be in the range 0-8388608`, Vhost: "example.com"}},
+		},
+		"websocket policy without websockets": {
+			objs: []*ingressroutev1.IngressRoute{ir32},
+			want: []Status{{Object: ir32, Status: "invalid", Description: `route "/ws": websocketPolicy requires enableWebsockets`, Vhost: "example.com"}},
+		},
+		"invalid websocket idle timeout": {
+			objs: []*ingressroutev1.IngressRoute{ir33},
+			want: []Status{{Object: ir33, Status: "invalid", Description: `route "/ws": websocketPolicy: invalid idle timeout: time: invalid duration peanut`, Vhost: "example.com"}},
+		},
+		"max connection duration with finite request timeout": {
+			objs: []*ingressroutev1.IngressRoute{ir34},
+			want: []Status{{Object: ir34, Status: "invalid", Description: `route "/ws": websocketPolicy: maxConnectionDuration cannot be combined with a finite request timeout`, Vhost: "example.com"}},
+		},
+		"max connection duration with infinite request timeout": {
+			objs: []*ingressroutev1.IngressRoute{ir35},
+			want: []Status{{Object: ir35, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"invalid header regex": {
+			objs: []*ingressroutev1.IngressRoute{ir36},
+			want: []Status{{Object: ir36, Status: "invalid", Description: "route \"/\": header \"x-version\": invalid regex: error parsing regexp: missing closing ): `v(1`", Vhost: "example.com"}},
+		},
+		"empty header range": {
+			objs: []*ingressroutev1.IngressRoute{ir37},
+			want: []Status{{Object: ir37, Status: "invalid", Description: `route "/": header "x-version": range start 3 must be less than end 2`, Vhost: "example.com"}},
+		},
+		"header match with two matchers": {
+			objs: []*ingressroutev1.IngressRoute{ir38},
+			want: []Status{{Object: ir38, Status: "invalid", Description: `route "/": header "x-version": exactly one of exact, present, regex or range is required`, Vhost: "example.com"}},
+		},
+		"same match with different header matches": {
+			objs: []*ingressroutev1.IngressRoute{ir52},
+			want: []Status{{Object: ir52, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"same match with the same header matches": {
+			objs: []*ingressroutev1.IngressRoute{ir53},
+			want: []Status{{Object: ir53, Status: "invalid", Description: `route "/": duplicate match`, Vhost: "example.com"}},
+		},
+		"same match with different match types": {
+			objs: []*ingressroutev1.IngressRoute{ir55},
+			want: []Status{{Object: ir55, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"same match with different case sensitivity": {
+			objs: []*ingressroutev1.IngressRoute{ir56},
+			want: []Status{{Object: ir56, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"unknown visibility": {
+			objs: []*ingressroutev1.IngressRoute{ir41},
+			want: []Status{{Object: ir41, Status: "invalid", Description: `visibility "private" must be one of public or internal`, Vhost: "example.com"}},
+		},
+		"internal visibility with tls": {
+			objs: []*ingressroutev1.IngressRoute{ir42},
+			want: []Status{{Object: ir42, Status: "invalid", Description: "visibility: internal virtual hosts do not support tls", Vhost: "example.com"}},
+		},
+		"internal visibility without an internal listener": {
+			objs: []*ingressroutev1.IngressRoute{ir54},
+			want: []Status{{Object: ir54, Status: "valid", Description: "valid IngressRoute, visibility: internal, but there is no internal listener, virtual host not served", Vhost: "example.com"}},
+		},
+		"wildcard fqdn with tls": {
+			objs: []*ingressroutev1.IngressRoute{ir43},
+			want: []Status{{Object: ir43, Status: "invalid", Description: "Spec.VirtualHost.Fqdn must not be a wildcard when tls is enabled", Vhost: "*"}},
+		},
+		"empty fqdn with tls": {
+			objs: []*ingressroutev1.IngressRoute{ir44},
+			want: []Status{{Object: ir44, Status: "invalid", Description: "Spec.VirtualHost.Fqdn must be specified"}},
+		},
+		"fallback with service and status": {
+			objs: []*ingressroutev1.IngressRoute{ir45},
+			want: []Status{{Object: ir45, Status: "invalid", Description: `route "/": fallback: only one of service or status may be specified`, Vhost: "example.com"}},
+		},
+		"decorator without operation": {
+			objs: []*ingressroutev1.IngressRoute{ir46},
+			want: []Status{{Object: ir46, Status: "invalid", Description: `route "/": decorator: operation must be specified`, Vhost: "example.com"}},
+		},
+		"regex match with unbalanced bracket": {
+			objs: []*ingressroutev1.IngressRoute{ir47},
+			want: []Status{{Object: ir47, Status: "valid", Description: "valid IngressRoute, route \"/foo/[bar\": invalid regex: error parsing regexp: missing closing ]: `[bar`, route skipped", Vhost: "example.com"}},
+		},
 	}
 
 	for name, tc := range tests {
@@ -3886,6 +6770,93 @@ func TestDAGIngressRouteStatus(t *testing.T) {
 	}
 }
 
+func TestDAGIngressRouteSecondarySecretStatus(t *testing.T) {
+	ir1 := &ingressroutev1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "example",
+		},
+		Spec: ingressroutev1.IngressRouteSpec{
+			VirtualHost: &ingressroutev1.VirtualHost{
+				Fqdn: "example.com",
+				TLS: &ingressroutev1.TLS{
+					SecretName:          "secret",
+					SecondarySecretName: "secret-ecdsa",
+				},
+			},
+			Routes: []ingressroutev1.Route{{
+				Match: "/foo",
+				Services: []ingressroutev1.Service{{
+					Name: "home",
+					Port: 8080,
+				}},
+			}},
+		},
+	}
+
+	sec1 := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "secret",
+		},
+		Data: secretdata("certificate", "key"),
+	}
+
+	sec2 := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "secret-ecdsa",
+		},
+		Data: secretdata("ecdsa-certificate", "ecdsa-key"),
+	}
+
+	// sec3 is missing its private key
+	sec3 := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "roots",
+			Name:      "secret-ecdsa",
+		},
+		Data: map[string][]byte{
+			v1.TLSCertKey: []byte("ecdsa-certificate"),
+		},
+	}
+
+	tests := map[string]struct {
+		objs []interface{}
+		want []Status
+	}{
+		"both secrets present": {
+			objs: []interface{}{ir1, sec1, sec2},
+			want: []Status{{Object: ir1, Status: "valid", Description: "valid IngressRoute", Vhost: "example.com"}},
+		},
+		"invalid secondary secret": {
+			objs: []interface{}{ir1, sec1, sec3},
+			want: []Status{{Object: ir1, Status: "valid", Description: `valid IngressRoute, secondary secret "secret-ecdsa" is missing or invalid, serving a single certificate`, Vhost: "example.com"}},
+		},
+		"missing primary secret": {
+			objs: []interface{}{ir1, sec2},
+			want: []Status{{Object: ir1, Status: "valid", Description: `valid IngressRoute, secret "secret" is missing or invalid, serving a single certificate`, Vhost: "example.com"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := Builder{
+				KubernetesCache: KubernetesCache{
+					IngressRouteRootNamespaces: []string{"roots"},
+				},
+			}
+			for _, o := range tc.objs {
+				b.Insert(o)
+			}
+			got := b.Build().Statuses()
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected:\n%v\ngot\n%v", tc.want, got)
+			}
+		})
+	}
+}
+
 func TestDAGIngressRouteUniqueFQDNs(t *testing.T) {
 	ir1 := &ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
@@ -4079,7 +7050,7 @@ func TestHttpPaths(t *testing.T) {
 func routemap(routes ...*Route) map[string]*Route {
 	m := make(map[string]*Route)
 	for _, r := range routes {
-		m[r.path] = r
+		m[r.key()] = r
 	}
 	return m
 }
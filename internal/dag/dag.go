@@ -16,6 +16,9 @@
 package dag
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"k8s.io/api/core/v1"
@@ -33,6 +36,13 @@ type DAG struct {
 
 	// status computed while building this dag.
 	statuses []Status
+
+	// warnings about the objects skipped while building this dag.
+	warnings []Warning
+
+	// serviceWarnings about the Service annotations ignored while
+	// building this dag.
+	serviceWarnings []ServiceWarning
 }
 
 // Visit calls fn on each root of this DAG.
@@ -48,11 +58,27 @@ func (d *DAG) Statuses() []Status {
 	return d.statuses
 }
 
+// Warnings returns a slice of Warnings about the objects skipped, or
+// partly ignored, during the computation of this DAG.
+func (d *DAG) Warnings() []Warning {
+	return d.warnings
+}
+
+// ServiceWarnings returns a slice of ServiceWarnings about the Service
+// annotations ignored during the computation of this DAG.
+func (d *DAG) ServiceWarnings() []ServiceWarning {
+	return d.serviceWarnings
+}
+
 type Route struct {
 	path     string
 	Object   interface{} // one of Ingress or IngressRoute
 	services map[portmeta]*Service
 
+	// Regex, if true, indicates the path of this route is a
+	// regular expression rather than a prefix.
+	Regex bool
+
 	// Should this route generate a 301 upgrade if accessed
 	// over HTTP?
 	HTTPSUpgrade bool
@@ -66,10 +92,75 @@ type Route struct {
 	// A timeout of -1 represents "infinity"
 	// TODO(dfc) should this move to service?
 	Timeout time.Duration
+
+	// The maximum grpc-timeout a client may request on this route.
+	// A value of zero implies "use the default", a value of -1
+	// represents "infinity".
+	MaxGRPCTimeout time.Duration
+
+	// The timeout for each upstream attempt.
+	// A timeout of zero or -1 implies no per try timeout.
+	PerTryTimeout time.Duration
+
+	// The timeout for an upgraded websocket connection with no
+	// activity. A timeout of zero implies "use envoy's default"
+	// A timeout of -1 represents "infinity"
+	WebsocketIdleTimeout time.Duration
+
+	// The longest an upgraded websocket connection may remain open.
+	// A value of zero implies "use the request timeout", a value
+	// of -1 represents "infinity".
+	WebsocketMaxConnectionDuration time.Duration
+
+	// DirectResponse, if non zero, is the HTTP status Envoy responds
+	// with directly, rather than proxying to the route's services.
+	DirectResponse int
+
+	// PerFilterConfig overrides the configuration of the
+	// named HTTP filters for this route.
+	PerFilterConfig map[string]ingressroutev1.FilterConfig
+
+	// CorsPolicy, if not nil, overrides the virtual host's
+	// cross origin resource sharing policy for this route.
+	CorsPolicy *ingressroutev1.CorsPolicy
+
+	// MaxRequestBytes is the largest request body accepted
+	// by this route. A value of zero implies no limit.
+	MaxRequestBytes uint32
+
+	// RetryBufferBytes, if non zero, is the largest request body
+	// buffered so that retried requests are replayed in full.
+	RetryBufferBytes uint32
+
+	// MetadataMatch, if not empty, restricts the route's requests to
+	// the endpoints whose load balancing metadata has these values.
+	MetadataMatch map[string]string
+
+	// CaseInsensitive matches the route's prefix or regex
+	// without regard to case.
+	CaseInsensitive bool
+
+	// HeaderMatches are conditions on the request headers, each
+	// of which must be met for a request to match the route.
+	HeaderMatches []ingressroutev1.HeaderMatch
+
+	// DecoratorOperation, if set, is the operation name of the
+	// spans traced for this route.
+	DecoratorOperation string
+
+	// RequestHeadersToAdd maps each of the route's services to the
+	// headers added to the requests this route sends to it. Services
+	// are shared between routes, so the headers are kept here.
+	RequestHeadersToAdd map[*Service]map[string]string
 }
 
 func (r *Route) Prefix() string { return r.path }
 
+// key returns the key of r within its virtual host.
+func (r *Route) key() string {
+	return routeKey(r.path, r.Regex, r.CaseInsensitive, r.HeaderMatches)
+}
+
 func (r *Route) addService(s *Service, hc *ingressroutev1.HealthCheck, lbStrat string, weight int) {
 	if r.services == nil {
 		r.services = make(map[portmeta]*Service)
@@ -93,6 +184,39 @@ type VirtualHost struct {
 	// if the VirtualHost is generated inside Contour.
 	Port int
 
+	// Default is true if this VirtualHost was generated by Contour
+	// as the catch-all for hosts which no Ingress claims.
+	Default bool
+
+	// PerFilterConfig overrides the configuration of the
+	// named HTTP filters for this virtual host.
+	PerFilterConfig map[string]ingressroutev1.FilterConfig
+
+	// CorsPolicy is the default cross origin resource
+	// sharing policy for the routes of this virtual host.
+	CorsPolicy *ingressroutev1.CorsPolicy
+
+	// ForwardingHeaders, if not nil, overrides the default
+	// choice of whether forwarding headers are added to the
+	// requests proxied for this virtual host.
+	ForwardingHeaders *bool
+
+	// Internal, if true, restricts this virtual host to the
+	// internal HTTP listener.
+	Internal bool
+
+	// CaseInsensitive, if true, matches every route of this
+	// virtual host without regard to case.
+	CaseInsensitive bool
+
+	// AccessLogExcludePaths are the request paths, eg. a health
+	// check, which are not access logged for this virtual host.
+	AccessLogExcludePaths []string
+
+	// AccessLogSampleRate, if not zero, access logs one in this many
+	// requests to this virtual host, overriding the listener's rate.
+	AccessLogSampleRate uint32
+
 	host    string
 	aliases []string
 	routes  map[string]*Route
@@ -102,10 +226,9 @@ func (v *VirtualHost) FQDN() string { return v.host }
 
 func (v *VirtualHost) Aliases() []string { return v.aliases }
 
+// Visit calls f for each route of the virtual host, longest prefix first.
 func (v *VirtualHost) Visit(f func(Vertex)) {
-	for _, r := range v.routes {
-		f(r)
-	}
+	visitRoutes(v.routes, f)
 }
 
 // A SecureVirtualHost represents a HTTP host protected by TLS.
@@ -118,10 +241,43 @@ type SecureVirtualHost struct {
 	// TLS minimum protocol version. Defaults to auth.TlsParameters_TLS_AUTO
 	MinProtoVersion auth.TlsParameters_TlsProtocol
 
+	// PerFilterConfig overrides the configuration of the
+	// named HTTP filters for this virtual host.
+	PerFilterConfig map[string]ingressroutev1.FilterConfig
+
+	// CorsPolicy is the default cross origin resource
+	// sharing policy for the routes of this virtual host.
+	CorsPolicy *ingressroutev1.CorsPolicy
+
+	// ForwardingHeaders, if not nil, overrides the default
+	// choice of whether forwarding headers are added to the
+	// requests proxied for this virtual host.
+	ForwardingHeaders *bool
+
+	// CaseInsensitive, if true, matches every route of this
+	// virtual host without regard to case.
+	CaseInsensitive bool
+
+	// AccessLogExcludePaths are the request paths, eg. a health
+	// check, which are not access logged for this virtual host.
+	AccessLogExcludePaths []string
+
+	// AccessLogSampleRate, if not zero, access logs one in this many
+	// requests to this virtual host, overriding the listener's rate.
+	AccessLogSampleRate uint32
+
+	// TCPProxy, if set, proxies the TLS connections of this
+	// virtual host to a service in place of its routes.
+	TCPProxy *TCPProxy
+
 	host    string
 	aliases []string
 	routes  map[string]*Route
 	secret  *Secret
+
+	// secondary is an optional second certificate, eg. an ECDSA
+	// certificate served alongside an RSA one.
+	secondary *Secret
 }
 
 func (s *SecureVirtualHost) Data() map[string][]byte {
@@ -131,15 +287,102 @@ func (s *SecureVirtualHost) Data() map[string][]byte {
 	return s.secret.Data()
 }
 
+// SecondaryData returns the contents of the secondary secret's map,
+// or nil if this vhost serves a single certificate.
+func (s *SecureVirtualHost) SecondaryData() map[string][]byte {
+	if s.secondary == nil {
+		return nil
+	}
+	return s.secondary.Data()
+}
+
+// Secret returns the secret of this vhost, or nil if it has none.
+func (s *SecureVirtualHost) Secret() *Secret { return s.secret }
+
+// SecondarySecret returns the secondary secret of this vhost,
+// or nil if it serves a single certificate.
+func (s *SecureVirtualHost) SecondarySecret() *Secret { return s.secondary }
+
 func (s *SecureVirtualHost) FQDN() string { return s.host }
 
 func (s *SecureVirtualHost) Aliases() []string { return s.aliases }
 
 func (s *SecureVirtualHost) Visit(f func(Vertex)) {
-	for _, r := range s.routes {
-		f(r)
+	visitRoutes(s.routes, f)
+	if s.TCPProxy != nil {
+		f(s.TCPProxy)
+	}
+	if s.secret != nil {
+		f(s.secret)
+	}
+	if s.secondary != nil {
+		f(s.secondary)
 	}
-	f(s.secret)
+}
+
+// visitRoutes calls f for each of routes in reverse lexical order of
+// their keys, so every route is visited before any route whose path
+// is a prefix of its own, and of the routes with the same path the
+// more specific, as routeKey orders them, first. The order is a function
+// of the keys alone, not of which Ingress or IngressRoute contributed
+// each route or the order they were processed in.
+func visitRoutes(routes map[string]*Route, f func(Vertex)) {
+	keys := make([]string, 0, len(routes))
+	for key := range routes {
+		keys = append(keys, key)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	for _, key := range keys {
+		f(routes[key])
+	}
+}
+
+// routeKey returns the key of the route with the supplied match, match
+// type, case sensitivity and header matches within its virtual host, so
+// routes which differ in any of them are distinct. The match type, the
+// case sensitivity and the header matches, in name order, follow the
+// match, each after a NUL. A regex sorts after a prefix, a case
+// sensitive match after a case insensitive one, and a route with
+// header matches after the route without, so of the routes with the
+// same match the more specific are visited first.
+func routeKey(match string, regex, caseInsensitive bool, headers []ingressroutev1.HeaderMatch) string {
+	keys := []string{match, "prefix", "sensitive"}
+	if regex {
+		keys[1] = "regex"
+	}
+	if caseInsensitive {
+		keys[2] = "insensitive"
+	}
+	hms := make([]string, 0, len(headers))
+	for _, hm := range headers {
+		name := strings.ToLower(hm.Name)
+		switch {
+		case hm.Exact != "":
+			hms = append(hms, fmt.Sprintf("%s exact %q", name, hm.Exact))
+		case hm.Present:
+			hms = append(hms, fmt.Sprintf("%s present", name))
+		case hm.Regex != "":
+			hms = append(hms, fmt.Sprintf("%s regex %q", name, hm.Regex))
+		case hm.Range != nil:
+			hms = append(hms, fmt.Sprintf("%s range %d %d", name, hm.Range.Start, hm.Range.End))
+		}
+	}
+	sort.Strings(hms)
+	return strings.Join(append(keys, hms...), "\x00")
+}
+
+// TCPProxy represents a TCP proxy, selected by SNI hostname, from a
+// SecureVirtualHost to a Service.
+type TCPProxy struct {
+	// Passthrough, if true, passes the TLS connection through to
+	// the service rather than terminating it.
+	Passthrough bool
+
+	Service *Service
+}
+
+func (t *TCPProxy) Visit(f func(Vertex)) {
+	f(t.Service)
 }
 
 type Visitable interface {
@@ -164,6 +407,10 @@ type Service struct {
 	HealthCheck          *ingressroutev1.HealthCheck
 	LoadBalancerStrategy string
 
+	// MinimumRingSize is the minimum size of the hash ring when
+	// LoadBalancerStrategy is RingHash. Zero means Envoy's default.
+	MinimumRingSize uint64
+
 	// Circuit breaking limits
 
 	// Max connections is maximum number of connections
@@ -181,6 +428,46 @@ type Service struct {
 	// MaxRetries is the maximum number of parallel retries that
 	// Envoy will allow to the upstream cluster.
 	MaxRetries int
+
+	// ConnectTimeout is how long Envoy waits to establish an
+	// upstream connection to this service. A value of zero implies
+	// "use the cluster default".
+	ConnectTimeout time.Duration
+
+	// IdleTimeout is how long an upstream connection to this
+	// service may be idle before it is closed. A value of zero
+	// implies "use envoy's default", -1 represents "infinity".
+	IdleTimeout time.Duration
+
+	// MaxConnectionDuration is the longest an upstream connection
+	// to this service may remain open. A value of zero implies "use
+	// envoy's default", -1 represents "infinity".
+	MaxConnectionDuration time.Duration
+
+	// HTTP2MaxConcurrentStreams, HTTP2InitialStreamWindowSize, and
+	// HTTP2InitialConnectionWindowSize tune the HTTP/2 connections to
+	// a service whose Protocol is h2 or h2c. Zero means Envoy's default.
+	HTTP2MaxConcurrentStreams        uint32
+	HTTP2InitialStreamWindowSize     uint32
+	HTTP2InitialConnectionWindowSize uint32
+
+	// Variant, if not empty, distinguishes this Service from the
+	// others for the same port whose route level settings, eg. a
+	// health check, differ. Each variant is a separate cluster.
+	Variant string
+
+	// DiscoveryType overrides how Envoy discovers the members of the
+	// upstream cluster, one of DiscoveryTypeStrictDNS,
+	// DiscoveryTypeLogicalDNS, or DiscoveryTypeStatic.
+	// If not set, members are discovered with EDS.
+	DiscoveryType string
+
+	// DNSLookupFamily and DNSRefreshRate, if set, override how the
+	// members of a STRICT_DNS or LOGICAL_DNS cluster are resolved.
+	// DNSLookupFamily is one of DNSLookupFamilyAuto, DNSLookupFamilyV4,
+	// or DNSLookupFamilyV6.
+	DNSLookupFamily string
+	DNSRefreshRate  time.Duration
 }
 
 func (s *Service) Name() string       { return s.Object.Name }
@@ -16,26 +16,37 @@
 package debug
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/pprof"
 
 	"github.com/heptio/contour/internal/dag"
-	"github.com/heptio/contour/internal/httpsvc"
 )
 
-// Service serves various http endpoints including /debug/pprof.
+// Service registers various http endpoints including /debug/pprof.
 type Service struct {
-	httpsvc.Service
-
 	*dag.Builder
+
+	// Resync, if not nil, is called on a POST to /debug/resync
+	// to recompute the DAG and re-emit the contents of every cache.
+	Resync func()
+
+	// Status, if not nil, is called on a GET to /debug/status and
+	// its result served as JSON. It must return a copy of any
+	// state it shares, as it is encoded without locks held.
+	Status func() interface{}
 }
 
-// Start fulfills the g.Start contract.
-// When stop is closed the http server will shutdown.
-func (svc *Service) Start(stop <-chan struct{}) error {
-	registerProfile(&svc.ServeMux)
-	registerDotWriter(&svc.ServeMux, svc.Builder)
-	return svc.Service.Start(stop)
+// Register registers the debug endpoints on mux.
+func (svc *Service) Register(mux *http.ServeMux) {
+	registerProfile(mux)
+	registerDotWriter(mux, svc.Builder)
+	if svc.Resync != nil {
+		registerResync(mux, svc.Resync)
+	}
+	if svc.Status != nil {
+		registerStatus(mux, svc.Status)
+	}
 }
 
 func registerProfile(mux *http.ServeMux) {
@@ -58,3 +69,29 @@ func registerDotWriter(mux *http.ServeMux, b *dag.Builder) {
 		dw.writeDot(w)
 	})
 }
+
+func registerResync(mux *http.ServeMux, resync func()) {
+	mux.HandleFunc("/debug/resync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		resync()
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func registerStatus(mux *http.ServeMux, status func() interface{}) {
+	mux.HandleFunc("/debug/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(status())
+	})
+}
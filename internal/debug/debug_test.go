@@ -0,0 +1,86 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResync(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		want   int
+		calls  int
+	}{
+		"post": {
+			method: http.MethodPost,
+			want:   http.StatusAccepted,
+			calls:  1,
+		},
+		"get": {
+			method: http.MethodGet,
+			want:   http.StatusMethodNotAllowed,
+			calls:  0,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			var mux http.ServeMux
+			registerResync(&mux, func() { calls++ })
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tc.method, "/debug/resync", nil))
+			if w.Code != tc.want {
+				t.Fatalf("expected status %d, got %d", tc.want, w.Code)
+			}
+			if calls != tc.calls {
+				t.Fatalf("expected %d calls to resync, got %d", tc.calls, calls)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var mux http.ServeMux
+	registerStatus(&mux, func() interface{} {
+		return map[string]int{"generation": 7}
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("expected content type application/json, got %q", got)
+	}
+	var got map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["generation"] != 7 {
+		t.Fatalf("expected generation 7, got %v", got)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/status", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -14,7 +14,6 @@
 package e2e
 
 import (
-	"context"
 	"testing"
 	"time"
 
@@ -23,7 +22,7 @@ import (
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	envoy_type "github.com/envoyproxy/go-control-plane/envoy/type"
 	"github.com/gogo/protobuf/types"
-	"google.golang.org/grpc"
+	"github.com/heptio/contour/contourtest"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,8 +32,8 @@ import (
 // heptio/contour#186
 // Cluster.ServiceName and ClusterLoadAssignment.ClusterName should not be truncated.
 func TestClusterLongServiceName(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -48,9 +47,9 @@ func TestClusterLongServiceName(t *testing.T) {
 			},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
-	rh.OnAdd(service(
+	h.OnAdd(service(
 		"default",
 		"kbujbkuhdod66gjdmwmijz8xzgsx1nkfbrloezdjiulquzk4x3p0nnvpzi8r",
 		v1.ServicePort{
@@ -61,21 +60,16 @@ func TestClusterLongServiceName(t *testing.T) {
 	))
 
 	// check that it's been translated correctly.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kbujbkuhdod66-172bef/8080", "default/kbujbkuhdod66gjdmwmijz8xzgsx1nkfbrloezdjiulquzk4x3p0nnvpzi8r")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.AssertCDS(
+		cluster("default/kbujbkuhdod66-172bef/8080", "default/kbujbkuhdod66gjdmwmijz8xzgsx1nkfbrloezdjiulquzk4x3p0nnvpzi8r"),
+	)
 }
 
 // Test adding, updating, and removing a service
 // doesn't leave turds in the CDS cache.
 func TestClusterAddUpdateDelete(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -89,7 +83,7 @@ func TestClusterAddUpdateDelete(t *testing.T) {
 			},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	i2 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -113,7 +107,7 @@ func TestClusterAddUpdateDelete(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i2)
+	h.OnAdd(i2)
 
 	// s1 is a simple tcp 80 -> 8080 service.
 	s1 := service("default", "kuard", v1.ServicePort{
@@ -121,16 +115,11 @@ func TestClusterAddUpdateDelete(t *testing.T) {
 		Port:       80,
 		TargetPort: intstr.FromInt(8080),
 	})
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/80", "default/kuard")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.AssertCDS(
+		cluster("default/kuard/80", "default/kuard"),
+	)
 
 	// s2 is the same as s2, but the service port has a name
 	s2 := service("default", "kuard", v1.ServicePort{
@@ -141,17 +130,12 @@ func TestClusterAddUpdateDelete(t *testing.T) {
 	})
 
 	// replace s1 with s2
-	rh.OnUpdate(s1, s2)
+	h.OnUpdate(s1, s2)
 
 	// check that we get two CDS records because the port is now named.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/80", "default/kuard/http")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.AssertCDS(
+		cluster("default/kuard/80", "default/kuard/http"),
+	)
 
 	// s3 is like s2, but has a second named port. The k8s spec
 	// requires all ports to be named if there is more than one of them.
@@ -171,19 +155,14 @@ func TestClusterAddUpdateDelete(t *testing.T) {
 	)
 
 	// replace s2 with s3
-	rh.OnUpdate(s2, s3)
+	h.OnUpdate(s2, s3)
 
 	// check that we get four CDS records. Order is important
 	// because the CDS cache is sorted.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/443", "default/kuard/https")),
-			any(t, cluster("default/kuard/80", "default/kuard/http")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.AssertCDS(
+		cluster("default/kuard/443", "default/kuard/https"),
+		cluster("default/kuard/80", "default/kuard/http"),
+	)
 
 	// s4 is s3 with the http port removed.
 	s4 := service("default", "kuard",
@@ -196,24 +175,19 @@ func TestClusterAddUpdateDelete(t *testing.T) {
 	)
 
 	// replace s3 with s4
-	rh.OnUpdate(s3, s4)
+	h.OnUpdate(s3, s4)
 
 	// check that we get two CDS records only, and that the 80 and http
 	// records have been removed even though the service object remains.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/443", "default/kuard/https")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.AssertCDS(
+		cluster("default/kuard/443", "default/kuard/https"),
+	)
 }
 
 // pathological hard case, one service is removed, the other is moved to a different port, and its name removed.
 func TestClusterRenameUpdateDelete(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -242,7 +216,7 @@ func TestClusterRenameUpdateDelete(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	s1 := service("default", "kuard",
 		v1.ServicePort{
@@ -259,16 +233,11 @@ func TestClusterRenameUpdateDelete(t *testing.T) {
 		},
 	)
 
-	rh.OnAdd(s1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/443", "default/kuard/https")),
-			any(t, cluster("default/kuard/80", "default/kuard/http")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.OnAdd(s1)
+	h.AssertCDS(
+		cluster("default/kuard/443", "default/kuard/https"),
+		cluster("default/kuard/80", "default/kuard/http"),
+	)
 
 	// s2 removes the name on port 80, moves it to port 443 and deletes the https port
 	s2 := service("default", "kuard",
@@ -279,44 +248,28 @@ func TestClusterRenameUpdateDelete(t *testing.T) {
 		},
 	)
 
-	rh.OnUpdate(s1, s2)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/443", "default/kuard")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.OnUpdate(s1, s2)
+	h.AssertCDS(
+		cluster("default/kuard/443", "default/kuard"),
+	)
 
 	// now replace s2 with s1 to check it works in the other direction.
-	rh.OnUpdate(s2, s1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/443", "default/kuard/https")),
-			any(t, cluster("default/kuard/80", "default/kuard/http")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.OnUpdate(s2, s1)
+	h.AssertCDS(
+		cluster("default/kuard/443", "default/kuard/https"),
+		cluster("default/kuard/80", "default/kuard/http"),
+	)
 
 	// cleanup and check
-	rh.OnDelete(s1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     clusterType,
-		Nonce:       "0",
-	}, streamCDS(t, cc))
+	h.OnDelete(s1)
+	h.AssertCDS()
 }
 
 // issue#243. A single unnamed service with a different numeric target port
 func TestIssue243(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
-
 	t.Run("single unnamed service with a different numeric target port", func(t *testing.T) {
+		h := contourtest.NewHarness(t)
+		defer h.Close()
 
 		i1 := &v1beta1.Ingress{
 			ObjectMeta: metav1.ObjectMeta{
@@ -330,7 +283,7 @@ func TestIssue243(t *testing.T) {
 				},
 			},
 		}
-		rh.OnAdd(i1)
+		h.OnAdd(i1)
 		s1 := service("default", "kuard",
 			v1.ServicePort{
 				Protocol:   "TCP",
@@ -338,22 +291,17 @@ func TestIssue243(t *testing.T) {
 				TargetPort: intstr.FromInt(8080),
 			},
 		)
-		rh.OnAdd(s1)
-		assertEqual(t, &v2.DiscoveryResponse{
-			VersionInfo: "0",
-			Resources: []types.Any{
-				any(t, cluster("default/kuard/80", "default/kuard")),
-			},
-			TypeUrl: clusterType,
-			Nonce:   "0",
-		}, streamCDS(t, cc))
+		h.OnAdd(s1)
+		h.AssertCDS(
+			cluster("default/kuard/80", "default/kuard"),
+		)
 	})
 }
 
 // issue 247, a single unnamed service with a named target port
 func TestIssue247(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -367,7 +315,7 @@ func TestIssue247(t *testing.T) {
 			},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	// spec:
 	//   ports:
@@ -381,19 +329,14 @@ func TestIssue247(t *testing.T) {
 			TargetPort: intstr.FromString("kuard"),
 		},
 	)
-	rh.OnAdd(s1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, cluster("default/kuard/80", "default/kuard")),
-		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	h.OnAdd(s1)
+	h.AssertCDS(
+		cluster("default/kuard/80", "default/kuard"),
+	)
 }
 func TestCDSResourceFiltering(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -422,7 +365,7 @@ func TestCDSResourceFiltering(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	// add two services, check that they are there
 	s1 := service("default", "kuard",
@@ -432,7 +375,7 @@ func TestCDSResourceFiltering(t *testing.T) {
 			TargetPort: intstr.FromString("kuard"),
 		},
 	)
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 	s2 := service("default", "httpbin",
 		v1.ServicePort{
 			Protocol:   "TCP",
@@ -440,40 +383,40 @@ func TestCDSResourceFiltering(t *testing.T) {
 			TargetPort: intstr.FromString("httpbin"),
 		},
 	)
-	rh.OnAdd(s2)
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.OnAdd(s2)
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
 			// note, resources are sorted by Cluster.Name
-			any(t, cluster("default/httpbin/8080", "default/httpbin")),
-			any(t, cluster("default/kuard/80", "default/kuard")),
+			h.Any(cluster("default/httpbin/8080", "default/httpbin")),
+			h.Any(cluster("default/kuard/80", "default/kuard")),
 		},
-		TypeUrl: clusterType,
+		TypeUrl: contourtest.ClusterType,
 		Nonce:   "0",
-	}, streamCDS(t, cc))
+	}, h.FetchCDS())
 
 	// assert we can filter on one resource
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, cluster("default/kuard/80", "default/kuard")),
+			h.Any(cluster("default/kuard/80", "default/kuard")),
 		},
-		TypeUrl: clusterType,
+		TypeUrl: contourtest.ClusterType,
 		Nonce:   "0",
-	}, streamCDS(t, cc, "default/kuard/80"))
+	}, h.FetchCDS("default/kuard/80"))
 
 	// assert a non matching filter returns no results
 	// note: streamCDS would stall at this point.
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
-		TypeUrl:     clusterType,
+		TypeUrl:     contourtest.ClusterType,
 		Nonce:       "0",
-	}, streamCDS(t, cc, "default/httpbin/9000"))
+	}, h.FetchCDS("default/httpbin/9000"))
 }
 
 func TestClusterCircuitbreakerAnnotations(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -487,7 +430,7 @@ func TestClusterCircuitbreakerAnnotations(t *testing.T) {
 			},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	s1 := serviceWithAnnotations(
 		"default",
@@ -504,13 +447,13 @@ func TestClusterCircuitbreakerAnnotations(t *testing.T) {
 			TargetPort: intstr.FromInt(8080),
 		},
 	)
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// check that it's been translated correctly.
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.Cluster{
+			h.Any(&v2.Cluster{
 				Name: "default/kuard/8080",
 				Type: v2.Cluster_EDS,
 				EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
@@ -534,9 +477,9 @@ func TestClusterCircuitbreakerAnnotations(t *testing.T) {
 				},
 			}),
 		},
-		TypeUrl: clusterType,
+		TypeUrl: contourtest.ClusterType,
 		Nonce:   "0",
-	}, streamCDS(t, cc))
+	}, h.FetchCDS())
 
 	// update s1 with slightly weird values
 	s2 := serviceWithAnnotations(
@@ -553,36 +496,31 @@ func TestClusterCircuitbreakerAnnotations(t *testing.T) {
 			TargetPort: intstr.FromInt(8080),
 		},
 	)
-	rh.OnUpdate(s1, s2)
+	h.OnUpdate(s1, s2)
 
 	// check that it's been translated correctly.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Cluster{
-				Name: "default/kuard/8080",
-				Type: v2.Cluster_EDS,
-				EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
-					EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
-					ServiceName: "default/kuard",
-				},
-				ConnectTimeout: 250 * time.Millisecond,
-				LbPolicy:       v2.Cluster_ROUND_ROBIN,
-				CircuitBreakers: &envoy_cluster.CircuitBreakers{
-					Thresholds: []*envoy_cluster.CircuitBreakers_Thresholds{{
-						MaxPendingRequests: uint32t(9999),
-					}},
-				},
-				CommonLbConfig: &v2.Cluster_CommonLbConfig{
-					HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
-						Value: 0,
-					},
+	h.AssertCDS(
+		&v2.Cluster{
+			Name: "default/kuard/8080",
+			Type: v2.Cluster_EDS,
+			EdsClusterConfig: &v2.Cluster_EdsClusterConfig{
+				EdsConfig:   apiconfigsource("contour"), // hard coded by initconfig
+				ServiceName: "default/kuard",
+			},
+			ConnectTimeout: 250 * time.Millisecond,
+			LbPolicy:       v2.Cluster_ROUND_ROBIN,
+			CircuitBreakers: &envoy_cluster.CircuitBreakers{
+				Thresholds: []*envoy_cluster.CircuitBreakers_Thresholds{{
+					MaxPendingRequests: uint32t(9999),
+				}},
+			},
+			CommonLbConfig: &v2.Cluster_CommonLbConfig{
+				HealthyPanicThreshold: &envoy_type.Percent{ // Disable HealthyPanicThreshold
+					Value: 0,
 				},
-			}),
+			},
 		},
-		TypeUrl: clusterType,
-		Nonce:   "0",
-	}, streamCDS(t, cc))
+	)
 }
 
 func uint32t(v int) *types.UInt32Value {
@@ -602,22 +540,6 @@ func serviceWithAnnotations(ns, name string, annotations map[string]string, port
 	}
 }
 
-func streamCDS(t *testing.T, cc *grpc.ClientConn, rn ...string) *v2.DiscoveryResponse {
-	t.Helper()
-	rds := v2.NewClusterDiscoveryServiceClient(cc)
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
-	defer cancel()
-	st, err := rds.StreamClusters(ctx)
-	if err != nil {
-		t.Fatal(err)
-	}
-	return stream(t, st, &v2.DiscoveryRequest{
-		TypeUrl:       clusterType,
-		ResourceNames: rn,
-	})
-}
-
 func cluster(name, servicename string) *v2.Cluster {
 	return &v2.Cluster{
 		Name: name,
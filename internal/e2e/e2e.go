@@ -11,152 +11,16 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package e2e tests the xDS resources Contour serves, end to end, with
+// the harness of the contourtest package.
 package e2e
 
-// grpc helpers
-
 import (
-	"net"
-	"sync"
 	"testing"
 
-	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v2"
-	"github.com/gogo/protobuf/proto"
-	"github.com/gogo/protobuf/types"
-	"github.com/heptio/contour/internal/contour"
-	"github.com/heptio/contour/internal/generated/clientset/versioned/fake"
-	cgrpc "github.com/heptio/contour/internal/grpc"
-	"github.com/heptio/contour/internal/k8s"
-	"github.com/heptio/contour/internal/metrics"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
-	"k8s.io/api/core/v1"
-	"k8s.io/client-go/tools/cache"
-)
-
-const (
-	googleApis   = "type.googleapis.com/"
-	typePrefix   = googleApis + "envoy.api.v2."
-	endpointType = typePrefix + "ClusterLoadAssignment"
-	clusterType  = typePrefix + "Cluster"
-	routeType    = typePrefix + "RouteConfiguration"
-	listenerType = typePrefix + "Listener"
 )
 
-type testWriter struct {
-	*testing.T
-}
-
-func (t *testWriter) Write(buf []byte) (int, error) {
-	t.Logf("%s", buf)
-	return len(buf), nil
-}
-
-type discardWriter struct {
-}
-
-func (d *discardWriter) Write(buf []byte) (int, error) {
-	return len(buf), nil
-}
-
-func setup(t *testing.T, opts ...func(*contour.ResourceEventHandler)) (cache.ResourceEventHandler, *grpc.ClientConn, func()) {
-	log := logrus.New()
-	log.Out = &testWriter{t}
-
-	et := &contour.EndpointsTranslator{
-		FieldLogger: log,
-	}
-
-	r := prometheus.NewRegistry()
-	ch := &contour.CacheHandler{
-		IngressRouteStatus: &k8s.IngressRouteStatus{
-			Client: fake.NewSimpleClientset(),
-		},
-		Metrics: metrics.NewMetrics(r),
-	}
-
-	reh := contour.ResourceEventHandler{
-		Notifier: ch,
-		Metrics:  ch.Metrics,
-	}
-
-	for _, opt := range opts {
-		opt(&reh)
-	}
-
-	l, err := net.Listen("tcp", "127.0.0.1:0")
-	check(t, err)
-	discard := logrus.New()
-	discard.Out = new(discardWriter)
-	// Resource types in xDS v2.
-	srv := cgrpc.NewAPI(discard, map[string]cgrpc.Cache{
-		clusterType:  &ch.ClusterCache,
-		routeType:    &ch.RouteCache,
-		listenerType: &ch.ListenerCache,
-		endpointType: et,
-	})
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		srv.Serve(l)
-	}()
-	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
-	check(t, err)
-
-	rh := &resourceEventHandler{
-		ResourceEventHandler: &reh,
-		EndpointsTranslator:  et,
-	}
-
-	return rh, cc, func() {
-		// close client connection
-		cc.Close()
-
-		// shut down listener, stop server and wait for it to stop
-		l.Close()
-		srv.Stop()
-		wg.Wait()
-	}
-}
-
-// resourceEventHandler composes a contour.Translator and a contour.EndpointsTranslator
-// into a single ResourceEventHandler type.
-type resourceEventHandler struct {
-	*contour.ResourceEventHandler
-	*contour.EndpointsTranslator
-}
-
-func (r *resourceEventHandler) OnAdd(obj interface{}) {
-	switch obj.(type) {
-	case *v1.Endpoints:
-		r.EndpointsTranslator.OnAdd(obj)
-	default:
-		r.ResourceEventHandler.OnAdd(obj)
-	}
-}
-
-func (r *resourceEventHandler) OnUpdate(oldObj, newObj interface{}) {
-	switch newObj.(type) {
-	case *v1.Endpoints:
-		r.EndpointsTranslator.OnUpdate(oldObj, newObj)
-	default:
-		r.ResourceEventHandler.OnUpdate(oldObj, newObj)
-	}
-}
-
-func (r *resourceEventHandler) OnDelete(obj interface{}) {
-	switch obj.(type) {
-	case *v1.Endpoints:
-		r.EndpointsTranslator.OnDelete(obj)
-	default:
-		r.ResourceEventHandler.OnDelete(obj)
-	}
-}
-
 func check(t *testing.T, err error) {
 	t.Helper()
 	if err != nil {
@@ -164,41 +28,6 @@ func check(t *testing.T, err error) {
 	}
 }
 
-func any(t *testing.T, pb proto.Message) types.Any {
-	t.Helper()
-	any, err := types.MarshalAny(pb)
-	check(t, err)
-	return *any
-}
-
-type grpcStream interface {
-	Send(*v2.DiscoveryRequest) error
-	Recv() (*v2.DiscoveryResponse, error)
-}
-
-func stream(t *testing.T, st grpcStream, req *v2.DiscoveryRequest) *v2.DiscoveryResponse {
-	t.Helper()
-	err := st.Send(req)
-	check(t, err)
-	resp, err := st.Recv()
-	check(t, err)
-	return resp
-}
-
-func assertEqual(t *testing.T, want, got *v2.DiscoveryResponse) {
-	t.Helper()
-	m := proto.TextMarshaler{Compact: true, ExpandAny: true}
-	a := m.Text(want)
-	b := m.Text(got)
-	if a != b {
-		m := proto.TextMarshaler{
-			Compact:   false,
-			ExpandAny: true,
-		}
-		t.Fatalf("\nexpected:\n%v\ngot:\n%v", m.Text(want), m.Text(got))
-	}
-}
-
 // fileAccessLog is defined here to avoid the conflict between the package that defines the
 // accesslog.AccessLog interface, "github.com/envoyproxy/go-control-plane/envoy/config/filter/accesslog/v2"
 // and the package the defines the FileAccessLog implement,
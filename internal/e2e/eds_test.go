@@ -14,15 +14,13 @@
 package e2e
 
 import (
-	"context"
 	"testing"
-	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	"github.com/gogo/protobuf/types"
-	"google.golang.org/grpc"
+	"github.com/heptio/contour/contourtest"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -30,8 +28,8 @@ import (
 // test that adding and removing endpoints don't leave turds
 // in the eds cache.
 func TestAddRemoveEndpoints(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// e1 is a simple endpoint for two hosts, and two ports
 	// it has a long name to check that it's clustername is _not_
@@ -54,36 +52,26 @@ func TestAddRemoveEndpoints(t *testing.T) {
 		},
 	)
 
-	rh.OnAdd(e1)
+	h.OnAdd(e1)
 
 	// check that it's been translated correctly.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, clusterloadassignment(
-				"super-long-namespace-name-oh-boy/what-a-descriptive-service-name-you-must-be-so-proud/http",
-				lbendpoint("172.16.0.1", 8000),
-				lbendpoint("172.16.0.2", 8000),
-			)),
-			any(t, clusterloadassignment(
-				"super-long-namespace-name-oh-boy/what-a-descriptive-service-name-you-must-be-so-proud/https",
-				lbendpoint("172.16.0.1", 8443),
-				lbendpoint("172.16.0.2", 8443),
-			)),
-		},
-		TypeUrl: endpointType,
-		Nonce:   "0",
-	}, streamEDS(t, cc))
+	h.AssertEDS(
+		clusterloadassignment(
+			"super-long-namespace-name-oh-boy/what-a-descriptive-service-name-you-must-be-so-proud/http",
+			lbendpoint("172.16.0.1", 8000),
+			lbendpoint("172.16.0.2", 8000),
+		),
+		clusterloadassignment(
+			"super-long-namespace-name-oh-boy/what-a-descriptive-service-name-you-must-be-so-proud/https",
+			lbendpoint("172.16.0.1", 8443),
+			lbendpoint("172.16.0.2", 8443),
+		),
+	)
 
 	// remove e1 and check that the EDS cache is now empty.
-	rh.OnDelete(e1)
+	h.OnDelete(e1)
 
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     endpointType,
-		Nonce:       "0",
-	}, streamEDS(t, cc))
+	h.AssertEDS()
 }
 
 // this example is generated by the combination of the service spec
@@ -101,8 +89,8 @@ func TestAddRemoveEndpoints(t *testing.T) {
 // where the kuard target port is one of 8080 or 9999 depending on the
 // matching pod spec.
 func TestAddEndpointComplicated(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	e1 := endpoints(
 		"default",
@@ -137,30 +125,25 @@ func TestAddEndpointComplicated(t *testing.T) {
 		},
 	)
 
-	rh.OnAdd(e1)
+	h.OnAdd(e1)
 
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, clusterloadassignment(
-				"default/kuard/admin",
-				lbendpoint("10.48.1.77", 9000),
-				lbendpoint("10.48.1.78", 9000),
-			)),
-			any(t, clusterloadassignment(
-				"default/kuard/foo",
-				lbendpoint("10.48.1.77", 9999), // TODO(dfc) order is not guaranteed by endpoint controller
-				lbendpoint("10.48.1.78", 8080),
-			)),
-		},
-		TypeUrl: endpointType,
-		Nonce:   "0",
-	}, streamEDS(t, cc))
+	h.AssertEDS(
+		clusterloadassignment(
+			"default/kuard/admin",
+			lbendpoint("10.48.1.77", 9000),
+			lbendpoint("10.48.1.78", 9000),
+		),
+		clusterloadassignment(
+			"default/kuard/foo",
+			lbendpoint("10.48.1.77", 9999), // TODO order is not guaranteed by endpoint controller
+			lbendpoint("10.48.1.78", 8080),
+		),
+	)
 }
 
 func TestEndpointFilter(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// a single endpoint that represents several
 	// cluster load assignments.
@@ -197,34 +180,34 @@ func TestEndpointFilter(t *testing.T) {
 		},
 	)
 
-	rh.OnAdd(e1)
+	h.OnAdd(e1)
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, clusterloadassignment(
+			h.Any(clusterloadassignment(
 				"default/kuard/foo",
-				lbendpoint("10.48.1.77", 9999), // TODO(dfc) order is not guaranteed by endpoint controller
+				lbendpoint("10.48.1.77", 9999), // TODO order is not guaranteed by endpoint controller
 				lbendpoint("10.48.1.78", 8080),
 			)),
 		},
-		TypeUrl: endpointType,
+		TypeUrl: contourtest.EndpointType,
 		Nonce:   "0",
-	}, streamEDS(t, cc, "default/kuard/foo"))
+	}, h.FetchEDS("default/kuard/foo"))
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
-		TypeUrl:     endpointType,
+		TypeUrl:     contourtest.EndpointType,
 		Nonce:       "0",
-	}, streamEDS(t, cc, "default/kuard/bar"))
+	}, h.FetchEDS("default/kuard/bar"))
 
 }
 
 // issue 602, test that an update from N endpoints
 // to zero endpoints is handled correctly.
 func TestIssue602(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	e1 := endpoints("default", "simple", v1.EndpointSubset{
 		Addresses: addresses("192.168.183.24"),
@@ -232,44 +215,23 @@ func TestIssue602(t *testing.T) {
 			Port: 8080,
 		}},
 	})
-	rh.OnAdd(e1)
+	h.OnAdd(e1)
 
 	// Assert endpoint was added
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, clusterloadassignment("default/simple", lbendpoint("192.168.183.24", 8080))),
+			h.Any(clusterloadassignment("default/simple", lbendpoint("192.168.183.24", 8080))),
 		},
-		TypeUrl: endpointType,
+		TypeUrl: contourtest.EndpointType,
 		Nonce:   "0",
-	}, streamEDS(t, cc))
+	}, h.FetchEDS())
 
 	// e2 is the same as e1, but without endpoint subsets
 	e2 := endpoints("default", "simple")
-	rh.OnUpdate(e1, e2)
+	h.OnUpdate(e1, e2)
 
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     endpointType,
-		Nonce:       "0",
-	}, streamEDS(t, cc))
-}
-
-func streamEDS(t *testing.T, cc *grpc.ClientConn, rn ...string) *v2.DiscoveryResponse {
-	t.Helper()
-	rds := v2.NewEndpointDiscoveryServiceClient(cc)
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
-	defer cancel()
-	st, err := rds.StreamEndpoints(ctx)
-	if err != nil {
-		t.Fatal(err)
-	}
-	return stream(t, st, &v2.DiscoveryRequest{
-		TypeUrl:       endpointType,
-		ResourceNames: rn,
-	})
+	h.AssertEDS()
 }
 
 func endpoints(ns, name string, subsets ...v1.EndpointSubset) *v1.Endpoints {
@@ -300,6 +262,7 @@ func clusterloadassignment(name string, lbendpoints ...endpoint.LbEndpoint) *v2.
 }
 func lbendpoint(addr string, port uint32) endpoint.LbEndpoint {
 	return endpoint.LbEndpoint{
+		HealthStatus: core.HealthStatus_HEALTHY,
 		Endpoint: &endpoint.Endpoint{
 			Address: &core.Address{
 				Address: &core.Address_SocketAddress{
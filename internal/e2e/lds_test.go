@@ -15,9 +15,7 @@ package e2e
 
 import (
 	"bytes"
-	"context"
 	"testing"
-	"time"
 
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
 
@@ -30,10 +28,10 @@ import (
 	"github.com/gogo/protobuf/jsonpb"
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
+	"github.com/heptio/contour/contourtest"
 	"github.com/heptio/contour/internal/contour"
 	"github.com/heptio/contour/internal/generated/clientset/versioned/fake"
 	"github.com/heptio/contour/internal/k8s"
-	"google.golang.org/grpc"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -41,17 +39,12 @@ import (
 )
 
 func TestNonTLSListener(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// assert that without any ingress objects registered
 	// there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.AssertLDS()
 
 	// i1 is a simple ingress, no hostname, no tls.
 	i1 := &v1beta1.Ingress{
@@ -65,21 +58,16 @@ func TestNonTLSListener(t *testing.T) {
 	}
 
 	// add it and assert that we now have a ingress_http listener
-	rh.OnAdd(i1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(false, httpfilter("ingress_http")),
-				},
-			}),
+	h.OnAdd(i1)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+	)
 
 	// i2 is the same as i1 but has the kubernetes.io/ingress.allow-http: "false" annotation
 	i2 := &v1beta1.Ingress{
@@ -96,13 +84,8 @@ func TestNonTLSListener(t *testing.T) {
 	}
 
 	// update i1 to i2 and verify that ingress_http has gone.
-	rh.OnUpdate(i1, i2)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.OnUpdate(i1, i2)
+	h.AssertLDS()
 
 	// i3 is similar to i2, but uses the ingress.kubernetes.io/force-ssl-redirect: "true" annotation
 	// to force 80 -> 443 upgrade
@@ -120,26 +103,58 @@ func TestNonTLSListener(t *testing.T) {
 	}
 
 	// update i2 to i3 and check that ingress_http has returned
-	rh.OnUpdate(i2, i3)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(false, httpfilter("ingress_http")),
-				},
-			}),
+	h.OnUpdate(i2, i3)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+	)
+}
+
+// Envoy holds its LDS stream open, each change to the listeners is
+// sent on it without a further request.
+func TestNonTLSListenerStream(t *testing.T) {
+	h := contourtest.NewHarness(t)
+	defer h.Close()
+
+	lds := h.Watch(contourtest.ListenerType)
+	defer lds.Close()
+
+	// without any ingress objects there are no listeners.
+	lds.AssertNext()
+
+	i1 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "simple",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: backend("backend", intstr.FromInt(80)),
+		},
+	}
+
+	// adding it sends the ingress_http listener.
+	h.OnAdd(i1)
+	lds.AssertNext(&v2.Listener{
+		Name:    "ingress_http",
+		Address: socketaddress("0.0.0.0", 8080),
+		FilterChains: []listener.FilterChain{
+			filterchain(false, httpfilter("ingress_http")),
+		},
+	})
+
+	// deleting it sends no listeners.
+	h.OnDelete(i1)
+	lds.AssertNext()
 }
 
 func TestTLSListener(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// s1 is a tls secret
 	s1 := &v1.Secret{
@@ -169,39 +184,29 @@ func TestTLSListener(t *testing.T) {
 	}
 
 	// add secret
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// assert that there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.AssertLDS()
 
 	// add ingress and assert the existence of ingress_http and ingres_https
-	rh.OnAdd(i1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(false, httpfilter("ingress_http")),
-				},
-			}),
-			any(t, &v2.Listener{
-				Name:    "ingress_https",
-				Address: socketaddress("0.0.0.0", 8443),
-				FilterChains: []listener.FilterChain{
-					filterchaintls([]string{"kuard.example.com"}, "certificate", "key", false, httpfilter("ingress_https")),
-				},
-			}),
+	h.OnAdd(i1)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+		&v2.Listener{
+			Name:    "ingress_https",
+			Address: socketaddress("0.0.0.0", 8443),
+			FilterChains: []listener.FilterChain{
+				filterchaintls([]string{"kuard.example.com"}, "certificate", "key", false, httpfilter("ingress_https")),
+			},
+		},
+	)
 
 	// i2 is the same as i1 but has the kubernetes.io/ingress.allow-http: "false" annotation
 	i2 := &v1beta1.Ingress{
@@ -222,35 +227,25 @@ func TestTLSListener(t *testing.T) {
 	}
 
 	// update i1 to i2 and verify that ingress_http has gone.
-	rh.OnUpdate(i1, i2)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_https",
-				Address: socketaddress("0.0.0.0", 8443),
-				FilterChains: []listener.FilterChain{
-					filterchaintls([]string{"kuard.example.com"}, "certificate", "key", false, httpfilter("ingress_https")),
-				},
-			}),
+	h.OnUpdate(i1, i2)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_https",
+			Address: socketaddress("0.0.0.0", 8443),
+			FilterChains: []listener.FilterChain{
+				filterchaintls([]string{"kuard.example.com"}, "certificate", "key", false, httpfilter("ingress_https")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+	)
 
 	// delete secret and assert that ingress_https is removed
-	rh.OnDelete(s1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.OnDelete(s1)
+	h.AssertLDS()
 }
 
 func TestIngressRouteTLSListener(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// s1 is a tls secret
 	s1 := &v1.Secret{
@@ -313,15 +308,10 @@ func TestIngressRouteTLSListener(t *testing.T) {
 	}
 
 	// add secret
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// assert that there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.AssertLDS()
 
 	l1 := &v2.Listener{
 		Name:    "ingress_https",
@@ -334,43 +324,33 @@ func TestIngressRouteTLSListener(t *testing.T) {
 	l1.FilterChains[0].TlsContext.CommonTlsContext.TlsParams.TlsMinimumProtocolVersion = auth.TlsParameters_TLSv1_1
 
 	// add ingress and assert the existence of ingress_http and ingres_https
-	rh.OnAdd(i1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(false, httpfilter("ingress_http")),
-				},
-			}),
-			any(t, l1),
+	h.OnAdd(i1)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+		l1,
+	)
 
 	// delete secret and assert that ingress_https is removed
-	rh.OnDelete(s1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(false, httpfilter("ingress_http")),
-				},
-			}),
+	h.OnDelete(s1)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+	)
 
-	rh.OnDelete(i1)
+	h.OnDelete(i1)
 	// add secret
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 	l2 := &v2.Listener{
 		Name:    "ingress_https",
 		Address: socketaddress("0.0.0.0", 8443),
@@ -382,27 +362,22 @@ func TestIngressRouteTLSListener(t *testing.T) {
 	l2.FilterChains[0].TlsContext.CommonTlsContext.TlsParams.TlsMinimumProtocolVersion = auth.TlsParameters_TLSv1_3
 
 	// add ingress and assert the existence of ingress_http and ingres_https
-	rh.OnAdd(i2)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(false, httpfilter("ingress_http")),
-				},
-			}),
-			any(t, l2),
+	h.OnAdd(i2)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+		l2,
+	)
 }
 
 func TestLDSFilter(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// s1 is a tls secret
 	s1 := &v1.Secret{
@@ -432,14 +407,14 @@ func TestLDSFilter(t *testing.T) {
 	}
 
 	// add secret
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// add ingress and fetch ingress_https
-	rh.OnAdd(i1)
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.OnAdd(i1)
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.Listener{
+			h.Any(&v2.Listener{
 				Name:    "ingress_https",
 				Address: socketaddress("0.0.0.0", 8443),
 				FilterChains: []listener.FilterChain{
@@ -447,16 +422,16 @@ func TestLDSFilter(t *testing.T) {
 				},
 			}),
 		},
-		TypeUrl: listenerType,
+		TypeUrl: contourtest.ListenerType,
 		Nonce:   "0",
-	}, streamLDS(t, cc, "ingress_https"))
+	}, h.FetchLDS("ingress_https"))
 
 	// fetch ingress_http
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
 
-			any(t, &v2.Listener{
+			h.Any(&v2.Listener{
 				Name:    "ingress_http",
 				Address: socketaddress("0.0.0.0", 8080),
 				FilterChains: []listener.FilterChain{
@@ -464,31 +439,31 @@ func TestLDSFilter(t *testing.T) {
 				},
 			}),
 		},
-		TypeUrl: listenerType,
+		TypeUrl: contourtest.ListenerType,
 		Nonce:   "0",
-	}, streamLDS(t, cc, "ingress_http"))
+	}, h.FetchLDS("ingress_http"))
 
 	// fetch something non existent.
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
-		TypeUrl:     listenerType, Nonce: "0",
-	}, streamLDS(t, cc, "HTTP"))
+		TypeUrl:     contourtest.ListenerType, Nonce: "0",
+	}, h.FetchLDS("HTTP"))
 }
 
 func TestLDSStreamEmpty(t *testing.T) {
-	_, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// assert that streaming LDS with no ingresses does not stall.
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
-		TypeUrl:     listenerType, Nonce: "0",
-	}, streamLDS(t, cc, "HTTP"))
+		TypeUrl:     contourtest.ListenerType, Nonce: "0",
+	}, h.FetchLDS("HTTP"))
 }
 
 func TestLDSTLSMinimumProtocolVersion(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// s1 is a tls secret
 	s1 := &v1.Secret{
@@ -501,7 +476,7 @@ func TestLDSTLSMinimumProtocolVersion(t *testing.T) {
 			v1.TLSPrivateKeyKey: []byte("key"),
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// i1 is a tls ingress
 	i1 := &v1beta1.Ingress{
@@ -518,14 +493,14 @@ func TestLDSTLSMinimumProtocolVersion(t *testing.T) {
 		},
 	}
 
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	// add ingress and fetch ingress_https
-	rh.OnAdd(i1)
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.OnAdd(i1)
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.Listener{
+			h.Any(&v2.Listener{
 				Name:    "ingress_https",
 				Address: socketaddress("0.0.0.0", 8443),
 				FilterChains: []listener.FilterChain{
@@ -533,9 +508,9 @@ func TestLDSTLSMinimumProtocolVersion(t *testing.T) {
 				},
 			}),
 		},
-		TypeUrl: listenerType,
+		TypeUrl: contourtest.ListenerType,
 		Nonce:   "0",
-	}, streamLDS(t, cc, "ingress_https"))
+	}, h.FetchLDS("ingress_https"))
 
 	i2 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -555,7 +530,7 @@ func TestLDSTLSMinimumProtocolVersion(t *testing.T) {
 	}
 
 	// update tls version and fetch ingress_https
-	rh.OnUpdate(i1, i2)
+	h.OnUpdate(i1, i2)
 
 	l1 := &v2.Listener{
 		Name:    "ingress_https",
@@ -567,30 +542,30 @@ func TestLDSTLSMinimumProtocolVersion(t *testing.T) {
 	// easier to patch this up than add more params to filterchaintls
 	l1.FilterChains[0].TlsContext.CommonTlsContext.TlsParams.TlsMinimumProtocolVersion = auth.TlsParameters_TLSv1_3
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, l1),
+			h.Any(l1),
 		},
-		TypeUrl: listenerType,
+		TypeUrl: contourtest.ListenerType,
 		Nonce:   "0",
-	}, streamLDS(t, cc, "ingress_https"))
+	}, h.FetchLDS("ingress_https"))
 }
 
 func TestLDSIngressHTTPUseProxyProtocol(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.Notifier.(*contour.CacheHandler).UseProxyProto = true
 	})
-	defer done()
+	defer h.Close()
 
 	// assert that without any ingress objects registered
 	// there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
+		TypeUrl:     contourtest.ListenerType,
 		Nonce:       "0",
-	}, streamLDS(t, cc))
+	}, h.FetchLDS())
 
 	// i1 is a simple ingress, no hostname, no tls.
 	i1 := &v1beta1.Ingress{
@@ -605,28 +580,23 @@ func TestLDSIngressHTTPUseProxyProtocol(t *testing.T) {
 
 	// add it and assert that we now have a ingress_http listener using
 	// the proxy protocol (the true param to filterchain)
-	rh.OnAdd(i1)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(true, httpfilter("ingress_http")),
-				},
-			}),
+	h.OnAdd(i1)
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(true, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+	)
 }
 
 func TestLDSIngressHTTPSUseProxyProtocol(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.Notifier.(*contour.CacheHandler).UseProxyProto = true
 	})
-	defer done()
+	defer h.Close()
 
 	// s1 is a tls secret
 	s1 := &v1.Secret{
@@ -656,19 +626,14 @@ func TestLDSIngressHTTPSUseProxyProtocol(t *testing.T) {
 	}
 
 	// add secret
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// assert that there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.AssertLDS()
 
 	// add ingress and assert the existence of ingress_http and ingres_https and both
 	// are using proxy protocol
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	ingress_https := &v2.Listener{
 		Name:    "ingress_https",
@@ -678,31 +643,26 @@ func TestLDSIngressHTTPSUseProxyProtocol(t *testing.T) {
 		},
 	}
 	ingress_https.FilterChains[0].UseProxyProto = &types.BoolValue{Value: true}
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(true, httpfilter("ingress_http")),
-				},
-			}),
-			any(t, ingress_https),
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(true, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+		ingress_https,
+	)
 }
 
 func TestLDSCustomAddressAndPort(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.Notifier.(*contour.CacheHandler).HTTPAddress = "127.0.0.100"
 		reh.Notifier.(*contour.CacheHandler).HTTPPort = 9100
 		reh.Notifier.(*contour.CacheHandler).HTTPSAddress = "127.0.0.200"
 		reh.Notifier.(*contour.CacheHandler).HTTPSPort = 9200
 	})
-	defer done()
+	defer h.Close()
 
 	// s1 is a tls secret
 	s1 := &v1.Secret{
@@ -732,19 +692,14 @@ func TestLDSCustomAddressAndPort(t *testing.T) {
 	}
 
 	// add secret
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// assert that there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.AssertLDS()
 
 	// add ingress and assert the existence of ingress_http and ingres_https and both
 	// are using proxy protocol
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	ingress_http := &v2.Listener{
 		Name:    "ingress_http",
@@ -760,33 +715,72 @@ func TestLDSCustomAddressAndPort(t *testing.T) {
 			filterchaintls([]string{"kuard.example.com"}, "certificate", "key", false, httpfilter("ingress_https")),
 		},
 	}
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, ingress_http),
-			any(t, ingress_https),
+	h.AssertLDS(
+		ingress_http,
+		ingress_https,
+	)
+}
+
+func TestLDSDisableHTTPS(t *testing.T) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
+		reh.Notifier.(*contour.CacheHandler).DisableHTTPS = true
+	})
+	defer h.Close()
+
+	// s1 is a tls secret
+	s1 := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "default",
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte("certificate"),
+			v1.TLSPrivateKeyKey: []byte("key"),
+		},
+	}
+
+	// i1 is a tls ingress
+	i1 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "simple",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: backend("backend", intstr.FromInt(80)),
+			TLS: []v1beta1.IngressTLS{{
+				Hosts:      []string{"kuard.example.com"},
+				SecretName: "secret",
+			}},
+		},
+	}
+
+	h.OnAdd(s1)
+	h.OnAdd(i1)
+
+	// assert that only ingress_http is present, even though
+	// there is a valid tls vhost.
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
+		},
+	)
 }
 
 func TestLDSIngressRouteInsideRootNamespaces(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.IngressRouteRootNamespaces = []string{"roots"}
 		reh.Notifier.(*contour.CacheHandler).IngressRouteStatus = &k8s.IngressRouteStatus{
 			Client: fake.NewSimpleClientset(),
 		}
 	})
-	defer done()
+	defer h.Close()
 
 	// assert that there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.AssertLDS()
 
 	// ir1 is an ingressroute that is in the root namespace
 	ir1 := &ingressroutev1.IngressRoute{
@@ -807,41 +801,31 @@ func TestLDSIngressRouteInsideRootNamespaces(t *testing.T) {
 	}
 
 	// add ingressroute
-	rh.OnAdd(ir1)
+	h.OnAdd(ir1)
 
 	// assert there is an active listener
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.Listener{
-				Name:    "ingress_http",
-				Address: socketaddress("0.0.0.0", 8080),
-				FilterChains: []listener.FilterChain{
-					filterchain(false, httpfilter("ingress_http")),
-				},
-			}),
+	h.AssertLDS(
+		&v2.Listener{
+			Name:    "ingress_http",
+			Address: socketaddress("0.0.0.0", 8080),
+			FilterChains: []listener.FilterChain{
+				filterchain(false, httpfilter("ingress_http")),
+			},
 		},
-		TypeUrl: listenerType,
-		Nonce:   "0",
-	}, streamLDS(t, cc))
+	)
 }
 
 func TestLDSIngressRouteOutsideRootNamespaces(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.IngressRouteRootNamespaces = []string{"roots"}
 		reh.Notifier.(*contour.CacheHandler).IngressRouteStatus = &k8s.IngressRouteStatus{
 			Client: fake.NewSimpleClientset(),
 		}
 	})
-	defer done()
+	defer h.Close()
 
 	// assert that there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
+	h.AssertLDS()
 
 	// ir1 is an ingressroute that is not in the root namespaces
 	ir1 := &ingressroutev1.IngressRoute{
@@ -862,31 +846,10 @@ func TestLDSIngressRouteOutsideRootNamespaces(t *testing.T) {
 	}
 
 	// add ingressroute
-	rh.OnAdd(ir1)
+	h.OnAdd(ir1)
 
 	// assert that there are no active listeners
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources:   []types.Any{},
-		TypeUrl:     listenerType,
-		Nonce:       "0",
-	}, streamLDS(t, cc))
-}
-
-func streamLDS(t *testing.T, cc *grpc.ClientConn, rn ...string) *v2.DiscoveryResponse {
-	t.Helper()
-	rds := v2.NewListenerDiscoveryServiceClient(cc)
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
-	defer cancel()
-	st, err := rds.StreamListeners(ctx)
-	if err != nil {
-		t.Fatal(err)
-	}
-	return stream(t, st, &v2.DiscoveryRequest{
-		TypeUrl:       listenerType,
-		ResourceNames: rn,
-	})
+	h.AssertLDS()
 }
 
 func backend(name string, port intstr.IntOrString) *v1beta1.IngressBackend {
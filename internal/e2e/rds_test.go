@@ -22,9 +22,12 @@ import (
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
+	"github.com/heptio/contour/contourtest"
 	"github.com/heptio/contour/internal/contour"
+	"github.com/heptio/contour/internal/dag"
 	"github.com/heptio/contour/internal/generated/clientset/versioned/fake"
 	"github.com/heptio/contour/internal/k8s"
 	"google.golang.org/grpc"
@@ -62,8 +65,8 @@ import (
 //
 // fails to update the virtualhost cache.
 func TestEditIngress(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	meta := metav1.ObjectMeta{Name: "kuard", Namespace: "default"}
 
@@ -81,7 +84,7 @@ func TestEditIngress(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// add default/kuard to translator.
 	old := &v1beta1.Ingress{
@@ -93,33 +96,25 @@ func TestEditIngress(t *testing.T) {
 			},
 		},
 	}
-	rh.OnAdd(old)
+	h.OnAdd(old)
 
 	// check that it's been translated correctly.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_http",
-				VirtualHosts: []route.VirtualHost{{
-					Name:    "*",
-					Domains: []string{"*"},
-					Routes: []route.Route{{
-						Match:  prefixmatch("/"),
-						Action: routecluster("default/kuard/80"),
-					}},
+	h.AssertRDS(
+		&v2.RouteConfiguration{
+			Name: "ingress_http",
+			VirtualHosts: []route.VirtualHost{{
+				Name:    "*",
+				Domains: []string{"*"},
+				Routes: []route.Route{{
+					Match:  prefixmatch("/"),
+					Action: routecluster("default/kuard/80"),
 				}},
-			}),
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_https",
-			}),
+			}},
 		},
-		TypeUrl: routeType,
-		Nonce:   "0",
-	}, streamRDS(t, cc))
+	)
 
 	// update old to new
-	rh.OnUpdate(old, &v1beta1.Ingress{
+	h.OnUpdate(old, &v1beta1.Ingress{
 		ObjectMeta: meta,
 		Spec: v1beta1.IngressSpec{
 			Rules: []v1beta1.IngressRule{{
@@ -139,27 +134,19 @@ func TestEditIngress(t *testing.T) {
 	})
 
 	// check that ingress_http has been updated.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_http",
-				VirtualHosts: []route.VirtualHost{{
-					Name:    "*",
-					Domains: []string{"*"},
-					Routes: []route.Route{{
-						Match:  prefixmatch("/testing"),
-						Action: routecluster("default/kuard/80"),
-					}},
+	h.AssertRDS(
+		&v2.RouteConfiguration{
+			Name: "ingress_http",
+			VirtualHosts: []route.VirtualHost{{
+				Name:    "*",
+				Domains: []string{"*"},
+				Routes: []route.Route{{
+					Match:  prefixmatch("/testing"),
+					Action: routecluster("default/kuard/80"),
 				}},
-			}),
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_https",
-			}),
+			}},
 		},
-		TypeUrl: routeType,
-		Nonce:   "0",
-	}, streamRDS(t, cc))
+	)
 }
 
 // heptio/contour#101
@@ -178,11 +165,11 @@ func TestEditIngress(t *testing.T) {
 //           serviceName: hello
 //           servicePort: 80
 func TestIngressPathRouteWithoutHost(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// add default/hello to translator.
-	rh.OnAdd(&v1beta1.Ingress{
+	h.OnAdd(&v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
 		Spec: v1beta1.IngressSpec{
 			Rules: []v1beta1.IngressRule{{
@@ -215,35 +202,27 @@ func TestIngressPathRouteWithoutHost(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// check that it's been translated correctly.
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_http",
-				VirtualHosts: []route.VirtualHost{{
-					Name:    "*",
-					Domains: []string{"*"},
-					Routes: []route.Route{{
-						Match:  prefixmatch("/hello"),
-						Action: routecluster("default/hello/80"),
-					}},
+	h.AssertRDS(
+		&v2.RouteConfiguration{
+			Name: "ingress_http",
+			VirtualHosts: []route.VirtualHost{{
+				Name:    "*",
+				Domains: []string{"*"},
+				Routes: []route.Route{{
+					Match:  prefixmatch("/hello"),
+					Action: routecluster("default/hello/80"),
 				}},
-			}),
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_https",
-			}),
+			}},
 		},
-		TypeUrl: routeType,
-		Nonce:   "0",
-	}, streamRDS(t, cc))
+	)
 }
 
 func TestEditIngressInPlace(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
@@ -264,7 +243,7 @@ func TestEditIngressInPlace(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	s1 := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -280,7 +259,7 @@ func TestEditIngressInPlace(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	s2 := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -296,29 +275,21 @@ func TestEditIngressInPlace(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s2)
-
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_http",
-				VirtualHosts: []route.VirtualHost{{
-					Name:    "hello.example.com",
-					Domains: []string{"hello.example.com", "hello.example.com:80"},
-					Routes: []route.Route{{
-						Match:  prefixmatch("/"),
-						Action: routecluster("default/wowie/80"),
-					}},
+	h.OnAdd(s2)
+
+	h.AssertRDS(
+		&v2.RouteConfiguration{
+			Name: "ingress_http",
+			VirtualHosts: []route.VirtualHost{{
+				Name:    "hello.example.com",
+				Domains: []string{"hello.example.com", "hello.example.com:80"},
+				Routes: []route.Route{{
+					Match:  prefixmatch("/"),
+					Action: routecluster("default/wowie/80"),
 				}},
-			}),
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_https",
-			}),
+			}},
 		},
-		TypeUrl: routeType,
-		Nonce:   "0",
-	}, streamRDS(t, cc))
+	)
 
 	// i2 is like i1 but adds a second route
 	i2 := &v1beta1.Ingress{
@@ -346,31 +317,23 @@ func TestEditIngressInPlace(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnUpdate(i1, i2)
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_http",
-				VirtualHosts: []route.VirtualHost{{
-					Name:    "hello.example.com",
-					Domains: []string{"hello.example.com", "hello.example.com:80"},
-					Routes: []route.Route{{
-						Match:  prefixmatch("/whoop"),
-						Action: routecluster("default/kerpow/9000"),
-					}, {
-						Match:  prefixmatch("/"),
-						Action: routecluster("default/wowie/80"),
-					}},
+	h.OnUpdate(i1, i2)
+	h.AssertRDS(
+		&v2.RouteConfiguration{
+			Name: "ingress_http",
+			VirtualHosts: []route.VirtualHost{{
+				Name:    "hello.example.com",
+				Domains: []string{"hello.example.com", "hello.example.com:80"},
+				Routes: []route.Route{{
+					Match:  prefixmatch("/whoop"),
+					Action: routecluster("default/kerpow/9000"),
+				}, {
+					Match:  prefixmatch("/"),
+					Action: routecluster("default/wowie/80"),
 				}},
-			}),
-			any(t, &v2.RouteConfiguration{
-				Name: "ingress_https",
-			}),
+			}},
 		},
-		TypeUrl: routeType,
-		Nonce:   "0",
-	}, streamRDS(t, cc))
+	)
 
 	// i3 is like i2, but adds the ingress.kubernetes.io/force-ssl-redirect: "true" annotation
 	i3 := &v1beta1.Ingress{
@@ -403,11 +366,11 @@ func TestEditIngressInPlace(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnUpdate(i2, i3)
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.OnUpdate(i2, i3)
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_http",
 				VirtualHosts: []route.VirtualHost{{
 					Name:    "hello.example.com",
@@ -420,13 +383,12 @@ func TestEditIngressInPlace(t *testing.T) {
 						Action: redirecthttps(),
 					}},
 				}}}),
-			any(t, &v2.RouteConfiguration{Name: "ingress_https"}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc))
+	}, h.FetchRDS())
 
-	rh.OnAdd(&v1.Secret{
+	h.OnAdd(&v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "hello-kitty",
 			Namespace: "default",
@@ -473,11 +435,11 @@ func TestEditIngressInPlace(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnUpdate(i3, i4)
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.OnUpdate(i3, i4)
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_http",
 				VirtualHosts: []route.VirtualHost{{
 					Name:    "hello.example.com",
@@ -490,7 +452,7 @@ func TestEditIngressInPlace(t *testing.T) {
 						Action: redirecthttps(),
 					}},
 				}}}),
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_https",
 				VirtualHosts: []route.VirtualHost{{
 					Name:    "hello.example.com",
@@ -504,9 +466,33 @@ func TestEditIngressInPlace(t *testing.T) {
 					}},
 				}}}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
+		Nonce:   "0",
+	}, h.FetchRDS())
+
+	// reverting i4 to i3 removes the last TLS vhost, so ingress_https
+	// should no longer be advertised.
+	h.OnUpdate(i4, i3)
+	h.AssertEqual(&v2.DiscoveryResponse{
+		VersionInfo: "0",
+		Resources: []types.Any{
+			h.Any(&v2.RouteConfiguration{
+				Name: "ingress_http",
+				VirtualHosts: []route.VirtualHost{{
+					Name:    "hello.example.com",
+					Domains: []string{"hello.example.com", "hello.example.com:80"},
+					Routes: []route.Route{{
+						Match:  prefixmatch("/whoop"),
+						Action: redirecthttps(),
+					}, {
+						Match:  prefixmatch("/"),
+						Action: redirecthttps(),
+					}},
+				}}}),
+		},
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc))
+	}, h.FetchRDS())
 }
 
 // contour#164: backend request timeout support
@@ -516,8 +502,8 @@ func TestRequestTimeout(t *testing.T) {
 		duration10Minutes = 10 * time.Minute
 	)
 
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	s1 := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -532,7 +518,7 @@ func TestRequestTimeout(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// i1 is a simple ingress bound to the default vhost.
 	i1 := &v1beta1.Ingress{
@@ -541,8 +527,8 @@ func TestRequestTimeout(t *testing.T) {
 			Backend: backend("backend", intstr.FromInt(80)),
 		},
 	}
-	rh.OnAdd(i1)
-	assertRDS(t, cc, []route.VirtualHost{{
+	h.OnAdd(i1)
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "*",
 		Domains: []string{"*"},
 		Routes: []route.Route{{
@@ -562,8 +548,8 @@ func TestRequestTimeout(t *testing.T) {
 			Backend: backend("backend", intstr.FromInt(80)),
 		},
 	}
-	rh.OnUpdate(i1, i2)
-	assertRDS(t, cc, []route.VirtualHost{{
+	h.OnUpdate(i1, i2)
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "*",
 		Domains: []string{"*"},
 		Routes: []route.Route{{
@@ -583,8 +569,8 @@ func TestRequestTimeout(t *testing.T) {
 			Backend: backend("backend", intstr.FromInt(80)),
 		},
 	}
-	rh.OnUpdate(i2, i3)
-	assertRDS(t, cc, []route.VirtualHost{{
+	h.OnUpdate(i2, i3)
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "*",
 		Domains: []string{"*"},
 		Routes: []route.Route{{
@@ -604,8 +590,8 @@ func TestRequestTimeout(t *testing.T) {
 			Backend: backend("backend", intstr.FromInt(80)),
 		},
 	}
-	rh.OnUpdate(i3, i4)
-	assertRDS(t, cc, []route.VirtualHost{{
+	h.OnUpdate(i3, i4)
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "*",
 		Domains: []string{"*"},
 		Routes: []route.Route{{
@@ -618,8 +604,8 @@ func TestRequestTimeout(t *testing.T) {
 // contour#250 ingress.kubernetes.io/force-ssl-redirect: "true" should apply
 // per route, not per vhost.
 func TestSSLRedirectOverlay(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// i1 is a stock ingress with force-ssl-redirect on the / route
 	i1 := &v1beta1.Ingress{
@@ -651,9 +637,9 @@ func TestSSLRedirectOverlay(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
-	rh.OnAdd(&v1.Secret{
+	h.OnAdd(&v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "example-tls",
 			Namespace: "default",
@@ -664,7 +650,7 @@ func TestSSLRedirectOverlay(t *testing.T) {
 		},
 	})
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "app-service",
 			Namespace: "default",
@@ -699,9 +685,9 @@ func TestSSLRedirectOverlay(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i2)
+	h.OnAdd(i2)
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "challenge-service",
 			Namespace: "nginx-ingress",
@@ -716,7 +702,7 @@ func TestSSLRedirectOverlay(t *testing.T) {
 		},
 	})
 
-	assertRDS(t, cc, []route.VirtualHost{{ // ingress_http
+	assertRDS(h, []route.VirtualHost{{ // ingress_http
 		Name:    "example.com",
 		Domains: []string{"example.com", "example.com:80"},
 		Routes: []route.Route{{
@@ -741,8 +727,8 @@ func TestSSLRedirectOverlay(t *testing.T) {
 
 // issue #257: editing default ingress did not remove original default route
 func TestIssue257(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// apiVersion: extensions/v1beta1
 	// kind: Ingress
@@ -771,7 +757,7 @@ func TestIssue257(t *testing.T) {
 			},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	s1 := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -787,9 +773,9 @@ func TestIssue257(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
-	assertRDS(t, cc, []route.VirtualHost{{
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "*",
 		Domains: []string{"*"},
 		Routes: []route.Route{{
@@ -840,9 +826,9 @@ func TestIssue257(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnUpdate(i1, i2)
+	h.OnUpdate(i1, i2)
 
-	assertRDS(t, cc, []route.VirtualHost{{
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "kuard.db.gd-ms.com",
 		Domains: []string{"kuard.db.gd-ms.com", "kuard.db.gd-ms.com:80"},
 		Routes: []route.Route{{
@@ -853,8 +839,8 @@ func TestIssue257(t *testing.T) {
 }
 
 func TestRDSFilter(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	// i1 is a stock ingress with force-ssl-redirect on the / route
 	i1 := &v1beta1.Ingress{
@@ -886,9 +872,9 @@ func TestRDSFilter(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
-	rh.OnAdd(&v1.Secret{
+	h.OnAdd(&v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "example-tls",
 			Namespace: "default",
@@ -913,7 +899,7 @@ func TestRDSFilter(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	// i2 is an overlay to add the let's encrypt handler.
 	i2 := &v1beta1.Ingress{
@@ -935,7 +921,7 @@ func TestRDSFilter(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i2)
+	h.OnAdd(i2)
 
 	s2 := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -951,12 +937,12 @@ func TestRDSFilter(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s2)
+	h.OnAdd(s2)
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_http",
 				VirtualHosts: []route.VirtualHost{{ // ingress_http
 					Name:    "example.com",
@@ -971,14 +957,14 @@ func TestRDSFilter(t *testing.T) {
 				}},
 			}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc, "ingress_http"))
+	}, h.FetchRDS("ingress_http"))
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_https",
 				VirtualHosts: []route.VirtualHost{{ // ingress_https
 					Name:    "example.com",
@@ -993,16 +979,16 @@ func TestRDSFilter(t *testing.T) {
 				}},
 			}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc, "ingress_https"))
+	}, h.FetchRDS("ingress_https"))
 }
 
 func TestWebsocketIngress(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "ws",
 			Namespace: "default",
@@ -1016,7 +1002,7 @@ func TestWebsocketIngress(t *testing.T) {
 		},
 	})
 
-	rh.OnAdd(&v1beta1.Ingress{
+	h.OnAdd(&v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "ws",
 			Namespace: "default",
@@ -1042,7 +1028,7 @@ func TestWebsocketIngress(t *testing.T) {
 		},
 	})
 
-	assertRDS(t, cc, []route.VirtualHost{{
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "websocket.hello.world",
 		Domains: []string{"websocket.hello.world", "websocket.hello.world:80"},
 		Routes: []route.Route{{
@@ -1053,10 +1039,10 @@ func TestWebsocketIngress(t *testing.T) {
 }
 
 func TestWebsocketIngressRoute(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "ws",
 			Namespace: "default",
@@ -1070,7 +1056,7 @@ func TestWebsocketIngressRoute(t *testing.T) {
 		},
 	})
 
-	rh.OnAdd(&ingressroutev1.IngressRoute{
+	h.OnAdd(&ingressroutev1.IngressRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "simple",
 			Namespace: "default",
@@ -1101,7 +1087,7 @@ func TestWebsocketIngressRoute(t *testing.T) {
 		},
 	})
 
-	assertRDS(t, cc, []route.VirtualHost{{
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "websocket.hello.world",
 		Domains: []string{"websocket.hello.world", "websocket.hello.world:80"},
 		Routes: []route.Route{{
@@ -1119,10 +1105,10 @@ func TestWebsocketIngressRoute(t *testing.T) {
 
 // issue 404
 func TestDefaultBackendDoesNotOverwriteNamedHost(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "kuard",
 			Namespace: "default",
@@ -1142,7 +1128,7 @@ func TestDefaultBackendDoesNotOverwriteNamedHost(t *testing.T) {
 		},
 	})
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "test-gui",
 			Namespace: "default",
@@ -1157,7 +1143,7 @@ func TestDefaultBackendDoesNotOverwriteNamedHost(t *testing.T) {
 		},
 	})
 
-	rh.OnAdd(&v1beta1.Ingress{
+	h.OnAdd(&v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "hello",
 			Namespace: "default",
@@ -1197,10 +1183,10 @@ func TestDefaultBackendDoesNotOverwriteNamedHost(t *testing.T) {
 		},
 	})
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_http",
 				VirtualHosts: []route.VirtualHost{{
 					Name:    "*",
@@ -1222,21 +1208,21 @@ func TestDefaultBackendDoesNotOverwriteNamedHost(t *testing.T) {
 				}},
 			}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc, "ingress_http"))
+	}, h.FetchRDS("ingress_http"))
 }
 
 func TestRDSIngressRouteWithAliases(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.IngressRouteRootNamespaces = []string{"roots"}
 		reh.Notifier.(*contour.CacheHandler).IngressRouteStatus = &k8s.IngressRouteStatus{
 			Client: fake.NewSimpleClientset(),
 		}
 	})
-	defer done()
+	defer h.Close()
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "kuard",
 			Namespace: "roots",
@@ -1275,12 +1261,12 @@ func TestRDSIngressRouteWithAliases(t *testing.T) {
 	}
 
 	// add ingressroute
-	rh.OnAdd(ir1)
+	h.OnAdd(ir1)
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_http",
 				VirtualHosts: []route.VirtualHost{{
 					Name:    "example.com",
@@ -1292,21 +1278,21 @@ func TestRDSIngressRouteWithAliases(t *testing.T) {
 				}},
 			}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc, "ingress_http"))
+	}, h.FetchRDS("ingress_http"))
 }
 
 func TestRDSIngressRouteInsideRootNamespaces(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.IngressRouteRootNamespaces = []string{"roots"}
 		reh.Notifier.(*contour.CacheHandler).IngressRouteStatus = &k8s.IngressRouteStatus{
 			Client: fake.NewSimpleClientset(),
 		}
 	})
-	defer done()
+	defer h.Close()
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "kuard",
 			Namespace: "roots",
@@ -1339,12 +1325,12 @@ func TestRDSIngressRouteInsideRootNamespaces(t *testing.T) {
 	}
 
 	// add ingressroute
-	rh.OnAdd(ir1)
+	h.OnAdd(ir1)
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_http",
 				VirtualHosts: []route.VirtualHost{{
 					Name:    "example.com",
@@ -1356,21 +1342,21 @@ func TestRDSIngressRouteInsideRootNamespaces(t *testing.T) {
 				}},
 			}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc, "ingress_http"))
+	}, h.FetchRDS("ingress_http"))
 }
 
 func TestRDSIngressRouteOutsideRootNamespaces(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.IngressRouteRootNamespaces = []string{"roots"}
 		reh.Notifier.(*contour.CacheHandler).IngressRouteStatus = &k8s.IngressRouteStatus{
 			Client: fake.NewSimpleClientset(),
 		}
 	})
-	defer done()
+	defer h.Close()
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "kuard",
 			Namespace: "default",
@@ -1403,30 +1389,30 @@ func TestRDSIngressRouteOutsideRootNamespaces(t *testing.T) {
 	}
 
 	// add ingressroute
-	rh.OnAdd(ir1)
+	h.OnAdd(ir1)
 
-	assertEqual(t, &v2.DiscoveryResponse{
+	h.AssertEqual(&v2.DiscoveryResponse{
 		VersionInfo: "0",
 		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
+			h.Any(&v2.RouteConfiguration{
 				Name: "ingress_http",
 			}),
 		},
-		TypeUrl: routeType,
+		TypeUrl: contourtest.RouteType,
 		Nonce:   "0",
-	}, streamRDS(t, cc, "ingress_http"))
+	}, h.FetchRDS("ingress_http"))
 }
 
 // Test DAGAdapter.IngressClass setting works, this could be done
 // in LDS or RDS, or even CDS, but this test mirrors the place it's
 // tested in internal/contour/route_test.go
 func TestRDSIngressClass(t *testing.T) {
-	rh, cc, done := setup(t, func(reh *contour.ResourceEventHandler) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
 		reh.IngressClass = "linkerd"
 	})
-	defer done()
+	defer h.Close()
 
-	rh.OnAdd(&v1.Service{
+	h.OnAdd(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "kuard",
 			Namespace: "default",
@@ -1452,8 +1438,8 @@ func TestRDSIngressClass(t *testing.T) {
 			},
 		},
 	}
-	rh.OnAdd(i1)
-	assertRDS(t, cc, []route.VirtualHost{{
+	h.OnAdd(i1)
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "*",
 		Domains: []string{"*"},
 		Routes: []route.Route{{
@@ -1477,8 +1463,8 @@ func TestRDSIngressClass(t *testing.T) {
 			},
 		},
 	}
-	rh.OnUpdate(i1, i2)
-	assertRDS(t, cc, nil, nil)
+	h.OnUpdate(i1, i2)
+	assertRDS(h, nil, nil)
 
 	i3 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -1495,8 +1481,8 @@ func TestRDSIngressClass(t *testing.T) {
 			},
 		},
 	}
-	rh.OnUpdate(i2, i3)
-	assertRDS(t, cc, []route.VirtualHost{{
+	h.OnUpdate(i2, i3)
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "*",
 		Domains: []string{"*"},
 		Routes: []route.Route{{
@@ -1505,15 +1491,15 @@ func TestRDSIngressClass(t *testing.T) {
 		}},
 	}}, nil)
 
-	rh.OnUpdate(i3, i2)
-	assertRDS(t, cc, nil, nil)
+	h.OnUpdate(i3, i2)
+	assertRDS(h, nil, nil)
 }
 
 // issue 523, check for data races caused by accidentally
 // sorting the contents of an RDS entry's virtualhost list.
 func TestRDSAssertNoDataRaceDuringInsertAndStream(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	stop := make(chan struct{})
 
@@ -1531,11 +1517,11 @@ func TestRDSAssertNoDataRaceDuringInsertAndStream(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
 	go func() {
 		for i := 0; i < 100; i++ {
-			rh.OnAdd(&ingressroutev1.IngressRoute{
+			h.OnAdd(&ingressroutev1.IngressRoute{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      fmt.Sprintf("simple-%d", i),
 					Namespace: "default",
@@ -1560,7 +1546,7 @@ func TestRDSAssertNoDataRaceDuringInsertAndStream(t *testing.T) {
 		case <-stop:
 			return
 		default:
-			streamRDS(t, cc)
+			h.FetchRDS()
 		}
 	}
 }
@@ -1584,8 +1570,8 @@ func TestRDSAssertNoDataRaceDuringInsertAndStream(t *testing.T) {
 // note: this test caused a panic in dag.Builder, but testing the
 // context of RDS is a good place to start.
 func TestRDSIngressSpecMissingHTTPKey(t *testing.T) {
-	rh, cc, done := setup(t)
-	defer done()
+	h := contourtest.NewHarness(t)
+	defer h.Close()
 
 	i1 := &v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
@@ -1611,7 +1597,7 @@ func TestRDSIngressSpecMissingHTTPKey(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(i1)
+	h.OnAdd(i1)
 
 	s1 := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -1627,9 +1613,9 @@ func TestRDSIngressSpecMissingHTTPKey(t *testing.T) {
 			}},
 		},
 	}
-	rh.OnAdd(s1)
+	h.OnAdd(s1)
 
-	assertRDS(t, cc, []route.VirtualHost{{
+	assertRDS(h, []route.VirtualHost{{
 		Name:    "test2.test.com",
 		Domains: []string{"test2.test.com", "test2.test.com:80"},
 		Routes: []route.Route{{
@@ -1639,39 +1625,302 @@ func TestRDSIngressSpecMissingHTTPKey(t *testing.T) {
 	}}, nil)
 }
 
-func assertRDS(t *testing.T, cc *grpc.ClientConn, ingress_http, ingress_https []route.VirtualHost) {
-	t.Helper()
-	assertEqual(t, &v2.DiscoveryResponse{
-		VersionInfo: "0",
-		Resources: []types.Any{
-			any(t, &v2.RouteConfiguration{
-				Name:         "ingress_http",
-				VirtualHosts: ingress_http,
-			}),
-			any(t, &v2.RouteConfiguration{
-				Name:         "ingress_https",
-				VirtualHosts: ingress_https,
-			}),
+// assertRDS asserts the contents of ingress_http and ingress_https. If ingress_https
+// is empty, the ingress_https route configuration is expected to be absent.
+func TestRDSDisableHTTPS(t *testing.T) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
+		reh.Notifier.(*contour.CacheHandler).DisableHTTPS = true
+	})
+	defer h.Close()
+
+	h.OnAdd(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "default",
 		},
-		TypeUrl: routeType,
-		Nonce:   "0",
-	}, streamRDS(t, cc))
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte("certificate"),
+			v1.TLSPrivateKeyKey: []byte("key"),
+		},
+	})
+	h.OnAdd(service("default", "kuard", v1.ServicePort{
+		Protocol:   "TCP",
+		Port:       80,
+		TargetPort: intstr.FromInt(8080),
+	}))
+	h.OnAdd(&v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "simple",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			TLS: []v1beta1.IngressTLS{{
+				Hosts:      []string{"kuard.example.com"},
+				SecretName: "secret",
+			}},
+			Rules: []v1beta1.IngressRule{{
+				Host: "kuard.example.com",
+				IngressRuleValue: v1beta1.IngressRuleValue{
+					HTTP: &v1beta1.HTTPIngressRuleValue{
+						Paths: []v1beta1.HTTPIngressPath{{
+							Backend: *backend("kuard", intstr.FromInt(80)),
+						}},
+					},
+				},
+			}},
+		},
+	})
+
+	// assert that ingress_https is not present.
+	assertRDS(h, []route.VirtualHost{{
+		Name:    "kuard.example.com",
+		Domains: []string{"kuard.example.com", "kuard.example.com:80"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: routecluster("default/kuard/80"),
+		}},
+	}}, nil)
+}
+
+func TestRDSDefaultResponse(t *testing.T) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
+		reh.DefaultResponse = &dag.DefaultResponse{Status: 404}
+	})
+	defer h.Close()
+
+	h.OnAdd(service("default", "kuard", v1.ServicePort{
+		Protocol:   "TCP",
+		Port:       80,
+		TargetPort: intstr.FromInt(8080),
+	}))
+
+	// assert that the catch-all vhost is present with no ingress objects.
+	assertRDS(h, []route.VirtualHost{{
+		Name:    "*",
+		Domains: []string{"*"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: directresponse(404),
+		}},
+	}}, nil)
+	i1 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "simple",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{{
+				Host: "kuard.example.com",
+				IngressRuleValue: v1beta1.IngressRuleValue{
+					HTTP: &v1beta1.HTTPIngressRuleValue{
+						Paths: []v1beta1.HTTPIngressPath{{
+							Backend: *backend("kuard", intstr.FromInt(80)),
+						}},
+					},
+				},
+			}},
+		},
+	}
+	h.OnAdd(i1)
+
+	// assert that the catch-all vhost sorts after the user's vhost.
+	assertRDS(h, []route.VirtualHost{{
+		Name:    "kuard.example.com",
+		Domains: []string{"kuard.example.com", "kuard.example.com:80"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: routecluster("default/kuard/80"),
+		}},
+	}, {
+		Name:    "*",
+		Domains: []string{"*"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: directresponse(404),
+		}},
+	}}, nil)
+
+	// i2 adds a default backend, which takes precedence
+	// over the catch-all vhost.
+	i2 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: backend("kuard", intstr.FromInt(80)),
+		},
+	}
+	h.OnAdd(i2)
+	assertRDS(h, []route.VirtualHost{{
+		Name:    "*",
+		Domains: []string{"*"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: routecluster("default/kuard/80"),
+		}},
+	}, {
+		Name:    "kuard.example.com",
+		Domains: []string{"kuard.example.com", "kuard.example.com:80"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: routecluster("default/kuard/80"),
+		}},
+	}}, nil)
+}
+
+func TestRDSDefaultResponseRouteTo(t *testing.T) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
+		reh.DefaultResponse = &dag.DefaultResponse{
+			Namespace: "heptio-contour",
+			Name:      "default-backend",
+			Port:      intstr.FromInt(8080),
+		}
+	})
+	defer h.Close()
+
+	h.OnAdd(service("default", "kuard", v1.ServicePort{
+		Protocol:   "TCP",
+		Port:       80,
+		TargetPort: intstr.FromInt(8080),
+	}))
+
+	// assert that the catch-all responds with 503
+	// while the default backend is missing.
+	assertRDS(h, []route.VirtualHost{{
+		Name:    "*",
+		Domains: []string{"*"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: directresponse(503),
+		}},
+	}}, nil)
+
+	h.OnAdd(service("heptio-contour", "default-backend", v1.ServicePort{
+		Protocol:   "TCP",
+		Port:       8080,
+		TargetPort: intstr.FromInt(8080),
+	}))
+
+	assertRDS(h, []route.VirtualHost{{
+		Name:    "*",
+		Domains: []string{"*"},
+		Routes: []route.Route{{
+			Match:  prefixmatch("/"),
+			Action: routecluster("heptio-contour/default-backend/8080"),
+		}},
+	}}, nil)
 }
 
-func streamRDS(t *testing.T, cc *grpc.ClientConn, rn ...string) *v2.DiscoveryResponse {
+func TestRDSFetchVersion(t *testing.T) {
+	h := contourtest.NewHarness(t)
+	defer h.Close()
+
+	h.OnAdd(&v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kuard",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: &v1beta1.IngressBackend{
+				ServiceName: "kuard",
+				ServicePort: intstr.FromInt(80),
+			},
+		},
+	})
+	h.OnAdd(service("default", "kuard", v1.ServicePort{
+		Protocol: "TCP",
+		Port:     80,
+	}))
+
+	resources := []types.Any{
+		h.Any(&v2.RouteConfiguration{
+			Name: "ingress_http",
+			VirtualHosts: []route.VirtualHost{{
+				Name:    "*",
+				Domains: []string{"*"},
+				Routes: []route.Route{{
+					Match:  prefixmatch("/"),
+					Action: routecluster("default/kuard/80"),
+				}},
+			}},
+		}),
+	}
+	first := fetchRDS(t, h.ClientConn(), "")
+	if first.VersionInfo == "" || first.VersionInfo == "0" {
+		t.Fatalf("expected a content version, got %q", first.VersionInfo)
+	}
+	h.AssertEqual(&v2.DiscoveryResponse{
+		VersionInfo: first.VersionInfo,
+		Resources:   resources,
+		TypeUrl:     contourtest.RouteType,
+		Nonce:       first.VersionInfo,
+	}, first)
+
+	// polling with the current version returns no resources.
+	h.AssertEqual(&v2.DiscoveryResponse{
+		VersionInfo: first.VersionInfo,
+		TypeUrl:     contourtest.RouteType,
+		Nonce:       first.VersionInfo,
+	}, fetchRDS(t, h.ClientConn(), first.VersionInfo))
+
+	// a change to the routes moves the version on.
+	h.OnAdd(&v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "www",
+			Namespace: "default",
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{{
+				Host: "www.example.com",
+				IngressRuleValue: v1beta1.IngressRuleValue{
+					HTTP: &v1beta1.HTTPIngressRuleValue{
+						Paths: []v1beta1.HTTPIngressPath{{
+							Backend: v1beta1.IngressBackend{
+								ServiceName: "kuard",
+								ServicePort: intstr.FromInt(80),
+							},
+						}},
+					},
+				},
+			}},
+		},
+	})
+	changed := fetchRDS(t, h.ClientConn(), first.VersionInfo)
+	if changed.VersionInfo == first.VersionInfo || len(changed.Resources) != 1 {
+		t.Fatalf("expected a new version and one resource, got %q and %d resources", changed.VersionInfo, len(changed.Resources))
+	}
+}
+
+func assertRDS(h *contourtest.Harness, ingress_http, ingress_https []route.VirtualHost) {
+	want := []proto.Message{
+		&v2.RouteConfiguration{
+			Name:         "ingress_http",
+			VirtualHosts: ingress_http,
+		},
+	}
+	if len(ingress_https) > 0 {
+		want = append(want, &v2.RouteConfiguration{
+			Name:         "ingress_https",
+			VirtualHosts: ingress_https,
+		})
+	}
+	h.AssertRDS(want...)
+}
+
+func fetchRDS(t *testing.T, cc *grpc.ClientConn, version string, rn ...string) *v2.DiscoveryResponse {
 	t.Helper()
 	rds := v2.NewRouteDiscoveryServiceClient(cc)
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
 	defer cancel()
-	st, err := rds.StreamRoutes(ctx)
-	if err != nil {
-		t.Fatal(err)
-	}
-	return stream(t, st, &v2.DiscoveryRequest{
-		TypeUrl:       routeType,
+	resp, err := rds.FetchRoutes(ctx, &v2.DiscoveryRequest{
+		TypeUrl:       contourtest.RouteType,
+		VersionInfo:   version,
 		ResourceNames: rn,
 	})
+	check(t, err)
+	return resp
 }
 
 func prefixmatch(prefix string) route.RouteMatch {
@@ -1724,6 +1973,15 @@ func service(ns, name string, ports ...v1.ServicePort) *v1.Service {
 	}
 }
 
+// directresponse returns a direct response with the supplied status.
+func directresponse(status uint32) *route.Route_DirectResponse {
+	return &route.Route_DirectResponse{
+		DirectResponse: &route.DirectResponseAction{
+			Status: status,
+		},
+	}
+}
+
 // redirecthttps returns a 301 redirect to the HTTPS scheme.
 func redirecthttps() *route.Route_Redirect {
 	return &route.Route_Redirect{
@@ -0,0 +1,140 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	"github.com/heptio/contour/contourtest"
+	"github.com/heptio/contour/internal/contour"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestSDSSecretRotation(t *testing.T) {
+	h := contourtest.NewHarness(t, func(reh *contour.ResourceEventHandler) {
+		reh.Notifier.(*contour.CacheHandler).UseSDS = true
+	})
+	defer h.Close()
+
+	s1 := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte("certificate"),
+			v1.TLSPrivateKeyKey: []byte("key"),
+		},
+	}
+
+	i1 := &v1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "simple",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"kubernetes.io/ingress.allow-http": "false",
+			},
+		},
+		Spec: v1beta1.IngressSpec{
+			Backend: backend("backend", intstr.FromInt(80)),
+			TLS: []v1beta1.IngressTLS{{
+				Hosts:      []string{"kuard.example.com"},
+				SecretName: "secret",
+			}},
+		},
+	}
+	h.OnAdd(s1)
+	h.OnAdd(i1)
+
+	// the listener refers to the secret by name.
+	lds := h.Watch(contourtest.ListenerType)
+	defer lds.Close()
+	fc := filterchain(false, httpfilter("ingress_https"))
+	fc.FilterChainMatch = &listener.FilterChainMatch{
+		SniDomains: []string{"kuard.example.com"},
+	}
+	fc.TlsContext = &auth.DownstreamTlsContext{
+		CommonTlsContext: &auth.CommonTlsContext{
+			TlsParams: &auth.TlsParameters{
+				TlsMinimumProtocolVersion: auth.TlsParameters_TLSv1_1,
+			},
+			TlsCertificateSdsSecretConfigs: []*auth.SdsSecretConfig{{
+				Name: "default/secret",
+				SdsConfig: &core.ConfigSource{
+					ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
+						ApiConfigSource: &core.ApiConfigSource{
+							ApiType:      core.ApiConfigSource_GRPC,
+							ClusterNames: []string{"contour"},
+						},
+					},
+				},
+			}},
+			AlpnProtocols: []string{"h2", "http/1.1"},
+		},
+	}
+	lds.AssertNext(&v2.Listener{
+		Name:         "ingress_https",
+		Address:      socketaddress("0.0.0.0", 8443),
+		FilterChains: []listener.FilterChain{fc},
+	})
+
+	// the certificate is served over SDS.
+	sds := h.Watch(contourtest.SecretType, "default/secret")
+	defer sds.Close()
+	sds.AssertNext(tlssecret("default/secret", "certificate", "key"))
+
+	// rotate the certificate.
+	s2 := &v1.Secret{
+		ObjectMeta: s1.ObjectMeta,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte("rotated-certificate"),
+			v1.TLSPrivateKeyKey: []byte("rotated-key"),
+		},
+	}
+	h.OnUpdate(s1, s2)
+
+	// the new certificate is delivered on the open SDS stream.
+	sds.AssertNext(tlssecret("default/secret", "rotated-certificate", "rotated-key"))
+
+	// and the listener is not sent again.
+	lds.AssertIdle(100 * time.Millisecond)
+}
+
+func tlssecret(name, cert, key string) *auth.Secret {
+	return &auth.Secret{
+		Name: name,
+		Type: &auth.Secret_TlsCertificate{
+			TlsCertificate: &auth.TlsCertificate{
+				CertificateChain: &core.DataSource{
+					Specifier: &core.DataSource_InlineBytes{
+						InlineBytes: []byte(cert),
+					},
+				},
+				PrivateKey: &core.DataSource{
+					Specifier: &core.DataSource_InlineBytes{
+						InlineBytes: []byte(key),
+					},
+				},
+			},
+		},
+	}
+}
@@ -18,7 +18,9 @@ package envoy
 
 import (
 	"io"
+	"strconv"
 	"text/template"
+	"time"
 )
 
 // A ConfigWriter knows how to write a bootstap Envoy configuration in YAML format.
@@ -68,6 +70,10 @@ type ConfigWriter struct {
 	// StatsdPort is port of the statsd endpoint
 	// Defaults to 9125.
 	StatsdPort int
+
+	// StatsFlushInterval is how often Envoy flushes stats to its sinks.
+	// Defaults to omitted, which Envoy treats as 5s.
+	StatsFlushInterval time.Duration
 }
 
 const yamlConfig = `dynamic_resources:
@@ -151,6 +157,8 @@ stats_sinks:
           address: {{ if .StatsdAddress }}{{ .StatsdAddress }}{{ else }}127.0.0.1{{ end }}
           port_value: {{ if .StatsdPort }}{{ .StatsdPort }}{{ else }}9125{{ end }}
 {{ end -}}
+{{ if .StatsFlushInterval }}stats_flush_interval: {{ seconds .StatsFlushInterval }}
+{{ end -}}
 admin:
   access_log_path: {{ if .AdminAccessLogPath }}{{ .AdminAccessLogPath }}{{ else }}/dev/null{{ end }}
   address:
@@ -162,9 +170,16 @@ admin:
 // WriteYAML writes the configuration to the supplied writer in YAML v2 format.
 // If the supplied io.Writer is a file, it should end with a .yaml extension.
 func (c *ConfigWriter) WriteYAML(w io.Writer) error {
-	t, err := template.New("config").Parse(yamlConfig)
+	t, err := template.New("config").Funcs(template.FuncMap{
+		"seconds": seconds,
+	}).Parse(yamlConfig)
 	if err != nil {
 		return err
 	}
 	return t.Execute(w, c)
 }
+
+// seconds formats d as a protobuf JSON duration, eg. 1.5s.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
@@ -16,6 +16,7 @@ package envoy
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 func TestConfigWriter_WriteYAML(t *testing.T) {
@@ -164,6 +165,66 @@ stats_sinks:
           protocol: UDP
           address: 127.0.0.1
           port_value: 9125
+admin:
+  access_log_path: /dev/null
+  address:
+    socket_address:
+      address: 127.0.0.1
+      port_value: 9001
+`,
+		},
+		"stats flush interval": {
+			ConfigWriter: ConfigWriter{
+				StatsFlushInterval: 1500 * time.Millisecond,
+			},
+			want: `dynamic_resources:
+  lds_config:
+    api_config_source:
+      api_type: GRPC
+      cluster_names: [contour]
+      grpc_services:
+      - envoy_grpc:
+          cluster_name: contour
+  cds_config:
+    api_config_source:
+      api_type: GRPC
+      cluster_names: [contour]
+      grpc_services:
+      - envoy_grpc:
+          cluster_name: contour
+static_resources:
+  clusters:
+  - name: contour
+    connect_timeout: { seconds: 5 }
+    type: STRICT_DNS
+    hosts:
+    - socket_address:
+        address: 127.0.0.1
+        port_value: 8001
+    lb_policy: ROUND_ROBIN
+    http2_protocol_options: {}
+    circuit_breakers:
+      thresholds:
+        - priority: high
+          max_connections: 100000
+          max_pending_requests: 100000
+          max_requests: 60000000
+          max_retries: 50
+        - priority: default
+          max_connections: 100000
+          max_pending_requests: 100000
+          max_requests: 60000000
+          max_retries: 50
+  - name: service_stats
+    connect_timeout: 0.250s
+    type: LOGICAL_DNS
+    lb_policy: ROUND_ROBIN
+    hosts:
+      - socket_address:
+          protocol: TCP
+          address: 127.0.0.1
+          port_value: 9001
+stats_flush_interval: 1.5s
 admin:
   access_log_path: /dev/null
   address:
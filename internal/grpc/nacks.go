@@ -0,0 +1,189 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NACKs detects NACK storms; versions of a type's resources which the
+// connected Envoys reject again and again, eg. because an invalid
+// object slipped past Contour's validation. A NACKs is safe for
+// concurrent use and its zero value detects nothing.
+type NACKs struct {
+	// Threshold is the number of consecutive NACKs, across every
+	// stream of a type, of one version of its resources after which
+	// that version is rejected.
+	// If not set, versions are never rejected.
+	Threshold int
+
+	// Rollback, if true, re-serves to each stream the resources its
+	// Envoy last ACKed in place of a rejected version, until the
+	// resources change again.
+	Rollback bool
+
+	// Notify, if set, is called after a version is rejected and
+	// after a rejected version is superseded, so that Rejections
+	// may be reported afresh.
+	Notify func()
+
+	mu    sync.Mutex
+	types map[string]*nackState
+}
+
+// nackState is the NACK bookkeeping of a single type. A version is
+// only tracked while it is the latest version of some stream, so the
+// versions superseded on every stream are forgotten.
+type nackState struct {
+	streams  map[string]int    // the number of streams whose latest version each is
+	nacks    map[string]int    // the consecutive NACKs of each version
+	rejected map[string]string // the error detail of each rejected version
+
+	// rejectedc is closed, and replaced, each time a version is
+	// rejected.
+	rejectedc chan struct{}
+}
+
+// state returns the nackState of typeURL. n.mu must be held.
+func (n *NACKs) state(typeURL string) *nackState {
+	if n.types == nil {
+		n.types = make(map[string]*nackState)
+	}
+	s, ok := n.types[typeURL]
+	if !ok {
+		s = &nackState{
+			streams:   make(map[string]int),
+			nacks:     make(map[string]int),
+			rejected:  make(map[string]string),
+			rejectedc: make(chan struct{}),
+		}
+		n.types[typeURL] = s
+	}
+	return s
+}
+
+// retain records that version is the latest version of the typeURL
+// resources of a stream.
+func (n *NACKs) retain(typeURL, version string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.state(typeURL).streams[version]++
+}
+
+// release records that version is no longer the latest version of
+// the typeURL resources of a stream. Once it is no stream's latest
+// version its NACKs, and any rejection, are forgotten.
+func (n *NACKs) release(typeURL, version string) {
+	if version == "" {
+		return
+	}
+	if n.forget(typeURL, version) && n.Notify != nil {
+		n.Notify()
+	}
+}
+
+// forget forgets version of the typeURL resources if no stream still
+// retains it. It reports whether a rejected version was forgotten.
+func (n *NACKs) forget(typeURL, version string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := n.state(typeURL)
+	if s.streams[version]--; s.streams[version] > 0 {
+		return false
+	}
+	delete(s.streams, version)
+	delete(s.nacks, version)
+	_, rejected := s.rejected[version]
+	delete(s.rejected, version)
+	return rejected
+}
+
+// nack records a NACK, with Envoy's error detail, of version of the
+// typeURL resources. It reports whether this NACK rejected the version.
+// NACKs of a version which is no stream's latest are not counted.
+func (n *NACKs) nack(typeURL, version, detail string) bool {
+	rejected := n.count(typeURL, version, detail)
+	if rejected && n.Notify != nil {
+		n.Notify()
+	}
+	return rejected
+}
+
+// count counts a NACK of version of the typeURL resources, rejecting
+// the version once its consecutive NACKs reach the threshold.
+func (n *NACKs) count(typeURL, version, detail string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := n.state(typeURL)
+	if s.streams[version] == 0 {
+		return false
+	}
+	s.nacks[version]++
+	if n.Threshold <= 0 || s.nacks[version] != n.Threshold {
+		return false
+	}
+	s.rejected[version] = detail
+	close(s.rejectedc)
+	s.rejectedc = make(chan struct{})
+	return true
+}
+
+// ack records an ACK of version of the typeURL resources, which ends
+// the run of NACKs of that version.
+func (n *NACKs) ack(typeURL, version string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.state(typeURL).nacks, version)
+}
+
+// rejected reports whether version of the typeURL resources has been
+// rejected.
+func (n *NACKs) rejected(typeURL, version string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.state(typeURL).rejected[version]
+	return ok
+}
+
+// wait returns a channel which is closed the next time a version of
+// the typeURL resources is rejected.
+func (n *NACKs) wait(typeURL string) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state(typeURL).rejectedc
+}
+
+// Rejections describes each rejected version which is yet to be
+// superseded by the name of its type, eg. RouteConfiguration, and the
+// error detail of the NACK which rejected it.
+func (n *NACKs) Rejections() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	seen := make(map[string]bool)
+	var rejections []string
+	for typeURL, s := range n.types {
+		name := typeURL[strings.LastIndex(typeURL, ".")+1:]
+		for _, detail := range s.rejected {
+			r := name + ": " + detail
+			if !seen[r] {
+				seen[r] = true
+				rejections = append(rejections, r)
+			}
+		}
+	}
+	sort.Strings(rejections)
+	return rejections
+}
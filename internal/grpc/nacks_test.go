@@ -0,0 +1,183 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNACKsRejected(t *testing.T) {
+	tests := map[string]struct {
+		threshold int
+		events    func(n *NACKs)
+		want      map[string]bool
+	}{
+		"disabled": {
+			events: func(n *NACKs) {
+				for i := 0; i < 10; i++ {
+					n.nack(clusterType, "v1", "rejected")
+				}
+			},
+			want: map[string]bool{"v1": false},
+		},
+		"below threshold": {
+			threshold: 3,
+			events: func(n *NACKs) {
+				n.nack(clusterType, "v1", "rejected")
+				n.nack(clusterType, "v1", "rejected")
+			},
+			want: map[string]bool{"v1": false},
+		},
+		"at threshold": {
+			threshold: 3,
+			events: func(n *NACKs) {
+				n.nack(clusterType, "v1", "rejected")
+				n.nack(clusterType, "v1", "rejected")
+				n.nack(clusterType, "v1", "rejected")
+			},
+			want: map[string]bool{"v1": true},
+		},
+		"ack breaks the run": {
+			threshold: 3,
+			events: func(n *NACKs) {
+				n.nack(clusterType, "v1", "rejected")
+				n.nack(clusterType, "v1", "rejected")
+				n.ack(clusterType, "v1")
+				n.nack(clusterType, "v1", "rejected")
+			},
+			want: map[string]bool{"v1": false},
+		},
+		"ack of another version does not break the run": {
+			threshold: 3,
+			events: func(n *NACKs) {
+				n.nack(clusterType, "v2", "rejected")
+				n.ack(clusterType, "v1")
+				n.nack(clusterType, "v2", "rejected")
+				n.nack(clusterType, "v2", "rejected")
+			},
+			want: map[string]bool{"v1": false, "v2": true},
+		},
+		"versions are counted apart": {
+			threshold: 2,
+			events: func(n *NACKs) {
+				n.nack(clusterType, "v1", "rejected")
+				n.nack(clusterType, "v2", "rejected")
+				n.nack(clusterType, "v1", "rejected")
+			},
+			want: map[string]bool{"v1": true, "v2": false},
+		},
+		"superseded versions are not counted": {
+			threshold: 2,
+			events: func(n *NACKs) {
+				n.release(clusterType, "v1")
+				n.nack(clusterType, "v1", "rejected")
+				n.nack(clusterType, "v1", "rejected")
+			},
+			want: map[string]bool{"v1": false},
+		},
+		"superseded versions are forgotten": {
+			threshold: 1,
+			events: func(n *NACKs) {
+				n.nack(clusterType, "v1", "rejected")
+				n.release(clusterType, "v1")
+			},
+			want: map[string]bool{"v1": false},
+		},
+		"types are counted apart": {
+			threshold: 2,
+			events: func(n *NACKs) {
+				n.nack(clusterType, "v1", "rejected")
+				n.nack(routeType, "v1", "rejected")
+			},
+			want: map[string]bool{"v1": false},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			n := NACKs{Threshold: tc.threshold}
+			for _, version := range []string{"v1", "v2"} {
+				n.retain(clusterType, version)
+				n.retain(routeType, version)
+			}
+			tc.events(&n)
+			got := make(map[string]bool)
+			for version := range tc.want {
+				got[version] = n.rejected(clusterType, version)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNACKsWait(t *testing.T) {
+	n := NACKs{Threshold: 2}
+	n.retain(clusterType, "v1")
+	rejected := n.wait(clusterType)
+	if n.nack(clusterType, "v1", "rejected") {
+		t.Fatal("expected the first nack not to reject v1")
+	}
+	select {
+	case <-rejected:
+		t.Fatal("expected no rejection after the first nack")
+	default:
+	}
+	if !n.nack(clusterType, "v1", "rejected") {
+		t.Fatal("expected the second nack to reject v1")
+	}
+	select {
+	case <-rejected:
+	default:
+		t.Fatal("expected a rejection after the second nack")
+	}
+	if n.nack(clusterType, "v1", "rejected") {
+		t.Fatal("expected v1 to be rejected only once")
+	}
+}
+
+func TestNACKsRejections(t *testing.T) {
+	notified := 0
+	n := NACKs{
+		Threshold: 1,
+		Notify:    func() { notified++ },
+	}
+	n.retain(routeType, "v1")
+	n.retain(routeType, "v1") // a second stream
+	n.retain(clusterType, "v1")
+
+	n.nack(routeType, "v1", "invalid regex")
+	n.nack(clusterType, "v1", "unknown cluster")
+	want := []string{"Cluster: unknown cluster", "RouteConfiguration: invalid regex"}
+	if got := n.Rejections(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+
+	// a rejection lasts until no stream serves the version.
+	n.release(routeType, "v1")
+	n.release(clusterType, "v1")
+	want = []string{"RouteConfiguration: invalid regex"}
+	if got := n.Rejections(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+	n.release(routeType, "v1")
+	if got := n.Rejections(); len(got) != 0 {
+		t.Fatalf("expected no rejections, got: %v", got)
+	}
+	if notified != 4 {
+		t.Fatalf("expected 4 notifications, got: %d", notified)
+	}
+}
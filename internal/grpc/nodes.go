@@ -0,0 +1,195 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/heptio/contour/internal/metrics"
+)
+
+// Nodes records, for each open xDS stream, which generation of its
+// resources has been sent to the connected Envoy and which generation
+// that Envoy has applied. A Nodes is safe for concurrent use and its
+// zero value is ready to use.
+type Nodes struct {
+	mu      sync.Mutex
+	streams map[uint64]*streamState
+}
+
+// streamState is the ACK bookkeeping of a single stream.
+//
+// Stream responses carry a fixed version and nonce so Envoy's replies
+// cannot be matched to a response by either. Instead, as Envoy answers
+// every response, in order, with exactly one ACK or NACK, the replies
+// are matched to the oldest unanswered response.
+type streamState struct {
+	node    string
+	typeURL string
+
+	pending []sent // responses not yet answered, oldest first
+	sent    int    // generation of the last response sent
+	applied int    // generation of the last response ACKed, -1 if none
+
+	// since is when the first response after applied was sent, it
+	// is zero while Envoy holds the last response sent.
+	since time.Time
+
+	errorDetail string // the message of the last NACK, if any
+}
+
+type sent struct {
+	generation int
+	at         time.Time
+}
+
+// StaleNode describes a stream whose Envoy has not applied the
+// latest generation of its resources.
+type StaleNode struct {
+	Node    string `json:"node"`
+	TypeURL string `json:"typeURL"`
+
+	// Sent is the generation last sent to the node.
+	Sent int `json:"sent"`
+
+	// Applied is the generation last ACKed by the node, -1 if none.
+	Applied int `json:"applied"`
+
+	// Lag is how long the node has not applied a response sent to it.
+	Lag string `json:"lag"`
+
+	// ErrorDetail is the message of the node's last NACK, if any.
+	ErrorDetail string `json:"errorDetail,omitempty"`
+}
+
+// open records a new stream, id, of typeURL resources to node.
+func (n *Nodes) open(id uint64, node, typeURL string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.streams == nil {
+		n.streams = make(map[uint64]*streamState)
+	}
+	n.streams[id] = &streamState{
+		node:    node,
+		typeURL: typeURL,
+		sent:    -1,
+		applied: -1,
+	}
+}
+
+// close forgets stream id.
+func (n *Nodes) close(id uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.streams, id)
+}
+
+// send records that generation was sent on stream id at now.
+func (n *Nodes) send(id uint64, generation int, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s, ok := n.streams[id]
+	if !ok {
+		return
+	}
+	s.pending = append(s.pending, sent{generation: generation, at: now})
+	s.sent = generation
+	if s.since.IsZero() {
+		s.since = now
+	}
+}
+
+// answer records Envoy's reply on stream id to its oldest unanswered
+// response, a NACK if nack is set.
+func (n *Nodes) answer(id uint64, nack bool, errorDetail string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s, ok := n.streams[id]
+	if !ok || len(s.pending) == 0 {
+		return
+	}
+	oldest := s.pending[0]
+	s.pending = s.pending[1:]
+	if nack {
+		// a NACKed response was not applied, the clock keeps running.
+		s.errorDetail = errorDetail
+		return
+	}
+	s.applied = oldest.generation
+	s.errorDetail = ""
+	switch {
+	case s.applied == s.sent:
+		s.since = time.Time{}
+	case len(s.pending) > 0:
+		s.since = s.pending[0].at
+	}
+}
+
+// Stale returns the streams whose Envoy has not applied a response
+// sent to it more than lag before now, ordered by node and type URL.
+func (n *Nodes) Stale(now time.Time, lag time.Duration) []StaleNode {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var stale []StaleNode
+	for _, s := range n.streams {
+		if s.since.IsZero() || now.Sub(s.since) <= lag {
+			continue
+		}
+		stale = append(stale, StaleNode{
+			Node:        s.node,
+			TypeURL:     s.typeURL,
+			Sent:        s.sent,
+			Applied:     s.applied,
+			Lag:         now.Sub(s.since).String(),
+			ErrorDetail: s.errorDetail,
+		})
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].Node == stale[j].Node {
+			return stale[i].TypeURL < stale[j].TypeURL
+		}
+		return stale[i].Node < stale[j].Node
+	})
+	return stale
+}
+
+// Reconcile counts, every interval until stop is closed, the nodes
+// with a stream which has lagged more than lag and records the count
+// in m. If interval is not positive the count is taken every second.
+func (n *Nodes) Reconcile(stop <-chan struct{}, interval, lag time.Duration, m *metrics.Metrics) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.SetXDSStaleNodes(staleNodes(n.Stale(now, lag)))
+		case <-stop:
+			return
+		}
+	}
+}
+
+// staleNodes returns the number of distinct nodes in stale.
+func staleNodes(stale []StaleNode) int {
+	nodes := make(map[string]bool)
+	for _, s := range stale {
+		nodes[s.Node] = true
+	}
+	return len(nodes)
+}
@@ -0,0 +1,156 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNodesStale(t *testing.T) {
+	epoch := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return epoch.Add(d) }
+
+	tests := map[string]struct {
+		events func(n *Nodes)
+		want   []StaleNode
+	}{
+		"acked in time": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-1", clusterType)
+				n.send(1, 1, at(0))
+				n.answer(1, false, "")
+			},
+		},
+		"never acked": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-1", clusterType)
+				n.send(1, 1, at(0))
+			},
+			want: []StaleNode{{
+				Node:    "envoy-1",
+				TypeURL: clusterType,
+				Sent:    1,
+				Applied: -1,
+				Lag:     "2m0s",
+			}},
+		},
+		"stopped acking": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-1", clusterType)
+				n.send(1, 1, at(0))
+				n.answer(1, false, "")
+				n.send(1, 2, at(30*time.Second))
+				n.send(1, 3, at(90*time.Second))
+			},
+			want: []StaleNode{{
+				Node:    "envoy-1",
+				TypeURL: clusterType,
+				Sent:    3,
+				Applied: 1,
+				Lag:     "1m30s",
+			}},
+		},
+		"caught up after lagging": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-1", clusterType)
+				n.send(1, 1, at(0))
+				n.send(1, 2, at(90*time.Second))
+				n.answer(1, false, "")
+				n.answer(1, false, "")
+			},
+		},
+		"acked an older response": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-1", clusterType)
+				n.send(1, 1, at(0))
+				n.send(1, 2, at(30*time.Second))
+				n.answer(1, false, "")
+			},
+			want: []StaleNode{{
+				Node:    "envoy-1",
+				TypeURL: clusterType,
+				Sent:    2,
+				Applied: 1,
+				Lag:     "1m30s",
+			}},
+		},
+		"nack loop": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-1", listenerType)
+				n.send(1, 1, at(0))
+				n.answer(1, true, "invalid listener")
+				n.send(1, 2, at(60*time.Second))
+				n.answer(1, true, "invalid listener again")
+			},
+			want: []StaleNode{{
+				Node:        "envoy-1",
+				TypeURL:     listenerType,
+				Sent:        2,
+				Applied:     -1,
+				Lag:         "2m0s",
+				ErrorDetail: "invalid listener again",
+			}},
+		},
+		"closed stream": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-1", clusterType)
+				n.send(1, 1, at(0))
+				n.close(1)
+			},
+		},
+		"ordered by node and type": {
+			events: func(n *Nodes) {
+				n.open(1, "envoy-2", clusterType)
+				n.open(2, "envoy-1", routeType)
+				n.open(3, "envoy-1", clusterType)
+				n.open(4, "envoy-3", clusterType)
+				for id := uint64(1); id <= 4; id++ {
+					n.send(id, 1, at(0))
+				}
+				n.answer(4, false, "")
+			},
+			want: []StaleNode{{
+				Node: "envoy-1", TypeURL: clusterType, Sent: 1, Applied: -1, Lag: "2m0s",
+			}, {
+				Node: "envoy-1", TypeURL: routeType, Sent: 1, Applied: -1, Lag: "2m0s",
+			}, {
+				Node: "envoy-2", TypeURL: clusterType, Sent: 1, Applied: -1, Lag: "2m0s",
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var n Nodes
+			tc.events(&n)
+			got := n.Stale(at(2*time.Minute), time.Minute)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStaleNodes(t *testing.T) {
+	stale := []StaleNode{
+		{Node: "envoy-1", TypeURL: clusterType},
+		{Node: "envoy-1", TypeURL: routeType},
+		{Node: "envoy-2", TypeURL: clusterType},
+	}
+	if got := staleNodes(stale); got != 2 {
+		t.Fatalf("expected: %d, got: %d", 2, got)
+	}
+}
@@ -14,13 +14,17 @@ package grpc
 
 import (
 	"context"
+	"net"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
 	envoy_service_v2 "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v2"
+	"github.com/heptio/contour/internal/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -29,9 +33,44 @@ const (
 	grpcMaxConcurrentStreams = 1 << 20
 )
 
-// NewAPI returns a *grpc.Server which responds to the Envoy v2 xDS gRPC API.
-func NewAPI(log logrus.FieldLogger, cacheMap map[string]Cache) *grpc.Server {
-	opts := []grpc.ServerOption{
+// Options configure the streams served by NewAPI.
+type Options struct {
+	// SendTimeout is how long a DiscoveryResponse may take to be sent
+	// to an Envoy before its stream is closed.
+	// If not set, sends never time out.
+	SendTimeout time.Duration
+
+	// Metrics, if set, counts the streams closed by SendTimeout.
+	Metrics *metrics.Metrics
+
+	// Nodes, if set, records the responses each connected Envoy
+	// has applied.
+	Nodes *Nodes
+
+	// NACKs, if set, detects versions of the resources which the
+	// connected Envoys reject again and again. Metrics, if set,
+	// counts them.
+	NACKs *NACKs
+}
+
+// API is a *grpc.Server which responds to the Envoy v2 xDS gRPC API.
+type API struct {
+	*grpc.Server
+
+	// ctx is the parent of the context of every stream, it is
+	// cancelled by Run to end the streams when stopping.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewAPI returns an *API which responds to the Envoy v2 xDS gRPC API.
+func NewAPI(log logrus.FieldLogger, cacheMap map[string]Cache, opts Options) *API {
+	ctx, cancel := context.WithCancel(context.Background())
+	api := &API{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	sopts := []grpc.ServerOption{
 		// By default the Go grpc library defaults to a value of ~100 streams per
 		// connection. This number is likely derived from the HTTP/2 spec:
 		// https://http2.github.io/http2-spec/#SettingValues
@@ -39,11 +78,16 @@ func NewAPI(log logrus.FieldLogger, cacheMap map[string]Cache) *grpc.Server {
 		// CDS entry. There doesn't seem to be a penalty for increasing this value,
 		// so set it the limit similar to envoyproxy/go-control-plane#70.
 		grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams),
+		grpc.StreamInterceptor(api.intercept),
 	}
-	g := grpc.NewServer(opts...)
+	g := grpc.NewServer(sopts...)
 	s := &grpcServer{
 		xdsHandler{
 			FieldLogger: log,
+			sendTimeout: opts.SendTimeout,
+			metrics:     opts.Metrics,
+			nodes:       opts.Nodes,
+			nacks:       opts.NACKs,
 			resources: map[string]resource{
 				clusterType: &CDS{
 					Cache: cacheMap[clusterType],
@@ -57,6 +101,9 @@ func NewAPI(log logrus.FieldLogger, cacheMap map[string]Cache) *grpc.Server {
 				routeType: &RDS{
 					Cache: cacheMap[routeType],
 				},
+				secretType: &SDS{
+					Cache: cacheMap[secretType],
+				},
 			},
 		},
 	}
@@ -65,10 +112,57 @@ func NewAPI(log logrus.FieldLogger, cacheMap map[string]Cache) *grpc.Server {
 	v2.RegisterEndpointDiscoveryServiceServer(g, s)
 	v2.RegisterListenerDiscoveryServiceServer(g, s)
 	v2.RegisterRouteDiscoveryServiceServer(g, s)
-	return g
+	discovery.RegisterSecretDiscoveryServiceServer(g, s)
+	api.Server = g
+	return api
 }
 
-// grpcServer implements the LDS, RDS, CDS, and EDS, gRPC endpoints.
+// Run serves connections accepted on l until ctx is cancelled. It then
+// ends the open streams, via their context, and waits for them and any
+// fetches in flight to finish before returning.
+func (a *API) Run(ctx context.Context, l net.Listener) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- a.Serve(l)
+	}()
+	select {
+	case err := <-errc:
+		a.cancel()
+		return err
+	case <-ctx.Done():
+	}
+
+	// streams only return when their context is done, so end
+	// them first else GracefulStop would wait forever.
+	a.cancel()
+	a.GracefulStop()
+	return <-errc
+}
+
+// intercept replaces the context of each stream with one which is also
+// cancelled when the API stops.
+func (a *API) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
+
+// serverStream is a grpc.ServerStream with a replacement context.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// grpcServer implements the LDS, RDS, CDS, EDS, and SDS, gRPC endpoints.
 type grpcServer struct {
 	xdsHandler
 }
@@ -97,6 +191,10 @@ func (s *grpcServer) FetchRoutes(_ context.Context, req *v2.DiscoveryRequest) (*
 	return s.fetch(req)
 }
 
+func (s *grpcServer) FetchSecrets(_ context.Context, req *v2.DiscoveryRequest) (*v2.DiscoveryResponse, error) {
+	return s.fetch(req)
+}
+
 func (s *grpcServer) StreamClusters(srv v2.ClusterDiscoveryService_StreamClustersServer) error {
 	return s.stream(srv)
 }
@@ -124,3 +222,7 @@ func (s *grpcServer) StreamListeners(srv v2.ListenerDiscoveryService_StreamListe
 func (s *grpcServer) StreamRoutes(srv v2.RouteDiscoveryService_StreamRoutesServer) error {
 	return s.stream(srv)
 }
+
+func (s *grpcServer) StreamSecrets(srv discovery.SecretDiscoveryService_StreamSecretsServer) error {
+	return s.stream(srv)
+}
@@ -194,7 +194,7 @@ func TestGRPCStreaming(t *testing.T) {
 				routeType:    &ch.RouteCache,
 				listenerType: &ch.ListenerCache,
 				endpointType: et,
-			})
+			}, Options{})
 			var err error
 			l, err = net.Listen("tcp", "127.0.0.1:0")
 			check(t, err)
@@ -289,7 +289,7 @@ func TestGRPCFetching(t *testing.T) {
 				routeType:    &ch.RouteCache,
 				listenerType: &ch.ListenerCache,
 				endpointType: et,
-			})
+			}, Options{})
 			var err error
 			l, err = net.Listen("tcp", "127.0.0.1:0")
 			check(t, err)
@@ -309,6 +309,52 @@ func TestGRPCFetching(t *testing.T) {
 	}
 }
 
+func TestGRPCRunStopsStreams(t *testing.T) {
+	log := testLogger(t)
+	ch := contour.CacheHandler{
+		Metrics: metrics.NewMetrics(prometheus.NewRegistry()),
+	}
+	srv := NewAPI(log, map[string]Cache{
+		clusterType:  &ch.ClusterCache,
+		routeType:    &ch.RouteCache,
+		listenerType: &ch.ListenerCache,
+		endpointType: &contour.EndpointsTranslator{FieldLogger: log},
+	}, Options{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	check(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Run(ctx, l)
+	}()
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	check(t, err)
+	defer cc.Close()
+	cds := v2.NewClusterDiscoveryServiceClient(cc)
+	stream, err := cds.StreamClusters(context.Background())
+	check(t, err)
+	sendreq(t, stream, clusterType)
+	checkrecv(t, stream) // the stream is now waiting for a change
+
+	cancel()
+
+	// the stream must end, rather than wait for the next change.
+	if _, err := stream.Recv(); err == nil {
+		t.Fatal("expected the stream to end")
+	}
+
+	select {
+	case err := <-errc:
+		check(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+}
+
 func check(t *testing.T, err error) {
 	t.Helper()
 	if err != nil {
@@ -15,11 +15,18 @@ package grpc
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"runtime"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/heptio/contour/internal/metrics"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
@@ -30,6 +37,19 @@ type xdsHandler struct {
 	logrus.FieldLogger
 	connections counter
 	resources   map[string]resource // registered resource types
+
+	// sendTimeout, if set, bounds each Send on a stream. metrics,
+	// if set, records sends which time out.
+	sendTimeout time.Duration
+	metrics     *metrics.Metrics
+
+	// nodes, if set, records the responses each stream's Envoy
+	// has applied.
+	nodes *Nodes
+
+	// nacks, if set, detects versions of the resources which Envoy
+	// rejects again and again.
+	nacks *NACKs
 }
 
 // fetch handles a single DiscoveryRequest.
@@ -39,13 +59,52 @@ func (xh *xdsHandler) fetch(req *v2.DiscoveryRequest) (*v2.DiscoveryResponse, er
 	if !ok {
 		return nil, fmt.Errorf("no resource registered for typeURL %q", req.TypeUrl)
 	}
-	resources, err := toAny(r, toFilter(req.ResourceNames))
+	values := r.Values(toFilter(req.ResourceNames))
+	version, _, err := contentVersion(values, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resources []types.Any
+	if req.VersionInfo != version {
+		// otherwise the caller already holds these resources;
+		// respond with the version alone so polling is cheap.
+		resources, _, err = toAny(r, values, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &v2.DiscoveryResponse{
-		VersionInfo: "0",
+		VersionInfo: version,
 		Resources:   resources,
 		TypeUrl:     r.TypeURL(),
-		Nonce:       "0",
-	}, err
+		Nonce:       version,
+	}, nil
+}
+
+// contentVersion returns the version of values, derived from the
+// digest of the text format of each. The generated marshalers write
+// map fields, such as the fields of a Struct, in random order, whereas
+// the text format writes them in key order, so that unchanged values
+// always report the same version.
+// The caches replace, rather than modify, a value which changes, so
+// values found in digests, the digest of each value by its pointer,
+// are not formatted again. The digests of values are returned.
+func contentVersion(values []proto.Message, digests map[proto.Message][]byte) (string, map[proto.Message][]byte, error) {
+	h := sha256.New()
+	next := make(map[proto.Message][]byte, len(values))
+	for _, v := range values {
+		sum, ok := digests[v]
+		if !ok {
+			d := sha256.New()
+			if err := proto.CompactText(d, v); err != nil {
+				return "", nil, err
+			}
+			sum = d.Sum(nil)
+		}
+		next[v] = sum
+		h.Write(sum)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)[:8]), next, nil
 }
 
 type grpcStream interface {
@@ -57,7 +116,8 @@ type grpcStream interface {
 // stream processes a stream of DiscoveryRequests.
 func (xh *xdsHandler) stream(st grpcStream) (err error) {
 	// bump connection counter and set it as a field on the logger
-	log := xh.WithField("connection", xh.connections.next())
+	id := xh.connections.next()
+	log := xh.WithField("connection", id)
 
 	// set up some nice function exit handling which notifies if the
 	// stream terminated on error or not.
@@ -77,78 +137,298 @@ func (xh *xdsHandler) stream(st grpcStream) (err error) {
 	last := -1
 	ctx := st.Context()
 
-	// now stick in this loop until the client disconnects.
-	for {
-		// first we wait for the request from Envoy, this is part of
-		// the xDS protocol.
-		req, err := st.Recv()
-		if err != nil {
-			return err
-		}
+	// first we wait for the request from Envoy, this is part of
+	// the xDS protocol.
+	req, err := st.Recv()
+	if err != nil {
+		return err
+	}
 
-		// from the request we derive the resource to stream which have
-		// been registered according to the typeURL.
-		r, ok := xh.resources[req.TypeUrl]
-		if !ok {
-			return fmt.Errorf("no resource registered for typeURL %q", req.TypeUrl)
-		}
+	// from the request we derive the resource to stream which have
+	// been registered according to the typeURL.
+	r, ok := xh.resources[req.TypeUrl]
+	if !ok {
+		return fmt.Errorf("no resource registered for typeURL %q", req.TypeUrl)
+	}
+
+	// stick some debugging details on the logger.
+	log = log.WithField("version_info", req.VersionInfo).WithField("resource_names", req.ResourceNames).WithField("type_url", req.TypeUrl).WithField("response_nonce", req.ResponseNonce).WithField("error_detail", req.ErrorDetail)
 
-		// stick some debugging details on the logger, not that we redeclare log in this scope
-		// so the next time around the loop all is forgotten.
-		log := log.WithField("version_info", req.VersionInfo).WithField("resource_names", req.ResourceNames).WithField("type_url", req.TypeUrl).WithField("response_nonce", req.ResponseNonce).WithField("error_detail", req.ErrorDetail)
+	nodes := xh.nodes
+	if nodes == nil {
+		nodes = new(Nodes)
+	}
+	nodes.open(id, req.GetNode().GetId(), req.TypeUrl)
+	defer nodes.close(id)
+
+	nacks := xh.nacks
+	if nacks == nil {
+		nacks = new(NACKs)
+	}
+	rejected := nacks.wait(req.TypeUrl)
 
+	// every later request from Envoy ACKs or NACKs a response, they
+	// are received in the background so that they may be recorded
+	// while waiting for a notification.
+	reqs := make(chan *v2.DiscoveryRequest)
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
 		for {
+			req, err := st.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// pending are the responses Envoy has yet to answer, oldest first.
+	// acked is the last response Envoy ACKed, kept so that it may be
+	// served again if a later version is rejected.
+	var (
+		pending []snapshot
+		acked   *snapshot
+		current string // the version of the last response sent
+		latest  string // the version of the resources last generated
+
+		// the digest, and marshalled form, of each value of the
+		// resources last generated, which the next generation
+		// reuses for the values which have not changed.
+		digests    map[proto.Message][]byte
+		marshalled map[proto.Message]types.Any
+	)
+	defer func() {
+		nacks.release(r.TypeURL(), latest)
+	}()
+	respond := func(resources []types.Any, version string) error {
+		resp := &v2.DiscoveryResponse{
+			VersionInfo: "0",
+			Resources:   resources,
+			TypeUrl:     r.TypeURL(),
+			Nonce:       "0",
+		}
+		nodes.send(id, last, time.Now())
+		if err := xh.send(st, resp); err != nil {
+			return err
+		}
+		pending = append(pending, snapshot{version: version, resources: resources})
+		current = version
+		log.WithField("count", len(resources)).Info("response")
+		return nil
+	}
+
+	// now stick in this loop until the client disconnects.
+	registered := false
+	for {
+		if !registered {
 			log.Info("stream_wait")
 
 			// now we wait for a notification, if this is the first time through the loop
 			// then last will be zero and that will trigger a notification immediately.
 			r.Register(ch, last)
-			select {
-			case last = <-ch:
-				// boom, something in the cache has changed.
-				// TODO(dfc) the thing that has changed may not be in the scope of the filter
-				// so we're going to be sending an update that is a no-op. See #426
-
-				// generate a filter from the request, then call toAny which
-				// will get r's (our resource) filter values, then convert them
-				// to the types.Any from required by gRPC.
-				resources, err := toAny(r, toFilter(req.ResourceNames))
-				if err != nil {
-					return err
-				}
+			registered = true
+		}
+		select {
+		case last = <-ch:
+			registered = false
+
+			// boom, something in the cache has changed.
+			// TODO the thing that has changed may not be in the scope of the filter
+			// so we're going to be sending an update that is a no-op. See #426
 
-				resp := &v2.DiscoveryResponse{
-					VersionInfo: "0",
-					Resources:   resources,
-					TypeUrl:     r.TypeURL(),
-					Nonce:       "0",
+			// generate a filter from the request, get r's (our
+			// resource) filtered values, then call toAny to convert
+			// them to the types.Any form required by gRPC.
+			values := r.Values(toFilter(req.ResourceNames))
+			var resources []types.Any
+			resources, marshalled, err = toAny(r, values, marshalled)
+			if err != nil {
+				return err
+			}
+			var version string
+			version, digests, err = contentVersion(values, digests)
+			if err != nil {
+				return err
+			}
+			if version != latest {
+				// NACKs are only counted, and rejections kept,
+				// for the latest version of some stream.
+				nacks.retain(r.TypeURL(), version)
+				nacks.release(r.TypeURL(), latest)
+				latest = version
+			}
+			if nacks.Rollback && acked != nil && nacks.rejected(r.TypeURL(), version) {
+				log.WithField("rejected_version", version).WithField("acked_version", acked.version).Warn("serving the last acked resources in place of a rejected version")
+				resources, version = acked.resources, acked.version
+			}
+			if err := respond(resources, version); err != nil {
+				return err
+			}
+		case ack := <-reqs:
+			nodes.answer(id, ack.ErrorDetail != nil, ack.ErrorDetail.GetMessage())
+			if len(pending) == 0 {
+				break
+			}
+			answered := pending[0]
+			pending = pending[1:]
+			if ack.ErrorDetail == nil {
+				acked = &answered
+				nacks.ack(r.TypeURL(), answered.version)
+				break
+			}
+			log.WithField("error_detail", ack.ErrorDetail).Error("nack")
+			if nacks.nack(r.TypeURL(), answered.version, ack.ErrorDetail.GetMessage()) {
+				log.WithField("rejected_version", answered.version).WithField("nacks", nacks.Threshold).Error("version rejected after consecutive nacks, the object which produced it needs fixing")
+				if xh.metrics != nil {
+					xh.metrics.IncXDSRejectedVersion(r.TypeURL())
 				}
-				if err := st.Send(resp); err != nil {
+			}
+		case <-rejected:
+			// a version was rejected; if this stream serves it, roll
+			// back to the resources its Envoy last ACKed.
+			rejected = nacks.wait(r.TypeURL())
+			if nacks.Rollback && acked != nil && current != acked.version && nacks.rejected(r.TypeURL(), current) {
+				log.WithField("rejected_version", current).WithField("acked_version", acked.version).Warn("rolling back to the last acked resources")
+				if err := respond(acked.resources, acked.version); err != nil {
 					return err
 				}
-				log.WithField("count", len(resources)).Info("response")
-
-				// ok, the client hung up, return any error stored in the context and we're done.
-			case <-ctx.Done():
-				return ctx.Err()
 			}
+		case err := <-errc:
+			// the client hung up, or the stream broke.
+			return err
+		case <-ctx.Done():
+			// ok, the client hung up, return any error stored in the context and we're done.
+			return ctx.Err()
+		}
+	}
+}
+
+// snapshot is the version, and marshaled resources, of a response.
+type snapshot struct {
+	version   string
+	resources []types.Any
+}
+
+// send sends resp on st. If the send does not complete within the send
+// timeout an error is returned; returning it closes the stream, which
+// unblocks the pending Send and releases resp.
+func (xh *xdsHandler) send(st grpcStream, resp *v2.DiscoveryResponse) error {
+	if xh.sendTimeout <= 0 {
+		return st.Send(resp)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- st.Send(resp)
+	}()
+	timer := time.NewTimer(xh.sendTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-errc:
+		return err
+	case <-timer.C:
+		if xh.metrics != nil {
+			xh.metrics.IncXDSSendTimeout(resp.TypeUrl)
 		}
+		return status.Errorf(codes.DeadlineExceeded, "send of %s response did not complete within %v, is Envoy reading its stream?", resp.TypeUrl, xh.sendTimeout)
 	}
 }
 
-// toAny converts the contents of a resourcer's Values to the
-// respective slice of types.Any.
-func toAny(res resource, filter func(string) bool) ([]types.Any, error) {
-	v := res.Values(filter)
+// parallelMarshalThreshold is the number of values above which toAny
+// marshals them in parallel.
+const parallelMarshalThreshold = 1024
+
+// toAny converts v, the Values of res, to the respective slice of
+// types.Any. As with contentVersion, values found in marshalled, the
+// types.Any of each value by its pointer, are not marshalled again;
+// the types.Any of each of v are returned.
+func toAny(res resource, v []proto.Message, marshalled map[proto.Message]types.Any) ([]types.Any, map[proto.Message]types.Any, error) {
 	resources := make([]types.Any, len(v))
-	for i := range v {
-		value, err := proto.Marshal(v[i])
+	var src []proto.Message
+	var missing []int // the index in v of each of src
+	for i, value := range v {
+		if a, ok := marshalled[value]; ok {
+			resources[i] = a
+			continue
+		}
+		src = append(src, value)
+		missing = append(missing, i)
+	}
+	dst := make([]types.Any, len(src))
+	if err := marshalAll(res, dst, src); err != nil {
+		return nil, nil, err
+	}
+	next := make(map[proto.Message]types.Any, len(v))
+	for i, a := range dst {
+		resources[missing[i]] = a
+	}
+	for i, value := range v {
+		next[value] = resources[i]
+	}
+	return resources, next, nil
+}
+
+// marshalAll marshals each value of src into the respective types.Any
+// of dst. Large sets of values are marshalled in parallel by up to
+// GOMAXPROCS goroutines, each converting a contiguous run of values,
+// so the order of src is preserved.
+func marshalAll(res resource, dst []types.Any, src []proto.Message) error {
+	if len(src) == 0 {
+		return nil
+	}
+	// marshal the first value before asking res for its type URL, so
+	// a value which cannot be marshalled is reported first.
+	if err := marshalAny(dst[:1], src[:1], ""); err != nil {
+		return err
+	}
+	typeURL := res.TypeURL()
+	dst[0].TypeUrl = typeURL
+	rest, v := dst[1:], src[1:]
+	workers := runtime.GOMAXPROCS(0)
+	if len(v) < parallelMarshalThreshold || workers < 2 {
+		return marshalAny(rest, v, typeURL)
+	}
+
+	n := (len(v) + workers - 1) / workers
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w*n < len(v); w++ {
+		lo, hi := w*n, (w+1)*n
+		if hi > len(v) {
+			hi = len(v)
+		}
+		wg.Add(1)
+		go func(w, lo, hi int) {
+			defer wg.Done()
+			errs[w] = marshalAny(rest[lo:hi], v[lo:hi], typeURL)
+		}(w, lo, hi)
+	}
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
-			return nil, err
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalAny marshals each value of src into the respective types.Any
+// of dst.
+func marshalAny(dst []types.Any, src []proto.Message, typeURL string) error {
+	for i := range src {
+		value, err := proto.Marshal(src[i])
+		if err != nil {
+			return err
 		}
-		resources[i] = types.Any{TypeUrl: res.TypeURL(), Value: value}
+		dst[i] = types.Any{TypeUrl: typeURL, Value: value}
 	}
-	return resources, nil
+	return nil
 }
 
 // toFilter converts a slice of strings into a filter function.
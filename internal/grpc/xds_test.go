@@ -19,9 +19,19 @@ import (
 	"io"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	google_rpc "github.com/gogo/googleapis/google/rpc"
 	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/heptio/contour/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestXDSHandlerFetch(t *testing.T) {
@@ -63,6 +73,91 @@ func TestXDSHandlerFetch(t *testing.T) {
 	}
 }
 
+func TestXDSHandlerFetchVersion(t *testing.T) {
+	values := []proto.Message{&v2.Cluster{Name: "default/kuard/80"}}
+	xh := xdsHandler{
+		FieldLogger: testLogger(t),
+		resources: map[string]resource{
+			clusterType: &mockResource{
+				values:  func(func(string) bool) []proto.Message { return values },
+				typeurl: func() string { return clusterType },
+			},
+		},
+	}
+
+	first, err := xh.fetch(&v2.DiscoveryRequest{TypeUrl: clusterType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.VersionInfo == "" || len(first.Resources) != 1 {
+		t.Fatalf("expected a version and one resource, got %q and %d resources", first.VersionInfo, len(first.Resources))
+	}
+
+	// nothing has changed, so only the version is returned.
+	unchanged, err := xh.fetch(&v2.DiscoveryRequest{TypeUrl: clusterType, VersionInfo: first.VersionInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.VersionInfo != first.VersionInfo || len(unchanged.Resources) != 0 {
+		t.Fatalf("expected version %q and no resources, got %q and %d resources", first.VersionInfo, unchanged.VersionInfo, len(unchanged.Resources))
+	}
+
+	values = []proto.Message{&v2.Cluster{Name: "default/kuard/8080"}}
+	changed, err := xh.fetch(&v2.DiscoveryRequest{TypeUrl: clusterType, VersionInfo: first.VersionInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.VersionInfo == first.VersionInfo || len(changed.Resources) != 1 {
+		t.Fatalf("expected a new version and one resource, got %q and %d resources", changed.VersionInfo, len(changed.Resources))
+	}
+}
+
+func TestXDSHandlerFetchVersionListener(t *testing.T) {
+	// the filter config is a Struct, whose fields are marshalled in
+	// random order, so the version must not depend on the wire format.
+	config := &types.Struct{
+		Fields: map[string]*types.Value{
+			"codec_type":          {Kind: &types.Value_StringValue{StringValue: "auto"}},
+			"stat_prefix":         {Kind: &types.Value_StringValue{StringValue: "ingress_http"}},
+			"use_remote_address":  {Kind: &types.Value_BoolValue{BoolValue: true}},
+			"normalize_path":      {Kind: &types.Value_BoolValue{BoolValue: true}},
+			"generate_request_id": {Kind: &types.Value_BoolValue{BoolValue: false}},
+		},
+	}
+	values := []proto.Message{&v2.Listener{
+		Name: "ingress_http",
+		FilterChains: []listener.FilterChain{{
+			Filters: []listener.Filter{{
+				Name:   "envoy.http_connection_manager",
+				Config: config,
+			}},
+		}},
+	}}
+	xh := xdsHandler{
+		FieldLogger: testLogger(t),
+		resources: map[string]resource{
+			listenerType: &mockResource{
+				values:  func(func(string) bool) []proto.Message { return values },
+				typeurl: func() string { return listenerType },
+			},
+		},
+	}
+
+	first, err := xh.fetch(&v2.DiscoveryRequest{TypeUrl: listenerType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		unchanged, err := xh.fetch(&v2.DiscoveryRequest{TypeUrl: listenerType, VersionInfo: first.VersionInfo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unchanged.VersionInfo != first.VersionInfo || len(unchanged.Resources) != 0 {
+			t.Fatalf("expected version %q and no resources, got %q and %d resources", first.VersionInfo, unchanged.VersionInfo, len(unchanged.Resources))
+		}
+	}
+}
+
 func TestXDSHandlerStream(t *testing.T) {
 	log := testLogger(t)
 	tests := map[string]struct {
@@ -185,6 +280,278 @@ func TestXDSHandlerStream(t *testing.T) {
 	}
 }
 
+func TestXDSHandlerStreamSendTimeout(t *testing.T) {
+	// unblock releases the slow receiver's pending Send once the
+	// test is done.
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	xh := xdsHandler{
+		FieldLogger: testLogger(t),
+		resources: map[string]resource{
+			"com.heptio.potato": &mockResource{
+				register: func(ch chan int, i int) {
+					ch <- i + 1
+				},
+				values: func(fn func(string) bool) []proto.Message {
+					return []proto.Message{new(v2.ClusterLoadAssignment)}
+				},
+				typeurl: func() string { return "com.heptio.potato" },
+			},
+		},
+		sendTimeout: 10 * time.Millisecond,
+		metrics:     metrics.NewMetrics(prometheus.NewRegistry()),
+	}
+	st := &mockStream{
+		context: context.Background,
+		recv: func() (*v2.DiscoveryRequest, error) {
+			return &v2.DiscoveryRequest{
+				TypeUrl: "com.heptio.potato",
+			}, nil
+		},
+		send: func(resp *v2.DiscoveryResponse) error {
+			// a slow receiver never reads its response.
+			<-unblock
+			return io.EOF
+		},
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- xh.stream(st)
+	}()
+
+	select {
+	case got := <-errc:
+		want := status.Errorf(codes.DeadlineExceeded, "send of %s response did not complete within %v, is Envoy reading its stream?", "com.heptio.potato", 10*time.Millisecond)
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("expected: %v, got: %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not terminate after the send timeout")
+	}
+}
+
+func TestXDSHandlerStreamStaleNode(t *testing.T) {
+	registered := make(chan chan int, 1)
+	sent := make(chan *v2.DiscoveryResponse, 1)
+	receiving := make(chan struct{})
+	reqs := make(chan *v2.DiscoveryRequest)
+
+	var nodes Nodes
+	xh := xdsHandler{
+		FieldLogger: testLogger(t),
+		resources: map[string]resource{
+			clusterType: &mockResource{
+				register: func(ch chan int, i int) {
+					registered <- ch
+				},
+				values: func(fn func(string) bool) []proto.Message {
+					return []proto.Message{&v2.Cluster{Name: "default/kuard/80"}}
+				},
+				typeurl: func() string { return clusterType },
+			},
+		},
+		nodes: &nodes,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st := &mockStream{
+		context: func() context.Context { return ctx },
+		recv: func() (*v2.DiscoveryRequest, error) {
+			select {
+			case receiving <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			select {
+			case req := <-reqs:
+				return req, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+		send: func(resp *v2.DiscoveryResponse) error {
+			sent <- resp
+			return nil
+		},
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- xh.stream(st)
+	}()
+
+	// request sends req on the stream, then waits until the stream
+	// is receiving again, by which time req has been handed on.
+	<-receiving
+	request := func(req *v2.DiscoveryRequest) {
+		reqs <- req
+		<-receiving
+	}
+	// notify sends generation to the stream's registration and waits
+	// for the response.
+	notify := func(generation int) {
+		ch := <-registered
+		ch <- generation
+		<-sent
+	}
+
+	request(&v2.DiscoveryRequest{
+		TypeUrl: clusterType,
+		Node:    &core.Node{Id: "envoy-1"},
+	})
+	notify(1)
+
+	// the first response is ACKed.
+	request(&v2.DiscoveryRequest{TypeUrl: clusterType, VersionInfo: "0", ResponseNonce: "0"})
+	notify(2)
+
+	// the second is not, nor is the third.
+	notify(3)
+
+	later := time.Now().Add(time.Hour)
+	got := nodes.Stale(later, time.Minute)
+	if len(got) != 1 {
+		t.Fatalf("expected one stale node, got: %+v", got)
+	}
+	got[0].Lag = ""
+	want := StaleNode{
+		Node:    "envoy-1",
+		TypeURL: clusterType,
+		Sent:    3,
+		Applied: 1,
+	}
+	if !reflect.DeepEqual(want, got[0]) {
+		t.Fatalf("expected: %+v, got: %+v", want, got[0])
+	}
+
+	// once the client hangs up the node is forgotten.
+	cancel()
+	<-errc
+	if got := nodes.Stale(later, time.Minute); len(got) != 0 {
+		t.Fatalf("expected no stale nodes, got: %+v", got)
+	}
+}
+
+func TestXDSHandlerStreamNACKRollback(t *testing.T) {
+	registered := make(chan chan int, 1)
+	sent := make(chan *v2.DiscoveryResponse, 1)
+	receiving := make(chan struct{})
+	reqs := make(chan *v2.DiscoveryRequest)
+
+	cluster := "default/kuard/80"
+	nacks := NACKs{Threshold: 1, Rollback: true}
+	xh := xdsHandler{
+		FieldLogger: testLogger(t),
+		resources: map[string]resource{
+			clusterType: &mockResource{
+				register: func(ch chan int, i int) {
+					registered <- ch
+				},
+				values: func(fn func(string) bool) []proto.Message {
+					return []proto.Message{&v2.Cluster{Name: cluster}}
+				},
+				typeurl: func() string { return clusterType },
+			},
+		},
+		metrics: metrics.NewMetrics(prometheus.NewRegistry()),
+		nacks:   &nacks,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st := &mockStream{
+		context: func() context.Context { return ctx },
+		recv: func() (*v2.DiscoveryRequest, error) {
+			select {
+			case receiving <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			select {
+			case req := <-reqs:
+				return req, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+		send: func(resp *v2.DiscoveryResponse) error {
+			sent <- resp
+			return nil
+		},
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- xh.stream(st)
+	}()
+
+	<-receiving
+	request := func(req *v2.DiscoveryRequest) {
+		reqs <- req
+		<-receiving
+	}
+	ack := func() {
+		request(&v2.DiscoveryRequest{TypeUrl: clusterType, VersionInfo: "0", ResponseNonce: "0"})
+	}
+	nack := func() {
+		request(&v2.DiscoveryRequest{TypeUrl: clusterType, ResponseNonce: "0", ErrorDetail: &google_rpc.Status{Message: "rejected"}})
+	}
+	notify := func(generation int) {
+		ch := <-registered
+		ch <- generation
+	}
+	// assertSent asserts the next response carries the named cluster.
+	assertSent := func(name string) {
+		t.Helper()
+		select {
+		case resp := <-sent:
+			want := make([]types.Any, 1)
+			check(t, marshalAny(want, []proto.Message{&v2.Cluster{Name: name}}, clusterType))
+			if !reflect.DeepEqual(want, resp.Resources) {
+				t.Fatalf("expected: %v, got: %v", want, resp.Resources)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a response carrying %q", name)
+		}
+	}
+
+	request(&v2.DiscoveryRequest{TypeUrl: clusterType})
+	notify(1)
+	assertSent("default/kuard/80")
+	ack()
+
+	// the next version is rejected, so the stream rolls back to the
+	// one its Envoy last ACKed.
+	cluster = "default/kuard/broken"
+	notify(2)
+	assertSent("default/kuard/broken")
+	nack()
+	assertSent("default/kuard/80")
+	ack()
+	if got, want := nacks.Rejections(), []string{"Cluster: rejected"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+
+	// the rejected version is not served again.
+	notify(3)
+	assertSent("default/kuard/80")
+	ack()
+
+	// until the resources change.
+	cluster = "default/kuard/8080"
+	notify(4)
+	assertSent("default/kuard/8080")
+	if got := nacks.Rejections(); len(got) != 0 {
+		t.Fatalf("expected the rejection to be forgotten, got: %v", got)
+	}
+
+	cancel()
+	<-errc
+}
+
 type mockStream struct {
 	context func() context.Context
 	send    func(*v2.DiscoveryResponse) error
@@ -205,6 +572,160 @@ func (m *mockResource) Values(fn func(string) bool) []proto.Message { return m.v
 func (m *mockResource) Register(ch chan int, last int)              { m.register(ch, last) }
 func (m *mockResource) TypeURL() string                             { return m.typeurl() }
 
+func TestToAny(t *testing.T) {
+	tests := map[string]struct {
+		count int
+		nilAt int // index of a nil value, or -1
+		want  error
+	}{
+		"sequential": {
+			count: parallelMarshalThreshold - 1,
+			nilAt: -1,
+		},
+		"parallel": {
+			count: 4*parallelMarshalThreshold + 7,
+			nilAt: -1,
+		},
+		"parallel with error": {
+			count: 4 * parallelMarshalThreshold,
+			nilAt: 3 * parallelMarshalThreshold,
+			want:  fmt.Errorf("proto: Marshal called with nil"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			values := clusterloadassignments(tc.count)
+			if tc.nilAt >= 0 {
+				values[tc.nilAt] = nil
+			}
+			res := &mockResource{
+				values:  func(func(string) bool) []proto.Message { return values },
+				typeurl: func() string { return endpointType },
+			}
+			got, _, err := toAny(res, values, nil)
+			if !reflect.DeepEqual(tc.want, err) {
+				t.Fatalf("expected: %v, got: %v", tc.want, err)
+			}
+			if err != nil {
+				return
+			}
+			want := make([]types.Any, len(values))
+			check(t, marshalAny(want, values, endpointType))
+			if !reflect.DeepEqual(want, got) {
+				t.Fatal("toAny did not preserve the order of its values")
+			}
+		})
+	}
+}
+
+func TestToAnyMarshalled(t *testing.T) {
+	res := &mockResource{
+		typeurl: func() string { return clusterType },
+	}
+	c1, c2 := &v2.Cluster{Name: "default/kuard/80"}, &v2.Cluster{Name: "default/kuard/8080"}
+	_, marshalled, err := toAny(res, []proto.Message{c1}, nil)
+	check(t, err)
+
+	// c1 is not marshalled again, c2 is.
+	stale := types.Any{TypeUrl: clusterType, Value: []byte("stale")}
+	marshalled[c1] = stale
+	got, next, err := toAny(res, []proto.Message{c1, c2}, marshalled)
+	check(t, err)
+	want := make([]types.Any, 2)
+	check(t, marshalAny(want[1:], []proto.Message{c2}, clusterType))
+	want[0] = stale
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+	if len(next) != 2 || !reflect.DeepEqual(next[c2], want[1]) {
+		t.Fatalf("expected the marshalled form of both values, got: %v", next)
+	}
+}
+
+func TestContentVersion(t *testing.T) {
+	c := &v2.Cluster{Name: "default/kuard/80"}
+	first, digests, err := contentVersion([]proto.Message{c}, nil)
+	check(t, err)
+
+	// an equal value, however allocated, has the same version.
+	second, _, err := contentVersion([]proto.Message{&v2.Cluster{Name: "default/kuard/80"}}, nil)
+	check(t, err)
+	if first != second {
+		t.Fatalf("expected version %q, got %q", first, second)
+	}
+
+	// the digest of a value already seen is not computed again.
+	digests[c] = []byte("stale")
+	third, _, err := contentVersion([]proto.Message{c}, digests)
+	check(t, err)
+	if third == first {
+		t.Fatal("expected the version to be derived from the remembered digest")
+	}
+}
+
+func BenchmarkToAny(b *testing.B) {
+	values := clusterloadassignments(20000)
+	b.Run("sequential", func(b *testing.B) {
+		dst := make([]types.Any, len(values))
+		for i := 0; i < b.N; i++ {
+			if err := marshalAny(dst, values, endpointType); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	res := &mockResource{
+		values:  func(func(string) bool) []proto.Message { return values },
+		typeurl: func() string { return endpointType },
+	}
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := toAny(res, values, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unchanged", func(b *testing.B) {
+		_, marshalled, err := toAny(res, values, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := toAny(res, values, marshalled); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// clusterloadassignments returns n distinct ClusterLoadAssignments.
+func clusterloadassignments(n int) []proto.Message {
+	values := make([]proto.Message, n)
+	for i := range values {
+		values[i] = &v2.ClusterLoadAssignment{
+			ClusterName: fmt.Sprintf("default/service-%d/8080", i),
+			Endpoints: []endpoint.LocalityLbEndpoints{{
+				LbEndpoints: []endpoint.LbEndpoint{{
+					Endpoint: &endpoint.Endpoint{
+						Address: &core.Address{
+							Address: &core.Address_SocketAddress{
+								SocketAddress: &core.SocketAddress{
+									Address: fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff),
+									PortSpecifier: &core.SocketAddress_PortValue{
+										PortValue: 8080,
+									},
+								},
+							},
+						},
+					},
+				}},
+			}},
+		}
+	}
+	return values
+}
+
 func TestToFilter(t *testing.T) {
 	tests := map[string]struct {
 		names []string
@@ -0,0 +1,117 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpsvc
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Purpose identifies a group of HTTP handlers which are always
+// served together.
+type Purpose string
+
+const (
+	// Metrics serves the Prometheus /metrics endpoint.
+	Metrics Purpose = "metrics"
+
+	// Health serves the /health endpoint.
+	Health Purpose = "health"
+
+	// Debug serves the /debug endpoints.
+	Debug Purpose = "debug"
+)
+
+// Endpoint is the address and port a Purpose is served on.
+type Endpoint struct {
+	Addr string
+	Port int
+}
+
+// Manager serves each Purpose on its own Endpoint. Purposes which are
+// configured with the same Endpoint share a single Service.
+type Manager struct {
+	logrus.FieldLogger
+
+	endpoints map[Purpose]*Endpoint
+	services  map[Endpoint]*Service
+	purposes  map[*Service][]string
+}
+
+// Endpoint returns the Endpoint for Purpose p, creating it if required.
+// The result may be modified until the first call to ServeMux.
+func (m *Manager) Endpoint(p Purpose) *Endpoint {
+	if m.endpoints == nil {
+		m.endpoints = make(map[Purpose]*Endpoint)
+	}
+	ep, ok := m.endpoints[p]
+	if !ok {
+		ep = new(Endpoint)
+		m.endpoints[p] = ep
+	}
+	return ep
+}
+
+// ServeMux returns the ServeMux on which handlers for Purpose p
+// should be registered.
+func (m *Manager) ServeMux(p Purpose) *http.ServeMux {
+	ep := *m.Endpoint(p)
+	svc, ok := m.services[ep]
+	if !ok {
+		svc = &Service{
+			Addr: ep.Addr,
+			Port: ep.Port,
+		}
+		if m.services == nil {
+			m.services = make(map[Endpoint]*Service)
+			m.purposes = make(map[*Service][]string)
+		}
+		m.services[ep] = svc
+	}
+	if !contains(m.purposes[svc], string(p)) {
+		m.purposes[svc] = append(m.purposes[svc], string(p))
+	}
+	return &svc.ServeMux
+}
+
+// Services returns the Services required to serve every Purpose
+// which has had handlers registered, ordered by address and port.
+func (m *Manager) Services() []*Service {
+	var services []*Service
+	for _, svc := range m.services {
+		purposes := m.purposes[svc]
+		sort.Strings(purposes)
+		svc.FieldLogger = m.WithField("context", strings.Join(purposes, ","))
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Addr == services[j].Addr {
+			return services[i].Port < services[j].Port
+		}
+		return services[i].Addr < services[j].Addr
+	})
+	return services
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
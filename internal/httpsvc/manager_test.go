@@ -0,0 +1,92 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpsvc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestManagerServices(t *testing.T) {
+	tests := map[string]struct {
+		endpoints map[Purpose]Endpoint
+		want      []Endpoint
+	}{
+		"combined": {
+			endpoints: map[Purpose]Endpoint{
+				Metrics: {Addr: "0.0.0.0", Port: 8000},
+				Health:  {Addr: "0.0.0.0", Port: 8000},
+				Debug:   {Addr: "0.0.0.0", Port: 8000},
+			},
+			want: []Endpoint{
+				{Addr: "0.0.0.0", Port: 8000},
+			},
+		},
+		"debug on localhost": {
+			endpoints: map[Purpose]Endpoint{
+				Metrics: {Addr: "0.0.0.0", Port: 8000},
+				Health:  {Addr: "0.0.0.0", Port: 8000},
+				Debug:   {Addr: "127.0.0.1", Port: 6060},
+			},
+			want: []Endpoint{
+				{Addr: "0.0.0.0", Port: 8000},
+				{Addr: "127.0.0.1", Port: 6060},
+			},
+		},
+		"all separate": {
+			endpoints: map[Purpose]Endpoint{
+				Metrics: {Addr: "0.0.0.0", Port: 8000},
+				Health:  {Addr: "0.0.0.0", Port: 8001},
+				Debug:   {Addr: "127.0.0.1", Port: 6060},
+			},
+			want: []Endpoint{
+				{Addr: "0.0.0.0", Port: 8000},
+				{Addr: "0.0.0.0", Port: 8001},
+				{Addr: "127.0.0.1", Port: 6060},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := Manager{
+				FieldLogger: logrus.New(),
+			}
+			for p, ep := range tc.endpoints {
+				*m.Endpoint(p) = ep
+			}
+			for p := range tc.endpoints {
+				m.ServeMux(p)
+			}
+			var got []Endpoint
+			for _, svc := range m.Services() {
+				got = append(got, Endpoint{Addr: svc.Addr, Port: svc.Port})
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestManagerServeMuxShared(t *testing.T) {
+	var m Manager
+	*m.Endpoint(Metrics) = Endpoint{Addr: "0.0.0.0", Port: 8000}
+	*m.Endpoint(Health) = Endpoint{Addr: "0.0.0.0", Port: 8000}
+	if m.ServeMux(Metrics) != m.ServeMux(Health) {
+		t.Fatal("expected metrics and health to share a ServeMux")
+	}
+}
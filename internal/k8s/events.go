@@ -0,0 +1,115 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// IngressEvents records Warning Events against Ingress objects.
+type IngressEvents struct {
+	Client kubernetes.Interface
+
+	mu sync.Mutex
+	// written records, for each Ingress, the events already written
+	// against its latest version, so that each is written once per
+	// version of its Ingress rather than on every rebuild of the DAG.
+	written map[types.NamespacedName]*writtenEvents
+}
+
+// writtenEvents are the events written against one version of an
+// Ingress.
+type writtenEvents struct {
+	version string
+	events  map[ingressEvent]bool
+}
+
+type ingressEvent struct {
+	reason, message string
+}
+
+// Warning records a Warning Event with the supplied reason and message
+// against ing, unless it has already been recorded against this version
+// of ing. The events written against older versions of ing are
+// forgotten.
+func (ie *IngressEvents) Warning(ing *v1beta1.Ingress, reason, message string) error {
+	ie.mu.Lock()
+	defer ie.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name}
+	w, ok := ie.written[key]
+	if !ok || w.version != ing.ResourceVersion {
+		w = &writtenEvents{
+			version: ing.ResourceVersion,
+			events:  make(map[ingressEvent]bool),
+		}
+	}
+	ev := ingressEvent{reason: reason, message: message}
+	if w.events[ev] {
+		return nil
+	}
+
+	now := metav1.Now()
+	_, err := ie.Client.CoreV1().Events(ing.Namespace).Create(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ing.Name + ".",
+			Namespace:    ing.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "Ingress",
+			APIVersion:      "extensions/v1beta1",
+			Namespace:       ing.Namespace,
+			Name:            ing.Name,
+			UID:             ing.UID,
+			ResourceVersion: ing.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           v1.EventTypeWarning,
+		Source:         v1.EventSource{Component: "contour"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	if err != nil {
+		return err
+	}
+	if ie.written == nil {
+		ie.written = make(map[types.NamespacedName]*writtenEvents)
+	}
+	ie.written[key] = w
+	w.events[ev] = true
+	return nil
+}
+
+// OnDelete forgets the events written against a deleted Ingress. It is
+// the DeleteFunc of a cache.ResourceEventHandlerFuncs registered with
+// the Ingress informer.
+func (ie *IngressEvents) OnDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	if ing, ok := obj.(*v1beta1.Ingress); ok {
+		ie.mu.Lock()
+		defer ie.mu.Unlock()
+		delete(ie.written, types.NamespacedName{Namespace: ing.Namespace, Name: ing.Name})
+	}
+}
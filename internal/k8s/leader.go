@@ -0,0 +1,126 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LeaderElection elects a single leader among the Contours which share
+// its lock, a ConfigMap. Every Contour serves xDS, only the leader
+// writes IngressRoute status.
+type LeaderElection struct {
+	logrus.FieldLogger
+	Client kubernetes.Interface
+
+	// Namespace and Name name the ConfigMap used as the lock.
+	Namespace, Name string
+
+	// Identity identifies this Contour to the others, eg. its pod name.
+	Identity string
+
+	LeaseDuration, RenewDeadline, RetryPeriod time.Duration
+
+	// OnStartedLeading, if set, is called in its own goroutine each
+	// time this Contour becomes the leader. stop is closed when it
+	// stops being the leader.
+	OnStartedLeading func(stop <-chan struct{})
+
+	leading int32
+}
+
+// IsLeader reports whether this Contour is the leader.
+func (le *LeaderElection) IsLeader() bool {
+	return atomic.LoadInt32(&le.leading) == 1
+}
+
+// Start fulfills the g.Start contract.
+// It campaigns for leadership, again each time leadership is lost, until
+// stop is closed.
+func (le *LeaderElection) Start(stop <-chan struct{}) error {
+	lock := &resourcelock.ConfigMapLock{
+		ConfigMapMeta: metav1.ObjectMeta{
+			Namespace: le.Namespace,
+			Name:      le.Name,
+		},
+		Client: le.Client.CoreV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      le.Identity,
+			EventRecorder: le,
+		},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: le.LeaseDuration,
+		RenewDeadline: le.RenewDeadline,
+		RetryPeriod:   le.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(lost <-chan struct{}) {
+				select {
+				case <-stop:
+					// elected while campaigning after this
+					// Contour was stopped.
+					return
+				default:
+				}
+				atomic.StoreInt32(&le.leading, 1)
+				le.WithField("identity", le.Identity).Info("started leading")
+				if le.OnStartedLeading != nil {
+					le.OnStartedLeading(lost)
+				}
+			},
+			OnStoppedLeading: func() {
+				atomic.StoreInt32(&le.leading, 0)
+				le.WithField("identity", le.Identity).Info("stopped leading")
+			},
+			OnNewLeader: func(identity string) {
+				le.WithField("leader", identity).Info("elected")
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Run returns when leadership is lost, and cannot be interrupted
+	// while campaigning, so it runs apart from stop, which is checked
+	// before each campaign.
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			elector.Run()
+		}
+	}()
+	<-stop
+	atomic.StoreInt32(&le.leading, 0)
+	return nil
+}
+
+// Eventf logs the events of the lock, rather than recording them.
+func (le *LeaderElection) Eventf(obj runtime.Object, eventType, reason, message string, args ...interface{}) {
+	le.WithField("reason", reason).Infof(message, args...)
+}
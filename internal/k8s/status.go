@@ -15,32 +15,226 @@ package k8s
 
 import (
 	"encoding/json"
+	"sync"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
 	clientset "github.com/heptio/contour/internal/generated/clientset/versioned"
+	"github.com/heptio/contour/internal/metrics"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// statusHoldoff is the minimum interval between two status
+	// writes to the same IngressRoute. Writes inside this window are
+	// coalesced and only the most recent status is written.
+	statusHoldoff = 1 * time.Second
+
+	statusWriteAttempted = "attempted"
+	statusWriteSkipped   = "skipped"
+	statusWriteFailed    = "failed"
 )
 
 // IngressRouteStatus allows for updating the object's Status field
 type IngressRouteStatus struct {
 	Client clientset.Interface
+
+	// FieldLogger, if set, logs the failure of deferred status
+	// writes, which have no caller to return an error to.
+	logrus.FieldLogger
+
+	// Metrics, if set, records the number of attempted, skipped,
+	// and failed status writes.
+	*metrics.Metrics
+
+	mu      sync.Mutex
+	entries map[types.NamespacedName]*statusEntry
+}
+
+// statusEntry records the write history of a single IngressRoute.
+type statusEntry struct {
+	// written is the last status successfully written, and version
+	// the resource version of the object it was written against.
+	written ingressroutev1.Status
+	version string
+
+	// last is the time of the last write.
+	last time.Time
+
+	// pending, if not nil, is the status to be written when timer fires.
+	pending *ingressroutev1.Status
+	timer   *time.Timer
 }
 
-// SetStatus sets the IngressRoute status field to an Valid or Invalid status
+// SetStatus sets the IngressRoute status field to an Valid or Invalid status.
+// Writes to the same IngressRoute within statusHoldoff of each other are
+// coalesced; only the latest status is written once the holdoff expires.
 func (irs *IngressRouteStatus) SetStatus(status, desc string, existing *ingressroutev1.IngressRoute) error {
-	// Check if update needed by comparing status & desc
-	if existing.CurrentStatus != status || existing.Description != desc {
+	irs.mu.Lock()
+
+	key := types.NamespacedName{Namespace: existing.Namespace, Name: existing.Name}
+	want := ingressroutev1.Status{
+		CurrentStatus: status,
+		Description:   desc,
+	}
+	e := irs.entry(key)
+
+	// Check if update needed by comparing status & desc against the object
+	// and, if we have not yet observed the result of our last write, the
+	// status we last wrote.
+	if existing.Status == want || (e.pending == nil && e.version == existing.ResourceVersion && e.written == want) {
+		e.pending = nil
+		irs.mu.Unlock()
+		irs.record(statusWriteSkipped)
+		return nil
+	}
+
+	since := time.Since(e.last)
+	if since > statusHoldoff {
+		// update immediately
+		if e.timer != nil {
+			e.timer.Stop()
+			e.timer = nil
+		}
+		e.pending = nil
+		e.last = time.Now()
+		irs.mu.Unlock()
+
 		updated := existing.DeepCopy()
-		updated.Status = ingressroutev1.Status{
-			CurrentStatus: status,
-			Description:   desc,
+		updated.Status = want
+		return irs.write(e, existing, updated)
+	}
+
+	// a status was written recently, replace any pending update with
+	// this one and write it once the holdoff period has elapsed.
+	if e.pending != nil {
+		irs.record(statusWriteSkipped)
+	}
+	e.pending = &want
+	if e.timer == nil {
+		e.timer = time.AfterFunc(statusHoldoff-since, func() {
+			irs.flush(key, e)
+		})
+	}
+	irs.mu.Unlock()
+	return nil
+}
+
+// flush writes the pending status of e, the entry for key, once its
+// holdoff period has elapsed. The object may have changed since the
+// write was deferred, so the status is patched against its latest
+// version.
+func (irs *IngressRouteStatus) flush(key types.NamespacedName, e *statusEntry) {
+	irs.mu.Lock()
+	e.timer = nil
+	want := e.pending
+	e.pending = nil
+	if want == nil || irs.entries[key] != e {
+		// nothing to write, or the IngressRoute has been deleted.
+		irs.mu.Unlock()
+		return
+	}
+	e.last = time.Now()
+	irs.mu.Unlock()
+
+	existing, err := irs.Client.ContourV1beta1().IngressRoutes(key.Namespace).Get(key.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			irs.forget(key)
+			return
 		}
-		return irs.setStatus(existing, updated)
+		irs.record(statusWriteFailed)
+		irs.logError(err, key)
+		return
 	}
+	if existing.Status == *want {
+		irs.record(statusWriteSkipped)
+		return
+	}
+	updated := existing.DeepCopy()
+	updated.Status = *want
+	if err := irs.write(e, existing, updated); err != nil {
+		irs.logError(err, key)
+	}
+}
+
+// OnDelete forgets the write history of a deleted IngressRoute,
+// cancelling any deferred write. It is the DeleteFunc of a
+// cache.ResourceEventHandlerFuncs registered with the IngressRoute
+// informer.
+func (irs *IngressRouteStatus) OnDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	if ir, ok := obj.(*ingressroutev1.IngressRoute); ok {
+		irs.forget(types.NamespacedName{Namespace: ir.Namespace, Name: ir.Name})
+	}
+}
+
+// forget removes the statusEntry for key, stopping its timer.
+func (irs *IngressRouteStatus) forget(key types.NamespacedName) {
+	irs.mu.Lock()
+	defer irs.mu.Unlock()
+	if e, ok := irs.entries[key]; ok {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(irs.entries, key)
+	}
+}
+
+// entry returns the statusEntry for key, creating it if required.
+func (irs *IngressRouteStatus) entry(key types.NamespacedName) *statusEntry {
+	if irs.entries == nil {
+		irs.entries = make(map[types.NamespacedName]*statusEntry)
+	}
+	e, ok := irs.entries[key]
+	if !ok {
+		e = new(statusEntry)
+		irs.entries[key] = e
+	}
+	return e
+}
+
+// write writes updated to the API server and records the result in e.
+// It must be called without irs.mu held.
+func (irs *IngressRouteStatus) write(e *statusEntry, existing, updated *ingressroutev1.IngressRoute) error {
+	irs.record(statusWriteAttempted)
+	err := irs.setStatus(existing, updated)
+
+	irs.mu.Lock()
+	defer irs.mu.Unlock()
+	if err != nil {
+		irs.record(statusWriteFailed)
+		// forget what we wrote so the next attempt is not skipped.
+		e.written, e.version = ingressroutev1.Status{}, ""
+		e.last = time.Time{}
+		return err
+	}
+	e.written, e.version = updated.Status, existing.ResourceVersion
 	return nil
 }
 
+// logError logs err, the failure of a deferred write of the status of
+// key, if a logger is configured.
+func (irs *IngressRouteStatus) logError(err error, key types.NamespacedName) {
+	if irs.FieldLogger != nil {
+		irs.WithError(err).Errorf("error setting status of IngressRoute %s", key)
+	}
+}
+
+// record records the result of a status write, if metrics are configured.
+func (irs *IngressRouteStatus) record(result string) {
+	if irs.Metrics != nil {
+		irs.IncIngressRouteStatusWrite(result)
+	}
+}
+
 func (irs *IngressRouteStatus) setStatus(existing, updated *ingressroutev1.IngressRoute) error {
 	existingBytes, err := json.Marshal(existing)
 	if err != nil {
@@ -15,13 +15,19 @@ package k8s
 
 import (
 	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	ingressroutev1beta1 "github.com/heptio/contour/apis/contour/v1beta1"
 	"github.com/heptio/contour/internal/generated/clientset/versioned/fake"
+	"github.com/heptio/contour/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestSetStatus(t *testing.T) {
@@ -112,3 +118,153 @@ func TestSetStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestSetStatusCoalesce(t *testing.T) {
+	existing := &ingressroutev1beta1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test",
+			Namespace:       "default",
+			ResourceVersion: "1",
+		},
+	}
+	client := fake.NewSimpleClientset(existing)
+	var mu sync.Mutex
+	var patches []string
+	client.PrependReactor("patch", "ingressroutes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		patches = append(patches, string(action.(k8stesting.PatchActionImpl).GetPatch()))
+		return true, existing, nil
+	})
+	registry := prometheus.NewRegistry()
+	irs := IngressRouteStatus{
+		Client:  client,
+		Metrics: metrics.NewMetrics(registry),
+	}
+	assertPatches := func(want int) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if len(patches) != want {
+			t.Fatalf("expected %d patches, got %d", want, len(patches))
+		}
+	}
+
+	// first write goes straight through.
+	if err := irs.SetStatus("valid", "this is a valid IR", existing); err != nil {
+		t.Fatal(err)
+	}
+	assertPatches(1)
+
+	// the object has not been updated by the informer yet, but we
+	// have already written this status, so it should be skipped.
+	if err := irs.SetStatus("valid", "this is a valid IR", existing); err != nil {
+		t.Fatal(err)
+	}
+	assertPatches(1)
+
+	// a different status inside the holdoff window is deferred, and
+	// replaced by the next.
+	if err := irs.SetStatus("invalid", "boo hiss", existing); err != nil {
+		t.Fatal(err)
+	}
+	if err := irs.SetStatus("invalid", "still boo hiss", existing); err != nil {
+		t.Fatal(err)
+	}
+	assertPatches(1)
+
+	// once the holdoff has elapsed only the latest status is written.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(patches)
+		mu.Unlock()
+		if n > 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertPatches(2)
+	want := `{"status":{"currentStatus":"invalid","description":"still boo hiss"}}`
+	if got := patches[1]; got != want {
+		t.Fatalf("expected patch: %s, got: %s", want, got)
+	}
+
+	got := statusWrites(t, registry)
+	expected := map[string]float64{
+		statusWriteAttempted: 2,
+		statusWriteSkipped:   2,
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSetStatusDeleted(t *testing.T) {
+	existing := &ingressroutev1beta1.IngressRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test",
+			Namespace:       "default",
+			ResourceVersion: "1",
+		},
+	}
+	client := fake.NewSimpleClientset(existing)
+	var mu sync.Mutex
+	var patches int
+	client.PrependReactor("patch", "ingressroutes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		patches++
+		return true, existing, nil
+	})
+	irs := IngressRouteStatus{
+		Client: client,
+	}
+
+	if err := irs.SetStatus("valid", "this is a valid IR", existing); err != nil {
+		t.Fatal(err)
+	}
+	// deferred, then cancelled by the deletion.
+	if err := irs.SetStatus("invalid", "boo hiss", existing); err != nil {
+		t.Fatal(err)
+	}
+	irs.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/test", Obj: existing})
+
+	irs.mu.Lock()
+	entries := len(irs.entries)
+	irs.mu.Unlock()
+	if entries != 0 {
+		t.Fatalf("expected no entries, got %d", entries)
+	}
+
+	time.Sleep(statusHoldoff + 100*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if patches != 1 {
+		t.Fatalf("expected 1 patch, got %d", patches)
+	}
+}
+
+// statusWrites returns the value of each result of the IngressRoute
+// status writes counter gathered from registry.
+func statusWrites(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := make(map[string]float64)
+	for _, mf := range families {
+		if mf.GetName() != metrics.IngressRouteStatusWritesCounter {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "result" {
+					writes[l.GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return writes
+}
@@ -19,53 +19,176 @@ import (
 
 	ingressroutev1 "github.com/heptio/contour/apis/contour/v1beta1"
 	clientset "github.com/heptio/contour/internal/generated/clientset/versioned"
+	contourinformers "github.com/heptio/contour/internal/generated/informers/externalversions"
 	"github.com/heptio/workgroup"
 	"github.com/sirupsen/logrus"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
 
-// WatchServices creates a SharedInformer for v1.Services and registers it with g.
-func WatchServices(g *workgroup.Group, client *kubernetes.Clientset, log logrus.FieldLogger, rs ...cache.ResourceEventHandler) {
-	watch(g, client.CoreV1().RESTClient(), log, "services", new(v1.Service), rs...)
+// lastAppliedConfig is the annotation kubectl apply uses to record the
+// previous configuration. It can be as large as the object itself and is
+// never used by Contour.
+const lastAppliedConfig = "kubectl.kubernetes.io/last-applied-configuration"
+
+// WatchServices registers rs with the v1.Service informer of factory.
+func WatchServices(factory informers.SharedInformerFactory, rs ...cache.ResourceEventHandler) {
+	watchResource(factory, coreV1, "services", new(v1.Service), fields.Everything(), labels.Everything(), rs...)
 }
 
-// WatchEndpoints creates a SharedInformer for v1.Endpoints and registers it with g.
-func WatchEndpoints(g *workgroup.Group, client *kubernetes.Clientset, log logrus.FieldLogger, rs ...cache.ResourceEventHandler) {
-	watch(g, client.CoreV1().RESTClient(), log, "endpoints", new(v1.Endpoints), rs...)
+// WatchEndpoints registers rs with the v1.Endpoints informer of factory.
+func WatchEndpoints(factory informers.SharedInformerFactory, rs ...cache.ResourceEventHandler) {
+	watchResource(factory, coreV1, "endpoints", new(v1.Endpoints), fields.Everything(), labels.Everything(), rs...)
 }
 
-// WatchIngress creates a SharedInformer for v1beta1.Ingress and registers it with g.
-func WatchIngress(g *workgroup.Group, client *kubernetes.Clientset, log logrus.FieldLogger, rs ...cache.ResourceEventHandler) {
-	watch(g, client.ExtensionsV1beta1().RESTClient(), log, "ingresses", new(v1beta1.Ingress), rs...)
+// WatchPods registers rs with the informer of running v1.Pods of factory.
+// Pods are trimmed to their name, labels, and deletion timestamp to bound
+// the memory used by the informer's cache.
+func WatchPods(factory informers.SharedInformerFactory, rs ...cache.ResourceEventHandler) {
+	running := fields.OneTermEqualSelector("status.phase", string(v1.PodRunning))
+	watchResource(factory, coreV1, "pods", new(v1.Pod), running, labels.Everything(), rs...)
 }
 
-// WatchSecrets creates a SharedInformer for v1.Secrets and registers it with g.
-func WatchSecrets(g *workgroup.Group, client *kubernetes.Clientset, log logrus.FieldLogger, rs ...cache.ResourceEventHandler) {
-	watch(g, client.CoreV1().RESTClient(), log, "secrets", new(v1.Secret), rs...)
+// WatchIngress registers rs with the v1beta1.Ingress informer of factory.
+// Only Ingress objects matching selector are watched.
+func WatchIngress(factory informers.SharedInformerFactory, selector labels.Selector, rs ...cache.ResourceEventHandler) {
+	watchResource(factory, extensionsV1beta1, "ingresses", new(v1beta1.Ingress), fields.Everything(), selector, rs...)
 }
 
-// WatchIngressRoutes creates a SharedInformer for contour.heptio.com/v1.IngressRoutes and registers it with g.
-func WatchIngressRoutes(g *workgroup.Group, client *clientset.Clientset, log logrus.FieldLogger, rs ...cache.ResourceEventHandler) {
-	watch(g, client.ContourV1beta1().RESTClient(), log, ingressroutev1.ResourcePlural, new(ingressroutev1.IngressRoute), rs...)
+// WatchSecrets registers rs with the v1.Secret informer of factory.
+// If tlsOnly is true, only Secrets of type kubernetes.io/tls are watched.
+func WatchSecrets(factory informers.SharedInformerFactory, tlsOnly bool, rs ...cache.ResourceEventHandler) {
+	fs := fields.Everything()
+	if tlsOnly {
+		fs = fields.OneTermEqualSelector("type", string(v1.SecretTypeTLS))
+	}
+	watchResource(factory, coreV1, "secrets", new(v1.Secret), fs, labels.Everything(), rs...)
 }
 
-func watch(g *workgroup.Group, c cache.Getter, log logrus.FieldLogger, resource string, objType runtime.Object, rs ...cache.ResourceEventHandler) {
-	lw := cache.NewListWatchFromClient(c, resource, v1.NamespaceAll, fields.Everything())
-	sw := cache.NewSharedInformer(lw, objType, time.Duration(0)) // resync timer disabled
+// WatchIngressRoutes registers rs with the contour.heptio.com/v1beta1.IngressRoute
+// informer of factory. Only IngressRoute objects matching selector are watched.
+func WatchIngressRoutes(factory contourinformers.SharedInformerFactory, selector labels.Selector, rs ...cache.ResourceEventHandler) {
+	informer := factory.InformerFor(new(ingressroutev1.IngressRoute), func(client clientset.Interface, resync time.Duration) cache.SharedIndexInformer {
+		lw := newListWatch(client.ContourV1beta1().RESTClient(), ingressroutev1.ResourcePlural, v1.NamespaceAll, fields.Everything(), selector)
+		return cache.NewSharedIndexInformer(lw, new(ingressroutev1.IngressRoute), resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	})
 	for _, r := range rs {
-		sw.AddEventHandler(r)
+		informer.AddEventHandler(r)
 	}
+}
+
+// StartInformers registers with g a goroutine which starts the informers
+// requested of each of factories, and stops them when g is stopped.
+func StartInformers(g *workgroup.Group, log logrus.FieldLogger, factories ...interface {
+	Start(stop <-chan struct{})
+}) {
 	g.Add(func(stop <-chan struct{}) error {
-		log := log.WithField("resource", resource)
 		log.Println("started")
 		defer log.Println("stopped")
-		sw.Run(stop)
+		for _, f := range factories {
+			f.Start(stop)
+		}
+		<-stop
 		return nil
 	})
 }
+
+func coreV1(client kubernetes.Interface) cache.Getter { return client.CoreV1().RESTClient() }
+
+func extensionsV1beta1(client kubernetes.Interface) cache.Getter {
+	return client.ExtensionsV1beta1().RESTClient()
+}
+
+// watchResource registers rs with the informer of factory for objType,
+// which lists and watches resource with the REST client returned by
+// getter, restricted by fs and ls. Each resource has one informer, so
+// the selectors of the first call for it apply.
+func watchResource(factory informers.SharedInformerFactory, getter func(kubernetes.Interface) cache.Getter, resource string, objType runtime.Object, fs fields.Selector, ls labels.Selector, rs ...cache.ResourceEventHandler) {
+	informer := factory.InformerFor(objType, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		lw := newListWatch(getter(client), resource, v1.NamespaceAll, fs, ls)
+		return cache.NewSharedIndexInformer(lw, objType, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	})
+	for _, r := range rs {
+		informer.AddEventHandler(r)
+	}
+}
+
+// newListWatch is like cache.NewListWatchFromClient but also restricts
+// the objects returned by label, and trims each object before it is
+// stored in the informer's cache.
+func newListWatch(c cache.Getter, resource, namespace string, fs fields.Selector, ls labels.Selector) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fs.String()
+			options.LabelSelector = ls.String()
+			obj, err := c.Get().
+				Namespace(namespace).
+				Resource(resource).
+				VersionedParams(&options, metav1.ParameterCodec).
+				Do().
+				Get()
+			if err != nil {
+				return nil, err
+			}
+			err = meta.EachListItem(obj, func(obj runtime.Object) error {
+				trim(obj)
+				return nil
+			})
+			return obj, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.Watch = true
+			options.FieldSelector = fs.String()
+			options.LabelSelector = ls.String()
+			w, err := c.Get().
+				Namespace(namespace).
+				Resource(resource).
+				VersionedParams(&options, metav1.ParameterCodec).
+				Watch()
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+				trim(e.Object)
+				return e, true
+			}), nil
+		},
+	}
+}
+
+// trim removes the parts of obj which Contour does not use.
+func trim(obj runtime.Object) {
+	if pod, ok := obj.(*v1.Pod); ok {
+		*pod = v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              pod.Name,
+				Namespace:         pod.Namespace,
+				UID:               pod.UID,
+				ResourceVersion:   pod.ResourceVersion,
+				Labels:            pod.Labels,
+				DeletionTimestamp: pod.DeletionTimestamp,
+			},
+		}
+		return
+	}
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		// not an object, probably a watch error, leave it alone.
+		return
+	}
+	annotations := m.GetAnnotations()
+	if _, ok := annotations[lastAppliedConfig]; ok {
+		delete(annotations, lastAppliedConfig)
+		m.SetAnnotations(annotations)
+	}
+}
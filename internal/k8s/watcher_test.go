@@ -0,0 +1,96 @@
+// Copyright © 2018 Heptio
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestTrim(t *testing.T) {
+	tests := map[string]struct {
+		obj  runtime.Object
+		want runtime.Object
+	}{
+		"no annotations": {
+			obj: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "kuard", Namespace: "default"},
+			},
+			want: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "kuard", Namespace: "default"},
+			},
+		},
+		"last applied configuration removed": {
+			obj: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "kuard",
+					Namespace: "default",
+					Annotations: map[string]string{
+						lastAppliedConfig:                    `{"kind":"Service"}`,
+						"contour.heptio.com/max-connections": "9000",
+					},
+				},
+			},
+			want: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "kuard",
+					Namespace: "default",
+					Annotations: map[string]string{
+						"contour.heptio.com/max-connections": "9000",
+					},
+				},
+			},
+		},
+		"pod trimmed": {
+			obj: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "kuard-1",
+					Namespace:         "default",
+					ResourceVersion:   "42",
+					Labels:            map[string]string{"app": "kuard"},
+					DeletionTimestamp: &metav1.Time{},
+				},
+				Spec: v1.PodSpec{
+					NodeName: "node-1",
+				},
+			},
+			want: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "kuard-1",
+					Namespace:         "default",
+					ResourceVersion:   "42",
+					Labels:            map[string]string{"app": "kuard"},
+					DeletionTimestamp: &metav1.Time{},
+				},
+			},
+		},
+		"not an object": {
+			obj:  &metav1.Status{Message: "too old resource version"},
+			want: &metav1.Status{Message: "too old resource version"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			trim(tc.obj)
+			if !reflect.DeepEqual(tc.want, tc.obj) {
+				t.Fatalf("expected:\n%+v\ngot:\n%+v", tc.want, tc.obj)
+			}
+		})
+	}
+}
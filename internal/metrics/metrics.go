@@ -17,7 +17,6 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/heptio/contour/internal/httpsvc"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -31,7 +30,14 @@ type Metrics struct {
 	ingressRouteOrphanedGauge  *prometheus.GaugeVec
 
 	CacheHandlerOnUpdateSummary prometheus.Summary
+	DAGRebuildHistogram         prometheus.Histogram
 	ResourceEventHandlerSummary *prometheus.SummaryVec
+
+	ingressRouteStatusWritesCounter *prometheus.CounterVec
+
+	xdsSendTimeoutsCounter     *prometheus.CounterVec
+	xdsStaleNodesGauge         prometheus.Gauge
+	xdsRejectedVersionsCounter *prometheus.CounterVec
 }
 
 // IngressRouteMetric stores various metrics for IngressRoute objects
@@ -55,7 +61,14 @@ const (
 	IngressRouteValidGauge     = "contour_ingressroute_valid_total"
 	IngressRouteOrphanedGauge  = "contour_ingressroute_orphaned_total"
 
+	IngressRouteStatusWritesCounter = "contour_ingressroute_status_writes_total"
+
+	XDSSendTimeoutsCounter     = "contour_xds_send_timeouts_total"
+	XDSStaleNodesGauge         = "contour_xds_stale_nodes"
+	XDSRejectedVersionsCounter = "contour_xds_rejected_versions_total"
+
 	cacheHandlerOnUpdateSummary = "contour_cachehandler_onupdate_duration_seconds"
+	dagRebuildHistogram         = "contour_dag_rebuild_duration_seconds"
 	resourceEventHandlerSummary = "contour_resourceeventhandler_duration_seconds"
 )
 
@@ -103,6 +116,11 @@ func NewMetrics(registry *prometheus.Registry) *Metrics {
 			Help:       "Histogram for the runtime of xDS cache regeneration",
 			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 		}),
+		DAGRebuildHistogram: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    dagRebuildHistogram,
+			Help:    "Histogram for the runtime of each DAG rebuild and the xDS cache updates which follow it",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+		}),
 		ResourceEventHandlerSummary: prometheus.NewSummaryVec(prometheus.SummaryOpts{
 			Name:       resourceEventHandlerSummary,
 			Help:       "Histogram for the runtime of k8s watcher events",
@@ -110,6 +128,33 @@ func NewMetrics(registry *prometheus.Registry) *Metrics {
 		},
 			[]string{"op"},
 		),
+		ingressRouteStatusWritesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: IngressRouteStatusWritesCounter,
+				Help: "Total number of IngressRoute status writes by result",
+			},
+			[]string{"result"},
+		),
+		xdsSendTimeoutsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: XDSSendTimeoutsCounter,
+				Help: "Total number of xDS streams closed because a response could not be sent in time",
+			},
+			[]string{"type_url"},
+		),
+		xdsStaleNodesGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: XDSStaleNodesGauge,
+				Help: "Number of connected Envoys which have not applied the latest configuration sent to them",
+			},
+		),
+		xdsRejectedVersionsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: XDSRejectedVersionsCounter,
+				Help: "Total number of versions of xDS resources rejected after repeated NACKs from Envoy",
+			},
+			[]string{"type_url"},
+		),
 	}
 	m.register(registry)
 	return &m
@@ -124,7 +169,12 @@ func (m *Metrics) register(registry *prometheus.Registry) {
 		m.ingressRouteValidGauge,
 		m.ingressRouteOrphanedGauge,
 		m.CacheHandlerOnUpdateSummary,
+		m.DAGRebuildHistogram,
 		m.ResourceEventHandlerSummary,
+		m.ingressRouteStatusWritesCounter,
+		m.xdsSendTimeoutsCounter,
+		m.xdsStaleNodesGauge,
+		m.xdsRejectedVersionsCounter,
 	)
 }
 
@@ -147,28 +197,40 @@ func (m *Metrics) SetIngressRouteMetric(metrics IngressRouteMetric) {
 	}
 }
 
-// Service serves various metric and health checking endpoints
-type Service struct {
-	httpsvc.Service
-	*prometheus.Registry
+// IncIngressRouteStatusWrite increments the count of IngressRoute status
+// writes for the supplied result; one of "attempted", "skipped", or "failed".
+func (m *Metrics) IncIngressRouteStatusWrite(result string) {
+	m.ingressRouteStatusWritesCounter.WithLabelValues(result).Inc()
+}
+
+// IncXDSSendTimeout increments the count of xDS streams, of the
+// supplied type URL, closed because a response was not sent in time.
+func (m *Metrics) IncXDSSendTimeout(typeURL string) {
+	m.xdsSendTimeoutsCounter.WithLabelValues(typeURL).Inc()
 }
 
-// Start fulfills the g.Start contract.
-// When stop is closed the http server will shutdown.
-func (svc *Service) Start(stop <-chan struct{}) error {
-	registerHealthCheck(&svc.ServeMux)
-	registerMetrics(&svc.ServeMux, svc.Registry)
+// SetXDSStaleNodes records the number of connected Envoys which have
+// not applied the latest configuration sent to them.
+func (m *Metrics) SetXDSStaleNodes(n int) {
+	m.xdsStaleNodesGauge.Set(float64(n))
+}
 
-	return svc.Service.Start(stop)
+// IncXDSRejectedVersion increments the count of versions of the
+// supplied type URL's resources rejected after repeated NACKs.
+func (m *Metrics) IncXDSRejectedVersion(typeURL string) {
+	m.xdsRejectedVersionsCounter.WithLabelValues(typeURL).Inc()
 }
 
-func registerHealthCheck(mux *http.ServeMux) {
+// RegisterHealthCheck registers the /health endpoint on mux.
+func RegisterHealthCheck(mux *http.ServeMux) {
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "OK")
 	})
 }
 
-func registerMetrics(mux *http.ServeMux, registry *prometheus.Registry) {
+// RegisterMetrics registers the /metrics endpoint, serving the
+// contents of registry, on mux.
+func RegisterMetrics(mux *http.ServeMux, registry *prometheus.Registry) {
 	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 }